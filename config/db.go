@@ -48,9 +48,31 @@ func ConnectDB() {
 		&models.SiteSetting{},
 		&models.RequestLog{},
 		&models.FlashSale{},
+		&models.FlashSaleItem{},
+		&models.WaitingRoomEntry{},
+		&models.WaitingRoomState{},
+		&models.TicketPriceTier{},
+		&models.BundleItem{},
+		&models.Product{},
+		&models.ProductVariant{},
+		&models.OrderAddOn{},
 		&models.BankTransaction{},
 		&models.EmailVerification{},
 		&models.ReferralCode{},
+		&models.TicketHold{},
+		&models.EventSigningKey{},
+		&models.CheckInLog{},
+		&models.EventScanner{},
+		&models.Refund{},
+		&models.RefundItem{},
+		&models.IdempotencyKey{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.Job{},
+		&models.ScheduledJob{},
+		&models.QuarantinedBankEmail{},
+		&models.UniqueCodeAllocation{},
+		&models.MailboxCheckpoint{},
 	)
 	if err != nil {
 		log.Println("AutoMigrate failed:", err)
@@ -78,6 +100,12 @@ func ConnectDB() {
 	if !DB.Migrator().HasColumn(&models.Order{}, "referral_code") {
 		DB.Migrator().AddColumn(&models.Order{}, "referral_code")
 	}
+	if !DB.Migrator().HasColumn(&models.Order{}, "payment_provider") {
+		DB.Migrator().AddColumn(&models.Order{}, "payment_provider")
+	}
+	if !DB.Migrator().HasColumn(&models.Order{}, "payment_reference") {
+		DB.Migrator().AddColumn(&models.Order{}, "payment_reference")
+	}
 
 	// Multi-Role & Custom Fee
 	if !DB.Migrator().HasColumn(&models.Event{}, "organizer_id") {
@@ -119,6 +147,17 @@ func ConnectDB() {
 	// Data Migrations
 	DB.Exec("UPDATE events SET status = 'completed' WHERE status = 'ended'")
 	DB.Exec("UPDATE ticket_types SET available = quota WHERE available > quota OR available < 0")
+	DB.Exec("UPDATE orders SET payment_reference = payment_data WHERE payment_provider = 'flip' AND (payment_reference IS NULL OR payment_reference = '') AND payment_data <> ''")
+
+	// Single-ticket-type flash sales become campaigns with one item (see migration 000025)
+	if DB.Migrator().HasColumn(&models.FlashSale{}, "ticket_type_id") {
+		DB.Exec("INSERT INTO flash_sale_items (flash_sale_id, ticket_type_id, flash_price, quota, sold, created_at, updated_at) SELECT id, ticket_type_id, flash_price, quota, sold, created_at, updated_at FROM flash_sales WHERE ticket_type_id IS NOT NULL ON CONFLICT DO NOTHING")
+		DB.Exec("UPDATE flash_sales SET starts_at = (flash_date + NULLIF(start_time, '')::time) AT TIME ZONE 'Asia/Jakarta', ends_at = (flash_date + NULLIF(end_time, '')::time) AT TIME ZONE 'Asia/Jakarta' WHERE starts_at IS NULL AND flash_date IS NOT NULL AND start_time <> '' AND end_time <> ''")
+		DB.Exec("UPDATE flash_sales SET starts_at = created_at, ends_at = created_at, is_active = FALSE WHERE starts_at IS NULL")
+		for _, column := range []string{"ticket_type_id", "flash_price", "quota", "sold", "flash_date", "start_time", "end_time"} {
+			DB.Migrator().DropColumn(&models.FlashSale{}, column)
+		}
+	}
 }
 
 func seedSettings(db *gorm.DB) {
@@ -129,6 +168,7 @@ func seedSettings(db *gorm.DB) {
 		{Key: "facebook_url", Value: "https://facebook.com/kartcis"},
 		{Key: "twitter_url", Value: "https://twitter.com/kartcis"},
 		{Key: "instagram_url", Value: "https://instagram.com/kartcis"},
+		{Key: "payment_provider_default", Value: "manual_jago"},
 	}
 
 	for _, s := range defaults {
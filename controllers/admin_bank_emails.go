@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"errors"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGetQuarantinedBankEmails lists payment-inbox emails no bank parser could read,
+// newest first. Bodies are left out; fetch one email for its body.
+func AdminGetQuarantinedBankEmails(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.QuarantinedBankEmail{})
+	if status := c.DefaultQuery("status", "pending"); status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	emails := []models.QuarantinedBankEmail{}
+	if err := query.Omit("body").Order("id DESC").Limit(limit).Offset(offset).Find(&emails).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch quarantined emails"})
+		return
+	}
+
+	banks := make([]string, 0, len(utils.BankParsers))
+	for _, p := range utils.BankParsers {
+		banks = append(banks, p.BankName())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"emails": emails,
+			"banks":  banks,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
+		},
+	})
+}
+
+// AdminGetQuarantinedBankEmail returns one quarantined email including its body.
+func AdminGetQuarantinedBankEmail(c *gin.Context) {
+	var email models.QuarantinedBankEmail
+	if err := config.DB.First(&email, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Quarantined email not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": email})
+}
+
+// ReprocessQuarantinedBankEmail runs a quarantined email through the parsers again,
+// e.g. after a parser was fixed. "bank" forces a parser for emails from an unexpected
+// sender address.
+func ReprocessQuarantinedBankEmail(c *gin.Context) {
+	var email models.QuarantinedBankEmail
+	if err := config.DB.First(&email, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Quarantined email not found"})
+		return
+	}
+	if email.Status != "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Email has already been reviewed", "data": gin.H{"status": email.Status}})
+		return
+	}
+
+	var input struct {
+		Bank string `json:"bank"`
+	}
+	c.ShouldBindJSON(&input)
+
+	parser := utils.FindBankParser(email.From)
+	if input.Bank != "" {
+		parser = utils.FindBankParserByName(input.Bank)
+	}
+	if parser == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "message": "No bank parser recognises this email"})
+		return
+	}
+
+	record, err := utils.ProcessBankEmail(config.DB, parser, utils.BankEmail{
+		MessageID: email.MessageID,
+		From:      email.From,
+		Subject:   email.Subject,
+		Body:      email.Body,
+		Date:      email.ReceivedAt,
+	}, "Reprocess")
+	switch {
+	case errors.Is(err, utils.ErrBankEmailNotParsed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "message": parser.BankName() + " parser could not read this email"})
+		return
+	case errors.Is(err, utils.ErrBankEmailNotCredit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "message": "Email is not an incoming transfer; dismiss it instead"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to process email"})
+		return
+	}
+	if record == nil {
+		// Recorded before (e.g. the inbox delivered it twice)
+		var existing models.BankTransaction
+		if config.DB.Where("reference_id = ?", email.MessageID).First(&existing).Error == nil {
+			record = &existing
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      "reprocessed",
+		"bank_name":   parser.BankName(),
+		"reviewed_by": currentUserID(c),
+		"reviewed_at": now,
+	}
+	if record != nil {
+		updates["bank_transaction_id"] = record.ID
+	}
+	config.DB.Model(&email).Updates(updates)
+	config.DB.First(&email, email.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Email reprocessed", "data": gin.H{"email": email, "transaction": record}})
+}
+
+// DismissQuarantinedBankEmail marks a quarantined email as reviewed without recording
+// a transaction (promotions, statements, ...).
+func DismissQuarantinedBankEmail(c *gin.Context) {
+	var email models.QuarantinedBankEmail
+	if err := config.DB.First(&email, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Quarantined email not found"})
+		return
+	}
+	if email.Status != "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Email has already been reviewed", "data": gin.H{"status": email.Status}})
+		return
+	}
+
+	var input struct {
+		Notes string `json:"notes"`
+	}
+	c.ShouldBindJSON(&input)
+
+	now := time.Now()
+	if err := config.DB.Model(&email).Updates(map[string]interface{}{
+		"status":       "dismissed",
+		"reviewed_by":  currentUserID(c),
+		"reviewed_at":  now,
+		"review_notes": input.Notes,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to dismiss email"})
+		return
+	}
+	config.DB.First(&email, email.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Email dismissed", "data": email})
+}
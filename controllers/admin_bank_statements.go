@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxStatementFileSize = 5 << 20
+	// A statement transfer with a time is the same as a notification email within this
+	statementEmailWindow = 15 * time.Minute
+)
+
+// Outcome of one statement row.
+const (
+	statementRowMatched     = "matched"      // Recorded and paid an order now
+	statementRowAlreadyPaid = "already_paid" // The transfer's order was already paid (notification email, admin)
+	statementRowUnmatched   = "unmatched"    // Recorded for the reconciliation console (see status)
+	statementRowDuplicate   = "duplicate"    // Already recorded by an earlier import or email
+	statementRowFailed      = "failed"
+)
+
+type statementRowResult struct {
+	Line              int       `json:"line"`
+	Date              time.Time `json:"date"`
+	Amount            float64   `json:"amount"`
+	Sender            string    `json:"sender"`
+	Result            string    `json:"result"`
+	Status            string    `json:"status,omitempty"` // Status of the bank transaction
+	BankTransactionID uint      `json:"bank_transaction_id,omitempty"`
+	OrderID           uint      `json:"order_id,omitempty"`
+	OrderNumber       string    `json:"order_number,omitempty"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// ImportBankStatement reads a mutation statement downloaded from internet banking
+// (multipart `file` as .csv, .xlsx or .xls, and `bank`) and runs every incoming transfer
+// through the same matching as the payment inbox checker. Rows are recorded once (by a
+// hash of the row), so overlapping statements can be uploaded again.
+func ImportBankStatement(c *gin.Context) {
+	parser := utils.FindBankParserByName(c.PostForm("bank"))
+	if parser == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "bank is required (Bank Jago, BCA, Mandiri or BRI)"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Statement file is required"})
+		return
+	}
+	if file.Size > maxStatementFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Statement file is larger than 5 MB"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Failed to read statement file"})
+		return
+	}
+	defer f.Close()
+
+	statement, err := utils.ParseBankStatement(file.Filename, f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	source := fmt.Sprintf("Import by admin #%d", currentUserID(c))
+	rules := utils.LoadBankMatchRules(config.DB)
+	occurrences := map[string]int{}
+	claimed := map[uint]bool{} // Email transfers already paired with a row of this file
+	summary := map[string]int{"rows": len(statement.Rows), "skipped": statement.Skipped}
+	results := make([]statementRowResult, 0, len(statement.Rows))
+
+	for _, row := range statement.Rows {
+		first := utils.StatementReference(parser.BankName(), row, 0)
+		reference := utils.StatementReference(parser.BankName(), row, occurrences[first])
+		occurrences[first]++
+
+		result := importStatementRow(parser, row, reference, file.Filename, source, rules, claimed)
+		summary[result.Result]++
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("%d transfers read: %d matched, %d already paid, %d unmatched",
+			len(results), summary[statementRowMatched], summary[statementRowAlreadyPaid], summary[statementRowUnmatched]),
+		"data": gin.H{"bank": parser.BankName(), "summary": summary, "rows": results},
+	})
+}
+
+func importStatementRow(parser utils.BankNotificationParser, row utils.StatementRow, reference, filename, source string, rules utils.BankMatchRules, claimed map[uint]bool) statementRowResult {
+	result := statementRowResult{Line: row.Line, Date: row.Date, Amount: row.Amount, Sender: row.Sender}
+
+	var existing models.BankTransaction
+	if config.DB.Where("reference_id = ?", reference).First(&existing).Error == nil {
+		return result.withTransaction(statementRowDuplicate, existing)
+	}
+
+	// The same transfer read earlier from a notification email
+	if known := findEmailedTransfer(parser.BankName(), row, claimed); known != nil {
+		claimed[known.ID] = true
+		if known.Status == "matched" {
+			return result.withTransaction(statementRowAlreadyPaid, *known)
+		}
+		return result.withTransaction(statementRowDuplicate, *known)
+	}
+
+	// Paid another way (gateway, admin) and no unpaid order to give the transfer to
+	match, err := utils.FindBankMatch(config.DB, rules, row.Amount, row.Date)
+	if err != nil {
+		result.Result, result.Error = statementRowFailed, err.Error()
+		return result
+	}
+	if match == nil {
+		if order := findPaidOrderForTransfer(parser, rules, row); order != nil {
+			result.Result, result.OrderID, result.OrderNumber = statementRowAlreadyPaid, order.ID, order.OrderNumber
+			return result
+		}
+	}
+
+	record, err := utils.RecordBankTransfer(config.DB, parser, utils.BankTransfer{
+		ReferenceID: reference,
+		Amount:      row.Amount,
+		Sender:      row.Sender,
+		Date:        row.Date,
+		RawData:     fmt.Sprintf("%s line %d: %s", filename, row.Line, row.Description),
+		Via:         parser.BankName() + " statement",
+	}, source)
+	switch {
+	case err != nil:
+		result.Result, result.Error = statementRowFailed, err.Error()
+		return result
+	case record == nil:
+		// Recorded by a concurrent import
+		result.Result = statementRowDuplicate
+		return result
+	case record.Status == "matched":
+		return result.withTransaction(statementRowMatched, *record)
+	}
+	return result.withTransaction(statementRowUnmatched, *record)
+}
+
+func (r statementRowResult) withTransaction(outcome string, transaction models.BankTransaction) statementRowResult {
+	r.Result = outcome
+	r.Status = transaction.Status
+	r.BankTransactionID = transaction.ID
+	if transaction.OrderID != nil {
+		var order models.Order
+		if config.DB.Select("id", "order_number").First(&order, *transaction.OrderID).Error == nil {
+			r.OrderID, r.OrderNumber = order.ID, order.OrderNumber
+		}
+	}
+	return r
+}
+
+// findEmailedTransfer looks for a transfer of the same bank and amount recorded from a
+// notification email on the row's day (or within 15 minutes when the row has a time).
+func findEmailedTransfer(bankName string, row utils.StatementRow, claimed map[uint]bool) *models.BankTransaction {
+	from, to := row.Date.Add(-statementEmailWindow), row.Date.Add(statementEmailWindow)
+	if row.DateOnly {
+		from, to = row.Date.Add(-24*time.Hour+time.Second), row.Date
+	}
+
+	var transfers []models.BankTransaction
+	config.DB.Omit("raw_data").
+		Where("bank_name = ? AND amount = ? AND reference_id NOT LIKE ?", bankName, row.Amount, "stmt:%").
+		Where("transaction_date BETWEEN ? AND ?", from, to).
+		Order("transaction_date").Find(&transfers)
+	for i := range transfers {
+		if !claimed[transfers[i].ID] {
+			return &transfers[i]
+		}
+	}
+	return nil
+}
+
+// findPaidOrderForTransfer finds a paid order of exactly the amount, payable into the
+// bank, created in the window the matcher looks at.
+func findPaidOrderForTransfer(parser utils.BankNotificationParser, rules utils.BankMatchRules, row utils.StatementRow) *models.Order {
+	var orders []models.Order
+	config.DB.Where("status = ? AND total_amount = ?", utils.OrderStatusPaid, row.Amount).
+		Where("created_at BETWEEN ? AND ?", row.Date.Add(-rules.OrderLookback), row.Date.Add(rules.OrderLookbackAfter)).
+		Order("created_at DESC").Find(&orders)
+	for i := range orders {
+		if parser.AcceptsPaymentMethod(orders[i].PaymentMethod) {
+			return &orders[i]
+		}
+	}
+	return nil
+}
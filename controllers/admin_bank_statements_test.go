@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func uploadStatement(r http.Handler, bank, filename, content string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("bank", bank)
+	part, _ := form.CreateFormFile("file", filename)
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/bank-transactions/import", &buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func statementResults(resp map[string]interface{}) map[float64]map[string]interface{} {
+	results := map[float64]map[string]interface{}{}
+	for _, row := range resp["data"].(map[string]interface{})["rows"].([]interface{}) {
+		row := row.(map[string]interface{})
+		results[row["amount"].(float64)] = row
+	}
+	return results
+}
+
+func TestImportBankStatement_MatchesAndReports(t *testing.T) {
+	setupControllerDB(t)
+	now := time.Now()
+	wib := time.FixedZone("WIB", 7*3600)
+	at := func(d time.Duration) string { return now.Add(d).In(wib).Format("02/01/2006 15:04:05") }
+
+	pending := models.Order{OrderNumber: "ORD-STMT-1", Status: "pending", TotalAmount: 150123, PaymentMethod: "MANUAL_BCA", CreatedAt: now.Add(-time.Hour)}
+	paidByEmail := models.Order{OrderNumber: "ORD-STMT-2", Status: "paid", TotalAmount: 75042, PaymentMethod: "MANUAL_BCA", CreatedAt: now.Add(-time.Hour)}
+	paidByAdmin := models.Order{OrderNumber: "ORD-STMT-3", Status: "paid", TotalAmount: 50500, PaymentMethod: "MANUAL_BCA", CreatedAt: now.Add(-time.Hour)}
+	for _, o := range []*models.Order{&pending, &paidByEmail, &paidByAdmin} {
+		config.DB.Create(o)
+	}
+	config.DB.Create(&models.BankTransaction{ReferenceID: "<bca-email@mail>", OrderID: &paidByEmail.ID, Amount: 75042, BankName: "BCA", Status: "matched", TransactionDate: now.Add(-20 * time.Minute)})
+
+	csv := "Tanggal;Keterangan;Nama Pengirim;Debit;Kredit\n" +
+		fmt.Sprintf("%s;TRSF CR;BUDI SANTOSO;0;150.123,00\n", at(-30*time.Minute)) +
+		fmt.Sprintf("%s;TRSF CR;SITI AMINAH;0;75.042,00\n", at(-19*time.Minute)) +
+		fmt.Sprintf("%s;TRSF CR;ANDI;0;50.500,00\n", at(-10*time.Minute)) +
+		fmt.Sprintf("%s;TRSF CR;UNKNOWN PAYER;0;99.999,00\n", at(-5*time.Minute)) +
+		fmt.Sprintf("%s;BIAYA ADM;;17.000,00;0\n", at(-time.Minute))
+
+	r := gin.New()
+	r.Use(asUser(1, "admin"))
+	r.POST("/bank-transactions/import", ImportBankStatement)
+
+	w, resp := uploadStatement(r, "BCA", "mutasi.csv", csv)
+	assert.Equal(t, http.StatusOK, w.Code)
+	summary := resp["data"].(map[string]interface{})["summary"].(map[string]interface{})
+	assert.Equal(t, 4.0, summary["rows"])
+	assert.Equal(t, 1.0, summary["skipped"])
+	assert.Equal(t, 1.0, summary["matched"])
+	assert.Equal(t, 2.0, summary["already_paid"])
+	assert.Equal(t, 1.0, summary["unmatched"])
+
+	results := statementResults(resp)
+	assert.Equal(t, "matched", results[150123]["result"])
+	assert.Equal(t, "ORD-STMT-1", results[150123]["order_number"])
+	assert.Equal(t, "already_paid", results[75042]["result"])
+	assert.Equal(t, "ORD-STMT-2", results[75042]["order_number"])
+	assert.Equal(t, "already_paid", results[50500]["result"])
+	assert.Equal(t, "ORD-STMT-3", results[50500]["order_number"])
+	assert.Equal(t, "unmatched", results[99999]["result"])
+	assert.Equal(t, "unmatched", results[99999]["status"])
+
+	config.DB.First(&pending, pending.ID)
+	assert.Equal(t, "paid", pending.Status)
+	var history models.OrderStatusHistory
+	config.DB.Where("order_id = ? AND status = ?", pending.ID, "paid").First(&history)
+	assert.Contains(t, history.Notes, "BCA statement")
+
+	// The same statement again: nothing is recorded twice
+	w, resp = uploadStatement(r, "BCA", "mutasi.csv", csv)
+	assert.Equal(t, http.StatusOK, w.Code)
+	results = statementResults(resp)
+	assert.Equal(t, "duplicate", results[150123]["result"])
+	assert.Equal(t, "duplicate", results[99999]["result"])
+	assert.Equal(t, "already_paid", results[75042]["result"])
+
+	var count int64
+	config.DB.Model(&models.BankTransaction{}).Count(&count)
+	assert.Equal(t, int64(3), count, "the email transfer plus two statement rows")
+}
+
+func TestImportBankStatement_Validation(t *testing.T) {
+	setupControllerDB(t)
+	r := gin.New()
+	r.Use(asUser(1, "admin"))
+	r.POST("/bank-transactions/import", ImportBankStatement)
+
+	w, _ := uploadStatement(r, "Bank Antah", "mutasi.csv", "Tanggal,Jumlah\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, resp := uploadStatement(r, "BCA", "mutasi.csv", "Nama,Alamat\nBudi,Jakarta\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "header")
+}
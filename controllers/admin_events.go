@@ -3,6 +3,7 @@ package controllers
 import (
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -52,7 +53,7 @@ func AdminGetEvents(c *gin.Context) {
 	query.Count(&totalItems)
 
 	// Fetch Data
-	query.Preload("Category").Preload("TicketTypes").Order("updated_at desc").Limit(limit).Offset(offset).Find(&events)
+	query.Preload("Category").Preload("TicketTypes.PriceTiers", orderPriceTiers).Preload("TicketTypes.BundleItems.TicketType").Order("updated_at desc").Limit(limit).Offset(offset).Find(&events)
 
 	totalPages := int(totalItems) / limit
 	if int(totalItems)%limit != 0 {
@@ -74,26 +75,30 @@ func AdminGetEvents(c *gin.Context) {
 }
 
 type EventRequest struct {
-	Title               string               `json:"title"`
-	Slug                string               `json:"slug"`
-	Description         string               `json:"description"`
-	DetailedDescription string               `json:"detailed_description"`
-	EventDate           string               `json:"event_date"` // Change to string for flexible parsing
-	EventTime           string               `json:"event_time"`
-	Venue               string               `json:"venue"`
-	City                string               `json:"city"`
-	Organizer           string               `json:"organizer"`
-	OrganizerID         uint                 `json:"organizer_id"` // Added for Admin assignment
-	Image               string               `json:"image"`
-	Quota               int                  `json:"quota"`
-	IsFeatured          *bool                `json:"is_featured"` // Use pointer for boolean
-	Status              string               `json:"status"`
-	CategoryID          uint                 `json:"category_id"`
-	MinPrice            float64              `json:"min_price"`
-	MaxPrice            float64              `json:"max_price"`
-	FeePercentage       float64              `json:"fee_percentage"`
-	CustomFields        string               `json:"custom_fields"`
-	TicketTypes         *[]models.TicketType `json:"ticket_types"` // Use pointer to distinguish between nil (omitted) and [] (empty)
+	Title                   string               `json:"title"`
+	Slug                    string               `json:"slug"`
+	Description             string               `json:"description"`
+	DetailedDescription     string               `json:"detailed_description"`
+	EventDate               string               `json:"event_date"` // Change to string for flexible parsing
+	EventTime               string               `json:"event_time"`
+	Venue                   string               `json:"venue"`
+	City                    string               `json:"city"`
+	Organizer               string               `json:"organizer"`
+	OrganizerID             uint                 `json:"organizer_id"` // Added for Admin assignment
+	Image                   string               `json:"image"`
+	Quota                   int                  `json:"quota"`
+	IsFeatured              *bool                `json:"is_featured"` // Use pointer for boolean
+	Status                  string               `json:"status"`
+	CategoryID              uint                 `json:"category_id"`
+	MinPrice                float64              `json:"min_price"`
+	MaxPrice                float64              `json:"max_price"`
+	FeePercentage           float64              `json:"fee_percentage"`
+	CustomFields            string               `json:"custom_fields"`
+	HoldMinutes             int                  `json:"hold_minutes"` // Seat hold / payment window, default 30
+	WaitingRoom             *bool                `json:"waiting_room"`
+	WaitingRoomRate         int                  `json:"waiting_room_rate"`          // Visitors admitted per minute, default 100
+	WaitingRoomAdmitMinutes int                  `json:"waiting_room_admit_minutes"` // Checkout window after admission, default 15
+	TicketTypes             *[]models.TicketType `json:"ticket_types"`               // Use pointer to distinguish between nil (omitted) and [] (empty)
 }
 
 func parseEventDate(dateStr string) (time.Time, error) {
@@ -160,6 +165,17 @@ func CreateEvent(c *gin.Context) {
 	if req.TicketTypes != nil {
 		ticketTypes = *req.TicketTypes
 	}
+	for i := range ticketTypes {
+		if err := validateTicketType(&ticketTypes[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		// Bundles point at ticket types by ID, which only exist once the event does
+		if len(ticketTypes[i].BundleItems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Ticket type '" + ticketTypes[i].Name + "': bundle_items can only be set once the event exists"})
+			return
+		}
+	}
 
 	// Calculate automatic fields from ticket types if available
 	var minPrice, maxPrice float64
@@ -254,10 +270,20 @@ func CreateEvent(c *gin.Context) {
 		MaxPrice:            maxPrice, // Auto calculated
 		FeePercentage:       finalFee,
 		CustomFields:        req.CustomFields,
+		HoldMinutes:         req.HoldMinutes,
 		TicketTypes:         ticketTypes,
 	}
+	if req.WaitingRoom != nil {
+		input.WaitingRoom = *req.WaitingRoom
+	}
+	input.WaitingRoomRate = req.WaitingRoomRate
+	input.WaitingRoomAdmitMinutes = req.WaitingRoomAdmitMinutes
 
 	// Basic defaults
+	if input.HoldMinutes <= 0 {
+		input.HoldMinutes = utils.DefaultHoldMinutes
+	}
+
 	if input.Slug == "" {
 		input.Slug = generateSlug(input.Title)
 	}
@@ -289,6 +315,13 @@ func CreateEvent(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create ticket types"})
 			return
 		}
+		// Early bird tiers open today set the starting price
+		if err := utils.SyncEventPrices(tx, input.ID, time.Now()); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update event prices"})
+			return
+		}
+		tx.Select("min_price", "max_price").First(&input, input.ID)
 	}
 
 	tx.Commit()
@@ -358,6 +391,18 @@ func UpdateEvent(c *gin.Context) {
 	if req.IsFeatured != nil {
 		updates["is_featured"] = *req.IsFeatured
 	}
+	if req.HoldMinutes > 0 {
+		updates["hold_minutes"] = req.HoldMinutes
+	}
+	if req.WaitingRoom != nil {
+		updates["waiting_room"] = *req.WaitingRoom
+	}
+	if req.WaitingRoomRate > 0 {
+		updates["waiting_room_rate"] = req.WaitingRoomRate
+	}
+	if req.WaitingRoomAdmitMinutes > 0 {
+		updates["waiting_room_admit_minutes"] = req.WaitingRoomAdmitMinutes
+	}
 
 	// Parsing Date if provided
 	if req.EventDate != "" {
@@ -406,6 +451,13 @@ func UpdateEvent(c *gin.Context) {
 	// Handle Ticket Types Sync if provided
 	if req.TicketTypes != nil {
 		newTTs := *req.TicketTypes
+		for i := range newTTs {
+			if err := validateTicketType(&newTTs[i]); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+				return
+			}
+		}
 
 		// AUTO CALCULATE from Ticket Types
 		var minPrice, maxPrice float64
@@ -420,7 +472,10 @@ func UpdateEvent(c *gin.Context) {
 				if tt.Price > maxPrice {
 					maxPrice = tt.Price
 				}
-				totalQuota += tt.Quota
+				// A bundle's seats are those of its admissions
+				if len(tt.BundleItems) == 0 {
+					totalQuota += tt.Quota
+				}
 			}
 			updates["min_price"] = minPrice
 			updates["max_price"] = maxPrice
@@ -438,11 +493,15 @@ func UpdateEvent(c *gin.Context) {
 		var existingTTs []models.TicketType
 		tx.Where("event_id = ?", event.ID).Find(&existingTTs)
 
+		keptIDs := make([]uint, 0, len(providedIDs))
+		for id := range providedIDs {
+			keptIDs = append(keptIDs, id)
+		}
 		for _, exTT := range existingTTs {
 			if !providedIDs[exTT.ID] {
 				// Safety check: is it sold?
 				var count int64
-				tx.Model(&models.Ticket{}).Where("ticket_type_id = ?", exTT.ID).Count(&count)
+				tx.Model(&models.Ticket{}).Where("ticket_type_id = ? OR bundle_ticket_type_id = ?", exTT.ID, exTT.ID).Count(&count)
 				if count > 0 {
 					tx.Rollback()
 					c.JSON(http.StatusBadRequest, gin.H{
@@ -451,6 +510,23 @@ func UpdateEvent(c *gin.Context) {
 					})
 					return
 				}
+				// ...or part of a bundle that stays?
+				if len(keptIDs) > 0 {
+					tx.Model(&models.BundleItem{}).Where("ticket_type_id = ? AND bundle_id IN ?", exTT.ID, keptIDs).Count(&count)
+				}
+				if count > 0 {
+					tx.Rollback()
+					c.JSON(http.StatusBadRequest, gin.H{
+						"success": false,
+						"message": "Cannot delete ticket type '" + exTT.Name + "' because it is part of a bundle.",
+					})
+					return
+				}
+				if err := tx.Where("bundle_id = ? OR ticket_type_id = ?", exTT.ID, exTT.ID).Delete(&models.BundleItem{}).Error; err != nil {
+					tx.Rollback()
+					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to delete old ticket type"})
+					return
+				}
 				if err := tx.Delete(&exTT).Error; err != nil {
 					tx.Rollback()
 					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to delete old ticket type"})
@@ -459,8 +535,14 @@ func UpdateEvent(c *gin.Context) {
 			}
 		}
 
-		// 2. Update existing or Create new
-		for _, tt := range newTTs {
+		// 2. Update existing or Create new. Bundle items are saved once every ticket type
+		// exists, as a bundle may include one created in this request.
+		bundleItems := make(map[int][]models.BundleItem)
+		for i, tt := range newTTs {
+			if tt.BundleItems != nil {
+				bundleItems[i] = tt.BundleItems
+			}
+			tt.BundleItems = nil
 			tt.EventID = event.ID
 			if tt.ID == 0 {
 				// New
@@ -470,6 +552,7 @@ func UpdateEvent(c *gin.Context) {
 					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create new ticket type"})
 					return
 				}
+				newTTs[i].ID = tt.ID
 			} else {
 				// Update existing: Adjust 'Available' based on change in 'Quota'
 				var oldTT models.TicketType
@@ -492,12 +575,34 @@ func UpdateEvent(c *gin.Context) {
 				}
 
 				if err := tx.Model(&models.TicketType{}).Where("id = ? AND event_id = ?", tt.ID, event.ID).
-					Select("Name", "Description", "Price", "OriginalPrice", "Quota", "Available", "MaxPurchasePerUser").
+					Select("Name", "Description", "Price", "OriginalPrice", "Quota", "Available", "MaxPurchasePerUser",
+						"SalesStart", "SalesEnd", "MinPurchase", "Hidden", "AccessCode").
 					Updates(tt).Error; err != nil {
 					tx.Rollback()
 					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update ticket type"})
 					return
 				}
+				// Recompute from paid tickets and live holds so a quota change can't drift
+				if err := utils.SyncAvailability(tx, tt.ID); err != nil {
+					tx.Rollback()
+					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update ticket availability"})
+					return
+				}
+				if tt.PriceTiers != nil {
+					if err := utils.SavePriceTiers(tx, tt.ID, tt.PriceTiers); err != nil {
+						tx.Rollback()
+						respondPriceTierError(c, err)
+						return
+					}
+				}
+			}
+		}
+		for i, items := range bundleItems {
+			newTTs[i].EventID = event.ID
+			if err := utils.SaveBundleItems(tx, newTTs[i], items); err != nil {
+				tx.Rollback()
+				respondBundleError(c, err)
+				return
 			}
 		}
 
@@ -511,12 +616,18 @@ func UpdateEvent(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update event totals"})
 			return
 		}
+		// Live price tiers override the ticket types' base prices
+		if err := utils.SyncEventPrices(tx, event.ID, time.Now()); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update event totals"})
+			return
+		}
 	}
 
 	tx.Commit()
 
 	// Load updated event with associations for response
-	config.DB.Preload("Category").Preload("TicketTypes").First(&event, id)
+	config.DB.Preload("Category").Preload("TicketTypes.PriceTiers", orderPriceTiers).Preload("TicketTypes.BundleItems.TicketType").First(&event, id)
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": event})
 }
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/queue"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminGetJobs lists background jobs, newest first, with a count per queue and status.
+func AdminGetJobs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.Job{})
+	if name := c.Query("queue"); name != "" {
+		query = query.Where("queue = ?", name)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if jobType := c.Query("type"); jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	jobs := []models.Job{}
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch jobs"})
+		return
+	}
+
+	type queueCount struct {
+		Queue  string `json:"queue"`
+		Status string `json:"status"`
+		Count  int64  `json:"count"`
+	}
+	summary := []queueCount{}
+	config.DB.Model(&models.Job{}).Select("queue, status, COUNT(*) AS count").Group("queue, status").Order("queue, status").Scan(&summary)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"jobs":    jobs,
+			"summary": summary,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
+		},
+	})
+}
+
+// AdminGetJobDetail returns one job including its payload and last error.
+func AdminGetJobDetail(c *gin.Context) {
+	var job models.Job
+	if err := config.DB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": job})
+}
+
+// RetryJob requeues a dead or cancelled job with a fresh set of attempts.
+func RetryJob(c *gin.Context) {
+	changeJobStatus(c, queue.Retry, "Job requeued")
+}
+
+// CancelJob stops a pending job (including one waiting for a retry) from running.
+func CancelJob(c *gin.Context) {
+	changeJobStatus(c, queue.Cancel, "Job cancelled")
+}
+
+func changeJobStatus(c *gin.Context, change func(db *gorm.DB, id uint) error, message string) {
+	var job models.Job
+	if err := config.DB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Job not found"})
+		return
+	}
+
+	if err := change(config.DB, job.ID); err != nil {
+		if errors.Is(err, queue.ErrJobNotRetryable) || errors.Is(err, queue.ErrJobNotCancellable) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error(), "data": gin.H{"status": job.Status}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update job"})
+		return
+	}
+
+	config.DB.First(&job, job.ID)
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": message, "data": job})
+}
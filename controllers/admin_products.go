@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ProductVariantRequest struct {
+	ID       uint     `json:"id"` // Existing variant to update, 0 for a new one
+	Name     string   `json:"name"`
+	Price    *float64 `json:"price"` // Omit to use the product's price
+	Stock    int      `json:"stock"`
+	Position int      `json:"position"`
+}
+
+type ProductRequest struct {
+	EventID     uint                    `json:"event_id" binding:"required"`
+	Name        string                  `json:"name" binding:"required"`
+	Description string                  `json:"description"`
+	Image       string                  `json:"image"`
+	Price       float64                 `json:"price"`
+	Stock       int                     `json:"stock"`
+	MaxPerOrder int                     `json:"max_per_order"`
+	IsActive    *bool                   `json:"is_active"`
+	Variants    []ProductVariantRequest `json:"variants"`
+}
+
+type UpdateProductRequest struct {
+	Name        *string                 `json:"name"`
+	Description *string                 `json:"description"`
+	Image       *string                 `json:"image"`
+	Price       *float64                `json:"price"`
+	Stock       *int                    `json:"stock"`
+	MaxPerOrder *int                    `json:"max_per_order"`
+	IsActive    *bool                   `json:"is_active"`
+	Variants    []ProductVariantRequest `json:"variants"` // Replaces the variants when given
+}
+
+func validateProduct(product models.Product) error {
+	if product.Name == "" {
+		return errors.New("name is required")
+	}
+	if product.Price < 0 || product.Stock < 0 || product.MaxPerOrder < 0 {
+		return errors.New("price, stock and max_per_order can't be negative")
+	}
+	return nil
+}
+
+func productVariants(variants []ProductVariantRequest) []models.ProductVariant {
+	result := make([]models.ProductVariant, 0, len(variants))
+	for _, variant := range variants {
+		result = append(result, models.ProductVariant{ID: variant.ID, Name: variant.Name, Price: variant.Price, Stock: variant.Stock, Position: variant.Position})
+	}
+	return result
+}
+
+// activeProducts preloads the products on sale, for public payloads.
+func activeProducts(db *gorm.DB) *gorm.DB {
+	return db.Where("is_active = ?", true).Order("id")
+}
+
+// orderProductVariants preloads a product's variants in display order.
+func orderProductVariants(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+// findManagedProduct loads a product of an event the current admin/organizer manages.
+func findManagedProduct(c *gin.Context) (*models.Product, bool) {
+	var product models.Product
+	if err := config.DB.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Product not found"})
+		return nil, false
+	}
+	if _, ok := findManagedEvent(c, fmt.Sprint(product.EventID)); !ok {
+		return nil, false
+	}
+	return &product, true
+}
+
+func respondProductVariantError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidVariant):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+	case errors.Is(err, utils.ErrVariantInUse):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot delete a variant that was ordered", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to save product variants"})
+	}
+}
+
+// AdminGetProducts lists the add-on products (merch, parking, ...) of the managed events.
+func AdminGetProducts(c *gin.Context) {
+	products := []models.Product{}
+	query := config.DB.Preload("Variants", orderProductVariants).Order("event_id, id")
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+	if role, _ := c.Get("userRole"); role == "organizer" {
+		userID, _ := c.Get("userID")
+		query = query.Where("event_id IN (?)", config.DB.Model(&models.Event{}).Select("id").Where("organizer_id = ?", userID))
+	}
+
+	if err := query.Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch products"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": products})
+}
+
+func CreateProduct(c *gin.Context) {
+	var req ProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
+		return
+	}
+	if _, ok := findManagedEvent(c, fmt.Sprint(req.EventID)); !ok {
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	product := models.Product{
+		EventID:     req.EventID,
+		Name:        req.Name,
+		Description: req.Description,
+		Image:       req.Image,
+		Price:       req.Price,
+		Stock:       req.Stock,
+		MaxPerOrder: req.MaxPerOrder,
+		IsActive:    isActive,
+	}
+	if err := validateProduct(product); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := tx.Create(&product).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create product"})
+		return
+	}
+	if err := utils.SaveProductVariants(tx, product.ID, productVariants(req.Variants)); err != nil {
+		tx.Rollback()
+		respondProductVariantError(c, err)
+		return
+	}
+	tx.Commit()
+	config.DB.Preload("Variants", orderProductVariants).First(&product, product.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Product created", "data": product})
+}
+
+func UpdateProduct(c *gin.Context) {
+	product, ok := findManagedProduct(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
+		return
+	}
+
+	if req.Name != nil {
+		product.Name = *req.Name
+	}
+	if req.Description != nil {
+		product.Description = *req.Description
+	}
+	if req.Image != nil {
+		product.Image = *req.Image
+	}
+	if req.Price != nil {
+		product.Price = *req.Price
+	}
+	if req.Stock != nil {
+		product.Stock = *req.Stock
+	}
+	if req.MaxPerOrder != nil {
+		product.MaxPerOrder = *req.MaxPerOrder
+	}
+	if req.IsActive != nil {
+		product.IsActive = *req.IsActive
+	}
+	if err := validateProduct(*product); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := tx.Model(&models.Product{}).Where("id = ?", product.ID).Updates(map[string]interface{}{
+		"name":          product.Name,
+		"description":   product.Description,
+		"image":         product.Image,
+		"price":         product.Price,
+		"stock":         product.Stock,
+		"max_per_order": product.MaxPerOrder,
+		"is_active":     product.IsActive,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update product"})
+		return
+	}
+	if req.Variants != nil {
+		if err := utils.SaveProductVariants(tx, product.ID, productVariants(req.Variants)); err != nil {
+			tx.Rollback()
+			respondProductVariantError(c, err)
+			return
+		}
+	}
+	tx.Commit()
+	config.DB.Preload("Variants", orderProductVariants).First(product, product.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product updated", "data": product})
+}
+
+// DeleteProduct removes a product that was never ordered; deactivate it otherwise.
+func DeleteProduct(c *gin.Context) {
+	product, ok := findManagedProduct(c)
+	if !ok {
+		return
+	}
+
+	var count int64
+	config.DB.Model(&models.OrderAddOn{}).Where("product_id = ?", product.ID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot delete product with existing orders, deactivate it instead"})
+		return
+	}
+
+	config.DB.Transaction(func(tx *gorm.DB) error {
+		tx.Where("product_id = ?", product.ID).Delete(&models.ProductVariant{})
+		return tx.Delete(product).Error
+	})
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product deleted"})
+}
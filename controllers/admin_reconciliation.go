@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Bank transactions the payment checker couldn't attach to an order.
+var unreconciledBankStatuses = []string{"unmatched", "method_mismatch", "partial", "overpaid", "quota_unavailable"}
+
+const (
+	defaultCandidateTolerance = 1000.0 // Rp; covers a mistyped unique code
+	candidateWindowBefore     = 72 * time.Hour
+	candidateWindowAfter      = time.Hour
+	maxReconcileCandidates    = 20
+)
+
+var errBankTransactionReconciled = errors.New("bank transaction is already linked to an order")
+
+// AdminGetUnmatchedBankTransactions lists transfers waiting for manual reconciliation,
+// newest first. ?status=unmatched or method_mismatch narrows the list.
+func AdminGetUnmatchedBankTransactions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.BankTransaction{}).Where("status IN ?", unreconciledBankStatuses)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if bank := c.Query("bank"); bank != "" {
+		query = query.Where("bank_name = ?", bank)
+	}
+	if search := c.Query("search"); search != "" {
+		query = query.Where("LOWER(sender) LIKE ?", "%"+strings.ToLower(search)+"%")
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	transactions := []models.BankTransaction{}
+	if err := query.Omit("raw_data").Order("transaction_date DESC, id DESC").Limit(limit).Offset(offset).Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch bank transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"transactions": transactions,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
+		},
+	})
+}
+
+// AdminGetBankTransactionDetail returns one bank transaction including the raw email.
+func AdminGetBankTransactionDetail(c *gin.Context) {
+	var transaction models.BankTransaction
+	if err := config.DB.First(&transaction, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Bank transaction not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": transaction})
+}
+
+type reconcileCandidate struct {
+	Order      models.Order `json:"order"`
+	Score      int          `json:"score"`
+	AmountDiff float64      `json:"amount_diff"` // Order total minus transferred amount
+	Reasons    []string     `json:"reasons"`
+}
+
+// GetReconcileCandidates suggests orders a transfer could belong to: unpaid orders
+// created from 72h before to 1h after the transfer whose total is within ?tolerance
+// (Rp, default 1000) of the amount or whose customer name matches the sender.
+func GetReconcileCandidates(c *gin.Context) {
+	var transaction models.BankTransaction
+	if err := config.DB.First(&transaction, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Bank transaction not found"})
+		return
+	}
+
+	tolerance := defaultCandidateTolerance
+	if v, err := strconv.ParseFloat(c.Query("tolerance"), 64); err == nil && v >= 0 {
+		tolerance = v
+	}
+
+	query := config.DB.Model(&models.Order{}).
+		Where("status IN ?", []string{utils.OrderStatusPending, utils.OrderStatusExpired, utils.OrderStatusCancelled}).
+		Where("created_at BETWEEN ? AND ?", transaction.TransactionDate.Add(-candidateWindowBefore), transaction.TransactionDate.Add(candidateWindowAfter))
+
+	sender := strings.ToLower(strings.TrimSpace(transaction.Sender))
+	if sender != "" && sender != "unknown" {
+		query = query.Where("(total_amount BETWEEN ? AND ? OR LOWER(customer_name) LIKE ?)",
+			transaction.Amount-tolerance, transaction.Amount+tolerance, "%"+sender+"%")
+	} else {
+		query = query.Where("total_amount BETWEEN ? AND ?", transaction.Amount-tolerance, transaction.Amount+tolerance)
+	}
+
+	var orders []models.Order
+	if err := query.Order("created_at DESC").Limit(100).Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch candidate orders"})
+		return
+	}
+
+	candidates := make([]reconcileCandidate, 0, len(orders))
+	for _, order := range orders {
+		candidates = append(candidates, scoreReconcileCandidate(transaction, order, tolerance))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > maxReconcileCandidates {
+		candidates = candidates[:maxReconcileCandidates]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"transaction": transaction, "candidates": candidates}})
+}
+
+// scoreReconcileCandidate ranks an order: exact amount beats a near amount, a matching
+// name adds weight, and orders placed closer to the transfer rank higher.
+func scoreReconcileCandidate(transaction models.BankTransaction, order models.Order, tolerance float64) reconcileCandidate {
+	candidate := reconcileCandidate{Order: order, AmountDiff: order.TotalAmount - transaction.Amount, Reasons: []string{}}
+
+	switch diff := math.Abs(candidate.AmountDiff); {
+	case diff == 0:
+		candidate.Score += 60
+		candidate.Reasons = append(candidate.Reasons, "exact_amount")
+	case diff <= tolerance:
+		candidate.Score += 30
+		candidate.Reasons = append(candidate.Reasons, "near_amount")
+	}
+
+	sender := strings.ToLower(strings.TrimSpace(transaction.Sender))
+	name := strings.ToLower(strings.TrimSpace(order.CustomerName))
+	if sender != "" && name != "" && (strings.Contains(name, sender) || strings.Contains(sender, name)) {
+		candidate.Score += 30
+		candidate.Reasons = append(candidate.Reasons, "sender_name")
+	}
+
+	// Up to 10 points for orders placed within a day before the transfer
+	gap := transaction.TransactionDate.Sub(order.CreatedAt)
+	if gap >= -candidateWindowAfter && gap <= 24*time.Hour {
+		candidate.Score += 10 - int(math.Abs(gap.Hours())*10/24)
+		candidate.Reasons = append(candidate.Reasons, "time_window")
+	}
+	if order.Status != utils.OrderStatusPending {
+		candidate.Reasons = append(candidate.Reasons, "order_"+order.Status)
+	}
+	return candidate
+}
+
+// LinkBankTransaction attaches an unmatched transfer to an order and marks the order
+// paid through the order state machine (seats, history, webhooks, ticket email).
+func LinkBankTransaction(c *gin.Context) {
+	var input struct {
+		OrderID uint   `json:"order_id" binding:"required"`
+		Notes   string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "order_id is required"})
+		return
+	}
+
+	adminID := currentUserID(c)
+	var transaction models.BankTransaction
+	var order models.Order
+	var transition *utils.OrderTransition
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transaction, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if !isUnreconciledBankStatus(transaction.Status) {
+			return errBankTransactionReconciled
+		}
+		if err := tx.First(&order, input.OrderID).Error; err != nil {
+			return err
+		}
+
+		notes := fmt.Sprintf("Reconciled by admin #%d with %s transfer #%d (Rp %.0f from %s)",
+			adminID, transaction.BankName, transaction.ID, transaction.Amount, transaction.Sender)
+		if input.Notes != "" {
+			notes += ": " + input.Notes
+		}
+		t, err := utils.OrderStates.TransitionTx(tx, &order, utils.OrderStatusPaid, utils.OrderTransitionOptions{Notes: notes})
+		if err != nil {
+			return err
+		}
+		transition = t
+
+		now := time.Now()
+		res := tx.Model(&models.BankTransaction{}).
+			Where("id = ? AND status IN ?", transaction.ID, unreconciledBankStatuses).
+			Updates(map[string]interface{}{
+				"order_id":        order.ID,
+				"status":          "matched",
+				"reconciled_by":   adminID,
+				"reconciled_at":   now,
+				"reconcile_notes": input.Notes,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errBankTransactionReconciled
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Bank transaction or order not found"})
+		return
+	case errors.Is(err, errBankTransactionReconciled):
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Bank transaction is already linked to an order", "data": gin.H{"order_id": transaction.OrderID}})
+		return
+	case err != nil:
+		respondOrderTransitionError(c, order, err)
+		return
+	}
+
+	utils.OrderStates.Notify(config.DB, transition, utils.OrderTransitionOptions{})
+
+	config.DB.First(&transaction, transaction.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Transaction linked, order marked as paid and tickets sent",
+		"data":    gin.H{"transaction": transaction, "order": order},
+	})
+}
+
+func isUnreconciledBankStatus(status string) bool {
+	for _, s := range unreconciledBankStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconciliation_CandidatesAndLink(t *testing.T) {
+	setupControllerDB(t)
+	now := time.Now()
+
+	transfer := models.BankTransaction{ReferenceID: "<jago-1@mail>", Amount: 150123, Sender: "BUDI SANTOSO", BankName: "Bank Jago", Status: "unmatched", TransactionDate: now}
+	config.DB.Create(&transfer)
+	config.DB.Create(&models.BankTransaction{ReferenceID: "<jago-0@mail>", Amount: 5000, BankName: "Bank Jago", Status: "matched", TransactionDate: now})
+
+	nearAmount := models.Order{OrderNumber: "ORD-NEAR", Status: "pending", TotalAmount: 150321, CustomerName: "Siti", CreatedAt: now.Add(-time.Hour)}
+	byName := models.Order{OrderNumber: "ORD-NAME", Status: "expired", TotalAmount: 99000, CustomerName: "Budi Santoso", CreatedAt: now.Add(-2 * time.Hour)}
+	exact := models.Order{OrderNumber: "ORD-EXACT", Status: "pending", TotalAmount: 150123, CustomerName: "Budi Santoso", PaymentMethod: "MANUAL_JAGO", CreatedAt: now.Add(-30 * time.Minute)}
+	tooOld := models.Order{OrderNumber: "ORD-OLD", Status: "pending", TotalAmount: 150123, CreatedAt: now.Add(-100 * time.Hour)}
+	paid := models.Order{OrderNumber: "ORD-PAID2", Status: "paid", TotalAmount: 150123, CreatedAt: now}
+	for _, o := range []*models.Order{&nearAmount, &byName, &exact, &tooOld, &paid} {
+		config.DB.Create(o)
+	}
+
+	r := gin.New()
+	r.Use(asUser(1, "admin"))
+	r.GET("/bank-transactions/unmatched", AdminGetUnmatchedBankTransactions)
+	r.GET("/bank-transactions/:id/candidates", GetReconcileCandidates)
+	r.POST("/bank-transactions/:id/link", LinkBankTransaction)
+
+	w, resp := doJSON(r, "GET", "/bank-transactions/unmatched", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, resp["data"].(map[string]interface{})["transactions"], 1)
+
+	w, resp = doJSON(r, "GET", "/bank-transactions/"+uintStr(transfer.ID)+"/candidates", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	candidates := resp["data"].(map[string]interface{})["candidates"].([]interface{})
+	var numbers []string
+	for _, c := range candidates {
+		numbers = append(numbers, c.(map[string]interface{})["order"].(map[string]interface{})["order_number"].(string))
+	}
+	assert.ElementsMatch(t, []string{"ORD-EXACT", "ORD-NAME", "ORD-NEAR"}, numbers, "paid and out-of-window orders left out")
+	assert.Equal(t, "ORD-EXACT", numbers[0], "exact amount and sender name rank first")
+
+	// Link to the expired order found by name: it is revived and paid
+	w, _ = doJSON(r, "POST", "/bank-transactions/"+uintStr(transfer.ID)+"/link", gin.H{"order_id": byName.ID, "notes": "Paid the old invoice"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	config.DB.First(&byName, byName.ID)
+	assert.Equal(t, "paid", byName.Status)
+	config.DB.First(&transfer, transfer.ID)
+	assert.Equal(t, "matched", transfer.Status)
+	assert.Equal(t, byName.ID, *transfer.OrderID)
+	assert.Equal(t, uint(1), *transfer.ReconciledBy)
+	assert.NotNil(t, transfer.ReconciledAt)
+
+	var history models.OrderStatusHistory
+	config.DB.Where("order_id = ? AND status = ?", byName.ID, "paid").First(&history)
+	assert.Contains(t, history.Notes, "Reconciled by admin #1")
+
+	// A linked transfer can't be linked again
+	w, _ = doJSON(r, "POST", "/bank-transactions/"+uintStr(transfer.ID)+"/link", gin.H{"order_id": exact.ID})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Paid orders can't take another transfer
+	other := models.BankTransaction{ReferenceID: "<jago-2@mail>", Amount: 150123, Status: "method_mismatch", TransactionDate: now}
+	config.DB.Create(&other)
+	w, _ = doJSON(r, "POST", "/bank-transactions/"+uintStr(other.ID)+"/link", gin.H{"order_id": paid.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	config.DB.First(&other, other.ID)
+	assert.Equal(t, "method_mismatch", other.Status)
+}
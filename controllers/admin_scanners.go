@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// canScanEvent reports whether the current user may check in tickets for the event:
+// admins always, organizers for their own events, scanners for assigned events.
+func canScanEvent(c *gin.Context, eventID uint) bool {
+	role, _ := c.Get("userRole")
+	userID := currentUserID(c)
+
+	switch role {
+	case "admin":
+		return true
+	case "organizer":
+		var count int64
+		config.DB.Model(&models.Event{}).Where("id = ? AND organizer_id = ?", eventID, userID).Count(&count)
+		return count > 0
+	case "scanner":
+		var count int64
+		config.DB.Model(&models.EventScanner{}).Where("event_id = ? AND user_id = ?", eventID, userID).Count(&count)
+		return count > 0
+	}
+	return false
+}
+
+// findScannableEvent is findManagedEvent for check-in endpoints, which scanners may also use.
+func findScannableEvent(c *gin.Context, id string) (*models.Event, bool) {
+	if role, _ := c.Get("userRole"); role != "scanner" {
+		return findManagedEvent(c, id)
+	}
+
+	var event models.Event
+	if err := config.DB.Where("id = ?", id).First(&event).Error; err != nil || !canScanEvent(c, event.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Event not found"})
+		return nil, false
+	}
+	return &event, true
+}
+
+// GetMyScannerEvents lists the events a scanner account is assigned to.
+func GetMyScannerEvents(c *gin.Context) {
+	events := []models.Event{}
+	config.DB.Joins("JOIN event_scanners ON event_scanners.event_id = events.id").
+		Where("event_scanners.user_id = ?", currentUserID(c)).
+		Order("events.event_date ASC").
+		Find(&events)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": events})
+}
+
+// GetEventScanners lists scanner accounts assigned to an event.
+func GetEventScanners(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	scanners := []models.EventScanner{}
+	config.DB.Preload("User").Where("event_id = ?", event.ID).Order("id ASC").Find(&scanners)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": scanners})
+}
+
+// AssignEventScanner invites a scanner to an event by email. Unknown emails get a new
+// scanner account and a link to set a password; existing scanner accounts are added to the
+// event. Emails of any other account are refused, its role is never changed.
+func AssignEventScanner(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+		Name  string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Valid email is required"})
+		return
+	}
+	email := strings.TrimSpace(input.Email)
+
+	tx := config.DB.Begin()
+
+	var user models.User
+	resetToken := ""
+	if err := tx.Where("email = ?", email).First(&user).Error; err != nil {
+		name := input.Name
+		if name == "" {
+			name = strings.Split(email, "@")[0]
+		}
+		now := time.Now()
+		user = models.User{Name: name, Email: email, Role: "scanner", Status: "active", EmailVerifiedAt: &now}
+		if err := tx.Create(&user).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create scanner account"})
+			return
+		}
+
+		// No password yet: the invite link lets the scanner set one via the reset flow
+		resetToken, _ = generateRandomString(32)
+		if err := tx.Create(&models.PasswordReset{
+			Email:     email,
+			Token:     resetToken,
+			ExpiresAt: now.Add(7 * 24 * time.Hour),
+			CreatedAt: now,
+		}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create scanner invite"})
+			return
+		}
+	} else if user.Role != "scanner" {
+		// Never repurpose a customer, organizer or admin account: its owner did not agree to it
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "This email belongs to an existing account; scanners need their own email"})
+		return
+	}
+
+	var count int64
+	tx.Model(&models.EventScanner{}).Where("event_id = ? AND user_id = ?", event.ID, user.ID).Count(&count)
+	if count > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Scanner is already assigned to this event"})
+		return
+	}
+
+	assignment := models.EventScanner{EventID: event.ID, UserID: user.ID, AssignedBy: currentUserID(c)}
+	if err := tx.Create(&assignment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to assign scanner"})
+		return
+	}
+	tx.Commit()
+
+	utils.SendScannerInviteEmail(user.Email, user.Name, event.Title, resetToken)
+
+	assignment.User = user
+	c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Scanner assigned", "data": assignment})
+}
+
+// RevokeEventScanner removes a scanner's access to an event. The dedicated scanner account
+// stays, so it can be assigned again; with no events left it can't scan anything.
+func RevokeEventScanner(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	result := config.DB.Where("event_id = ? AND user_id = ?", event.ID, c.Param("user_id")).Delete(&models.EventScanner{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to revoke scanner"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Scanner is not assigned to this event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Scanner access revoked"})
+}
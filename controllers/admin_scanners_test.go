@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventScanners_AssignScopeRevoke(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	other := models.Event{Title: "Other", Slug: "other", OrganizerID: 77}
+	config.DB.Create(&other)
+
+	// Organizer 5 owns fx.event and invites a new scanner by email
+	org := gin.New()
+	org.Use(asUser(5, "organizer"))
+	org.POST("/events/:id/scanners", AssignEventScanner)
+	org.GET("/events/:id/scanners", GetEventScanners)
+	org.DELETE("/events/:id/scanners/:user_id", RevokeEventScanner)
+
+	w, _ := doJSON(org, "POST", "/events/"+uintStr(fx.event.ID)+"/scanners", gin.H{"email": "door@kartcis.id", "name": "Door 1"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w, _ = doJSON(org, "POST", "/events/"+uintStr(fx.event.ID)+"/scanners", gin.H{"email": "door@kartcis.id"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "already assigned")
+	w, _ = doJSON(org, "POST", "/events/"+uintStr(other.ID)+"/scanners", gin.H{"email": "door@kartcis.id"})
+	assert.Equal(t, http.StatusNotFound, w.Code, "not the organizer's event")
+
+	// An existing customer account is not turned into a scanner
+	customer := models.User{Name: "Budi", Email: "budi@example.com", Role: "user", Status: "active"}
+	config.DB.Create(&customer)
+	w, _ = doJSON(org, "POST", "/events/"+uintStr(fx.event.ID)+"/scanners", gin.H{"email": "budi@example.com"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	config.DB.First(&customer, customer.ID)
+	assert.Equal(t, "user", customer.Role)
+
+	var scannerUser models.User
+	config.DB.Where("email = ?", "door@kartcis.id").First(&scannerUser)
+	assert.Equal(t, "scanner", scannerUser.Role)
+	var invites int64
+	config.DB.Model(&models.PasswordReset{}).Where("email = ?", "door@kartcis.id").Count(&invites)
+	assert.Equal(t, int64(1), invites)
+
+	// The scanner works only on the assigned event
+	sc := gin.New()
+	sc.Use(asUser(scannerUser.ID, "scanner"))
+	sc.POST("/tickets/check-in", CheckInTicket)
+	sc.GET("/events/:id/check-in/manifest", GetCheckInManifest)
+	sc.GET("/events/:id/ticket-keys", GetEventTicketKeys)
+
+	w, _ = doJSON(sc, "GET", "/events/"+uintStr(fx.event.ID)+"/check-in/manifest", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doJSON(sc, "GET", "/events/"+uintStr(fx.event.ID)+"/ticket-keys", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doJSON(sc, "GET", "/events/"+uintStr(other.ID)+"/check-in/manifest", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = doJSON(sc, "POST", "/tickets/check-in", gin.H{"ticket_code": fx.paid.TicketCode})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "scanner must select an event")
+	w, _ = doJSON(sc, "POST", "/tickets/check-in", gin.H{"ticket_code": fx.paid.TicketCode, "event_id": other.ID})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = doJSON(sc, "POST", "/tickets/check-in", gin.H{"ticket_code": fx.paid.TicketCode, "event_id": fx.event.ID})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, resp := doJSON(org, "GET", "/events/"+uintStr(fx.event.ID)+"/scanners", nil)
+	assert.Len(t, resp["data"], 1)
+
+	// Revoke: access is gone
+	w, _ = doJSON(org, "DELETE", "/events/"+uintStr(fx.event.ID)+"/scanners/"+uintStr(scannerUser.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doJSON(sc, "GET", "/events/"+uintStr(fx.event.ID)+"/check-in/manifest", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
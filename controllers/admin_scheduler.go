@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGetScheduledJobs shows each periodic job: last run, duration, error and which
+// instance is running it now.
+func AdminGetScheduledJobs(c *gin.Context) {
+	jobs := []models.ScheduledJob{}
+	config.DB.Order("name ASC").Find(&jobs)
+
+	now := time.Now()
+	data := make([]gin.H, 0, len(jobs))
+	for _, job := range jobs {
+		data = append(data, gin.H{
+			"job":     job,
+			"running": job.LockedUntil != nil && job.LockedUntil.After(now),
+			// Overdue by more than two intervals: no instance is picking it up
+			"stale": now.Sub(job.NextRunAt) > 2*time.Duration(job.IntervalSeconds)*time.Second+time.Minute,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
+
+// TriggerScheduledJob makes a job due now; the next instance to poll it runs it.
+func TriggerScheduledJob(c *gin.Context) {
+	res := config.DB.Model(&models.ScheduledJob{}).Where("name = ?", c.Param("name")).Update("next_run_at", time.Now())
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to trigger job"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Scheduled job not found"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Job will run within a few seconds"})
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// findManagedEvent loads an event the current admin/organizer may manage.
+// Organizers only see their own events; anything else is reported as not found.
+func findManagedEvent(c *gin.Context, id string) (*models.Event, bool) {
+	var event models.Event
+	query := config.DB.Where("id = ?", id)
+	if role, _ := c.Get("userRole"); role == "organizer" {
+		userID, _ := c.Get("userID")
+		query = query.Where("organizer_id = ?", userID)
+	}
+	if err := query.First(&event).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Event not found"})
+		return nil, false
+	}
+	return &event, true
+}
+
+// respondTicketLookupError maps utils.FindTicketByCode errors to responses.
+func respondTicketLookupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidTicketCode), errors.Is(err, utils.ErrTicketSignature), errors.Is(err, utils.ErrTicketKeyNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid ticket code"})
+	case errors.Is(err, utils.ErrTicketKeyRevoked), errors.Is(err, utils.ErrTicketCodeSuperseded):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Ticket code is no longer valid, please use the latest e-ticket"})
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket not found"})
+	}
+}
+
+// GetEventTicketKeys returns the public keys scanners need to verify signed ticket codes
+// offline. Revoked keys are listed so devices can drop them.
+func GetEventTicketKeys(c *gin.Context) {
+	event, ok := findScannableEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if _, err := utils.EnsureEventSigningKey(config.DB, event.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to load signing keys"})
+		return
+	}
+
+	keys := []models.EventSigningKey{}
+	config.DB.Where("event_id = ?", event.ID).Order("id ASC").Find(&keys)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"event_id":  event.ID,
+			"algorithm": "Ed25519",
+			"format":    utils.SignedTicketPrefix + "<base64url(payload)>.<base64url(signature)>",
+			"keys":      keys,
+		},
+	})
+}
+
+// RotateEventTicketKey retires the active key; existing codes stay valid.
+func RotateEventTicketKey(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	key, err := utils.RotateEventSigningKey(tx, event.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to rotate signing key"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Signing key rotated", "data": key})
+}
+
+// RevokeEventTicketKey revokes a key, re-signs the tickets that used it and re-sends the
+// e-tickets of the paid orders among them, whose old QR codes no longer scan.
+func RevokeEventTicketKey(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	reissued, err := utils.RevokeEventSigningKey(tx, event.ID, c.Param("key_id"))
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, utils.ErrTicketKeyNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Signing key not found"})
+		case errors.Is(err, utils.ErrTicketKeyAlreadyRevoked):
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Signing key is already revoked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to revoke signing key"})
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to revoke signing key"})
+		return
+	}
+
+	resent, err := utils.ResendReissuedTickets(config.DB, reissued)
+	if err != nil {
+		log.Printf("[TicketKeys] Failed to re-send e-tickets after revoking key %s of event %d: %v", c.Param("key_id"), event.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Signing key revoked",
+		"data": gin.H{
+			"reissued_tickets": len(reissued),
+			"resent_orders":    resent,
+		},
+	})
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeEventTicketKey_ResendsTickets(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	config.DB.Model(&models.Order{}).Where("order_number = ?", "ORD-PAID").Update("customer_email", "budi@example.com")
+
+	// A pending order's ticket is re-signed too, but it has no e-ticket to replace yet
+	pendingOrder := models.Order{OrderNumber: "ORD-PENDING", Status: "pending", CustomerEmail: "sari@example.com"}
+	config.DB.Create(&pendingOrder)
+	pending := models.Ticket{OrderID: &pendingOrder.ID, EventID: fx.event.ID, TicketTypeID: fx.paid.TicketTypeID, TicketCode: "T-3", Status: "active"}
+	config.DB.Create(&pending)
+	assert.NoError(t, utils.IssueTicketCode(config.DB, &pending))
+
+	var ticket models.Ticket
+	config.DB.First(&ticket, fx.paid.ID)
+	claims, err := utils.DecodeTicketCode(ticket.TicketCode)
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.Use(asUser(1, "admin"))
+	r.POST("/events/:id/ticket-keys/:key_id/revoke", RevokeEventTicketKey)
+
+	w, resp := doJSON(r, "POST", "/events/"+uintStr(fx.event.ID)+"/ticket-keys/"+claims.KeyID+"/revoke", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, 2.0, data["reissued_tickets"])
+	assert.Equal(t, 1.0, data["resent_orders"])
+
+	_, err = utils.FindTicketByCode(config.DB, ticket.TicketCode)
+	assert.Error(t, err, "the old code no longer scans")
+
+	// The paid order's customer is sent the new code
+	var current models.Ticket
+	config.DB.First(&current, fx.paid.ID)
+	var jobs []models.Job
+	config.DB.Where("type = ?", "email.tickets").Find(&jobs)
+	if assert.Len(t, jobs, 1) {
+		assert.Contains(t, jobs[0].Payload, current.TicketCode)
+		assert.Contains(t, jobs[0].Payload, "budi@example.com")
+	}
+
+	var notes int64
+	config.DB.Model(&models.OrderStatusHistory{}).Where("notes LIKE ?", "E-Ticket email re-sent%").Count(&notes)
+	assert.Equal(t, int64(1), notes)
+}
@@ -1,12 +1,17 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Ticket Type Management
@@ -28,10 +33,31 @@ func CreateTicketType(c *gin.Context) {
 		// Manual parse or assume body has it.
 	}
 
-	if err := config.DB.Create(&input).Error; err != nil {
+	if err := validateTicketType(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	// Price tiers are created with the ticket type, bundle items are checked after it
+	bundleItems := input.BundleItems
+	input.BundleItems = nil
+	tx := config.DB.Begin()
+	if err := tx.Create(&input).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create ticket type"})
 		return
 	}
+	if len(bundleItems) > 0 {
+		if err := utils.SaveBundleItems(tx, input, bundleItems); err != nil {
+			tx.Rollback()
+			respondBundleError(c, err)
+			return
+		}
+	}
+	utils.SyncAvailability(tx, input.ID)
+	utils.SyncEventPrices(tx, input.EventID, time.Now())
+	tx.Commit()
+	config.DB.Preload("PriceTiers", orderPriceTiers).Preload("BundleItems.TicketType").First(&input, input.ID)
 
 	c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Ticket type created", "data": input})
 }
@@ -47,7 +73,7 @@ func AdminGetTicketTypes(c *gin.Context) {
 		query = query.Where("event_id = ?", eventID)
 	}
 
-	if err := query.Find(&ticketTypes).Error; err != nil {
+	if err := query.Preload("PriceTiers", orderPriceTiers).Preload("BundleItems.TicketType").Find(&ticketTypes).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch ticket types"})
 		return
 	}
@@ -77,9 +103,40 @@ func UpdateTicketType(c *gin.Context) {
 	ticketType.Quota = input.Quota
 	ticketType.Description = input.Description
 	ticketType.MaxPurchasePerUser = input.MaxPurchasePerUser
+	ticketType.SalesStart = input.SalesStart
+	ticketType.SalesEnd = input.SalesEnd
+	ticketType.MinPurchase = input.MinPurchase
+	ticketType.Hidden = input.Hidden
+	ticketType.AccessCode = input.AccessCode
 	ticketType.UpdatedAt = time.Now()
+	if err := validateTicketType(&ticketType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
 
-	config.DB.Save(&ticketType)
+	tx := config.DB.Begin()
+	tx.Save(&ticketType)
+	// price_tiers replaces the tiers when sent, omitted keeps them
+	if input.PriceTiers != nil {
+		if err := utils.SavePriceTiers(tx, ticketType.ID, input.PriceTiers); err != nil {
+			tx.Rollback()
+			respondPriceTierError(c, err)
+			return
+		}
+	} else {
+		utils.SyncAvailability(tx, ticketType.ID)
+		utils.SyncEventPrices(tx, ticketType.EventID, time.Now())
+	}
+	// bundle_items likewise; an empty list turns the bundle back into a regular type
+	if input.BundleItems != nil {
+		if err := utils.SaveBundleItems(tx, ticketType, input.BundleItems); err != nil {
+			tx.Rollback()
+			respondBundleError(c, err)
+			return
+		}
+	}
+	tx.Commit()
+	config.DB.Preload("PriceTiers", orderPriceTiers).Preload("BundleItems.TicketType").First(&ticketType, ticketType.ID)
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Ticket type updated", "data": ticketType})
 }
@@ -96,11 +153,16 @@ func DeleteTicketType(c *gin.Context) {
 
 	// Check if tickets exist
 	var count int64
-	config.DB.Model(&models.Ticket{}).Where("ticket_type_id = ?", id).Count(&count)
+	config.DB.Model(&models.Ticket{}).Where("ticket_type_id = ? OR bundle_ticket_type_id = ?", id, id).Count(&count)
 	if count > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot delete ticket type with existing tickets"})
 		return
 	}
+	config.DB.Model(&models.BundleItem{}).Where("ticket_type_id = ?", id).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot delete ticket type that is part of a bundle"})
+		return
+	}
 
 	config.DB.Delete(&ticketType)
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Ticket type deleted"})
@@ -111,7 +173,7 @@ func GetTicketTypeDetail(c *gin.Context) {
 	id := c.Param("id")
 	var ticketType models.TicketType
 
-	if err := config.DB.First(&ticketType, id).Error; err != nil {
+	if err := config.DB.Preload("PriceTiers", orderPriceTiers).Preload("BundleItems.TicketType").First(&ticketType, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket type not found"})
 		return
 	}
@@ -119,6 +181,50 @@ func GetTicketTypeDetail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": ticketType})
 }
 
+// validateTicketType checks the sale window and purchase limits of a ticket type about
+// to be saved and normalizes its access code.
+func validateTicketType(tt *models.TicketType) error {
+	tt.AccessCode = strings.TrimSpace(tt.AccessCode)
+	switch {
+	case tt.SalesStart != nil && tt.SalesEnd != nil && !tt.SalesEnd.After(*tt.SalesStart):
+		return fmt.Errorf("Ticket type '%s': sales_end must be after sales_start", tt.Name)
+	case tt.MinPurchase < 0:
+		return fmt.Errorf("Ticket type '%s': min_purchase can't be negative", tt.Name)
+	case tt.MaxPurchasePerUser > 0 && tt.MinPurchase > tt.MaxPurchasePerUser:
+		return fmt.Errorf("Ticket type '%s': min_purchase is above max_purchase_per_user", tt.Name)
+	case len(tt.AccessCode) > 64:
+		return fmt.Errorf("Ticket type '%s': access_code is longer than 64 characters", tt.Name)
+	}
+	return nil
+}
+
+// orderPriceTiers preloads a ticket type's tiers in the order they apply.
+func orderPriceTiers(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+func respondPriceTierError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidPriceTier):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+	case errors.Is(err, utils.ErrPriceTierInUse):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot delete a price tier that tickets were sold in", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to save price tiers"})
+	}
+}
+
+func respondBundleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidBundle):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+	case errors.Is(err, utils.ErrBundleInUse):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Cannot change a bundle that was sold", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to save bundle items"})
+	}
+}
+
 // UpdateTicketTypeStatus
 func UpdateTicketTypeStatus(c *gin.Context) {
 	id := c.Param("id")
@@ -1,18 +1,58 @@
 package controllers
 
 import (
+	"errors"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// ticketLookupResult maps a utils.FindTicketByCode error to a check-in log result.
+// ok is false for unexpected (database) errors.
+func ticketLookupResult(err error) (result string, ok bool) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "not_found", true
+	case errors.Is(err, utils.ErrInvalidTicketCode), errors.Is(err, utils.ErrTicketSignature),
+		errors.Is(err, utils.ErrTicketKeyNotFound), errors.Is(err, utils.ErrTicketKeyRevoked),
+		errors.Is(err, utils.ErrTicketCodeSuperseded):
+		return "invalid", true
+	}
+	return "", false
+}
+
+// recordCheckIn writes an audit entry on its own connection, after any check-in
+// transaction has committed: a failed insert inside it would abort the transaction on
+// Postgres. Failures are logged to stdout only; an audit write must never block the door.
+func recordCheckIn(entry models.CheckInLog) {
+	if entry.ScannedAt.IsZero() {
+		entry.ScannedAt = time.Now()
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		log.Println("[CheckIn] Failed to write check-in log:", err)
+	}
+}
+
+func currentUserID(c *gin.Context) uint {
+	userID, _ := c.Get("userID")
+	id, _ := userID.(uint)
+	return id
+}
+
 // CheckInTicket
 func CheckInTicket(c *gin.Context) {
 	type CheckInInput struct {
 		TicketCode string `json:"ticket_code" binding:"required"`
+		EventID    uint   `json:"event_id"` // Event selected on the scanner; tickets of other events are rejected
+		Gate       string `json:"gate"`
+		DeviceID   string `json:"device_id"`
 	}
 
 	var input CheckInInput
@@ -21,31 +61,109 @@ func CheckInTicket(c *gin.Context) {
 		return
 	}
 
-	var ticket models.Ticket
+	// Scanners and organizers must pick one of their events; admins may scan anything
+	if role, _ := c.Get("userRole"); role != "admin" {
+		if input.EventID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Event ID is required"})
+			return
+		}
+		if !canScanEvent(c, input.EventID) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "You are not assigned to this event"})
+			return
+		}
+	}
+
+	entry := models.CheckInLog{
+		TicketCode: input.TicketCode,
+		EventID:    input.EventID,
+		OperatorID: currentUserID(c),
+		DeviceID:   input.DeviceID,
+		Gate:       input.Gate,
+		Source:     "online",
+		ScannedAt:  time.Now(),
+	}
+
 	// Preload details for response
-	if err := config.DB.Where("ticket_code = ?", input.TicketCode).Preload("Event").Preload("TicketType").First(&ticket).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket not found"})
+	ticket, err := utils.FindTicketByCode(config.DB, input.TicketCode, "Event", "TicketType", "Order")
+	if err != nil {
+		if result, ok := ticketLookupResult(err); ok {
+			entry.Result = result
+			entry.Reason = err.Error()
+			recordCheckIn(entry)
+		}
+		respondTicketLookupError(c, err)
+		return
+	}
+	entry.TicketID = &ticket.ID
+	if entry.EventID == 0 {
+		entry.EventID = ticket.EventID
+	}
+
+	if input.EventID != 0 && ticket.EventID != input.EventID {
+		entry.Result = "wrong_event"
+		recordCheckIn(entry)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Ticket is for a different event",
+			"data":    gin.H{"event_title": ticket.Event.Title},
+		})
 		return
 	}
 
-	if ticket.Status == "used" {
+	if ticket.Order.Status != "paid" {
+		entry.Result = "void"
+		entry.Reason = "order " + ticket.Order.Status
+		recordCheckIn(entry)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Ticket order is not paid",
+			"data":    gin.H{"order_status": ticket.Order.Status},
+		})
+		return
+	}
+
+	if ticket.Status == "refunded" {
+		entry.Result = "void"
+		entry.Reason = "ticket refunded"
+		recordCheckIn(entry)
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Ticket has been refunded"})
+		return
+	}
+
+	// Mark as used only if still active, so two gates can't both accept the same ticket
+	now := time.Now()
+	var checkedIn bool
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Ticket{}).Where("id = ? AND status = ?", ticket.ID, "active").
+			Updates(map[string]interface{}{"status": "used", "check_in_at": now, "check_in_device_id": input.DeviceID})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		checkedIn = true
+		return utils.EnqueueCheckInWebhook(tx, *ticket, "online", input.Gate, input.DeviceID, now)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to check in ticket"})
+		return
+	}
+	if !checkedIn {
+		config.DB.First(ticket, ticket.ID)
+		entry.Result = "duplicate"
+		recordCheckIn(entry)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "Ticket already used",
 			"data": gin.H{
-				"check_in_at": ticket.CheckInAt,
+				"check_in_at":        ticket.CheckInAt,
+				"check_in_device_id": ticket.CheckInDeviceID,
 			},
 		})
 		return
 	}
 
-	// Mark as used
-	now := time.Now()
-	ticket.Status = "used"
-	ticket.CheckInAt = &now
-	ticket.UpdatedAt = now
-
-	config.DB.Save(&ticket)
+	entry.Result = "accepted"
+	entry.ScannedAt = now
+	recordCheckIn(entry)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -55,7 +173,114 @@ func CheckInTicket(c *gin.Context) {
 			"attendee_name": ticket.AttendeeName,
 			"event_title":   ticket.Event.Title,
 			"ticket_type":   ticket.TicketType.Name,
-			"check_in_at":   ticket.CheckInAt,
+			"check_in_at":   now,
+		},
+	})
+}
+
+// UndoCheckIn reverts a used ticket to active (e.g. scanned by mistake) and logs why.
+func UndoCheckIn(c *gin.Context) {
+	var input struct {
+		Reason   string `json:"reason" binding:"required"`
+		Gate     string `json:"gate"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Reason is required"})
+		return
+	}
+
+	ticket, err := utils.FindTicketByCode(config.DB, c.Param("code"))
+	if err != nil {
+		respondTicketLookupError(c, err)
+		return
+	}
+	if ticket.Status != "used" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Ticket is not checked in"})
+		return
+	}
+
+	// Revert only if still used, so two undos (or an undo racing a check-in) can't both win
+	res := config.DB.Model(&models.Ticket{}).Where("id = ? AND status = ?", ticket.ID, "used").
+		Updates(map[string]interface{}{"status": "active", "check_in_at": nil, "check_in_device_id": ""})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to undo check-in"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Ticket check-in changed, try again"})
+		return
+	}
+
+	recordCheckIn(models.CheckInLog{
+		TicketID:   &ticket.ID,
+		TicketCode: ticket.TicketCode,
+		EventID:    ticket.EventID,
+		OperatorID: currentUserID(c),
+		DeviceID:   input.DeviceID,
+		Gate:       input.Gate,
+		Source:     "undo",
+		Result:     "undone",
+		Reason:     input.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Check-in undone"})
+}
+
+const (
+	checkInLogDefaultLimit = 50
+	checkInLogMaxLimit     = 500
+)
+
+// GetCheckInLogs lists scan attempts for an event, newest first.
+func GetCheckInLogs(c *gin.Context) {
+	event, ok := findManagedEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(checkInLogDefaultLimit)))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > checkInLogMaxLimit {
+		limit = checkInLogDefaultLimit
+	}
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.CheckInLog{}).Where("event_id = ?", event.ID)
+	if result := c.Query("result"); result != "" {
+		query = query.Where("result = ?", result)
+	}
+	if gate := c.Query("gate"); gate != "" {
+		query = query.Where("gate = ?", gate)
+	}
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	if ticketCode := c.Query("ticket_code"); ticketCode != "" {
+		query = query.Where("ticket_code = ?", ticketCode)
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	logs := []models.CheckInLog{}
+	if err := query.Preload("Operator").Order("id DESC").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch check-in logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"logs": logs,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
 		},
 	})
 }
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckInTicket_AuditAndUndo(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+
+	r := gin.New()
+	r.Use(asUser(1, "admin"))
+	r.POST("/tickets/check-in", CheckInTicket)
+	r.POST("/tickets/:code/undo-check-in", UndoCheckIn)
+	r.GET("/events/:id/check-in/logs", GetCheckInLogs)
+
+	scan := func(code string, eventID uint) (int, string) {
+		w, resp := doJSON(r, "POST", "/tickets/check-in", gin.H{"ticket_code": code, "event_id": eventID, "gate": "Gate A", "device_id": "dev-1"})
+		return w.Code, resp["message"].(string)
+	}
+
+	code, msg := scan(fx.paid.TicketCode, fx.event.ID+1)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Ticket is for a different event", msg)
+
+	code, msg = scan(fx.cancelled.TicketCode, fx.event.ID)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Ticket order is not paid", msg)
+
+	code, _ = scan(fx.paid.TicketCode, fx.event.ID)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, msg = scan(fx.paid.TicketCode, fx.event.ID)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "Ticket already used", msg)
+
+	code, _ = scan("T-UNKNOWN", fx.event.ID)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Undo requires a reason
+	w, _ := doJSON(r, "POST", "/tickets/"+fx.paid.TicketCode+"/undo-check-in", gin.H{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = doJSON(r, "POST", "/tickets/"+fx.paid.TicketCode+"/undo-check-in", gin.H{"reason": "scanned wrong person"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var ticket models.Ticket
+	config.DB.First(&ticket, fx.paid.ID)
+	assert.Equal(t, "active", ticket.Status)
+	assert.Nil(t, ticket.CheckInAt)
+
+	var results []string
+	config.DB.Model(&models.CheckInLog{}).Order("id ASC").Pluck("result", &results)
+	assert.Equal(t, []string{"wrong_event", "void", "accepted", "duplicate", "not_found", "undone"}, results)
+
+	var accepted models.CheckInLog
+	config.DB.Where("result = ?", "accepted").First(&accepted)
+	assert.Equal(t, uint(1), accepted.OperatorID)
+	assert.Equal(t, "Gate A", accepted.Gate)
+	assert.Equal(t, "dev-1", accepted.DeviceID)
+
+	_, resp := doJSON(r, "GET", "/events/"+uintStr(fx.event.ID)+"/check-in/logs?result=undone", nil)
+	logs := resp["data"].(map[string]interface{})["logs"].([]interface{})
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "scanned wrong person", logs[0].(map[string]interface{})["reason"])
+
+	_, resp = doJSON(r, "GET", "/events/"+uintStr(fx.event.ID)+"/check-in/logs?limit=1000000", nil)
+	assert.Equal(t, 50.0, resp["data"].(map[string]interface{})["pagination"].(map[string]interface{})["per_page"], "limit is capped")
+}
@@ -76,7 +76,7 @@ func AdminGetTransactions(c *gin.Context) {
 	query.Count(&totalItems)
 
 	// Fetch Data
-	query.Preload("Tickets.Event").Preload("Tickets.TicketType").Order("orders.created_at desc").Limit(limit).Offset(offset).Find(&orders)
+	query.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").Order("orders.created_at desc").Limit(limit).Offset(offset).Find(&orders)
 
 	totalPages := int(totalItems) / limit
 	if int(totalItems)%limit != 0 {
@@ -104,7 +104,7 @@ func AdminGetTransactionDetail(c *gin.Context) {
 	id := c.Param("id")
 	var order models.Order
 
-	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").First(&order, id).Error; err != nil {
+	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").First(&order, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Order not found"})
 		return
 	}
@@ -129,35 +129,14 @@ func CancelTransaction(c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
-
-	order.Status = "cancelled"
-	if err := tx.Save(&order).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to cancel transaction"})
+	if _, err := utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusCancelled, utils.OrderTransitionOptions{
+		Notes:  "Cancelled by Admin",
+		Reason: "Dibatalkan oleh Admin",
+	}); err != nil {
+		respondOrderTransitionError(c, order, err)
 		return
 	}
 
-	// Restore Quota
-	if err := utils.RestoreQuota(tx, order.ID); err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore quota"})
-		return
-	}
-
-	// Record history
-	tx.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    "cancelled",
-		Notes:     "Cancelled by Admin",
-		CreatedAt: time.Now(),
-	})
-
-	tx.Commit()
-
-	// Send Cancellation Email
-	utils.SendOrderCancelledEmail(order, "Dibatalkan oleh Admin")
-
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Transaction cancelled and quota restored"})
 }
 
@@ -178,29 +157,12 @@ func MarkTransactionPaid(c *gin.Context) {
 		return
 	}
 
-	order.Status = "paid"
-	config.DB.Save(&order)
-
-	// Record history
-	config.DB.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    "paid",
-		Notes:     "Marked as paid by Admin",
-		CreatedAt: time.Now(),
-	})
-
-	// Send Confirmation Email
-	var tickets []models.Ticket
-	config.DB.Preload("Event").Preload("TicketType").Where("order_id = ?", order.ID).Find(&tickets)
-	utils.SendTicketEmail(order, tickets)
-
-	// Record history: Email Sent
-	config.DB.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    "paid",
-		Notes:     "E-Ticket email sent to customer",
-		CreatedAt: time.Now(),
-	})
+	if _, err := utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusPaid, utils.OrderTransitionOptions{
+		Notes: "Marked as paid by Admin",
+	}); err != nil {
+		respondOrderTransitionError(c, order, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Transaction marked as paid and email sent"})
 }
@@ -222,10 +184,11 @@ func ResendTicketEmail(c *gin.Context) {
 
 	// Fetch tickets and send email
 	var tickets []models.Ticket
-	if err := config.DB.Preload("Event").Preload("TicketType").Where("order_id = ?", order.ID).Find(&tickets).Error; err != nil {
+	if err := config.DB.Preload("Event").Preload("TicketType").Preload("BundleTicketType").Where("order_id = ?", order.ID).Find(&tickets).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch tickets"})
 		return
 	}
+	config.DB.Where("order_id = ? AND status = ?", order.ID, "paid").Find(&order.AddOns)
 
 	utils.SendTicketEmail(order, tickets)
 
@@ -242,9 +205,10 @@ func ExportTransactions(c *gin.Context) {
 
 	// Filter Transactions based on Role and Event ID
 	query := config.DB.Table("orders").
-		Select("orders.id as order_id, orders.order_number, orders.customer_name, orders.customer_email, orders.customer_phone, orders.status, orders.total_amount, orders.created_at, tickets.ticket_code, tickets.attendee_name, tickets.attendee_email, tickets.attendee_phone, ticket_types.name as ticket_name, events.title as event_title").
+		Select("orders.id as order_id, orders.order_number, orders.customer_name, orders.customer_email, orders.customer_phone, orders.status, orders.total_amount, orders.created_at, tickets.ticket_code, tickets.attendee_name, tickets.attendee_email, tickets.attendee_phone, ticket_types.name as ticket_name, bundle_types.name as bundle_name, events.id as event_id, events.title as event_title").
 		Joins("LEFT JOIN tickets ON tickets.order_id = orders.id").
 		Joins("LEFT JOIN ticket_types ON ticket_types.id = tickets.ticket_type_id").
+		Joins("LEFT JOIN ticket_types AS bundle_types ON bundle_types.id = tickets.bundle_ticket_type_id").
 		Joins("LEFT JOIN events ON events.id = tickets.event_id").
 		Where("orders.status != ?", "") // Dummy condition
 
@@ -256,6 +220,7 @@ func ExportTransactions(c *gin.Context) {
 	}
 
 	type ExportResult struct {
+		OrderID       uint
 		OrderNumber   string
 		CustomerName  string
 		CustomerEmail string
@@ -268,6 +233,8 @@ func ExportTransactions(c *gin.Context) {
 		AttendeeEmail string
 		AttendeePhone string
 		TicketName    string
+		BundleName    string
+		EventID       uint
 		EventTitle    string
 	}
 	var results []ExportResult
@@ -276,11 +243,38 @@ func ExportTransactions(c *gin.Context) {
 		return
 	}
 
+	// Add-ons of the exported orders, per order and event
+	orderIDs := make([]uint, 0, len(results))
+	for _, res := range results {
+		orderIDs = append(orderIDs, res.OrderID)
+	}
+	var addOns []models.OrderAddOn
+	config.DB.Where("order_id IN ? AND status != ?", orderIDs, "released").Order("id").Find(&addOns)
+	addOnSummary := make(map[[2]uint]string)
+	for _, addOn := range addOns {
+		key := [2]uint{addOn.OrderID, addOn.EventID}
+		if addOnSummary[key] != "" {
+			addOnSummary[key] += "; "
+		}
+		addOnSummary[key] += fmt.Sprintf("%dx %s", addOn.Quantity, addOn.Name)
+	}
+	addOnsListed := make(map[[2]uint]bool)
+
 	// Simple CSV generation
-	csvContent := "Order Number,Waktu Pembelian,Status,Nama Pemesan,Email Pemesan,No Telepon Pemesan,Data Tiket (Tipe),Kode Tiket,Nama Pengunjung (Attendee),Email Pengunjung,Telepon Pengunjung\n"
+	csvContent := "Order Number,Waktu Pembelian,Status,Nama Pemesan,Email Pemesan,No Telepon Pemesan,Data Tiket (Tipe),Kode Tiket,Nama Pengunjung (Attendee),Email Pengunjung,Telepon Pengunjung,Add-on\n"
 	for _, res := range results {
+		if res.BundleName != "" {
+			res.TicketName = res.BundleName + " - " + res.TicketName
+		}
+		// Add-ons are listed once per order and event, on its first ticket row
+		key := [2]uint{res.OrderID, res.EventID}
+		addOnCell := ""
+		if !addOnsListed[key] {
+			addOnCell = addOnSummary[key]
+			addOnsListed[key] = true
+		}
 		// Escape simple characters if needed, but for MVP standard string format is okay.
-		csvContent += fmt.Sprintf(`"%s","%s","%s","%s","%s","%s","%s - %s","%s","%s","%s","%s"`+"\n",
+		csvContent += fmt.Sprintf(`"%s","%s","%s","%s","%s","%s","%s - %s","%s","%s","%s","%s","%s"`+"\n",
 			res.OrderNumber,
 			res.CreatedAt.Format("2006-01-02 15:04"),
 			res.Status,
@@ -293,6 +287,7 @@ func ExportTransactions(c *gin.Context) {
 			res.AttendeeName,
 			res.AttendeeEmail,
 			res.AttendeePhone,
+			addOnCell,
 		)
 	}
 
@@ -451,64 +446,12 @@ func UpdateTransactionStatus(c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
-
-	oldStatus := order.Status
-	order.Status = input.Status
-	// If marked as paid, update paid_at
-	if input.Status == "paid" && order.PaidAt == nil {
-		now := time.Now()
-		order.PaidAt = &now
-	}
-
-	if err := tx.Save(&order).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update status"})
+	if _, err := utils.OrderStates.Transition(config.DB, &order, input.Status, utils.OrderTransitionOptions{
+		Notes: "Status updated by Admin",
+	}); err != nil {
+		respondOrderTransitionError(c, order, err)
 		return
 	}
 
-	// If status changed to cancelled/expired, restore quota
-	if (input.Status == "cancelled" || input.Status == "expired") && (oldStatus != "cancelled" && oldStatus != "expired") {
-		if err := utils.RestoreQuota(tx, order.ID); err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore quota"})
-			return
-		}
-	}
-
-	// Record history
-	tx.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    input.Status,
-		Notes:     "Status updated by Admin",
-		CreatedAt: time.Now(),
-	})
-
-	tx.Commit()
-
-	// If status changed to cancelled/expired, send email
-	if (input.Status == "cancelled" || input.Status == "expired") && (oldStatus != "cancelled" && oldStatus != "expired") {
-		reason := "Pesanan telah dibatalkan"
-		if input.Status == "expired" {
-			reason = "Waktu pembayaran telah habis (Expired)"
-		}
-		utils.SendOrderCancelledEmail(order, reason)
-	}
-
-	// If status changed to paid, send email
-	if input.Status == "paid" && oldStatus != "paid" {
-		var tickets []models.Ticket
-		config.DB.Preload("Event").Preload("TicketType").Where("order_id = ?", order.ID).Find(&tickets)
-		utils.SendTicketEmail(order, tickets)
-
-		// Record history: Email Sent
-		config.DB.Create(&models.OrderStatusHistory{
-			OrderID:   order.ID,
-			Status:    "paid",
-			Notes:     "E-Ticket email sent to customer (Status update)",
-			CreatedAt: time.Now(),
-		})
-	}
-
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Transaction status updated", "data": order})
 }
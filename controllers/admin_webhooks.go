@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// managedWebhookScope limits endpoint queries to the current organizer; admins see all.
+func managedWebhookScope(c *gin.Context, query *gorm.DB) *gorm.DB {
+	if role, _ := c.Get("userRole"); role == "organizer" {
+		query = query.Where("webhook_endpoints.organizer_id = ?", currentUserID(c))
+	}
+	return query
+}
+
+func findManagedWebhook(c *gin.Context) (*models.WebhookEndpoint, bool) {
+	var endpoint models.WebhookEndpoint
+	if err := managedWebhookScope(c, config.DB).Where("id = ?", c.Param("id")).First(&endpoint).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Webhook endpoint not found"})
+		return nil, false
+	}
+	return &endpoint, true
+}
+
+// normalizeWebhookEventTypes validates a subscription list; empty means every event type.
+func normalizeWebhookEventTypes(types []string) (string, bool) {
+	known := make(map[string]bool, len(utils.WebhookEventTypes))
+	for _, t := range utils.WebhookEventTypes {
+		known[t] = true
+	}
+	cleaned := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		if !known[t] {
+			return "", false
+		}
+		cleaned = append(cleaned, t)
+	}
+	return strings.Join(cleaned, ","), true
+}
+
+// GetWebhookEndpoints lists webhook endpoints, optionally for one event.
+func GetWebhookEndpoints(c *gin.Context) {
+	query := managedWebhookScope(c, config.DB.Model(&models.WebhookEndpoint{}))
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+
+	endpoints := []models.WebhookEndpoint{}
+	query.Order("id ASC").Find(&endpoints)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": endpoints})
+}
+
+// CreateWebhookEndpoint registers an endpoint for one event (event_id) or for every event
+// of the organizer. The signing secret is only returned here and on rotation.
+func CreateWebhookEndpoint(c *gin.Context) {
+	var input struct {
+		URL         string   `json:"url" binding:"required"`
+		EventID     *uint    `json:"event_id"`
+		OrganizerID uint     `json:"organizer_id"` // Admin only, for account-wide endpoints
+		EventTypes  []string `json:"event_types"`
+		Description string   `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || utils.ValidateWebhookURL(input.URL) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "A public https URL is required"})
+		return
+	}
+	eventTypes, ok := normalizeWebhookEventTypes(input.EventTypes)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown event type", "data": gin.H{"event_types": utils.WebhookEventTypes}})
+		return
+	}
+
+	organizerID := currentUserID(c)
+	if input.EventID != nil {
+		event, ok := findManagedEvent(c, strconv.FormatUint(uint64(*input.EventID), 10))
+		if !ok {
+			return
+		}
+		organizerID = event.OrganizerID
+	} else if role, _ := c.Get("userRole"); role == "admin" && input.OrganizerID != 0 {
+		organizerID = input.OrganizerID
+	}
+
+	secret, err := utils.NewWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate secret"})
+		return
+	}
+	endpoint := models.WebhookEndpoint{
+		OrganizerID: organizerID,
+		EventID:     input.EventID,
+		URL:         input.URL,
+		Secret:      secret,
+		EventTypes:  eventTypes,
+		Description: input.Description,
+		IsActive:    true,
+	}
+	if err := config.DB.Create(&endpoint).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create webhook endpoint"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Webhook endpoint created. Store the secret now, it won't be shown again.",
+		"data":    gin.H{"endpoint": endpoint, "secret": secret},
+	})
+}
+
+// UpdateWebhookEndpoint changes the URL, subscriptions, description or active flag.
+func UpdateWebhookEndpoint(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL         *string  `json:"url"`
+		EventTypes  []string `json:"event_types"`
+		Description *string  `json:"description"`
+		IsActive    *bool    `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.URL != nil {
+		if utils.ValidateWebhookURL(*input.URL) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "A public https URL is required"})
+			return
+		}
+		updates["url"] = *input.URL
+	}
+	if input.EventTypes != nil {
+		eventTypes, ok := normalizeWebhookEventTypes(input.EventTypes)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Unknown event type", "data": gin.H{"event_types": utils.WebhookEventTypes}})
+			return
+		}
+		updates["event_types"] = eventTypes
+	}
+	if input.Description != nil {
+		updates["description"] = *input.Description
+	}
+	if input.IsActive != nil {
+		updates["is_active"] = *input.IsActive
+	}
+
+	if len(updates) > 0 {
+		if err := config.DB.Model(endpoint).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update webhook endpoint"})
+			return
+		}
+	}
+	config.DB.First(endpoint, endpoint.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook endpoint updated", "data": endpoint})
+}
+
+// DeleteWebhookEndpoint removes an endpoint and its delivery log.
+func DeleteWebhookEndpoint(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := tx.Where("endpoint_id = ?", endpoint.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to delete webhook deliveries"})
+		return
+	}
+	if err := tx.Delete(endpoint).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to delete webhook endpoint"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook endpoint deleted"})
+}
+
+// RotateWebhookSecret replaces the signing secret. Deliveries still queued are signed
+// with the new secret when sent.
+func RotateWebhookSecret(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	secret, err := utils.NewWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate secret"})
+		return
+	}
+	if err := config.DB.Model(endpoint).Update("secret", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to rotate secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook secret rotated", "data": gin.H{"secret": secret}})
+}
+
+// SendTestWebhook queues a webhook.test delivery for the endpoint.
+func SendTestWebhook(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := utils.EnqueueTestWebhook(config.DB, *endpoint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to queue test webhook"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Test webhook queued", "data": delivery})
+}
+
+// GetWebhookDeliveries is the delivery log of an endpoint, newest first.
+func GetWebhookDeliveries(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.WebhookDelivery{}).Where("endpoint_id = ?", endpoint.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if eventType := c.Query("event_type"); eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch deliveries"})
+		return
+	}
+	// Response bodies are for admins debugging a receiver; organizers see the status code
+	if role, _ := c.Get("userRole"); role != "admin" {
+		for i := range deliveries {
+			deliveries[i].ResponseBody = ""
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"deliveries": deliveries,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
+		},
+	})
+}
+
+// RedeliverWebhook queues a new attempt of a past delivery with the same payload, so
+// receivers can dedupe on the envelope id.
+func RedeliverWebhook(c *gin.Context) {
+	endpoint, ok := findManagedWebhook(c)
+	if !ok {
+		return
+	}
+
+	var original models.WebhookDelivery
+	if err := config.DB.Where("id = ? AND endpoint_id = ?", c.Param("delivery_id"), endpoint.ID).First(&original).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Delivery not found"})
+		return
+	}
+	if original.Status == "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Delivery is still being retried"})
+		return
+	}
+
+	delivery, err := utils.RedeliverWebhook(config.DB, original)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to queue redelivery"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Redelivery queued", "data": delivery})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEndpoints_ScopeCheckInAndRedeliver(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	other := models.Event{Title: "Other", Slug: "other", OrganizerID: 77}
+	config.DB.Create(&other)
+
+	org := gin.New()
+	org.Use(asUser(5, "organizer"))
+	org.GET("/webhooks", GetWebhookEndpoints)
+	org.POST("/webhooks", CreateWebhookEndpoint)
+	org.GET("/webhooks/:id/deliveries", GetWebhookDeliveries)
+	org.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", RedeliverWebhook)
+	org.POST("/tickets/check-in", CheckInTicket)
+
+	w, _ := doJSON(org, "POST", "/webhooks", gin.H{"url": "https://hooks.example.com/kartcis", "event_id": other.ID})
+	assert.Equal(t, http.StatusNotFound, w.Code, "not the organizer's event")
+	w, _ = doJSON(org, "POST", "/webhooks", gin.H{"url": "https://hooks.example.com/kartcis", "event_types": []string{"order.shipped"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	for _, url := range []string{"http://hooks.example.com/kartcis", "https://169.254.169.254/latest", "https://10.1.2.3/hook"} {
+		w, _ = doJSON(org, "POST", "/webhooks", gin.H{"url": url})
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+	w, resp := doJSON(org, "POST", "/webhooks", gin.H{"url": "https://hooks.example.com/kartcis", "event_id": fx.event.ID, "event_types": []string{"ticket.checked_in"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	data := resp["data"].(map[string]interface{})
+	assert.Contains(t, data["secret"], "whsec_")
+	assert.NotContains(t, data["endpoint"], "secret")
+	endpointID := uint(data["endpoint"].(map[string]interface{})["id"].(float64))
+
+	// Another organizer cannot see it
+	stranger := gin.New()
+	stranger.Use(asUser(77, "organizer"))
+	stranger.GET("/webhooks/:id/deliveries", GetWebhookDeliveries)
+	w, _ = doJSON(stranger, "GET", "/webhooks/"+uintStr(endpointID)+"/deliveries", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// An accepted check-in queues a delivery; a duplicate scan does not
+	w, _ = doJSON(org, "POST", "/tickets/check-in", gin.H{"ticket_code": fx.paid.TicketCode, "event_id": fx.event.ID})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doJSON(org, "POST", "/tickets/check-in", gin.H{"ticket_code": fx.paid.TicketCode, "event_id": fx.event.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var deliveries []models.WebhookDelivery
+	config.DB.Where("endpoint_id = ?", endpointID).Find(&deliveries)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, "ticket.checked_in", deliveries[0].EventType)
+
+	w, _ = doJSON(org, "POST", "/webhooks/"+uintStr(endpointID)+"/deliveries/"+uintStr(deliveries[0].ID)+"/redeliver", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "still pending")
+
+	config.DB.Model(&deliveries[0]).Update("status", "failed")
+	w, _ = doJSON(org, "POST", "/webhooks/"+uintStr(endpointID)+"/deliveries/"+uintStr(deliveries[0].ID)+"/redeliver", nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	w, resp = doJSON(org, "GET", "/webhooks/"+uintStr(endpointID)+"/deliveries", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	list := resp["data"].(map[string]interface{})["deliveries"].([]interface{})
+	assert.Len(t, list, 2)
+	assert.Equal(t, float64(deliveries[0].ID), list[0].(map[string]interface{})["redelivery_of"])
+}
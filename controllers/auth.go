@@ -67,7 +67,7 @@ func Register(c *gin.Context) {
 	config.DB.Create(&verification)
 
 	// Send Verification Email (Async)
-	go utils.SendEmailVerificationEmail(user.Email, user.Name, token)
+	utils.SendEmailVerificationEmail(user.Email, user.Name, token)
 
 	// Generate JWT Token
 	jwtToken, _ := generateToken(user)
@@ -167,7 +167,7 @@ func ResendVerificationEmail(c *gin.Context) {
 	config.DB.Create(&verification)
 
 	// Send Verification Email (Async)
-	go utils.SendEmailVerificationEmail(user.Email, user.Name, token)
+	utils.SendEmailVerificationEmail(user.Email, user.Name, token)
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Verifikasi ulang berhasil dikirim. Silakan cek email Anda."})
 }
@@ -395,7 +395,7 @@ func ForgotPassword(c *gin.Context) {
 	config.DB.Create(&resetEntry)
 
 	// Send Email (Async)
-	go utils.SendResetPasswordEmail(user.Email, user.Name, token)
+	utils.SendResetPasswordEmail(user.Email, user.Name, token)
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "If the email is registered, a reset link has been sent."})
 }
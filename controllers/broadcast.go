@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -9,10 +10,13 @@ import (
 	"strings"
 	"time"
 
+	"kartcis-backend/config"
+	"kartcis-backend/queue"
 	"kartcis-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
 )
 
 // GET /admin/broadcast/wa/qr
@@ -31,6 +35,15 @@ func GetWAStatus(c *gin.Context) {
 	})
 }
 
+type waMessagePayload struct {
+	Phone   string `json:"phone"`
+	Message string `json:"message"`
+}
+
+var sendWAMessageJob = queue.Define("whatsapp.message", queue.QueueWhatsApp, 3, func(ctx context.Context, p waMessagePayload) error {
+	return utils.SendWAMessage(p.Phone, p.Message)
+})
+
 // POST /admin/broadcast/wa/send
 func BroadcastWA(c *gin.Context) {
 	if utils.WAClient == nil || !utils.WAClient.IsConnected() || !utils.WAClient.IsLoggedIn() {
@@ -50,50 +63,58 @@ func BroadcastWA(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Gagal simpan file"})
 		return
 	}
+	defer os.Remove(tempPath)
 
-	// Langsung jawab ke frontend agar browser tidak timeout
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Broadcast sedang berjalan di latar belakang. Estimasi waktu: ~30-60 menit untuk 1000 pesan.",
-	})
-
-	// Jalankan proses pengiriman di background (Goroutine)
-	go func() {
-		defer os.Remove(tempPath)
-
-		f, err := excelize.OpenFile(tempPath)
-		if err != nil {
-			fmt.Println("Error buka excel:", err)
-			return
+	f, err := excelize.OpenFile(tempPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "File Excel tidak valid"})
+		return
+	}
+	defer f.Close()
+
+	// Satu job per penerima, dijadwalkan dengan jeda acak 3 - 7 detik agar lebih aman dari BAN
+	rows, _ := f.GetRows("Sheet1")
+	var messages []waMessagePayload
+	for i, row := range rows {
+		if i == 0 || len(row) < 2 {
+			continue
 		}
-		defer f.Close()
 
-		rows, _ := f.GetRows("Sheet1")
-		for i, row := range rows {
-			if i == 0 || len(row) < 2 {
-				continue
+		name := row[0]
+		phone := strings.TrimSpace(row[1])
+		phone = strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
 			}
+			return -1
+		}, phone)
 
-			name := row[0]
-			phone := strings.TrimSpace(row[1])
-			phone = strings.Map(func(r rune) rune {
-				if r >= '0' && r <= '9' {
-					return r
-				}
-				return -1
-			}, phone)
-
-			if phone == "" {
-				continue
-			}
+		if phone == "" {
+			continue
+		}
 
-			msg := strings.ReplaceAll(messageTemplate, "{nama}", name)
-			_ = utils.SendWAMessage(phone, msg)
+		messages = append(messages, waMessagePayload{Phone: phone, Message: strings.ReplaceAll(messageTemplate, "{nama}", name)})
+	}
 
-			// Jeda acak 3 - 7 detik agar lebih aman dari BAN
-			randomDelay := rand.Intn(4) + 3
-			time.Sleep(time.Duration(randomDelay) * time.Second)
+	runAt := time.Now()
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		for _, msg := range messages {
+			if err := sendWAMessageJob.Enqueue(msg, queue.Options{Tx: tx, RunAt: runAt}); err != nil {
+				return err
+			}
+			runAt = runAt.Add(time.Duration(rand.Intn(4)+3) * time.Second)
 		}
-		fmt.Println("Broadcast WhatsApp selesai!")
-	}()
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Gagal menjadwalkan broadcast"})
+		return
+	}
+	queued := len(messages)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("Broadcast dijadwalkan: %d pesan, estimasi selesai ~%d menit.", queued, int(time.Until(runAt).Minutes())+1),
+		"data":    gin.H{"queued": queued, "estimated_finish": runAt},
+	})
 }
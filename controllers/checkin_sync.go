@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Offline check-in sync for door scanners:
+//   1. GET  .../check-in/manifest?cursor=   download tickets changed since the cursor
+//   2. scan locally (signed codes can be verified with the event's public keys)
+//   3. POST .../check-in/sync               upload a batch of scans, get per-scan results
+//
+// Conflicts (same ticket scanned on several devices) keep the earliest scan; later
+// scans are reported back as duplicates. Only scan times inside the check-in window
+// count: a scanned_at in the future, before the event's check-in window or before the
+// manifest the device scanned against never becomes (or replaces) a check-in time.
+
+const (
+	manifestDefaultLimit = 1000
+	manifestMaxLimit     = 5000
+	syncMaxBatch         = 500
+
+	syncClockSkew      = 5 * time.Minute // Tolerated drift of a scanner's clock
+	checkInOpensBefore = 24 * time.Hour  // Scans count from a day before the event date
+)
+
+type ManifestTicket struct {
+	TicketID        uint       `json:"ticket_id"`
+	TicketCode      string     `json:"ticket_code"`
+	Status          string     `json:"status"` // active, used, void (order no longer paid or ticket refunded)
+	AttendeeName    string     `json:"attendee_name"`
+	TicketType      string     `json:"ticket_type"`
+	CheckInAt       *time.Time `json:"check_in_at"`
+	CheckInDeviceID string     `json:"check_in_device_id"`
+	ChangedAt       time.Time  `json:"changed_at"`
+}
+
+type manifestRow struct {
+	ID              uint
+	TicketCode      string
+	Status          string
+	AttendeeName    string
+	TicketTypeName  string
+	CheckInAt       *time.Time
+	CheckInDeviceID string
+	OrderStatus     string
+	TicketUpdatedAt time.Time
+	OrderUpdatedAt  time.Time
+}
+
+// manifestChangedAt is manifestRow.changedAt in SQL, the manifest's keyset column:
+// GREATEST(tickets.updated_at, orders.updated_at), spelled so SQLite runs it too.
+const manifestChangedAt = "CASE WHEN orders.updated_at > tickets.updated_at THEN orders.updated_at ELSE tickets.updated_at END"
+
+// changedAt is when the ticket last changed as far as scanners care: its own row or its
+// order's status (cancel/refund does not touch the ticket row).
+func (r manifestRow) changedAt() time.Time {
+	if r.OrderUpdatedAt.After(r.TicketUpdatedAt) {
+		return r.OrderUpdatedAt
+	}
+	return r.TicketUpdatedAt
+}
+
+func encodeManifestCursor(changedAt time.Time, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", changedAt.UnixNano(), id)))
+}
+
+func decodeManifestCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.Unix(0, nanos), uint(id), nil
+}
+
+// GetCheckInManifest returns an event's tickets for offline scanning. Without a cursor it
+// returns every ticket of a paid order; with a cursor it returns everything that changed
+// since, including tickets whose order was cancelled or refunded (status "void").
+// Pages are ordered by (changed_at, id) and the cursor is the last position returned.
+func GetCheckInManifest(c *gin.Context) {
+	event, ok := findScannableEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(manifestDefaultLimit)))
+	if limit <= 0 || limit > manifestMaxLimit {
+		limit = manifestDefaultLimit
+	}
+
+	query := config.DB.Table("tickets").
+		Select("tickets.id, tickets.ticket_code, tickets.status, tickets.attendee_name, ticket_types.name AS ticket_type_name, tickets.check_in_at, tickets.check_in_device_id, orders.status AS order_status, tickets.updated_at AS ticket_updated_at, orders.updated_at AS order_updated_at").
+		Joins("JOIN orders ON orders.id = tickets.order_id").
+		Joins("LEFT JOIN ticket_types ON ticket_types.id = tickets.ticket_type_id").
+		Where("tickets.event_id = ?", event.ID)
+
+	cursor := c.Query("cursor")
+	if cursor != "" {
+		since, lastID, err := decodeManifestCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid cursor"})
+			return
+		}
+		query = query.Where("("+manifestChangedAt+" > ? OR ("+manifestChangedAt+" = ? AND tickets.id > ?))", since, since, lastID)
+	}
+
+	// One extra row tells whether there is another page
+	var rows []manifestRow
+	if err := query.Order(manifestChangedAt + ", tickets.id").Limit(limit + 1).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to load manifest", "error": err.Error()})
+		return
+	}
+
+	tickets := make([]ManifestTicket, 0, len(rows))
+	nextCursor := cursor
+	hasMore := false
+	for i, r := range rows {
+		if i == limit {
+			hasMore = true
+			break
+		}
+		changed := r.changedAt()
+
+		status := r.Status
+		if r.OrderStatus != "paid" || r.Status == "refunded" {
+			status = "void"
+		}
+		nextCursor = encodeManifestCursor(changed, r.ID)
+		// A first download only needs valid tickets; the cursor still moves past the rest
+		if cursor == "" && status == "void" {
+			continue
+		}
+		tickets = append(tickets, ManifestTicket{
+			TicketID:        r.ID,
+			TicketCode:      r.TicketCode,
+			Status:          status,
+			AttendeeName:    r.AttendeeName,
+			TicketType:      r.TicketTypeName,
+			CheckInAt:       r.CheckInAt,
+			CheckInDeviceID: r.CheckInDeviceID,
+			ChangedAt:       changed,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"event_id":    event.ID,
+			"tickets":     tickets,
+			"next_cursor": nextCursor,
+			"has_more":    hasMore,
+			"server_time": time.Now(),
+		},
+	})
+}
+
+type CheckInSyncItem struct {
+	TicketCode string    `json:"ticket_code" binding:"required"`
+	ScannedAt  time.Time `json:"scanned_at"`
+	ClientRef  string    `json:"client_ref"` // Optional device-side id, echoed back
+}
+
+type CheckInSyncRequest struct {
+	DeviceID           string            `json:"device_id" binding:"required"`
+	Gate               string            `json:"gate"`
+	ManifestServerTime *time.Time        `json:"manifest_server_time"` // Optional server_time of the manifest the device scanned against
+	CheckIns           []CheckInSyncItem `json:"check_ins" binding:"required"`
+}
+
+type CheckInSyncResult struct {
+	TicketCode string     `json:"ticket_code"`
+	ClientRef  string     `json:"client_ref,omitempty"`
+	Result     string     `json:"result"` // accepted, duplicate, void, wrong_event, invalid, not_found
+	Message    string     `json:"message,omitempty"`
+	CheckInAt  *time.Time `json:"check_in_at,omitempty"` // Winning check-in
+	DeviceID   string     `json:"device_id,omitempty"`   // Device of the winning check-in
+}
+
+// SyncCheckIns applies a batch of offline scans from one device.
+func SyncCheckIns(c *gin.Context) {
+	event, ok := findScannableEvent(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req CheckInSyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input", "error": err.Error()})
+		return
+	}
+	if len(req.CheckIns) > syncMaxBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("At most %d check-ins per batch", syncMaxBatch)})
+		return
+	}
+
+	from, to := syncScanWindow(*event, req.ManifestServerTime, time.Now())
+	results := make([]CheckInSyncResult, 0, len(req.CheckIns))
+	summary := map[string]int{}
+	for _, item := range req.CheckIns {
+		result, err := applySyncedCheckIn(event.ID, currentUserID(c), req.DeviceID, req.Gate, item, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to sync check-ins", "error": err.Error()})
+			return
+		}
+		results = append(results, result)
+		summary[result.Result]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"results":     results,
+			"summary":     summary,
+			"server_time": time.Now(),
+		},
+	})
+}
+
+// syncScanWindow is the range of scan times a sync upload may record: from the event's
+// check-in window (or the device's manifest, if later) until now plus clock skew.
+func syncScanWindow(event models.Event, manifestServerTime *time.Time, now time.Time) (time.Time, time.Time) {
+	from := event.EventDate.Add(-checkInOpensBefore)
+	if manifestServerTime != nil && manifestServerTime.Add(-syncClockSkew).After(from) {
+		from = manifestServerTime.Add(-syncClockSkew)
+	}
+	return from, now.Add(syncClockSkew)
+}
+
+// applySyncedCheckIn records one offline scan. A scanned_at outside [from, to] is kept in
+// the check-in log only: it never supersedes a recorded check-in, and a ticket that is
+// still active is checked in at the server's time instead.
+func applySyncedCheckIn(eventID, operatorID uint, deviceID, gate string, item CheckInSyncItem, from, to time.Time) (CheckInSyncResult, error) {
+	result := CheckInSyncResult{TicketCode: item.TicketCode, ClientRef: item.ClientRef}
+	scannedAt := item.ScannedAt
+	if scannedAt.IsZero() {
+		scannedAt = time.Now()
+	}
+	loggedAt := scannedAt
+	outOfWindow := scannedAt.Before(from) || scannedAt.After(to)
+	if outOfWindow {
+		scannedAt = time.Now()
+	}
+
+	entry := models.CheckInLog{
+		TicketCode: item.TicketCode,
+		EventID:    eventID,
+		OperatorID: operatorID,
+		DeviceID:   deviceID,
+		Gate:       gate,
+		Source:     "sync",
+		ScannedAt:  loggedAt,
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		found, err := utils.FindTicketByCode(tx, item.TicketCode, "Order")
+		if err != nil {
+			lookupResult, ok := ticketLookupResult(err)
+			if !ok {
+				return err
+			}
+			result.Result = lookupResult
+			if lookupResult == "invalid" {
+				result.Message = err.Error()
+			}
+			return nil
+		}
+		entry.TicketID = &found.ID
+
+		// Re-read under lock so concurrent uploads from other gates serialize per ticket
+		var ticket models.Ticket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ticket, found.ID).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case ticket.EventID != eventID:
+			result.Result = "wrong_event"
+			return nil
+		case found.Order.Status != "paid":
+			result.Result = "void"
+			result.Message = "Order is " + found.Order.Status
+			return nil
+		case ticket.Status == "refunded":
+			result.Result = "void"
+			result.Message = "Ticket has been refunded"
+			return nil
+		}
+
+		if ticket.Status == "used" && ticket.CheckInAt != nil {
+			sameScan := !outOfWindow && ticket.CheckInDeviceID == deviceID && ticket.CheckInAt.Equal(scannedAt)
+			if sameScan || outOfWindow || !scannedAt.Before(*ticket.CheckInAt) {
+				// Retried upload of the winning scan is fine; anything later is a duplicate
+				result.Result = "duplicate"
+				if sameScan {
+					result.Result = "accepted"
+					result.Message = "already synced"
+				}
+				result.CheckInAt = ticket.CheckInAt
+				result.DeviceID = ticket.CheckInDeviceID
+				return nil
+			}
+			// This scan happened before the recorded one: it becomes the first check-in
+			result.Message = "superseded check-in by " + ticket.CheckInDeviceID
+		}
+
+		if err := tx.Model(&ticket).Updates(map[string]interface{}{
+			"status":             "used",
+			"check_in_at":        scannedAt,
+			"check_in_device_id": deviceID,
+		}).Error; err != nil {
+			return err
+		}
+		// A superseding scan only corrects the time; the organizer already heard about this ticket
+		if ticket.Status != "used" {
+			if err := utils.EnqueueCheckInWebhook(tx, ticket, "sync", entry.Gate, deviceID, scannedAt); err != nil {
+				return err
+			}
+		}
+		result.Result = "accepted"
+		result.CheckInAt = &scannedAt
+		result.DeviceID = deviceID
+		return nil
+	})
+	if outOfWindow && result.Result != "" {
+		msg := "scanned_at outside the check-in window"
+		if result.Result == "accepted" {
+			msg += ", server time used"
+		}
+		if result.Message != "" {
+			msg = result.Message + "; " + msg
+		}
+		result.Message = msg
+	}
+	if err == nil {
+		entry.Result = result.Result
+		entry.Reason = result.Message
+		recordCheckIn(entry)
+	}
+	return result, err
+}
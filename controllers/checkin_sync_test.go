@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupControllerDB points config.DB at a fresh in-memory database.
+func setupControllerDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(
+		&models.User{},
+		&models.Event{},
+		&models.TicketType{},
+		&models.Order{},
+		&models.Ticket{},
+		&models.EventSigningKey{},
+		&models.CheckInLog{},
+		&models.EventScanner{},
+		&models.PasswordReset{},
+		&models.OrderStatusHistory{},
+		&models.SiteSetting{},
+		&models.Voucher{},
+		&models.ReferralCode{},
+		&models.FlashSale{},
+		&models.FlashSaleItem{},
+		&models.WaitingRoomEntry{},
+		&models.WaitingRoomState{},
+		&models.TicketPriceTier{},
+		&models.BundleItem{},
+		&models.Product{},
+		&models.ProductVariant{},
+		&models.OrderAddOn{},
+		&models.TicketHold{},
+		&models.Refund{},
+		&models.RefundItem{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.Job{},
+		&models.BankTransaction{},
+		&models.UniqueCodeAllocation{},
+	)
+	config.DB = db
+	gin.SetMode(gin.TestMode)
+}
+
+// asUser injects the auth context AuthMiddleware would set.
+func asUser(userID uint, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Set("userRole", role)
+		c.Next()
+	}
+}
+
+func doJSON(r http.Handler, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+type checkInFixture struct {
+	event     models.Event
+	paid      models.Ticket
+	cancelled models.Ticket
+}
+
+func seedCheckInFixture(t *testing.T) checkInFixture {
+	event := models.Event{Title: "Konser", Slug: "konser", OrganizerID: 5, Status: "published"}
+	config.DB.Create(&event)
+	tt := models.TicketType{EventID: event.ID, Name: "Regular", Quota: 10}
+	config.DB.Create(&tt)
+
+	paidOrder := models.Order{OrderNumber: "ORD-PAID", Status: "paid"}
+	config.DB.Create(&paidOrder)
+	cancelledOrder := models.Order{OrderNumber: "ORD-CANCEL", Status: "cancelled"}
+	config.DB.Create(&cancelledOrder)
+
+	paid := models.Ticket{OrderID: &paidOrder.ID, EventID: event.ID, TicketTypeID: tt.ID, TicketCode: "T-1", AttendeeName: "Budi", Status: "active"}
+	config.DB.Create(&paid)
+	assert.NoError(t, utils.IssueTicketCode(config.DB, &paid))
+	cancelled := models.Ticket{OrderID: &cancelledOrder.ID, EventID: event.ID, TicketTypeID: tt.ID, TicketCode: "T-2", AttendeeName: "Sari", Status: "active"}
+	config.DB.Create(&cancelled)
+
+	return checkInFixture{event: event, paid: paid, cancelled: cancelled}
+}
+
+func checkInRouter(userID uint, role string) *gin.Engine {
+	r := gin.New()
+	r.Use(asUser(userID, role))
+	r.GET("/events/:id/check-in/manifest", GetCheckInManifest)
+	r.POST("/events/:id/check-in/sync", SyncCheckIns)
+	return r
+}
+
+func TestCheckInManifest_FullAndDelta(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	r := checkInRouter(1, "admin")
+	path := "/events/" + uintStr(fx.event.ID) + "/check-in/manifest"
+
+	w, resp := doJSON(r, "GET", path, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	data := resp["data"].(map[string]interface{})
+	tickets := data["tickets"].([]interface{})
+	assert.Len(t, tickets, 1, "full manifest only has paid tickets")
+	assert.Equal(t, fx.paid.TicketCode, tickets[0].(map[string]interface{})["ticket_code"])
+	cursor := data["next_cursor"].(string)
+
+	// Nothing changed yet
+	_, resp = doJSON(r, "GET", path+"?cursor="+cursor, nil)
+	assert.Empty(t, resp["data"].(map[string]interface{})["tickets"])
+
+	// Paid order gets refunded: ticket shows up as void in the delta
+	time.Sleep(5 * time.Millisecond)
+	config.DB.Model(&models.Order{}).Where("order_number = ?", "ORD-PAID").Update("status", "refunded")
+	_, resp = doJSON(r, "GET", path+"?cursor="+cursor, nil)
+	delta := resp["data"].(map[string]interface{})["tickets"].([]interface{})
+	assert.Len(t, delta, 1)
+	assert.Equal(t, "void", delta[0].(map[string]interface{})["status"])
+
+	// Organizers can't read other organizers' events
+	w, _ = doJSON(checkInRouter(99, "organizer"), "GET", path, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Pages of one ticket walk the keyset without repeating or skipping a ticket
+	order := models.Order{OrderNumber: "ORD-PAID-2", Status: "paid"}
+	config.DB.Create(&order)
+	want := []string{"T-3", "T-4", "T-5"}
+	for _, code := range want {
+		config.DB.Create(&models.Ticket{OrderID: &order.ID, EventID: fx.event.ID, TicketTypeID: fx.paid.TicketTypeID, TicketCode: code, Status: "active"})
+	}
+	seen := map[string]int{}
+	cursor = ""
+	for pages := 0; pages < 10; pages++ {
+		_, resp = doJSON(r, "GET", path+"?limit=1&cursor="+cursor, nil)
+		data = resp["data"].(map[string]interface{})
+		for _, ticket := range data["tickets"].([]interface{}) {
+			seen[ticket.(map[string]interface{})["ticket_code"].(string)]++
+		}
+		cursor = data["next_cursor"].(string)
+		if data["has_more"] == false {
+			break
+		}
+	}
+	for code, n := range seen {
+		assert.Equal(t, 1, n, code)
+	}
+	for _, code := range want {
+		assert.Contains(t, seen, code)
+	}
+}
+
+func TestSyncCheckIns_ConflictsAndDuplicates(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	r := checkInRouter(1, "admin")
+	path := "/events/" + uintStr(fx.event.ID) + "/check-in/sync"
+
+	t0 := time.Now().Add(-10 * time.Minute).UTC().Truncate(time.Second)
+
+	// Gate B uploads first, but gate A scanned earlier
+	_, resp := doJSON(r, "POST", path, gin.H{
+		"device_id": "gate-b",
+		"check_ins": []gin.H{{"ticket_code": fx.paid.TicketCode, "scanned_at": t0.Add(2 * time.Minute), "client_ref": "b1"}},
+	})
+	results := resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "accepted", results[0].(map[string]interface{})["result"])
+
+	_, resp = doJSON(r, "POST", path, gin.H{
+		"device_id": "gate-a",
+		"check_ins": []gin.H{
+			{"ticket_code": fx.paid.TicketCode, "scanned_at": t0},
+			{"ticket_code": fx.paid.TicketCode, "scanned_at": t0.Add(5 * time.Minute)},
+			{"ticket_code": fx.cancelled.TicketCode, "scanned_at": t0},
+			{"ticket_code": "T-DOES-NOT-EXIST", "scanned_at": t0},
+			{"ticket_code": "KT1.forged.sig", "scanned_at": t0},
+		},
+	})
+	results = resp["data"].(map[string]interface{})["results"].([]interface{})
+	got := []string{}
+	for _, res := range results {
+		got = append(got, res.(map[string]interface{})["result"].(string))
+	}
+	assert.Equal(t, []string{"accepted", "duplicate", "void", "not_found", "invalid"}, got)
+	assert.Equal(t, "gate-a", results[1].(map[string]interface{})["device_id"])
+
+	var logged int64
+	config.DB.Model(&models.CheckInLog{}).Where("source = ? AND device_id = ?", "sync", "gate-a").Count(&logged)
+	assert.Equal(t, int64(5), logged)
+
+	var ticket models.Ticket
+	config.DB.First(&ticket, fx.paid.ID)
+	assert.Equal(t, "used", ticket.Status)
+	assert.Equal(t, "gate-a", ticket.CheckInDeviceID)
+	assert.True(t, ticket.CheckInAt.Equal(t0))
+
+	// Gate B re-syncs its scan: now reported as duplicate of gate A
+	_, resp = doJSON(r, "POST", path, gin.H{
+		"device_id": "gate-b",
+		"check_ins": []gin.H{{"ticket_code": fx.paid.TicketCode, "scanned_at": t0.Add(2 * time.Minute)}},
+	})
+	results = resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "duplicate", results[0].(map[string]interface{})["result"])
+}
+
+func TestSyncCheckIns_RejectsScanTimesOutsideWindow(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	config.DB.Model(&fx.event).Update("event_date", now)
+	order := models.Order{OrderNumber: "ORD-PAID-2", Status: "paid"}
+	config.DB.Create(&order)
+	second := models.Ticket{OrderID: &order.ID, EventID: fx.event.ID, TicketTypeID: fx.paid.TicketTypeID, TicketCode: "T-3", Status: "active"}
+	config.DB.Create(&second)
+	r := checkInRouter(1, "admin")
+	path := "/events/" + uintStr(fx.event.ID) + "/check-in/sync"
+
+	accepted := now.Add(-time.Minute)
+	_, resp := doJSON(r, "POST", path, gin.H{
+		"device_id": "gate-a",
+		"check_ins": []gin.H{{"ticket_code": fx.paid.TicketCode, "scanned_at": accepted}},
+	})
+	results := resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "accepted", results[0].(map[string]interface{})["result"])
+
+	// An earlier time before the check-in window can't take over the check-in
+	tooEarly := now.Add(-48 * time.Hour)
+	_, resp = doJSON(r, "POST", path, gin.H{
+		"device_id": "rogue",
+		"check_ins": []gin.H{{"ticket_code": fx.paid.TicketCode, "scanned_at": tooEarly}},
+	})
+	results = resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "duplicate", results[0].(map[string]interface{})["result"])
+	assert.Equal(t, "gate-a", results[0].(map[string]interface{})["device_id"])
+
+	var ticket models.Ticket
+	config.DB.First(&ticket, fx.paid.ID)
+	assert.Equal(t, "gate-a", ticket.CheckInDeviceID)
+	assert.True(t, ticket.CheckInAt.Equal(accepted))
+
+	var logged models.CheckInLog
+	config.DB.Where("device_id = ?", "rogue").First(&logged)
+	assert.True(t, logged.ScannedAt.Equal(tooEarly), "the rejected time is kept in the log")
+	assert.Contains(t, logged.Reason, "outside the check-in window")
+
+	// Neither can a time before the manifest the device scanned against
+	_, resp = doJSON(r, "POST", path, gin.H{
+		"device_id":            "rogue",
+		"manifest_server_time": now,
+		"check_ins":            []gin.H{{"ticket_code": fx.paid.TicketCode, "scanned_at": now.Add(-time.Hour)}},
+	})
+	results = resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "duplicate", results[0].(map[string]interface{})["result"])
+
+	// A future time still admits an active ticket, at the server's time
+	_, resp = doJSON(r, "POST", path, gin.H{
+		"device_id": "skewed",
+		"check_ins": []gin.H{{"ticket_code": second.TicketCode, "scanned_at": now.Add(time.Hour)}},
+	})
+	results = resp["data"].(map[string]interface{})["results"].([]interface{})
+	assert.Equal(t, "accepted", results[0].(map[string]interface{})["result"])
+	config.DB.First(&ticket, second.ID)
+	assert.Equal(t, "used", ticket.Status)
+	assert.True(t, ticket.CheckInAt.Before(now.Add(time.Minute)))
+}
+
+func uintStr(v uint) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
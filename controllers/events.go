@@ -78,6 +78,9 @@ func GetEvents(c *gin.Context) {
 
 	// Fetch Data
 	query.Limit(limit).Offset(offset).Find(&events)
+	for i := range events {
+		publicTicketTypes(&events[i], "")
+	}
 
 	// Pagination
 	totalPages := int(totalItems) / limit
@@ -99,6 +102,38 @@ func GetEvents(c *gin.Context) {
 	})
 }
 
+// publicTicketTypes prepares an event's ticket types for a public payload: hidden ones
+// are dropped unless accessCode unlocks them, and access codes are cleared. Bundles keep
+// their items, but hidden components are only listed by ID.
+func publicTicketTypes(event *models.Event, accessCode string) {
+	visible := event.TicketTypes[:0]
+	for _, tt := range event.TicketTypes {
+		if tt.Hidden && !tt.Unlocks(accessCode) {
+			continue
+		}
+		tt.AccessCode = ""
+		items := make([]models.BundleItem, len(tt.BundleItems))
+		for i, item := range tt.BundleItems {
+			if item.TicketType != nil {
+				component := *item.TicketType
+				component.AccessCode = ""
+				item.TicketType = &component
+				if component.Hidden {
+					item.TicketType = nil
+				}
+			}
+			items[i] = item
+		}
+		if tt.BundleItems != nil {
+			tt.BundleItems = items
+		}
+		visible = append(visible, tt)
+	}
+	event.TicketTypes = visible
+}
+
+// GetEventDetail returns a public event; ?access_code= reveals the hidden ticket types
+// it unlocks.
 func GetEventDetail(c *gin.Context) {
 	// Try to get identifier from 'slug' or 'id' param name
 	identifier := c.Param("slug")
@@ -109,14 +144,18 @@ func GetEventDetail(c *gin.Context) {
 	var event models.Event
 
 	// 1. Try find by Slug
-	if err := config.DB.Preload("Category").Preload("TicketTypes").Where("slug = ?", identifier).First(&event).Error; err == nil {
+	if err := config.DB.Preload("Category").Preload("TicketTypes.PriceTiers", orderPriceTiers).Preload("TicketTypes.BundleItems.TicketType").
+		Preload("Products", activeProducts).Preload("Products.Variants", orderProductVariants).Where("slug = ?", identifier).First(&event).Error; err == nil {
+		publicTicketTypes(&event, c.Query("access_code"))
 		c.JSON(http.StatusOK, gin.H{"success": true, "data": event})
 		return
 	}
 
 	// 2. Fallback: If identifier is numeric, try finding by ID
 	if id, errConv := strconv.Atoi(identifier); errConv == nil {
-		if err := config.DB.Preload("Category").Preload("TicketTypes").Where("id = ?", id).First(&event).Error; err == nil {
+		if err := config.DB.Preload("Category").Preload("TicketTypes.PriceTiers", orderPriceTiers).Preload("TicketTypes.BundleItems.TicketType").
+			Preload("Products", activeProducts).Preload("Products.Variants", orderProductVariants).Where("id = ?", id).First(&event).Error; err == nil {
+			publicTicketTypes(&event, c.Query("access_code"))
 			c.JSON(http.StatusOK, gin.H{"success": true, "data": event})
 			return
 		}
@@ -140,6 +179,9 @@ func GetUpcomingEvents(c *gin.Context) {
 		return
 	}
 
+	for i := range events {
+		publicTicketTypes(&events[i], "")
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": events})
 }
 
@@ -163,6 +205,9 @@ func GetPopularEvents(c *gin.Context) {
 		return
 	}
 
+	for i := range events {
+		publicTicketTypes(&events[i], "")
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": events})
 }
 
@@ -181,6 +226,9 @@ func GetFeaturedEvents(c *gin.Context) {
 		return
 	}
 
+	for i := range events {
+		publicTicketTypes(&events[i], "")
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": events})
 }
 
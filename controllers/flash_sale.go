@@ -1,31 +1,95 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-type FlashSaleRequest struct {
-	EventID      uint    `json:"event_id" binding:"required"`
+type FlashSaleItemRequest struct {
 	TicketTypeID uint    `json:"ticket_type_id" binding:"required"`
-	FlashPrice   float64 `json:"flash_price" binding:"required"`
+	FlashPrice   float64 `json:"flash_price"`
 	Quota        int     `json:"quota" binding:"required"`
-	FlashDate    string  `json:"flash_date"` // YYYY-MM-DD
-	StartTime    string  `json:"start_time"` // HH:MM
-	EndTime      string  `json:"end_time"`   // HH:MM
-	IsActive     *bool   `json:"is_active"`
+}
+
+type FlashSaleRequest struct {
+	EventID          uint                   `json:"event_id" binding:"required"`
+	Name             string                 `json:"name"`
+	StartsAt         time.Time              `json:"starts_at" binding:"required"` // RFC3339, e.g. 2026-11-11T11:00:00+07:00
+	EndsAt           time.Time              `json:"ends_at" binding:"required"`
+	PerCustomerLimit int                    `json:"per_customer_limit"`
+	WaitingRoom      bool                   `json:"waiting_room"`
+	QueueBatchSize   int                    `json:"queue_batch_size"`
+	QueueBatchEvery  int                    `json:"queue_batch_every"` // seconds
+	IsActive         *bool                  `json:"is_active"`
+	Items            []FlashSaleItemRequest `json:"items" binding:"required"`
 }
 
 type UpdateFlashSaleRequest struct {
-	FlashPrice *float64 `json:"flash_price"`
-	Quota      *int     `json:"quota"`
-	FlashDate  string   `json:"flash_date"` // YYYY-MM-DD
-	StartTime  string   `json:"start_time"` // HH:MM
-	EndTime    string   `json:"end_time"`   // HH:MM
-	IsActive   *bool    `json:"is_active"`
+	Name             *string                `json:"name"`
+	StartsAt         *time.Time             `json:"starts_at"`
+	EndsAt           *time.Time             `json:"ends_at"`
+	PerCustomerLimit *int                   `json:"per_customer_limit"`
+	WaitingRoom      *bool                  `json:"waiting_room"`
+	QueueBatchSize   *int                   `json:"queue_batch_size"`
+	QueueBatchEvery  *int                   `json:"queue_batch_every"`
+	IsActive         *bool                  `json:"is_active"`
+	Items            []FlashSaleItemRequest `json:"items"` // Replaces the items when given
+}
+
+// validateFlashSaleItems checks the items belong to the event, once each, and builds them.
+func validateFlashSaleItems(eventID uint, items []FlashSaleItemRequest) ([]models.FlashSaleItem, error) {
+	if len(items) == 0 {
+		return nil, errors.New("at least one ticket type is required")
+	}
+	seen := map[uint]bool{}
+	result := make([]models.FlashSaleItem, 0, len(items))
+	for _, item := range items {
+		if seen[item.TicketTypeID] {
+			return nil, fmt.Errorf("ticket type %d is listed twice", item.TicketTypeID)
+		}
+		seen[item.TicketTypeID] = true
+
+		var ticketType models.TicketType
+		if err := config.DB.Where("id = ? AND event_id = ?", item.TicketTypeID, eventID).First(&ticketType).Error; err != nil {
+			return nil, fmt.Errorf("ticket type %d does not belong to the event", item.TicketTypeID)
+		}
+		if item.Quota <= 0 || item.FlashPrice < 0 || item.FlashPrice >= ticketType.Price {
+			return nil, fmt.Errorf("ticket type '%s' needs a quota and a flash price below its normal price", ticketType.Name)
+		}
+		result = append(result, models.FlashSaleItem{TicketTypeID: item.TicketTypeID, FlashPrice: item.FlashPrice, Quota: item.Quota})
+	}
+	return result, nil
+}
+
+func validateFlashSale(sale models.FlashSale) error {
+	if !sale.EndsAt.After(sale.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if sale.PerCustomerLimit < 0 || sale.QueueBatchSize < 0 || sale.QueueBatchEvery < 0 {
+		return errors.New("per_customer_limit and queue settings can't be negative")
+	}
+	return nil
+}
+
+// findManagedFlashSale loads a campaign of an event the current admin/organizer manages.
+func findManagedFlashSale(c *gin.Context) (*models.FlashSale, bool) {
+	var flashSale models.FlashSale
+	if err := config.DB.First(&flashSale, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Flash sale not found"})
+		return nil, false
+	}
+	if _, ok := findManagedEvent(c, fmt.Sprint(flashSale.EventID)); !ok {
+		return nil, false
+	}
+	return &flashSale, true
 }
 
 func CreateFlashSale(c *gin.Context) {
@@ -34,6 +98,9 @@ func CreateFlashSale(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid input"})
 		return
 	}
+	if _, ok := findManagedEvent(c, fmt.Sprint(req.EventID)); !ok {
+		return
+	}
 
 	isActive := true
 	if req.IsActive != nil {
@@ -41,21 +108,26 @@ func CreateFlashSale(c *gin.Context) {
 	}
 
 	flashSale := models.FlashSale{
-		EventID:      req.EventID,
-		TicketTypeID: req.TicketTypeID,
-		FlashPrice:   req.FlashPrice,
-		Quota:        req.Quota,
-		StartTime:    req.StartTime,
-		EndTime:      req.EndTime,
-		IsActive:     isActive,
-	}
-
-	if req.FlashDate != "" {
-		fd, err := parseEventDate(req.FlashDate)
-		if err == nil {
-			flashSale.FlashDate = &fd
-		}
+		EventID:          req.EventID,
+		Name:             req.Name,
+		StartsAt:         req.StartsAt,
+		EndsAt:           req.EndsAt,
+		PerCustomerLimit: req.PerCustomerLimit,
+		WaitingRoom:      req.WaitingRoom,
+		QueueBatchSize:   req.QueueBatchSize,
+		QueueBatchEvery:  req.QueueBatchEvery,
+		IsActive:         isActive,
 	}
+	if err := validateFlashSale(flashSale); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	items, err := validateFlashSaleItems(req.EventID, req.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	flashSale.Items = items
 
 	if err := config.DB.Create(&flashSale).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create flash sale: " + err.Error()})
@@ -63,7 +135,7 @@ func CreateFlashSale(c *gin.Context) {
 	}
 
 	// Preload the relations so the response is not empty
-	config.DB.Preload("Event").Preload("TicketType").First(&flashSale, flashSale.ID)
+	config.DB.Preload("Event").Preload("Items.TicketType").First(&flashSale, flashSale.ID)
 
 	c.JSON(http.StatusCreated, gin.H{"success": true, "data": flashSale})
 }
@@ -72,21 +144,41 @@ func GetFlashSales(c *gin.Context) {
 	eventId := c.Query("event_id")
 	var sales []models.FlashSale
 
-	query := config.DB.Preload("Event").Preload("TicketType")
+	query := config.DB.Preload("Event").Preload("Items.TicketType").Order("starts_at")
 	if eventId != "" {
 		query = query.Where("event_id = ?", eventId)
 	}
+	public := false
+	switch role, _ := c.Get("userRole"); role {
+	case "admin":
+	case "organizer":
+		userID, _ := c.Get("userID")
+		query = query.Where("event_id IN (?)", config.DB.Model(&models.Event{}).Select("id").Where("organizer_id = ?", userID))
+	default:
+		// Checkout only sees campaigns that are running or coming up
+		query = query.Where("is_active = ? AND ends_at > ?", true, time.Now())
+		public = true
+	}
 
 	query.Find(&sales)
+	if public {
+		// Hidden ticket types stay out of public payloads, see publicTicketTypes
+		for i := range sales {
+			items := sales[i].Items[:0]
+			for _, item := range sales[i].Items {
+				if !item.TicketType.Hidden {
+					items = append(items, item)
+				}
+			}
+			sales[i].Items = items
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": sales})
 }
 
 func UpdateFlashSale(c *gin.Context) {
-	id := c.Param("id")
-	var flashSale models.FlashSale
-
-	if err := config.DB.First(&flashSale, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Flash sale not found"})
+	flashSale, ok := findManagedFlashSale(c)
+	if !ok {
 		return
 	}
 
@@ -96,37 +188,106 @@ func UpdateFlashSale(c *gin.Context) {
 		return
 	}
 
-	updates := map[string]interface{}{}
-
-	if req.FlashPrice != nil {
-		updates["flash_price"] = *req.FlashPrice
+	if req.Name != nil {
+		flashSale.Name = *req.Name
+	}
+	if req.StartsAt != nil {
+		flashSale.StartsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil {
+		flashSale.EndsAt = *req.EndsAt
+	}
+	if req.PerCustomerLimit != nil {
+		flashSale.PerCustomerLimit = *req.PerCustomerLimit
 	}
-	if req.Quota != nil {
-		updates["quota"] = *req.Quota
+	if req.WaitingRoom != nil {
+		flashSale.WaitingRoom = *req.WaitingRoom
 	}
-	if req.StartTime != "" {
-		updates["start_time"] = req.StartTime
+	if req.QueueBatchSize != nil {
+		flashSale.QueueBatchSize = *req.QueueBatchSize
 	}
-	if req.EndTime != "" {
-		updates["end_time"] = req.EndTime
+	if req.QueueBatchEvery != nil {
+		flashSale.QueueBatchEvery = *req.QueueBatchEvery
 	}
 	if req.IsActive != nil {
-		updates["is_active"] = *req.IsActive
+		flashSale.IsActive = *req.IsActive
 	}
-	if req.FlashDate != "" {
-		fd, err := parseEventDate(req.FlashDate)
-		if err == nil {
-			updates["flash_date"] = fd
+	if err := validateFlashSale(*flashSale); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	var items []models.FlashSaleItem
+	if req.Items != nil {
+		var err error
+		if items, err = validateFlashSaleItems(flashSale.EventID, req.Items); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
 		}
 	}
 
-	config.DB.Model(&flashSale).Updates(updates)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.FlashSale{}).Where("id = ?", flashSale.ID).Updates(map[string]interface{}{
+			"name":               flashSale.Name,
+			"starts_at":          flashSale.StartsAt,
+			"ends_at":            flashSale.EndsAt,
+			"per_customer_limit": flashSale.PerCustomerLimit,
+			"waiting_room":       flashSale.WaitingRoom,
+			"queue_batch_size":   flashSale.QueueBatchSize,
+			"queue_batch_every":  flashSale.QueueBatchEvery,
+			"is_active":          flashSale.IsActive,
+		}).Error; err != nil {
+			return err
+		}
+		if req.Items == nil {
+			return nil
+		}
+		// Items are matched by ticket type so sold counts carry over
+		keep := make([]uint, 0, len(items))
+		for _, item := range items {
+			keep = append(keep, item.TicketTypeID)
+			var existing models.FlashSaleItem
+			err := tx.Where("flash_sale_id = ? AND ticket_type_id = ?", flashSale.ID, item.TicketTypeID).First(&existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				item.FlashSaleID = flashSale.ID
+				if err := tx.Create(&item).Error; err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := tx.Model(&existing).Updates(map[string]interface{}{"flash_price": item.FlashPrice, "quota": item.Quota}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("flash_sale_id = ? AND ticket_type_id NOT IN ?", flashSale.ID, keep).Delete(&models.FlashSaleItem{}).Error; err != nil {
+			return err
+		}
+		for _, id := range keep {
+			if err := utils.SyncAvailability(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update flash sale: " + err.Error()})
+		return
+	}
 
+	config.DB.Preload("Event").Preload("Items.TicketType").First(flashSale, flashSale.ID)
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": flashSale})
 }
 
 func DeleteFlashSale(c *gin.Context) {
-	id := c.Param("id")
-	config.DB.Delete(&models.FlashSale{}, id)
+	flashSale, ok := findManagedFlashSale(c)
+	if !ok {
+		return
+	}
+	config.DB.Transaction(func(tx *gorm.DB) error {
+		tx.Where("flash_sale_id = ?", flashSale.ID).Delete(&models.FlashSaleItem{})
+		return tx.Delete(flashSale).Error
+	})
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Flash sale deleted"})
 }
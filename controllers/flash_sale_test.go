@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"kartcis-backend/waitingroom"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func flashCheckout(email string, ticketTypeID uint, qty int) gin.H {
+	return gin.H{
+		"items":          []gin.H{{"ticket_type_id": ticketTypeID, "quantity": qty}},
+		"payment_method": "MANUAL_BCA",
+		"customer_info":  gin.H{"name": "Budi", "email": email, "phone": "0812345678"},
+	}
+}
+
+func TestCreateOrder_FlashSaleCampaign(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Konser", Status: "published", FeePercentage: 0}
+	config.DB.Create(&event)
+	regular := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10}
+	config.DB.Create(&regular)
+	now := time.Now()
+	sale := models.FlashSale{EventID: event.ID, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour), PerCustomerLimit: 2, IsActive: true,
+		Items: []models.FlashSaleItem{{TicketTypeID: regular.ID, FlashPrice: 50000, Quota: 3}}}
+	config.DB.Create(&sale)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+
+	// Capped at 2 flash tickets, the third at the normal price
+	w, resp := doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", regular.ID, 3))
+	if !assert.Equal(t, http.StatusCreated, w.Code, resp["message"]) {
+		return
+	}
+	var tickets []models.Ticket
+	config.DB.Order("id").Find(&tickets)
+	if assert.Len(t, tickets, 3) {
+		assert.Equal(t, 50000.0, tickets[0].PurchasedPrice)
+		assert.Equal(t, &sale.ID, tickets[1].FlashSaleID)
+		assert.Equal(t, 100000.0, tickets[2].PurchasedPrice)
+		assert.Nil(t, tickets[2].FlashSaleID)
+	}
+	order := resp["data"].(map[string]interface{})
+	assert.InDelta(t, 200000, order["total_amount"].(float64)-order["unique_code"].(float64), 0.5)
+
+	var item models.FlashSaleItem
+	config.DB.Where("flash_sale_id = ?", sale.ID).First(&item)
+	assert.Equal(t, 2, item.Sold)
+
+	// Another buyer gets the one flash ticket left instead of a rejection
+	w, _ = doJSON(r, "POST", "/orders", flashCheckout("siti@example.com", regular.ID, 2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var flashTickets int64
+	config.DB.Model(&models.Ticket{}).Where("flash_sale_id = ?", sale.ID).Count(&flashTickets)
+	assert.Equal(t, int64(3), flashTickets)
+}
+
+func TestCreateOrder_FlashSaleWaitingRoom(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Konser", Status: "published"}
+	config.DB.Create(&event)
+	regular := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10}
+	config.DB.Create(&regular)
+	sale := models.FlashSale{EventID: event.ID, StartsAt: time.Now().Add(-time.Second), EndsAt: time.Now().Add(time.Hour), IsActive: true,
+		WaitingRoom: true, QueueBatchSize: 1, QueueBatchEvery: 60,
+		Items: []models.FlashSaleItem{{TicketTypeID: regular.ID, FlashPrice: 50000, Quota: 5}}}
+	config.DB.Create(&sale)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.POST("/events/:id/queue", JoinWaitingRoom)
+	r.GET("/events/:id/queue", GetWaitingRoomStatus)
+
+	w, resp := doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", regular.ID, 1))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["queue_required"])
+
+	// The campaign queues buyers in the event's waiting room
+	queuePath := fmt.Sprintf("/events/%d/queue", event.ID)
+	w, resp = doJSON(r, "POST", queuePath, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	first := resp["data"].(map[string]interface{})["token"].(string)
+	_, resp = doJSON(r, "POST", queuePath, nil)
+	second := resp["data"].(map[string]interface{})
+	assert.Equal(t, 2.0, second["position"])
+	assert.Equal(t, 1.0, second["rate_per_minute"])
+
+	assert.NoError(t, releaseEventWaitingRoom(event))
+	code, _ := doQueuedJSON(r, "/orders", flashCheckout("budi@example.com", regular.ID, 1), first)
+	assert.Equal(t, http.StatusCreated, code)
+	code, resp = doQueuedJSON(r, "/orders", flashCheckout("siti@example.com", regular.ID, 1), second["token"].(string))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, 2.0, resp["data"].(map[string]interface{})["position"])
+
+	var flashTickets int64
+	config.DB.Model(&models.Ticket{}).Where("flash_sale_id = ?", sale.ID).Count(&flashTickets)
+	assert.Equal(t, int64(1), flashTickets)
+}
+
+// releaseEventWaitingRoom does what the waiting_room_release job does for one event.
+func releaseEventWaitingRoom(event models.Event) error {
+	room, _, err := utils.EventWaitingRoom(config.DB, event, time.Now())
+	if err != nil {
+		return err
+	}
+	return waitingroom.Default().Release(context.Background(), room)
+}
@@ -2,10 +2,10 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -19,20 +19,29 @@ import (
 	"math"
 )
 
+type CheckoutAttendee struct {
+	Name                 string      `json:"name"`
+	Email                string      `json:"email"`
+	Phone                string      `json:"phone"`
+	CustomFieldResponses interface{} `json:"custom_field_responses"` // Allow object or string
+}
+
 type CheckoutRequest struct {
 	Items []struct {
-		TicketTypeID uint `json:"ticket_type_id"`
-		Quantity     int  `json:"quantity"`
-		Attendees    []struct {
-			Name                 string      `json:"name"`
-			Email                string      `json:"email"`
-			Phone                string      `json:"phone"`
-			CustomFieldResponses interface{} `json:"custom_field_responses"` // Allow object or string
-		} `json:"attendees"`
+		TicketTypeID uint               `json:"ticket_type_id"`
+		Quantity     int                `json:"quantity"`
+		Attendees    []CheckoutAttendee `json:"attendees"`
 	} `json:"items"`
+	// Merch, parking and other products that are not admission tickets
+	AddOns []struct {
+		ProductID uint  `json:"product_id"`
+		VariantID *uint `json:"variant_id"`
+		Quantity  int   `json:"quantity"`
+	} `json:"add_ons"`
 	PaymentMethod string `json:"payment_method"`
 	VoucherCode   string `json:"voucher_code"`  // Added for voucher discount
 	ReferralCode  string `json:"referral_code"` // Added for referral/affiliate
+	AccessCode    string `json:"access_code"`   // Unlocks hidden ticket types
 	// Guest Info (Optional if logged in)
 	CustomerInfo struct {
 		Name  string `json:"name"`
@@ -50,11 +59,18 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	ticketTypeIDs := make([]uint, 0, len(req.Items))
+	for _, item := range req.Items {
+		ticketTypeIDs = append(ticketTypeIDs, item.TicketTypeID)
+	}
+	if !admitCheckout(c, ticketTypeIDs) {
+		return
+	}
+
 	tx := config.DB.Begin()
 
 	var totalAmount float64
 	var orderItems []models.Ticket
-	ticketPrices := make(map[uint]float64)
 
 	// Determine Customer Info
 	var customerName, customerEmail, customerPhone string
@@ -86,12 +102,18 @@ func CreateOrder(c *gin.Context) {
 		userID = nil
 	}
 
+	flashCustomer := utils.FlashSaleCustomer{UserID: userID, Email: customerEmail}
+	flashPlanned := make(map[uint]int) // Flash tickets given per campaign earlier in this order
+
 	var totalAdminFee float64
+	var holdIDs []uint
+	var orderExpiresAt *time.Time
+	orderEvents := make(map[uint]models.Event) // Events of the order's tickets, for add-ons
 
 	for _, item := range req.Items {
 		var ticketType models.TicketType
 		// Preload Event to get FeePercentage
-		if err := tx.Preload("Event").First(&ticketType, item.TicketTypeID).Error; err != nil {
+		if err := tx.Preload("Event").Preload("BundleItems").First(&ticketType, item.TicketTypeID).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid ticket type"})
 			return
@@ -127,15 +149,50 @@ func CreateOrder(c *gin.Context) {
 			return
 		}
 
+		// --- SALE WINDOW & VISIBILITY ---
+		now := time.Now()
+		if ticketType.Hidden && !ticketType.Unlocks(req.AccessCode) {
+			tx.Rollback()
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Tiket ini membutuhkan kode akses yang valid."})
+			return
+		}
+		switch ticketType.SalesState(now) {
+		case models.SalesScheduled:
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Penjualan tiket '%s' belum dibuka.", ticketType.Name)})
+			return
+		case models.SalesClosed:
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Penjualan tiket '%s' sudah ditutup.", ticketType.Name)})
+			return
+		}
+		if item.Quantity < ticketType.MinPurchase {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Minimal pembelian tiket '%s' adalah %d tiket.", ticketType.Name, ticketType.MinPurchase)})
+			return
+		}
+
 		// --- CHECK MAX PURCHASE PER USER ---
 		if ticketType.MaxPurchasePerUser > 0 {
 			var alreadyPurchased int64
 			// Join with orders to check user's previous non-cancelled purchases
+			column := "tickets.ticket_type_id"
+			if ticketType.IsBundle() {
+				column = "tickets.bundle_ticket_type_id"
+			}
 			tx.Model(&models.Ticket{}).
 				Joins("JOIN orders ON orders.id = tickets.order_id").
-				Where("tickets.ticket_type_id = ? AND orders.status != ?", ticketType.ID, "cancelled").
+				Where(column+" = ? AND orders.status != ?", ticketType.ID, "cancelled").
 				Where("(orders.user_id = ? OR orders.customer_email = ?)", userID, customerEmail).
 				Count(&alreadyPurchased)
+			if ticketType.IsBundle() {
+				// A bundle purchase issues one ticket per admission
+				size := 0
+				for _, bundleItem := range ticketType.BundleItems {
+					size += bundleItem.Quantity
+				}
+				alreadyPurchased = int64(math.Ceil(float64(alreadyPurchased) / float64(size)))
+			}
 
 			if int(alreadyPurchased)+item.Quantity > ticketType.MaxPurchasePerUser {
 				tx.Rollback()
@@ -149,120 +206,119 @@ func CreateOrder(c *gin.Context) {
 			}
 		}
 
-		// --- FLASH SALE MODULE ---
-		// Determine active price: default to normal ticket price
-		activePrice := ticketType.Price
-		isFlashSaleContext := false
-
-		var activeFlashSales []models.FlashSale
-		var flashSale *models.FlashSale
-
-		// Load Timezone WIB (Asia/Jakarta) agar sinkron dengan database
-		loc, _ := time.LoadLocation("Asia/Jakarta")
-		now := time.Now().In(loc)
-
-		errFlash := tx.Where("ticket_type_id = ? AND is_active = true", ticketType.ID).Find(&activeFlashSales).Error
-		if errFlash == nil && len(activeFlashSales) > 0 {
-			ny, nm, nd := now.Date()
-			currentTimeStr := now.Format("15:04")
-
-			// Temukan 1 Flash Sale yang sedang aktif secara waktu persis saat ini
-			// Temukan Harga Termurah dari seluruh Flash Sale yang sedang aktif saat ini
-			for i := range activeFlashSales {
-				fs := activeFlashSales[i]
-				if fs.FlashDate != nil {
-					sy, sm, sd := fs.FlashDate.Date()
-					if sy == ny && sm == nm && sd == nd {
-						if fs.StartTime != "" && fs.EndTime != "" {
-							if currentTimeStr >= fs.StartTime && currentTimeStr < fs.EndTime {
-								// Pilih yang harganya paling murah jika ada jadwal bentrok
-								if flashSale == nil || fs.FlashPrice < flashSale.FlashPrice {
-									flashSale = &activeFlashSales[i]
-								}
-							}
-						}
-					}
-				}
-			}
+		holdExpiry := utils.HoldExpiry(ticketType.Event, now)
+		if orderExpiresAt == nil || holdExpiry.Before(*orderExpiresAt) {
+			orderExpiresAt = &holdExpiry
 		}
-
-		if flashSale != nil {
-			// Flash sale is active, check quota
-			availableFlashQuota := flashSale.Quota - flashSale.Sold
-			if availableFlashQuota >= item.Quantity {
-				isFlashSaleContext = true
-				activePrice = flashSale.FlashPrice
-			} else if availableFlashQuota > 0 {
-				// Edge case: Partially available flash sale
+		orderEvents[ticketType.EventID] = ticketType.Event
+
+		// --- BUNDLES ---
+		// A bundle (e.g. a 3-day pass) is sold at its own price and issues one ticket per
+		// admission, holding seats of each. Flash sales and price tiers don't apply.
+		if ticketType.IsBundle() {
+			holds, err := utils.HoldBundle(tx, 0, ticketType, item.Quantity, holdExpiry)
+			if err != nil {
 				tx.Rollback()
-				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Kuota Flash Sale sisa %d, mengurangi pesanan Anda.", availableFlashQuota)})
+				respondHoldError(c, ticketType, err)
 				return
 			}
-		}
+			for _, hold := range holds {
+				holdIDs = append(holdIDs, hold.ID)
+			}
 
-		if !isFlashSaleContext {
-			// Normal Ticketing Validation (Non-Flash Sale)
-			if ticketType.Available < item.Quantity {
-				tx.Rollback()
-				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Not enough quota for %s", ticketType.Name)})
-				return
+			itemSubtotal := ticketType.Price * float64(item.Quantity)
+			totalAmount += itemSubtotal
+			totalAdminFee += itemSubtotal * (ticketType.Event.FeePercentage / 100)
+
+			admissions := utils.BundleAdmissions(ticketType, ticketType.Price)
+			for i := 0; i < item.Quantity; i++ {
+				// Every ticket of one purchase belongs to the same attendee
+				attendeeName, attendeeEmail, attendeePhone, customResponses := checkoutAttendee(item.Attendees, i, customerName, customerEmail, customerPhone)
+				for j, admission := range admissions {
+					orderItems = append(orderItems, models.Ticket{
+						EventID:              ticketType.EventID,
+						TicketTypeID:         admission.TicketTypeID,
+						BundleTicketTypeID:   &ticketType.ID,
+						TicketCode:           fmt.Sprintf("T-%d-%d-%d-%d", time.Now().UnixNano(), ticketType.ID, i, j), // Placeholder, signed after insert
+						AttendeeName:         attendeeName,
+						AttendeeEmail:        attendeeEmail,
+						AttendeePhone:        attendeePhone,
+						PurchasedPrice:       admission.Price,
+						CustomFieldResponses: customResponses,
+						Status:               "active",
+					})
+				}
 			}
+			continue
+		}
 
-			// Deduct normal quota atomically
-			res := tx.Model(&ticketType).
-				Where("available >= ?", item.Quantity).
-				Update("available", gorm.Expr("available - ?", item.Quantity))
+		// --- FLASH SALE MODULE ---
+		// The running campaign's flash price covers what its quota and the customer's cap
+		// allow; the rest of the line is sold at the normal price.
+		offer, err := utils.PlanFlashSale(tx, ticketType.ID, item.Quantity, flashCustomer, flashPlanned, now)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to check flash sale"})
+			return
+		}
+		flashQty := 0
+		if offer != nil {
+			flashQty = offer.Quantity
+			flashPlanned[offer.Sale.ID] += flashQty
+		}
 
-			if err := res.Error; err != nil {
-				tx.Rollback()
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update quota (oversold check)"})
-				return
-			}
-			if res.RowsAffected == 0 {
-				tx.Rollback()
-				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Mohon maaf, tiket '%s' baru saja habis terjual atau kuota tidak cukup.", ticketType.Name)})
-				return
-			}
-		} else {
-			// Deduct flash sale quota
-			resFlash := tx.Model(flashSale).
-				Where("quota - sold >= ?", item.Quantity). // Extra safe check
-				Update("sold", gorm.Expr("sold + ?", item.Quantity))
+		// The rest of the line fills the open price tiers in order (early bird, presale,
+		// ...) and then the normal price.
+		portions, err := utils.PlanPriceTiers(tx, ticketType, item.Quantity-flashQty, now)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to check ticket price"})
+			return
+		}
 
-			if err := resFlash.Error; err != nil {
-				tx.Rollback()
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update flash sale quota"})
-				return
+		// Reserve seats with holds instead of decrementing quota; a hold is converted on
+		// payment or released on cancellation/expiry.
+		reserve := func(flashSaleID, priceTierID *uint, qty int) bool {
+			if qty == 0 {
+				return true
 			}
-			if resFlash.RowsAffected == 0 {
+			hold, err := utils.HoldTickets(tx, 0, ticketType.ID, flashSaleID, priceTierID, qty, holdExpiry)
+			if err != nil {
 				tx.Rollback()
-				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Mohon maaf, kuota Flash Sale sudah habis atau tidak mencukupi."})
-				return
+				respondHoldError(c, ticketType, err)
+				return false
 			}
-
-			// Optionally: still deduct event overall quota if needed?
-			// Usually Flash Sale Quota is a subset of Total Quota. Let's deduct both.
-			resMaster := tx.Model(&ticketType).
-				Where("available >= ?", item.Quantity).
-				Update("available", gorm.Expr("available - ?", item.Quantity))
-
-			if err := resMaster.Error; err != nil {
-				tx.Rollback()
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update master quota"})
-				return
+			holdIDs = append(holdIDs, hold.ID)
+			return true
+		}
+		// Per-ticket price and tier, flash tickets first
+		prices := make([]float64, 0, item.Quantity)
+		tierIDs := make([]*uint, 0, item.Quantity)
+		for i := 0; i < flashQty; i++ {
+			prices = append(prices, offer.Item.FlashPrice)
+			tierIDs = append(tierIDs, nil)
+		}
+		for _, portion := range portions {
+			var tierID *uint
+			if portion.Tier != nil {
+				tierID = &portion.Tier.ID
 			}
-			if resMaster.RowsAffected == 0 {
-				tx.Rollback()
-				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Mohon maaf, tiket utama '%s' sudah habis.", ticketType.Name)})
+			if !reserve(nil, tierID, portion.Quantity) {
 				return
 			}
+			for i := 0; i < portion.Quantity; i++ {
+				prices = append(prices, portion.Price)
+				tierIDs = append(tierIDs, tierID)
+			}
+		}
+		if offer != nil && !reserve(&offer.Sale.ID, nil, flashQty) {
+			return
 		}
 
-		// Refresh from DB to get the new 'available' value for the rest of the logic
-		tx.First(&ticketType, ticketType.ID)
-
-		ticketPrices[ticketType.ID] = activePrice
-		itemSubtotal := activePrice * float64(item.Quantity)
+		var itemSubtotal float64
+		for _, price := range prices {
+			itemSubtotal += price
+		}
 		totalAmount += itemSubtotal
 
 		// Calculate Admin Fee for this item based on Event settings
@@ -274,57 +330,63 @@ func CreateOrder(c *gin.Context) {
 
 		// Create tickets
 		for i := 0; i < item.Quantity; i++ {
-			attendeeName := customerName
-			attendeeEmail := customerEmail
-			attendeePhone := customerPhone
-			customResponses := ""
-
-			// If specific attendee info provided for this ticket index
-			if i < len(item.Attendees) {
-				if item.Attendees[i].Name != "" {
-					attendeeName = item.Attendees[i].Name
-				}
-				if item.Attendees[i].Email != "" {
-					attendeeEmail = item.Attendees[i].Email
-				}
-				if item.Attendees[i].Phone != "" {
-					attendeePhone = item.Attendees[i].Phone
-				}
-
-				// Handle CustomFieldResponses (could be string or object)
-				if item.Attendees[i].CustomFieldResponses != nil {
-					switch v := item.Attendees[i].CustomFieldResponses.(type) {
-					case string:
-						customResponses = v
-					default:
-						// Marshal object to JSON string
-						b, _ := json.Marshal(v)
-						customResponses = string(b)
-					}
-				}
-			}
+			attendeeName, attendeeEmail, attendeePhone, customResponses := checkoutAttendee(item.Attendees, i, customerName, customerEmail, customerPhone)
 
+			// The first tickets of the line take the flash price
 			var flashID *uint
-			if isFlashSaleContext && flashSale != nil {
-				id := flashSale.ID
+			if i < flashQty {
+				id := offer.Sale.ID
 				flashID = &id
 			}
 
 			orderItems = append(orderItems, models.Ticket{
 				EventID:              ticketType.EventID,
 				TicketTypeID:         ticketType.ID,
-				TicketCode:           fmt.Sprintf("T-%d-%d-%d", time.Now().UnixNano(), ticketType.ID, i),
+				TicketCode:           fmt.Sprintf("T-%d-%d-%d", time.Now().UnixNano(), ticketType.ID, i), // Placeholder, signed after insert
 				AttendeeName:         attendeeName,
 				AttendeeEmail:        attendeeEmail,
 				AttendeePhone:        attendeePhone,
-				PurchasedPrice:       activePrice,
+				PurchasedPrice:       prices[i],
 				FlashSaleID:          flashID,
+				PriceTierID:          tierIDs[i],
 				CustomFieldResponses: customResponses,
 				Status:               "active",
 			})
 		}
 	}
 
+	// Add-ons are reserved against their stock like seats, and only for the events the
+	// order has tickets for
+	var addOns []models.OrderAddOn
+	if len(req.AddOns) > 0 {
+		eventIDs := make(map[uint]bool)
+		for id := range orderEvents {
+			eventIDs[id] = true
+		}
+		lines := make([]utils.AddOnLine, 0, len(req.AddOns))
+		for _, addOn := range req.AddOns {
+			lines = append(lines, utils.AddOnLine{ProductID: addOn.ProductID, VariantID: addOn.VariantID, Quantity: addOn.Quantity})
+		}
+		var err error
+		addOns, err = utils.ReserveAddOns(tx, lines, eventIDs)
+		if err != nil {
+			tx.Rollback()
+			switch {
+			case errors.Is(err, utils.ErrInsufficientStock):
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Mohon maaf, stok add-on tidak cukup.", "error": err.Error()})
+			case errors.Is(err, utils.ErrInvalidAddOn):
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Add-on tidak valid.", "error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to reserve add-ons"})
+			}
+			return
+		}
+		for _, addOn := range addOns {
+			totalAmount += addOn.Subtotal()
+			totalAdminFee += addOn.Subtotal() * (orderEvents[addOn.EventID].FeePercentage / 100)
+		}
+	}
+
 	// Voucher Processing
 	var discountAmount float64
 	var appliedVoucherCode string
@@ -393,7 +455,15 @@ func CreateOrder(c *gin.Context) {
 						isEligible = false
 					}
 					if isEligible {
-						eligibleAmount += ticketPrices[item.TicketTypeID]
+						eligibleAmount += item.PurchasedPrice
+					}
+				}
+				// Event-wide vouchers cover the event's add-ons too
+				if voucher.TicketTypeID == nil {
+					for _, addOn := range addOns {
+						if voucher.EventID == nil || addOn.EventID == *voucher.EventID {
+							eligibleAmount += addOn.Subtotal()
+						}
 					}
 				}
 
@@ -476,42 +546,23 @@ func CreateOrder(c *gin.Context) {
 		}
 	}
 
-	// Handle Unique Code for Manual Bank Transfer (Enabled for all since Flip is disabled)
-	var uniqueCode int
-	if true { // Always generate for manual verification
-		// Generate unique code and ensure TOTAL AMOUNT is unique for pending orders
-		// Round base amount to nearest integer for IDR before adding unique code
-		baseAmount := math.Round(totalAmount + totalAdminFee - discountAmount - referralDiscount)
-
-		// 1. Get ALL codes used in the last 3 hours
-		var usedCodes []int
-		threeHoursAgo := time.Now().Add(-3 * time.Hour)
-		tx.Model(&models.Order{}).
-			Where("created_at >= ? AND total_amount >= ? AND total_amount <= ?", threeHoursAgo, baseAmount+101, baseAmount+999).
-			Pluck("unique_code", &usedCodes)
-
-		// 2. Check Capacity
-		if len(usedCodes) >= 899 {
-			tx.Rollback()
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"success": false,
-				"message": "Maaf, sistem pembayaran untuk nominal ini sedang sangat penuh. Mohon coba 15-30 menit lagi.",
-			})
-			return
-		}
-
-		// 3. Find First Available Slot (Linear Search)
-		usedMap := make(map[int]bool)
-		for _, code := range usedCodes {
-			usedMap[code] = true
-		}
-		for code := 101; code <= 999; code++ {
-			if !usedMap[code] {
-				uniqueCode = code
-				break
-			}
-		}
+	// Reserve a unique code so the transfer amount identifies the order. The allocator
+	// keeps totals unique among live orders, even under concurrent checkouts
+	baseAmount := math.Round(totalAmount + totalAdminFee - discountAmount - referralDiscount)
+	codeAllocation, err := utils.AllocateUniqueCode(tx, baseAmount)
+	if errors.Is(err, utils.ErrUniqueCodesExhausted) {
+		tx.Rollback()
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success": false,
+			"message": "Maaf, sistem pembayaran untuk nominal ini sedang sangat penuh. Mohon coba 15-30 menit lagi.",
+		})
+		return
+	} else if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to allocate payment code"})
+		return
 	}
+	uniqueCode := codeAllocation.Code
 
 	// Create Order
 	order := models.Order{
@@ -520,7 +571,7 @@ func CreateOrder(c *gin.Context) {
 		CustomerName:   customerName,
 		CustomerEmail:  customerEmail,
 		CustomerPhone:  customerPhone,
-		TotalAmount:    math.Round(totalAmount + totalAdminFee - discountAmount - referralDiscount) + float64(uniqueCode),
+		TotalAmount:    baseAmount + float64(uniqueCode),
 		AdminFee:       math.Round(totalAdminFee),
 		DiscountAmount: math.Round(discountAmount + referralDiscount), // Combined discount
 		VoucherCode:    appliedVoucherCode,
@@ -528,6 +579,7 @@ func CreateOrder(c *gin.Context) {
 		UniqueCode:     uniqueCode,
 		Status:         "pending",
 		PaymentMethod:  req.PaymentMethod,
+		ExpiresAt:      orderExpiresAt,
 		CreatedAt:      time.Now(),
 	}
 
@@ -545,6 +597,31 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// Attach seat holds and the unique code to the order
+	if err := tx.Model(&models.TicketHold{}).Where("id IN ?", holdIDs).Update("order_id", order.ID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to reserve tickets"})
+		return
+	}
+	if len(addOns) > 0 {
+		ids := make([]uint, 0, len(addOns))
+		for i := range addOns {
+			addOns[i].OrderID = order.ID
+			ids = append(ids, addOns[i].ID)
+		}
+		if err := tx.Model(&models.OrderAddOn{}).Where("id IN ?", ids).Update("order_id", order.ID).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to reserve add-ons"})
+			return
+		}
+		order.AddOns = addOns
+	}
+	if err := utils.AttachUniqueCode(tx, codeAllocation, order.ID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to allocate payment code"})
+		return
+	}
+
 	// Save tickets linked to order
 	for i := range orderItems {
 		orderItems[i].OrderID = &order.ID
@@ -553,6 +630,12 @@ func CreateOrder(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate tickets"})
 			return
 		}
+		// Replace the placeholder code with a signed one now that the ticket has an ID
+		if err := utils.IssueTicketCode(tx, &orderItems[i]); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate tickets"})
+			return
+		}
 	}
 
 	tx.Commit()
@@ -597,7 +680,7 @@ func GetUserOrders(c *gin.Context) {
 	query.Model(&models.Order{}).Count(&totalItems)
 
 	// Fetch Data
-	query.Preload("Tickets").Order("created_at desc").Limit(limit).Offset(offset).Find(&orders)
+	query.Preload("Tickets").Preload("AddOns").Order("created_at desc").Limit(limit).Offset(offset).Find(&orders)
 
 	totalPages := int(totalItems) / limit
 	if int(totalItems)%limit != 0 {
@@ -626,7 +709,7 @@ func GetOrderDetail(c *gin.Context) {
 	var order models.Order
 
 	// 1. Try find by order_number (SAFE for Guest)
-	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Where("order_number = ?", param).First(&order).Error; err == nil {
+	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").Where("order_number = ?", param).First(&order).Error; err == nil {
 		if loggedIn {
 			isAdmin := userRole == "admin"
 			isOwner := order.UserID != nil && *order.UserID == userID.(uint)
@@ -653,7 +736,7 @@ func GetOrderDetail(c *gin.Context) {
 			return
 		}
 
-		query := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Where("id = ?", id)
+		query := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").Where("id = ?", id)
 		if userRole != "admin" {
 			query = query.Where("user_id = ?", userID)
 		}
@@ -676,7 +759,7 @@ func GetOrderTickets(c *gin.Context) {
 
 	// 1. Try find by order_number (SAFE for Guest)
 	// We only need tickets here
-	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Where("order_number = ?", param).First(&order).Error; err == nil {
+	if err := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").Where("order_number = ?", param).First(&order).Error; err == nil {
 		if loggedIn {
 			isAdmin := userRole == "admin"
 			isOwner := order.UserID != nil && *order.UserID == userID.(uint)
@@ -703,7 +786,7 @@ func GetOrderTickets(c *gin.Context) {
 			return
 		}
 
-		query := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Where("id = ?", id)
+		query := config.DB.Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Preload("AddOns").Where("id = ?", id)
 		if userRole != "admin" {
 			query = query.Where("user_id = ?", userID)
 		}
@@ -717,6 +800,46 @@ func GetOrderTickets(c *gin.Context) {
 	c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Order not found"})
 }
 
+// DownloadOrderTicketsPDF returns every ticket of a paid order as one multi-page PDF.
+func DownloadOrderTicketsPDF(c *gin.Context) {
+	orderNumber := c.Param("order_number")
+	userID, loggedIn := c.Get("userID")
+	userRole, _ := c.Get("userRole")
+
+	var order models.Order
+	// Refunded tickets of a partially refunded order are void and get no page
+	if err := config.DB.Preload("Tickets", "status IN ?", []string{"active", "used"}).Preload("Tickets.Event").Preload("Tickets.TicketType").Preload("Tickets.BundleTicketType").Where("order_number = ?", orderNumber).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Order not found"})
+		return
+	}
+
+	// Same visibility rules as GetOrderTickets: guest orders by number, user orders by owner/admin
+	if order.UserID != nil {
+		if !loggedIn {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Please login to view these tickets"})
+			return
+		}
+		if userRole != "admin" && *order.UserID != userID.(uint) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "You are not authorized to view these tickets"})
+			return
+		}
+	}
+
+	if order.Status != "paid" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Tickets are only available for paid orders"})
+		return
+	}
+
+	pdf, err := utils.GenerateTicketsPDF(order.OrderNumber, order.Tickets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate ticket PDF", "error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=tickets-%s.pdf", order.OrderNumber))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
 func PayOrder(c *gin.Context) {
 	// Manual payment confirmation endpoint.
 	// In a real app, this might be called by an admin or triggered by manual transfer confirmation.
@@ -738,11 +861,12 @@ func PayOrder(c *gin.Context) {
 	}
 
 	// Simulate Success
-	now := time.Now()
-	config.DB.Model(&order).Updates(models.Order{
-		Status: "paid",
-		PaidAt: &now,
-	})
+	if _, err := utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusPaid, utils.OrderTransitionOptions{
+		Notes: "Payment simulated",
+	}); err != nil {
+		respondOrderTransitionError(c, order, err)
+		return
+	}
 
 	// Also Generate Tickets QR/Code if NOT generated at checkout?
 	// Current logic generated them at checkout as "active". If payment fails they should probably be "pending" or cancelled.
@@ -755,71 +879,55 @@ func PayOrder(c *gin.Context) {
 
 // Payment Callback (Webhook dari Flip)
 func PaymentCallback(c *gin.Context) {
-	callbackToken := os.Getenv("FLIP_WEBHOOK_TOKEN")
-
-	// Flip mengirim callback sebagai form-encoded:
-	// data  = JSON string berisi detail bill
-	// token = validation token (form field TERPISAH, bukan di dalam JSON)
-	dataStr := c.PostForm("data")
-	tokenStr := c.PostForm("token") // ← token di sini, bukan di dalam JSON data
-
-	if dataStr == "" {
-		// Log for debugging payload
-		log.Printf("[Flip-Callback] Empty data received")
-		c.Status(http.StatusBadRequest)
-		return
-	}
+	provider, _ := utils.GetPaymentProvider(utils.ProviderFlip)
 
-	// Validasi token (dari form field "token")
-	if callbackToken != "" && tokenStr != callbackToken {
-		log.Printf("[Flip-Callback] Invalid token received: %s", tokenStr)
-		c.Status(http.StatusUnauthorized)
-		return
-	}
-
-	var bill struct {
-		BillLinkID int64  `json:"bill_link_id"` // int64! Format berubah jadi 19 digit per April 10, 2026
-		Status     string `json:"status"`
-	}
-	if err := json.Unmarshal([]byte(dataStr), &bill); err != nil {
-		log.Printf("[Flip-Callback] Failed to parse data: %v", err)
+	notif, err := provider.ParseWebhook(c.Request)
+	if err != nil {
+		log.Printf("[Flip-Callback] Rejected callback: %v", err)
+		if errors.Is(err, utils.ErrWebhookInvalidToken) {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
 		c.Status(http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[Flip-Callback] Received: bill_link_id=%d, status=%s", bill.BillLinkID, bill.Status)
+	log.Printf("[Flip-Callback] Received: bill_link_id=%s, status=%s", notif.Reference, notif.RawStatus)
 
-	// Lookup order by payment_data yang menyimpan bill_link_id
+	// Lookup order by payment_reference yang menyimpan bill_link_id
 	var order models.Order
-
-	// Format terbaru ("146927")
-	exactMatch := fmt.Sprintf("%d", bill.BillLinkID)
-
-	if err := config.DB.Where("payment_data = ?", exactMatch).
+	if err := config.DB.Where("payment_reference = ?", notif.Reference).
 		First(&order).Error; err != nil {
 		// Return 200 agar Flip tidak retry terus
-		log.Printf("[Flip-Callback] Order not found for bill_link_id: %d", bill.BillLinkID)
+		log.Printf("[Flip-Callback] Order not found for bill_link_id: %s", notif.Reference)
 		c.Status(http.StatusOK)
 		return
 	}
 
-	status := "pending"
-	switch bill.Status {
-	case "SUCCESSFUL":
-		status = "paid"
-	case "CANCELLED", "FAILED":
-		status = "cancelled"
+	if notif.Status == "pending" {
+		c.Status(http.StatusOK)
+		return
 	}
 
-	if status == "pending" {
-		c.Status(http.StatusOK)
+	if err := utils.VerifyPaymentNotification(order, *notif); err != nil {
+		if errors.Is(err, utils.ErrOrderAlreadyInStatus) {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback already processed"})
+			return
+		}
+
+		// Money for an order that can't take it (or the wrong amount) needs an admin. The note
+		// on the timeline is the record; Flip gets a 200 so it stops retrying.
+		log.Printf("[Flip-Callback] Rejected %s callback for order %s: %v", notif.RawStatus, order.OrderNumber, err)
+		utils.AddOrderNoteOnce(config.DB, order, fmt.Sprintf("Flip callback %s rejected: %v", notif.RawStatus, err))
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback ignored"})
 		return
 	}
 
-	processOrderPayment(order.OrderNumber, status, c)
+	processOrderPayment(order.OrderNumber, notif.Status, c)
 }
 
-// Extracted internal function to process payment status
+// Extracted internal function to process payment status. Callbacks are retried by the
+// provider, so a transition that already happened is acknowledged without side effects.
 func processOrderPayment(orderNumber string, status string, c *gin.Context) {
 	var order models.Order
 	if err := config.DB.Where("order_number = ?", orderNumber).First(&order).Error; err != nil {
@@ -827,66 +935,42 @@ func processOrderPayment(orderNumber string, status string, c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
-
-	if status == "success" || status == "SUCCESSFUL" || status == "paid" {
-		now := time.Now()
-		if err := tx.Model(&order).Updates(models.Order{
-			Status: "paid",
-			PaidAt: &now,
-		}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update order status"})
-			return
-		}
-	} else if status == "cancelled" || status == "failed" || status == "CANCELLED" || status == "expired" {
-		if err := tx.Model(&order).Updates(models.Order{
-			Status: "cancelled",
-		}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update order status"})
-			return
-		}
-		if err := utils.RestoreQuota(tx, order.ID); err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore quota"})
-			return
-		}
-
-		// Restore used counts for vouchers/referrals and cancel commission
-		if err := restoreVoucherAndReferral(tx, order); err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore vouchers and referrals"})
-			return
-		}
+	var to string
+	switch status {
+	case "success", "SUCCESSFUL", "paid":
+		to = utils.OrderStatusPaid
+	case "cancelled", "failed", "CANCELLED", "expired":
+		to = utils.OrderStatusCancelled
+	default:
+		log.Printf("[Payment] Ignored %s callback for order %s", status, order.OrderNumber)
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback processed"})
+		return
 	}
 
-	// Record history
-	tx.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    status,
-		Notes:     "Callback received: " + status,
-		CreatedAt: time.Now(),
+	_, err := utils.OrderStates.Transition(config.DB, &order, to, utils.OrderTransitionOptions{
+		Notes:  "Callback received: " + status,
+		Reason: "Pembayaran dibatalkan",
 	})
-
-	tx.Commit()
-
-	// If status is paid, send email (Triggered outside transaction for performance)
-	if status == "success" || status == "SUCCESSFUL" || status == "paid" {
-		var tickets []models.Ticket
-		config.DB.Preload("Event").Preload("TicketType").Where("order_id = ?", order.ID).Find(&tickets)
-		utils.SendTicketEmail(order, tickets)
-
-		// Record history: E-Ticket Sent
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback processed"})
+	case errors.Is(err, utils.ErrOrderAlreadyInStatus):
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback already processed"})
+	case errors.Is(err, utils.ErrOrderTransitionNotAllowed), errors.Is(err, utils.ErrOrderStatusChanged),
+		errors.Is(err, utils.ErrInsufficientQuota), errors.Is(err, utils.ErrInsufficientFlashQuota),
+		errors.Is(err, utils.ErrInsufficientStock):
+		// Acknowledge so the provider stops retrying; the order needs a manual look
+		log.Printf("[Payment] Ignored %s callback for order %s (%s): %v", status, order.OrderNumber, order.Status, err)
 		config.DB.Create(&models.OrderStatusHistory{
 			OrderID:   order.ID,
-			Status:    "paid",
-			Notes:     "E-Ticket email sent to customer",
+			Status:    order.Status,
+			Notes:     fmt.Sprintf("Callback received: %s (not applied: %v)", status, err),
 			CreatedAt: time.Now(),
 		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback ignored"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update order status"})
 	}
-
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Callback processed"})
 }
 
 func UserCancelOrder(c *gin.Context) {
@@ -907,91 +991,84 @@ func UserCancelOrder(c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
-
-	if err := tx.Model(&order).Update("status", "cancelled").Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to cancel order", "error": err.Error()})
-		return
-	}
-
-	// Restore Quota
-	if err := utils.RestoreQuota(tx, order.ID); err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore quota"})
-		return
-	}
-
-	// Restore Vouchers and Referrals
-	if err := restoreVoucherAndReferral(tx, order); err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore vouchers and referrals"})
+	if _, err := utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusCancelled, utils.OrderTransitionOptions{
+		Notes:  "Cancelled by user",
+		Reason: "Dibatalkan oleh pengguna",
+	}); err != nil {
+		respondOrderTransitionError(c, order, err)
 		return
 	}
 
-	// Record history
-	tx.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    "cancelled",
-		Notes:     "Cancelled by user",
-		CreatedAt: time.Now(),
-	})
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order cancelled successfully", "data": order})
+}
 
-	tx.Commit()
+// processPaymentGateway creates the charge with the provider configured for the payment method.
+func processPaymentGateway(order *models.Order, paymentMethod string, userID *uint) error {
+	provider := utils.ResolvePaymentProvider(config.DB, paymentMethod)
+	order.PaymentProvider = provider.Name()
+	return provider.CreateCharge(order)
+}
 
-	// Send Cancellation Email
-	utils.SendOrderCancelledEmail(order, "Dibatalkan oleh pengguna")
+// respondOrderTransitionError maps utils.OrderStateMachine errors to responses.
+func respondOrderTransitionError(c *gin.Context, order models.Order, err error) {
+	switch {
+	case errors.Is(err, utils.ErrOrderAlreadyInStatus), errors.Is(err, utils.ErrOrderTransitionNotAllowed):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("Cannot change status because the transaction is already %s", order.Status),
+		})
+	case errors.Is(err, utils.ErrOrderStatusChanged):
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Transaction status changed, please reload"})
+	case errors.Is(err, utils.ErrInsufficientQuota), errors.Is(err, utils.ErrInsufficientFlashQuota),
+		errors.Is(err, utils.ErrInsufficientStock):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Tickets of this order are no longer available"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update order status"})
+	}
+}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order cancelled successfully", "data": order})
+// respondHoldError maps the errors of reserving seats of a ticket type to responses.
+func respondHoldError(c *gin.Context, ticketType models.TicketType, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInsufficientFlashQuota):
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Mohon maaf, kuota Flash Sale baru saja berubah. Silakan coba lagi."})
+	case errors.Is(err, utils.ErrPriceTierSoldOut):
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Mohon maaf, harga tiket baru saja berubah. Silakan coba lagi."})
+	case errors.Is(err, utils.ErrInsufficientQuota):
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Mohon maaf, tiket '%s' baru saja habis terjual atau kuota tidak cukup.", ticketType.Name)})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to reserve tickets"})
+	}
 }
 
-// Helper to restore limits on cancellation
-func restoreVoucherAndReferral(tx *gorm.DB, order models.Order) error {
-	if order.VoucherCode != "" {
-		if err := tx.Model(&models.Voucher{}).Where("code = ?", order.VoucherCode).
-			Update("used_count", gorm.Expr("used_count - ?", 1)).Error; err != nil {
-			return err
-		}
+// checkoutAttendee returns the attendee of the i-th ticket of a checkout line, falling
+// back to the customer for anything not given.
+func checkoutAttendee(attendees []CheckoutAttendee, i int, name, email, phone string) (string, string, string, string) {
+	customResponses := ""
+	if i >= len(attendees) {
+		return name, email, phone, customResponses
 	}
-	if order.ReferralCode != "" {
-		if err := tx.Model(&models.ReferralCode{}).Where("code = ?", order.ReferralCode).
-			Update("used_count", gorm.Expr("used_count - ?", 1)).Error; err != nil {
-			return err
-		}
+	attendee := attendees[i]
+	if attendee.Name != "" {
+		name = attendee.Name
+	}
+	if attendee.Email != "" {
+		email = attendee.Email
+	}
+	if attendee.Phone != "" {
+		phone = attendee.Phone
 	}
-	return nil
-}
 
-// processPaymentGateway abstracts the payment generation logic.
-func processPaymentGateway(order *models.Order, paymentMethod string, userID *uint) error {
-	/* Disabling Flip for now as it's not working in prod
-	pm := strings.ToUpper(strings.TrimSpace(paymentMethod))
-	if !strings.HasPrefix(pm, "BANK_TRANSFER_") && pm != "MANUAL_JAGO" {
-		if os.Getenv("FLIP_API_KEY") != "" {
-			resp, err := utils.CreateFlipBill(order.OrderNumber, int(order.TotalAmount), order.CustomerName, order.CustomerEmail, order.CustomerPhone, os.Getenv("FRONTEND_URL")+"/orders/"+order.OrderNumber, nil)
-			if err == nil {
-				order.PaymentURL = resp.PaymentURL
-				order.PaymentData = fmt.Sprintf("%d", resp.ID)
-				return nil
-			}
-			return err
-		}
-	}
-	*/
-
-	// Default to Manual Jago for all payments for now
-	order.PaymentMethod = "MANUAL_JAGO"
-	accNo := os.Getenv("JAGO_ACCOUNT_NUMBER")
-	accName := os.Getenv("JAGO_ACCOUNT_NAME")
-	if accNo == "" {
-		accNo = "1010101020" // Default Demo
-		accName = "Kartcis Demo Account"
-	}
-	order.VirtualAccountNumber = accNo
-	order.PaymentData = accName
-	order.PaymentInstructions = fmt.Sprintf(
-		"Silakan transfer ke Bank Jago: %s a/n %s. Pastikan nominal sampai 3 digit terakhir (Rp %v) agar dapat diverifikasi otomatis.",
-		accNo, accName, utils.FormatPrice(order.TotalAmount),
-	)
-	return nil
+	// Handle CustomFieldResponses (could be string or object)
+	if attendee.CustomFieldResponses != nil {
+		switch v := attendee.CustomFieldResponses.(type) {
+		case string:
+			customResponses = v
+		default:
+			// Marshal object to JSON string
+			b, _ := json.Marshal(v)
+			customResponses = string(b)
+		}
+	}
+	return name, email, phone, customResponses
 }
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOrder_BundleWithAddOns(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Festival", Status: "published", FeePercentage: 10}
+	config.DB.Create(&event)
+	var days []models.TicketType
+	for _, name := range []string{"Day 1", "Day 2", "Day 3"} {
+		day := models.TicketType{EventID: event.ID, Name: name, Price: 100000, Quota: 5, Available: 5}
+		config.DB.Create(&day)
+		days = append(days, day)
+	}
+	pass := models.TicketType{EventID: event.ID, Name: "3-Day Pass", Price: 240000, Quota: 2, Available: 2}
+	config.DB.Create(&pass)
+	assert.NoError(t, utils.SaveBundleItems(config.DB, pass, []models.BundleItem{
+		{TicketTypeID: days[0].ID}, {TicketTypeID: days[1].ID}, {TicketTypeID: days[2].ID},
+	}))
+	shirt := models.Product{EventID: event.ID, Name: "Kaos", Price: 100000, IsActive: true,
+		Variants: []models.ProductVariant{{Name: "M"}, {Name: "L"}}}
+	parking := models.Product{EventID: event.ID, Name: "Parkir", Price: 20000, Stock: 1, IsActive: true}
+	config.DB.Create(&shirt)
+	config.DB.Create(&parking)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.POST("/orders/:order_number/cancel", UserCancelOrder)
+	r.GET("/events/:id", GetEventDetail)
+
+	// A hidden component of a public bundle is listed by ID only
+	config.DB.Model(&days[2]).Updates(map[string]interface{}{"hidden": true, "access_code": "CREW"})
+	w, resp := doJSON(r, "GET", "/events/"+fmt.Sprint(event.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "CREW")
+	for _, tt := range resp["data"].(map[string]interface{})["ticket_types"].([]interface{}) {
+		ticketType := tt.(map[string]interface{})
+		if ticketType["name"] != pass.Name {
+			continue
+		}
+		items := ticketType["bundle_items"].([]interface{})
+		if assert.Len(t, items, 3) {
+			assert.Contains(t, items[0], "ticket_type")
+			assert.NotContains(t, items[2], "ticket_type")
+		}
+	}
+
+	body := flashCheckout("budi@example.com", pass.ID, 1)
+	body["add_ons"] = []gin.H{
+		{"product_id": shirt.ID, "variant_id": shirt.Variants[0].ID, "quantity": 1},
+		{"product_id": parking.ID, "quantity": 1},
+	}
+	w, resp = doJSON(r, "POST", "/orders", body)
+	if !assert.Equal(t, http.StatusCreated, w.Code, resp["message"]) {
+		return
+	}
+
+	// One purchase of the pass issues a ticket per day, sharing its price
+	var tickets []models.Ticket
+	config.DB.Order("id").Find(&tickets)
+	if assert.Len(t, tickets, 3) {
+		for i, ticket := range tickets {
+			assert.Equal(t, days[i].ID, ticket.TicketTypeID)
+			assert.Equal(t, &pass.ID, ticket.BundleTicketTypeID)
+			assert.Equal(t, 80000.0, ticket.PurchasedPrice)
+		}
+	}
+	assert.Equal(t, 4, availableSeats(days[0].ID))
+	assert.Equal(t, 1, availableSeats(pass.ID))
+
+	// Add-ons count towards the total and the fee
+	order := resp["data"].(map[string]interface{})
+	assert.Len(t, order["add_ons"], 2)
+	assert.InDelta(t, 396000, order["total_amount"].(float64)-order["unique_code"].(float64), 0.5)
+	assert.Equal(t, 36000.0, order["admin_fee"])
+
+	// Parking is sold out, and the shirt needs a size
+	body = flashCheckout("siti@example.com", days[0].ID, 1)
+	body["add_ons"] = []gin.H{{"product_id": parking.ID, "quantity": 1}}
+	w, _ = doJSON(r, "POST", "/orders", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	body["add_ons"] = []gin.H{{"product_id": shirt.ID, "quantity": 1}}
+	w, _ = doJSON(r, "POST", "/orders", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Cancelling gives the seats and the stock back
+	w, _ = doJSON(r, "POST", "/orders/"+order["order_number"].(string)+"/cancel", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	config.DB.First(&parking, parking.ID)
+	assert.Equal(t, 0, parking.Sold)
+	assert.Equal(t, 5, availableSeats(days[0].ID))
+	assert.Equal(t, 2, availableSeats(pass.ID))
+}
+
+func availableSeats(id uint) int {
+	var tt models.TicketType
+	config.DB.First(&tt, id)
+	return tt.Available
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func paymentRouter() *gin.Engine {
+	r := gin.New()
+	r.POST("/orders/:order_number/payment/:status", func(c *gin.Context) {
+		processOrderPayment(c.Param("order_number"), c.Param("status"), c)
+	})
+	return r
+}
+
+func TestProcessOrderPayment_RepeatedPaidIsNoop(t *testing.T) {
+	setupControllerDB(t)
+	order := models.Order{OrderNumber: "ORD-PAY", Status: "pending", TotalAmount: 100000}
+	config.DB.Create(&order)
+	r := paymentRouter()
+
+	w, resp := doJSON(r, "POST", "/orders/ORD-PAY/payment/SUCCESSFUL", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback processed", resp["message"])
+
+	var histories int64
+	config.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ?", order.ID).Count(&histories)
+
+	// Retried webhook: acknowledged, nothing written
+	w, resp = doJSON(r, "POST", "/orders/ORD-PAY/payment/SUCCESSFUL", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback already processed", resp["message"])
+
+	var after int64
+	config.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ?", order.ID).Count(&after)
+	assert.Equal(t, histories, after)
+
+	// A late cancel callback must not cancel a paid order
+	doJSON(r, "POST", "/orders/ORD-PAY/payment/CANCELLED", nil)
+	var reloaded models.Order
+	config.DB.First(&reloaded, order.ID)
+	assert.Equal(t, "paid", reloaded.Status)
+	assert.NotNil(t, reloaded.PaidAt)
+}
+
+func flipCallback(r http.Handler, linkID string, amount int, status string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	form := url.Values{}
+	form.Set("data", fmt.Sprintf(`{"bill_link_id":%s,"amount":%d,"status":"%s"}`, linkID, amount, status))
+	req := httptest.NewRequest("POST", "/payment/callback", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestPaymentCallback_VerifiesAmountAndFinalOrders(t *testing.T) {
+	setupControllerDB(t)
+	t.Setenv("FLIP_WEBHOOK_TOKEN", "")
+	order := models.Order{OrderNumber: "ORD-FLIP-CB", Status: "pending", TotalAmount: 125000, PaymentProvider: "flip", PaymentReference: "9001"}
+	expired := models.Order{OrderNumber: "ORD-FLIP-EXP", Status: "expired", TotalAmount: 80000, PaymentProvider: "flip", PaymentReference: "9002"}
+	config.DB.Create(&order)
+	config.DB.Create(&expired)
+
+	r := gin.New()
+	r.POST("/payment/callback", PaymentCallback)
+
+	// Wrong or missing amount: acknowledged but not paid, noted once however often Flip retries
+	w, resp := flipCallback(r, "9001", 12500, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback ignored", resp["message"])
+	flipCallback(r, "9001", 12500, "SUCCESSFUL")
+	w, _ = flipCallback(r, "9001", 0, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+	config.DB.First(&order, order.ID)
+	assert.Equal(t, "pending", order.Status)
+	var notes int64
+	config.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ? AND notes LIKE ?", order.ID, "%received, order total%").Count(&notes)
+	assert.Equal(t, int64(1), notes)
+	config.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ? AND notes LIKE ?", order.ID, "%no amount reported%").Count(&notes)
+	assert.Equal(t, int64(1), notes)
+
+	w, resp = flipCallback(r, "9001", 125000, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback processed", resp["message"])
+	config.DB.First(&order, order.ID)
+	assert.Equal(t, "paid", order.Status)
+
+	// Duplicate notification
+	w, resp = flipCallback(r, "9001", 125000, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback already processed", resp["message"])
+
+	// Final orders ignore other outcomes
+	w, resp = flipCallback(r, "9001", 125000, "CANCELLED")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Callback ignored", resp["message"])
+	w, _ = flipCallback(r, "9002", 80000, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+	config.DB.First(&expired, expired.ID)
+	assert.Equal(t, "expired", expired.Status)
+	config.DB.First(&order, order.ID)
+	assert.Equal(t, "paid", order.Status)
+
+	// Unknown bill: acknowledged so Flip stops retrying
+	w, _ = flipCallback(r, "12345", 1000, "SUCCESSFUL")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
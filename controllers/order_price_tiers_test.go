@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOrder_SplitsAcrossPriceTiers(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Konser", Status: "published", MinPrice: 100000, MaxPrice: 100000}
+	config.DB.Create(&event)
+	regular := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10,
+		PriceTiers: []models.TicketPriceTier{
+			{Name: "Early Bird", Price: 50000, Quota: 2},
+			{Name: "Presale", Price: 75000, Quota: 2, Position: 1},
+		}}
+	config.DB.Create(&regular)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.GET("/events/:id", GetEventDetail)
+
+	// Two early bird tickets, then the presale price
+	w, resp := doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", regular.ID, 3))
+	if !assert.Equal(t, http.StatusCreated, w.Code, resp["message"]) {
+		return
+	}
+	var tickets []models.Ticket
+	config.DB.Order("id").Find(&tickets)
+	if assert.Len(t, tickets, 3) {
+		assert.Equal(t, 50000.0, tickets[0].PurchasedPrice)
+		assert.Equal(t, 50000.0, tickets[1].PurchasedPrice)
+		assert.Equal(t, 75000.0, tickets[2].PurchasedPrice)
+		assert.Equal(t, &regular.PriceTiers[1].ID, tickets[2].PriceTierID)
+	}
+	order := resp["data"].(map[string]interface{})
+	assert.InDelta(t, 175000, order["total_amount"].(float64)-order["unique_code"].(float64), 0.5)
+
+	// The listing price follows the live tier
+	w, resp = doJSON(r, "GET", fmt.Sprintf("/events/%d", event.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, 75000.0, data["min_price"])
+	ticketType := data["ticket_types"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, 75000.0, ticketType["current_price"])
+	assert.Len(t, ticketType["price_tiers"], 2)
+
+	// The last presale ticket, then the normal price
+	w, _ = doJSON(r, "POST", "/orders", flashCheckout("siti@example.com", regular.ID, 2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var normal int64
+	config.DB.Model(&models.Ticket{}).Where("price_tier_id IS NULL AND purchased_price = ?", 100000).Count(&normal)
+	assert.Equal(t, int64(1), normal)
+	config.DB.First(&event, event.ID)
+	assert.Equal(t, 100000.0, event.MinPrice)
+}
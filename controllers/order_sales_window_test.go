@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOrder_SalesWindowAndVisibility(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Konser", Status: "published"}
+	config.DB.Create(&event)
+	now := time.Now()
+	tomorrow, yesterday := now.Add(24*time.Hour), now.Add(-24*time.Hour)
+	regular := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10, MinPurchase: 2}
+	presale := models.TicketType{EventID: event.ID, Name: "Presale", Price: 80000, Quota: 10, Available: 10, SalesStart: &tomorrow}
+	closed := models.TicketType{EventID: event.ID, Name: "Early", Price: 60000, Quota: 10, Available: 10, SalesEnd: &yesterday}
+	invite := models.TicketType{EventID: event.ID, Name: "Sponsor", Price: 50000, Quota: 10, Available: 10, Hidden: true, AccessCode: "SPONSOR24"}
+	for _, tt := range []*models.TicketType{&regular, &presale, &closed, &invite} {
+		config.DB.Create(tt)
+	}
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.GET("/events/:id", GetEventDetail)
+
+	w, resp := doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", regular.ID, 1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "Minimal pembelian")
+	w, resp = doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", presale.ID, 1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "belum dibuka")
+	w, resp = doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", closed.ID, 1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "sudah ditutup")
+
+	// Hidden ticket types need the access code, in any case
+	w, _ = doJSON(r, "POST", "/orders", flashCheckout("budi@example.com", invite.ID, 1))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	body := flashCheckout("budi@example.com", invite.ID, 1)
+	body["access_code"] = "sponsor24"
+	w, resp = doJSON(r, "POST", "/orders", body)
+	assert.Equal(t, http.StatusCreated, w.Code, resp["message"])
+
+	names := func(query string) []string {
+		w, resp := doJSON(r, "GET", fmt.Sprintf("/events/%d%s", event.ID, query), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result []string
+		for _, tt := range resp["data"].(map[string]interface{})["ticket_types"].([]interface{}) {
+			ticketType := tt.(map[string]interface{})
+			assert.NotContains(t, ticketType, "access_code")
+			result = append(result, ticketType["name"].(string)+":"+ticketType["sales_status"].(string))
+		}
+		return result
+	}
+	assert.ElementsMatch(t, []string{"Regular:on_sale", "Presale:scheduled", "Early:closed"}, names(""))
+	assert.ElementsMatch(t, []string{"Regular:on_sale", "Presale:scheduled", "Early:closed"}, names("?access_code=WRONG"))
+	assert.ElementsMatch(t, []string{"Regular:on_sale", "Presale:scheduled", "Early:closed", "Sponsor:on_sale"}, names("?access_code=SPONSOR24"))
+}
@@ -10,6 +10,7 @@ import (
 
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -246,8 +247,8 @@ func GetReferralStats(c *gin.Context) {
 		return
 	}
 
-	// Status yang dihitung sebagai sales (kecuali cancelled)
-	validStatuses := []string{"pending", "paid", "success", "SUCCESSFUL"}
+	// Status yang dihitung sebagai sales (belum / sudah dibayar)
+	validStatuses := []string{utils.OrderStatusPending, utils.OrderStatusPaid}
 
 	// 1. Total orders
 	var totalOrders int64
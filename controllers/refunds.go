@@ -0,0 +1,494 @@
+package controllers
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Refunds of paid orders:
+//   1. the customer requests a refund of some or all active tickets, with a reason
+//   2. an admin or the event's organizer approves (tickets become "refunded", seats go back
+//      on sale) or rejects it
+//   3. once the money is transferred the refund is marked completed
+//
+// A refund that leaves no ticket on the order moves the order to "refunded" and reverses
+// its voucher and referral usage.
+
+// findCustomerOrder loads an order for the customer refund endpoints with the same access
+// rules as GetOrderTickets. Guest orders must also pass the customer email.
+func findCustomerOrder(c *gin.Context, email string) (*models.Order, bool) {
+	var order models.Order
+	if err := config.DB.Where("order_number = ?", c.Param("order_number")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Order not found"})
+		return nil, false
+	}
+
+	userID, loggedIn := c.Get("userID")
+	if order.UserID != nil {
+		if !loggedIn {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Please login to manage this order"})
+			return nil, false
+		}
+		if role, _ := c.Get("userRole"); role != "admin" && *order.UserID != userID.(uint) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "You are not authorized to manage this order"})
+			return nil, false
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(email), order.CustomerEmail) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Email does not match the order"})
+		return nil, false
+	}
+	return &order, true
+}
+
+// managedRefundScope limits a refunds query to refunds whose tickets all belong to the
+// organizer's events. Admins see everything.
+func managedRefundScope(c *gin.Context, query *gorm.DB) *gorm.DB {
+	if role, _ := c.Get("userRole"); role == "organizer" {
+		query = query.Where(`NOT EXISTS (
+			SELECT 1 FROM refund_items
+			JOIN tickets ON tickets.id = refund_items.ticket_id
+			JOIN events ON events.id = tickets.event_id
+			WHERE refund_items.refund_id = refunds.id AND events.organizer_id <> ?)`, currentUserID(c))
+	}
+	return query
+}
+
+// findManagedRefund loads a refund the current admin/organizer may review.
+func findManagedRefund(c *gin.Context) (*models.Refund, bool) {
+	var refund models.Refund
+	query := managedRefundScope(c, config.DB.Preload("Items.Ticket").Preload("Order").Where("refunds.id = ?", c.Param("id")))
+	if err := query.First(&refund).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Refund not found"})
+		return nil, false
+	}
+	return &refund, true
+}
+
+func refundTicketIDs(refund models.Refund) []uint {
+	ids := make([]uint, 0, len(refund.Items))
+	for _, item := range refund.Items {
+		ids = append(ids, item.TicketID)
+	}
+	return ids
+}
+
+// RequestRefund lets the customer ask for a refund of a paid order. Without ticket_ids every
+// active ticket is included (full refund).
+func RequestRefund(c *gin.Context) {
+	var input struct {
+		TicketIDs []uint `json:"ticket_ids"`
+		Reason    string `json:"reason" binding:"required"`
+		Email     string `json:"email"` // Required for guest orders
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Reason is required"})
+		return
+	}
+
+	order, ok := findCustomerOrder(c, input.Email)
+	if !ok {
+		return
+	}
+	if order.Status != "paid" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("Cannot refund order because it is %s", order.Status),
+		})
+		return
+	}
+
+	var tickets []models.Ticket
+	config.DB.Where("order_id = ? AND status <> ?", order.ID, "refunded").Order("id ASC").Find(&tickets)
+
+	selected := []models.Ticket{}
+	if len(input.TicketIDs) == 0 {
+		for _, t := range tickets {
+			if t.Status == "active" {
+				selected = append(selected, t)
+			}
+		}
+	} else {
+		wanted := make(map[uint]bool, len(input.TicketIDs))
+		for _, id := range input.TicketIDs {
+			wanted[id] = true
+		}
+		for _, t := range tickets {
+			if !wanted[t.ID] {
+				continue
+			}
+			if t.Status != "active" {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Ticket %d has already been used", t.ID)})
+				return
+			}
+			selected = append(selected, t)
+			delete(wanted, t.ID)
+		}
+		if len(wanted) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Some tickets do not belong to this order or are already refunded"})
+			return
+		}
+	}
+	if len(selected) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "No refundable tickets on this order"})
+		return
+	}
+
+	ids := make([]uint, 0, len(selected))
+	for _, t := range selected {
+		ids = append(ids, t.ID)
+	}
+	var pending int64
+	config.DB.Model(&models.RefundItem{}).
+		Joins("JOIN refunds ON refunds.id = refund_items.refund_id").
+		Where("refund_items.ticket_id IN ? AND refunds.status = ?", ids, "requested").
+		Count(&pending)
+	if pending > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "A refund request for these tickets is already pending"})
+		return
+	}
+
+	// Full refunds return whatever is left of the payment (fees included); partial refunds
+	// the price paid for each ticket, less its share of the order discount
+	remaining := order.TotalAmount - order.RefundedAmount
+	refund := models.Refund{
+		OrderID: order.ID,
+		Status:  "requested",
+		IsFull:  len(selected) == len(tickets),
+		Reason:  input.Reason,
+	}
+	if userID, loggedIn := c.Get("userID"); loggedIn {
+		id := userID.(uint)
+		refund.RequestedBy = &id
+	}
+	for _, t := range selected {
+		amount := ticketRefundAmount(*order, t.PurchasedPrice)
+		refund.Items = append(refund.Items, models.RefundItem{TicketID: t.ID, Amount: amount})
+		refund.Amount += amount
+	}
+	if refund.IsFull || refund.Amount > remaining {
+		refund.Amount = remaining
+	}
+
+	tx := config.DB.Begin()
+	if err := tx.Create(&refund).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create refund request"})
+		return
+	}
+	tx.Create(&models.OrderStatusHistory{
+		OrderID:   order.ID,
+		Status:    order.Status,
+		Notes:     fmt.Sprintf("Refund #%d requested for %d ticket(s): %s", refund.ID, len(refund.Items), input.Reason),
+		CreatedAt: time.Now(),
+	})
+	tx.Commit()
+
+	utils.SendRefundEmail(*order, refund)
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Refund requested", "data": refund})
+}
+
+// ticketRefundAmount is the price of a ticket less its share of the order discount. The
+// discount is spread over the order subtotal (tickets and add-ons) before fees.
+func ticketRefundAmount(order models.Order, price float64) float64 {
+	subtotal := order.TotalAmount - order.AdminFee - float64(order.UniqueCode) + order.DiscountAmount
+	if order.DiscountAmount <= 0 || subtotal <= 0 {
+		return price
+	}
+	return math.Round(price - order.DiscountAmount*price/subtotal)
+}
+
+// GetOrderRefunds lists the refunds of an order for its customer.
+func GetOrderRefunds(c *gin.Context) {
+	order, ok := findCustomerOrder(c, c.Query("email"))
+	if !ok {
+		return
+	}
+
+	refunds := []models.Refund{}
+	config.DB.Preload("Items").Where("order_id = ?", order.ID).Order("id DESC").Find(&refunds)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refunds})
+}
+
+// AdminGetRefunds lists refunds, newest first. Organizers only see refunds of their events.
+func AdminGetRefunds(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	query := managedRefundScope(c, config.DB.Model(&models.Refund{}))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("refunds.status = ?", status)
+	}
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where(`EXISTS (
+			SELECT 1 FROM refund_items JOIN tickets ON tickets.id = refund_items.ticket_id
+			WHERE refund_items.refund_id = refunds.id AND tickets.event_id = ?)`, eventID)
+	}
+
+	var totalItems int64
+	query.Count(&totalItems)
+
+	refunds := []models.Refund{}
+	if err := query.Preload("Order").Preload("Items.Ticket").Order("refunds.id DESC").Limit(limit).Offset(offset).Find(&refunds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"refunds": refunds,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_items":  totalItems,
+				"per_page":     limit,
+			},
+		},
+	})
+}
+
+// AdminGetRefundDetail returns one refund with its order and tickets.
+func AdminGetRefundDetail(c *gin.Context) {
+	refund, ok := findManagedRefund(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refund})
+}
+
+// ApproveRefund invalidates the refunded tickets, returns their seats to sale and records
+// the refunded amount. An approval that refunds the last ticket of the order moves the
+// order to "refunded" and reverses its voucher and referral usage.
+func ApproveRefund(c *gin.Context) {
+	var input struct {
+		Amount *float64 `json:"amount"` // Optional override of the requested amount
+		Notes  string   `json:"notes"`
+	}
+	c.ShouldBindJSON(&input)
+
+	refund, ok := findManagedRefund(c)
+	if !ok {
+		return
+	}
+	if refund.Status != "requested" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Refund is already %s", refund.Status)})
+		return
+	}
+
+	order := refund.Order
+	if order.Status != "paid" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Cannot refund order because it is %s", order.Status)})
+		return
+	}
+	amount := refund.Amount
+	if input.Amount != nil {
+		amount = *input.Amount
+	}
+	if amount < 0 || amount > order.TotalAmount-order.RefundedAmount {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Refund amount exceeds the remaining paid amount"})
+		return
+	}
+
+	ticketIDs := refundTicketIDs(*refund)
+	now := time.Now()
+	reviewer := currentUserID(c)
+
+	tx := config.DB.Begin()
+
+	// Conditional updates guard against a double approval and tickets scanned meanwhile
+	res := tx.Model(&models.Refund{}).Where("id = ? AND status = ?", refund.ID, "requested").Updates(map[string]interface{}{
+		"status":       "approved",
+		"amount":       amount,
+		"reviewed_by":  reviewer,
+		"review_notes": input.Notes,
+		"reviewed_at":  now,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Refund was already reviewed"})
+		return
+	}
+	res = tx.Model(&models.Ticket{}).Where("id IN ? AND status = ?", ticketIDs, "active").Update("status", "refunded")
+	if res.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to invalidate tickets"})
+		return
+	}
+	if int(res.RowsAffected) != len(ticketIDs) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Some tickets have already been used"})
+		return
+	}
+
+	// Restore Quota
+	if err := utils.RestoreTicketQuota(tx, ticketIDs); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to restore quota"})
+		return
+	}
+
+	var remaining int64
+	tx.Model(&models.Ticket{}).Where("order_id = ? AND status <> ?", order.ID, "refunded").Count(&remaining)
+
+	if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).
+		Update("refunded_amount", gorm.Expr("refunded_amount + ?", amount)).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to update order"})
+		return
+	}
+	notes := fmt.Sprintf("Refund #%d approved: %d ticket(s), Rp %s", refund.ID, len(ticketIDs), utils.FormatPrice(amount))
+	if remaining == 0 {
+		// Last ticket gone: the order itself is refunded (voucher/referral usage reversed)
+		if _, err := utils.OrderStates.TransitionTx(tx, &order, utils.OrderStatusRefunded, utils.OrderTransitionOptions{
+			Notes:     notes,
+			SkipEmail: true,
+		}); err != nil {
+			tx.Rollback()
+			respondOrderTransitionError(c, order, err)
+			return
+		}
+	} else {
+		tx.Create(&models.OrderStatusHistory{
+			OrderID:   order.ID,
+			Status:    order.Status,
+			Notes:     notes,
+			CreatedAt: now,
+		})
+		// The order stays paid, so the state machine won't notify organizers of this one
+		order.RefundedAmount += amount
+		if err := utils.EnqueueOrderWebhooks(tx, order, utils.WebhookOrderRefunded, map[string]interface{}{
+			"partial":           true,
+			"refund_id":         refund.ID,
+			"refund_amount":     amount,
+			"refund_ticket_ids": ticketIDs,
+		}); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to queue webhooks"})
+			return
+		}
+	}
+	tx.Commit()
+
+	refund.Status = "approved"
+	refund.Amount = amount
+	refund.ReviewedBy = &reviewer
+	refund.ReviewNotes = input.Notes
+	refund.ReviewedAt = &now
+	utils.SendRefundEmail(order, *refund)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Refund approved", "data": refund})
+}
+
+// RejectRefund declines a refund request; the tickets stay valid.
+func RejectRefund(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Reason is required"})
+		return
+	}
+
+	refund, ok := findManagedRefund(c)
+	if !ok {
+		return
+	}
+	if refund.Status != "requested" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("Refund is already %s", refund.Status)})
+		return
+	}
+
+	now := time.Now()
+	reviewer := currentUserID(c)
+
+	tx := config.DB.Begin()
+	res := tx.Model(&models.Refund{}).Where("id = ? AND status = ?", refund.ID, "requested").Updates(map[string]interface{}{
+		"status":       "rejected",
+		"reviewed_by":  reviewer,
+		"review_notes": input.Reason,
+		"reviewed_at":  now,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Refund was already reviewed"})
+		return
+	}
+	tx.Create(&models.OrderStatusHistory{
+		OrderID:   refund.OrderID,
+		Status:    refund.Order.Status,
+		Notes:     fmt.Sprintf("Refund #%d rejected: %s", refund.ID, input.Reason),
+		CreatedAt: now,
+	})
+	tx.Commit()
+
+	refund.Status = "rejected"
+	refund.ReviewedBy = &reviewer
+	refund.ReviewNotes = input.Reason
+	refund.ReviewedAt = &now
+	utils.SendRefundEmail(refund.Order, *refund)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Refund rejected", "data": refund})
+}
+
+// CompleteRefund records that the approved amount has been transferred to the customer.
+func CompleteRefund(c *gin.Context) {
+	var input struct {
+		TransferRef string `json:"transfer_ref"`
+	}
+	c.ShouldBindJSON(&input)
+
+	refund, ok := findManagedRefund(c)
+	if !ok {
+		return
+	}
+	if refund.Status != "approved" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Only approved refunds can be completed"})
+		return
+	}
+
+	now := time.Now()
+	tx := config.DB.Begin()
+	res := tx.Model(&models.Refund{}).Where("id = ? AND status = ?", refund.ID, "approved").Updates(map[string]interface{}{
+		"status":       "completed",
+		"transfer_ref": input.TransferRef,
+		"completed_at": now,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "Refund was already completed"})
+		return
+	}
+	notes := fmt.Sprintf("Refund #%d transferred: Rp %s", refund.ID, utils.FormatPrice(refund.Amount))
+	if input.TransferRef != "" {
+		notes += " (ref " + input.TransferRef + ")"
+	}
+	tx.Create(&models.OrderStatusHistory{
+		OrderID:   refund.OrderID,
+		Status:    refund.Order.Status,
+		Notes:     notes,
+		CreatedAt: now,
+	})
+	tx.Commit()
+
+	refund.Status = "completed"
+	refund.TransferRef = input.TransferRef
+	refund.CompletedAt = &now
+	utils.SendRefundEmail(refund.Order, *refund)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Refund completed", "data": refund})
+}
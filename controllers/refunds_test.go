@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type refundFixture struct {
+	order      models.Order
+	ticketType models.TicketType
+	tickets    []models.Ticket
+}
+
+// seedRefundFixture creates a paid guest order of three tickets (one already used) on an
+// event of organizer 5, paid with a voucher.
+func seedRefundFixture(t *testing.T) refundFixture {
+	event := models.Event{Title: "Konser", Slug: "konser", OrganizerID: 5, Status: "published"}
+	config.DB.Create(&event)
+	tt := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10}
+	config.DB.Create(&tt)
+	config.DB.Create(&models.Voucher{Code: "HEMAT", UsedCount: 1})
+
+	order := models.Order{OrderNumber: "ORD-REFUND", CustomerEmail: "budi@example.com", Status: "paid", TotalAmount: 290000, DiscountAmount: 10000, VoucherCode: "HEMAT"}
+	config.DB.Create(&order)
+
+	tickets := []models.Ticket{}
+	for i, status := range []string{"active", "active", "used"} {
+		ticket := models.Ticket{OrderID: &order.ID, EventID: event.ID, TicketTypeID: tt.ID, TicketCode: "T-R" + uintStr(uint(i)), PurchasedPrice: 100000, Status: status}
+		config.DB.Create(&ticket)
+		tickets = append(tickets, ticket)
+	}
+	assert.NoError(t, utils.SyncAvailability(config.DB, tt.ID))
+
+	return refundFixture{order: order, ticketType: tt, tickets: tickets}
+}
+
+func refundRouter(userID uint, role string) *gin.Engine {
+	r := gin.New()
+	r.POST("/orders/:order_number/refunds", RequestRefund)
+	admin := r.Group("/admin", asUser(userID, role))
+	admin.GET("/refunds", AdminGetRefunds)
+	admin.POST("/refunds/:id/approve", ApproveRefund)
+	admin.POST("/refunds/:id/reject", RejectRefund)
+	admin.POST("/refunds/:id/complete", CompleteRefund)
+	return r
+}
+
+func TestRefund_PartialApproveAndComplete(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedRefundFixture(t)
+	r := refundRouter(5, "organizer")
+
+	// Guests must prove the order email; used tickets can't be refunded
+	w, _ := doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Sakit", "email": "other@example.com"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Sakit", "email": "budi@example.com", "ticket_ids": []uint{fx.tickets[2].ID}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, resp := doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Sakit", "email": "budi@example.com", "ticket_ids": []uint{fx.tickets[0].ID}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	refund := resp["data"].(map[string]interface{})
+	assert.Equal(t, false, refund["is_full"])
+	assert.Equal(t, float64(96667), refund["amount"], "price less a third of the 10.000 discount")
+	path := "/admin/refunds/" + uintStr(uint(refund["id"].(float64)))
+
+	// Same ticket can't be requested twice while pending
+	w, _ = doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Sakit", "email": "budi@example.com", "ticket_ids": []uint{fx.tickets[0].ID}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Other organizers can't see or approve it
+	w, _ = doJSON(refundRouter(99, "organizer"), "POST", path+"/approve", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = doJSON(r, "POST", path+"/complete", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "only approved refunds can be completed")
+
+	w, _ = doJSON(r, "POST", path+"/approve", gin.H{"notes": "OK"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doJSON(r, "POST", path+"/approve", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var ticket models.Ticket
+	config.DB.First(&ticket, fx.tickets[0].ID)
+	assert.Equal(t, "refunded", ticket.Status)
+
+	var tt models.TicketType
+	config.DB.First(&tt, fx.ticketType.ID)
+	assert.Equal(t, 8, tt.Available, "refunded seat goes back on sale")
+
+	var order models.Order
+	config.DB.First(&order, fx.order.ID)
+	assert.Equal(t, "paid", order.Status)
+	assert.Equal(t, float64(96667), order.RefundedAmount)
+
+	var voucher models.Voucher
+	config.DB.Where("code = ?", "HEMAT").First(&voucher)
+	assert.Equal(t, 1, voucher.UsedCount, "partial refunds keep the voucher usage")
+
+	w, resp = doJSON(r, "POST", path+"/complete", gin.H{"transfer_ref": "TRF-1"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "completed", resp["data"].(map[string]interface{})["status"])
+}
+
+func TestRefund_FullRefundReversesVoucher(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedRefundFixture(t)
+	r := refundRouter(1, "admin")
+
+	// Free the used ticket so the whole order can be refunded
+	config.DB.Model(&models.Ticket{}).Where("id = ?", fx.tickets[2].ID).Update("status", "active")
+
+	w, resp := doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Event diundur", "email": "budi@example.com"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	refund := resp["data"].(map[string]interface{})
+	assert.Equal(t, true, refund["is_full"])
+	assert.Equal(t, float64(290000), refund["amount"], "full refunds return the paid total")
+	path := "/admin/refunds/" + uintStr(uint(refund["id"].(float64)))
+
+	w, _ = doJSON(r, "POST", path+"/approve", gin.H{"amount": 300000})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "cannot refund more than was paid")
+	w, _ = doJSON(r, "POST", path+"/approve", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var order models.Order
+	config.DB.First(&order, fx.order.ID)
+	assert.Equal(t, "refunded", order.Status)
+
+	var voucher models.Voucher
+	config.DB.Where("code = ?", "HEMAT").First(&voucher)
+	assert.Equal(t, 0, voucher.UsedCount)
+
+	var tt models.TicketType
+	config.DB.First(&tt, fx.ticketType.ID)
+	assert.Equal(t, 10, tt.Available)
+}
+
+func TestRefund_Reject(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedRefundFixture(t)
+	r := refundRouter(1, "admin")
+
+	_, resp := doJSON(r, "POST", "/orders/ORD-REFUND/refunds", gin.H{"reason": "Berubah pikiran", "email": "budi@example.com"})
+	path := "/admin/refunds/" + uintStr(uint(resp["data"].(map[string]interface{})["id"].(float64)))
+
+	w, _ := doJSON(r, "POST", path+"/reject", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "reason is required")
+	w, _ = doJSON(r, "POST", path+"/reject", gin.H{"reason": "Melewati batas waktu refund"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var active int64
+	config.DB.Model(&models.Ticket{}).Where("order_id = ? AND status = ?", fx.order.ID, "active").Count(&active)
+	assert.Equal(t, int64(2), active, "rejected refunds keep tickets valid")
+}
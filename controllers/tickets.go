@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"kartcis-backend/utils"
 	"net/http"
 	"time"
 
@@ -69,10 +70,9 @@ func GetMyTickets(c *gin.Context) {
 // GetTicketDetail (Public/Guest)
 func GetTicketDetail(c *gin.Context) {
 	ticketCode := c.Param("code")
-	var ticket models.Ticket
-
-	if err := config.DB.Where("ticket_code = ?", ticketCode).Preload("Event").Preload("TicketType").Preload("Order").First(&ticket).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket not found"})
+	ticket, err := utils.FindTicketByCode(config.DB, ticketCode, "Event", "TicketType", "Order")
+	if err != nil {
+		respondTicketLookupError(c, err)
 		return
 	}
 
@@ -82,10 +82,15 @@ func GetTicketDetail(c *gin.Context) {
 // VerifyTicket
 func VerifyTicket(c *gin.Context) {
 	ticketCode := c.Param("code")
-	var ticket models.Ticket
+	ticket, err := utils.FindTicketByCode(config.DB, ticketCode, "Event", "TicketType")
+	if err != nil {
+		respondTicketLookupError(c, err)
+		return
+	}
 
-	if err := config.DB.Where("ticket_code = ?", ticketCode).Preload("Event").Preload("TicketType").First(&ticket).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket not found"})
+	// Scanner accounts may only verify tickets of events they are assigned to
+	if role, _ := c.Get("userRole"); role == "scanner" && !canScanEvent(c, ticket.EventID) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "You are not assigned to this event"})
 		return
 	}
 
@@ -106,39 +111,31 @@ func VerifyTicket(c *gin.Context) {
 	})
 }
 
-// DownloadTicketPDF
+// DownloadTicketPDF returns a single ticket of a paid order as a PDF.
 func DownloadTicketPDF(c *gin.Context) {
 	ticketCode := c.Param("code")
-	var ticket models.Ticket
-	if err := config.DB.Where("ticket_code = ?", ticketCode).Preload("Event").Preload("TicketType").First(&ticket).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Ticket not found"})
+	ticket, err := utils.FindTicketByCode(config.DB, ticketCode, "Event", "TicketType", "Order")
+	if err != nil {
+		respondTicketLookupError(c, err)
+		return
+	}
+
+	// Same rule as DownloadOrderTicketsPDF: no QR code for pending, cancelled or expired orders
+	if ticket.Order.Status != "paid" {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Tickets are only available for paid orders"})
+		return
+	}
+	if ticket.Status == "refunded" {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Ticket has been refunded"})
+		return
+	}
+
+	pdf, err := utils.GenerateTicketsPDF(ticket.Order.OrderNumber, []models.Ticket{*ticket})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to generate ticket PDF", "error": err.Error()})
 		return
 	}
 
-	// Generate HTML Receipt/Ticket
-	htmlContent := fmt.Sprintf(`
-		<html>
-		<head><title>Ticket %s</title></head>
-		<body style="font-family: Arial, sans-serif; padding: 20px; border: 2px dashed #333; max-width: 600px; margin: 0 auto;">
-			<h1 style="text-align: center;">%s</h1>
-			<p style="text-align: center;">%s</p>
-			<hr/>
-			<div style="margin: 20px 0;">
-				<p><strong>Ticket Code:</strong> %s</p>
-				<p><strong>Attendee:</strong> %s</p>
-				<p><strong>Type:</strong> %s</p>
-				<p><strong>Date:</strong> %s</p>
-			</div>
-			<div style="text-align: center; margin-top: 30px;">
-				<p><em>Scan this QR Code at the venue</em></p>
-				<div style="background: #eee; width: 150px; height: 150px; margin: 0 auto; display: flex; align-items: center; justify-content: center;">
-					[QR CODE PLACEHOLDER]
-				</div>
-			</div>
-		</body>
-		</html>
-	`, ticket.TicketCode, ticket.Event.Title, ticket.Event.Venue, ticket.TicketCode, ticket.AttendeeName, ticket.TicketType.Name, ticket.Event.EventDate.Format("Mon, 02 Jan 2006"))
-
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ticket-%s.html", ticketCode))
-	c.Data(http.StatusOK, "text/html", []byte(htmlContent))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ticket-%s.pdf", ticketCode))
+	c.Data(http.StatusOK, "application/pdf", pdf)
 }
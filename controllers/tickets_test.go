@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadTicketPDF_OnlyPaidOrders(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+
+	r := gin.New()
+	r.GET("/tickets/:code/download", DownloadTicketPDF)
+
+	w, _ := doJSON(r, "GET", "/tickets/"+fx.paid.TicketCode+"/download", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+
+	w, resp := doJSON(r, "GET", "/tickets/"+fx.cancelled.TicketCode+"/download", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "Tickets are only available for paid orders", resp["message"])
+}
+
+// refundedTicket adds a refunded ticket to the fixture's paid order, as a partial refund leaves it
+func refundedTicket(t *testing.T, fx checkInFixture) models.Ticket {
+	refunded := models.Ticket{OrderID: fx.paid.OrderID, EventID: fx.event.ID, TicketTypeID: fx.paid.TicketTypeID, TicketCode: "T-3", AttendeeName: "Joko", Status: "refunded"}
+	assert.NoError(t, config.DB.Create(&refunded).Error)
+	return refunded
+}
+
+func TestDownloadTicketPDF_RefundedTicket(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	refunded := refundedTicket(t, fx)
+
+	r := gin.New()
+	r.GET("/tickets/:code/download", DownloadTicketPDF)
+
+	w, resp := doJSON(r, "GET", "/tickets/"+refunded.TicketCode+"/download", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "Ticket has been refunded", resp["message"])
+
+	// The order's other ticket is still valid
+	w, _ = doJSON(r, "GET", "/tickets/"+fx.paid.TicketCode+"/download", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDownloadOrderTicketsPDF_SkipsRefundedTickets(t *testing.T) {
+	setupControllerDB(t)
+	fx := seedCheckInFixture(t)
+	refundedTicket(t, fx)
+	used := models.Ticket{OrderID: fx.paid.OrderID, EventID: fx.event.ID, TicketTypeID: fx.paid.TicketTypeID, TicketCode: "T-4", AttendeeName: "Rina", Status: "used"}
+	config.DB.Create(&used)
+
+	r := gin.New()
+	r.GET("/orders/:order_number/tickets.pdf", DownloadOrderTicketsPDF)
+
+	w, _ := doJSON(r, "GET", "/orders/ORD-PAID/tickets.pdf", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	pages := regexp.MustCompile(`/Type /Page\b[^s]`).FindAll(w.Body.Bytes(), -1)
+	assert.Len(t, pages, 2, "one page each for the active and the used ticket")
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"kartcis-backend/waitingroom"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// waitingRoomToken reads the queue token from the X-Queue-Token header or ?token=.
+func waitingRoomToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Queue-Token"); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+// findWaitingRoomEvent loads the event as its waiting room sees it: with its own room, or
+// the room of a flash sale campaign that hasn't ended.
+func findWaitingRoomEvent(c *gin.Context) (*models.Event, bool) {
+	var event models.Event
+	if err := config.DB.First(&event, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Event not found"})
+		return nil, false
+	}
+	room, _, err := utils.EventWaitingRoom(config.DB, event, time.Now())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "message": "Gagal membaca antrean"})
+		return nil, false
+	}
+	if !room.WaitingRoom {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Event ini tidak memakai antrean"})
+		return nil, false
+	}
+	return &room, true
+}
+
+// JoinWaitingRoom queues the visitor for the event and returns a signed token with their
+// position. A visitor who sends a valid token keeps their place.
+func JoinWaitingRoom(c *gin.Context) {
+	event, ok := findWaitingRoomEvent(c)
+	if !ok {
+		return
+	}
+	if token := waitingRoomToken(c); token != "" {
+		if status, err := waitingroom.Default().Status(c.Request.Context(), *event, token); err == nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+			return
+		}
+	}
+
+	status, err := waitingroom.Default().Join(c.Request.Context(), *event)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "message": "Gagal masuk antrean, silakan coba lagi"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": status})
+}
+
+// GetWaitingRoomStatus returns the position and estimated wait of the token's holder.
+func GetWaitingRoomStatus(c *gin.Context) {
+	event, ok := findWaitingRoomEvent(c)
+	if !ok {
+		return
+	}
+	status, err := waitingroom.Default().Status(c.Request.Context(), *event, waitingRoomToken(c))
+	switch {
+	case errors.Is(err, waitingroom.ErrInvalidToken), errors.Is(err, waitingroom.ErrNotInQueue):
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Token antrean tidak valid, silakan masuk antrean kembali"})
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "message": "Gagal membaca antrean"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+	}
+}
+
+// admitCheckout lets a checkout through the waiting rooms of the events it buys from
+// (their own, or that of a running flash sale campaign). It runs before the order
+// transaction so queued visitors never reach the ticket rows. A queue token belongs to
+// one event, so a cart that mixes a queued event with any other event is rejected.
+func admitCheckout(c *gin.Context, ticketTypeIDs []uint) bool {
+	var events []models.Event
+	config.DB.Where("id IN (?)", config.DB.Model(&models.TicketType{}).Select("event_id").Where("id IN ?", ticketTypeIDs)).
+		Find(&events)
+
+	now := time.Now()
+	var queued []models.Event
+	for _, event := range events {
+		event, running, err := utils.EventWaitingRoom(config.DB, event, now)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "message": "Gagal membaca antrean"})
+			return false
+		}
+		if running {
+			queued = append(queued, event)
+		}
+	}
+	if len(queued) > 0 && len(events) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Event " + queued[0].Title + " memakai antrean. Tiket event ini harus dibeli dalam pesanan terpisah.", "data": gin.H{"event_id": queued[0].ID, "queue_required": true}})
+		return false
+	}
+
+	token := c.GetHeader("X-Queue-Token")
+	for _, event := range queued {
+		err := waitingroom.Default().Admit(c.Request.Context(), event, token)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, waitingroom.ErrNotAdmitted):
+			status, _ := waitingroom.Default().Status(c.Request.Context(), event, token)
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Anda masih dalam antrean. Mohon tunggu giliran Anda.", "data": status})
+		case errors.Is(err, waitingroom.ErrAdmitExpired):
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Waktu giliran Anda sudah habis, silakan masuk antrean kembali", "data": gin.H{"event_id": event.ID, "queue_required": true}})
+		case errors.Is(err, waitingroom.ErrInvalidToken), errors.Is(err, waitingroom.ErrNotInQueue):
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "Event ini memakai antrean. Silakan masuk antrean terlebih dahulu.", "data": gin.H{"event_id": event.ID, "queue_required": true}})
+		default:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "message": "Gagal membaca antrean"})
+		}
+		return false
+	}
+	return true
+}
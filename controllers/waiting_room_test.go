@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/waitingroom"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOrder_WaitingRoomGate(t *testing.T) {
+	setupControllerDB(t)
+	event := models.Event{Title: "Konser", Status: "published", WaitingRoom: true, WaitingRoomRate: 1}
+	config.DB.Create(&event)
+	regular := models.TicketType{EventID: event.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10}
+	config.DB.Create(&regular)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.POST("/events/:id/queue", JoinWaitingRoom)
+	r.GET("/events/:id/queue", GetWaitingRoomStatus)
+	queuePath := fmt.Sprintf("/events/%d/queue", event.ID)
+
+	_, first := doJSON(r, "POST", queuePath, nil)
+	firstStatus := first["data"].(map[string]interface{})
+	w, second := doJSON(r, "POST", queuePath, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	secondStatus := second["data"].(map[string]interface{})
+	assert.Equal(t, 2.0, secondStatus["position"])
+
+	// The scheduler releases the first minute's allowance: one visitor
+	assert.NoError(t, waitingroom.Default().Release(context.Background(), event))
+	_, resp := doJSON(r, "GET", queuePath+"?token="+firstStatus["token"].(string), nil)
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["admitted"])
+	w, resp = doJSON(r, "GET", queuePath+"?token="+secondStatus["token"].(string), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	secondStatus = resp["data"].(map[string]interface{})
+	assert.Equal(t, false, secondStatus["admitted"])
+	assert.Equal(t, 0.0, secondStatus["ahead"])
+	assert.Equal(t, 60.0, secondStatus["estimated_wait_seconds"])
+	w, _ = doJSON(r, "GET", queuePath+"?token=forged", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	checkout := func(token string) (int, map[string]interface{}) {
+		return doQueuedJSON(r, "/orders", flashCheckout("budi@example.com", regular.ID, 1), token)
+	}
+
+	code, resp := checkout("")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, true, resp["data"].(map[string]interface{})["queue_required"])
+
+	code, resp = checkout(secondStatus["token"].(string))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, 2.0, resp["data"].(map[string]interface{})["position"])
+
+	code, _ = checkout(firstStatus["token"].(string))
+	assert.Equal(t, http.StatusCreated, code)
+
+	var orders int64
+	config.DB.Model(&models.Order{}).Count(&orders)
+	assert.Equal(t, int64(1), orders)
+}
+
+// doQueuedJSON POSTs body with the waiting room token in X-Queue-Token.
+func doQueuedJSON(r *gin.Engine, path string, body interface{}, token string) (int, map[string]interface{}) {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Queue-Token", token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestCreateOrder_WaitingRoomRejectsMultiEventCart(t *testing.T) {
+	setupControllerDB(t)
+	queued := models.Event{Title: "Konser", Status: "published", WaitingRoom: true, WaitingRoomRate: 1}
+	config.DB.Create(&queued)
+	other := models.Event{Title: "Seminar", Status: "published"}
+	config.DB.Create(&other)
+	queuedType := models.TicketType{EventID: queued.ID, Name: "Regular", Price: 100000, Quota: 10, Available: 10}
+	config.DB.Create(&queuedType)
+	otherType := models.TicketType{EventID: other.ID, Name: "Regular", Price: 50000, Quota: 10, Available: 10}
+	config.DB.Create(&otherType)
+
+	r := gin.New()
+	r.POST("/orders", CreateOrder)
+	r.POST("/events/:id/queue", JoinWaitingRoom)
+
+	_, resp := doJSON(r, "POST", fmt.Sprintf("/events/%d/queue", queued.ID), nil)
+	token := resp["data"].(map[string]interface{})["token"].(string)
+	assert.NoError(t, waitingroom.Default().Release(context.Background(), queued))
+
+	body := flashCheckout("budi@example.com", queuedType.ID, 1)
+	body["items"] = []gin.H{{"ticket_type_id": queuedType.ID, "quantity": 1}, {"ticket_type_id": otherType.ID, "quantity": 1}}
+	code, resp := doQueuedJSON(r, "/orders", body, token)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, resp["message"], "pesanan terpisah")
+	assert.Equal(t, float64(queued.ID), resp["data"].(map[string]interface{})["event_id"])
+
+	// The admitted token still works for a cart with only the queued event
+	code, _ = doQueuedJSON(r, "/orders", flashCheckout("budi@example.com", queuedType.ID, 1), token)
+	assert.Equal(t, http.StatusCreated, code)
+}
@@ -7,19 +7,10 @@ import (
 	"time"
 )
 
-// StartEventExpiryJob starts a background goroutine to mark past events as completed
-func StartEventExpiryJob() {
-	ticker := time.NewTicker(10 * time.Minute)
-	go func() {
-		for range ticker.C {
-			expireEvents()
-		}
-	}()
-}
-
-func expireEvents() {
+// expireEvents marks past events as completed (scheduled every 10 minutes)
+func expireEvents() error {
 	if config.DB == nil {
-		return
+		return nil
 	}
 
 	now := time.Now()
@@ -28,12 +19,11 @@ func expireEvents() {
 	var events []models.Event
 	err := config.DB.Where("status = ? AND event_date < ?", "published", now).Find(&events).Error
 	if err != nil {
-		fmt.Printf("[EventJob] Error fetching events: %v\n", err)
-		return
+		return fmt.Errorf("fetch past events: %w", err)
 	}
 
 	if len(events) == 0 {
-		return
+		return nil
 	}
 
 	fmt.Printf("[EventJob] Processing %d events to mark as COMPLETED...\n", len(events))
@@ -46,4 +36,5 @@ func expireEvents() {
 			fmt.Printf("[EventJob] Event %s (ID: %d) successfully marked as COMPLETED\n", event.Title, event.ID)
 		}
 	}
+	return nil
 }
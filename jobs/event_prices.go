@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"time"
+)
+
+// syncTieredEventPrices recomputes min/max prices of published events with price tiers,
+// so a tier opening or closing by date shows up in listings (scheduled every minute).
+// Tiers selling out are picked up by utils.SyncAvailability right away.
+func syncTieredEventPrices() error {
+	if config.DB == nil {
+		return nil
+	}
+
+	var eventIDs []uint
+	err := config.DB.Model(&models.TicketType{}).
+		Joins("JOIN events ON events.id = ticket_types.event_id").
+		Where("events.status = ?", "published").
+		Where("ticket_types.id IN (?)", config.DB.Model(&models.TicketPriceTier{}).Select("ticket_type_id")).
+		Distinct().Pluck("ticket_types.event_id", &eventIDs).Error
+	if err != nil {
+		return fmt.Errorf("fetch tiered events: %w", err)
+	}
+
+	now := time.Now()
+	for _, id := range eventIDs {
+		if err := utils.SyncEventPrices(config.DB, id, now); err != nil {
+			return fmt.Errorf("sync prices of event %d: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"time"
+)
+
+const (
+	// Orders younger than this are left to the callback
+	flipPollDelay = 2 * time.Minute
+	// Bills queried per run, the ones expiring soonest first
+	flipPollBatch = 50
+)
+
+// reconcileFlipPayments asks Flip about pending Flip orders (scheduled every 2 minutes) so
+// an order whose payment callback was lost is still marked paid before it expires.
+// The amount is verified like a callback's.
+func reconcileFlipPayments() error {
+	provider := utils.FlipProvider{}
+	if config.DB == nil || !provider.Configured() {
+		return nil
+	}
+
+	var orders []models.Order
+	err := config.DB.Where("status = ? AND payment_provider = ? AND payment_reference <> ''", utils.OrderStatusPending, utils.ProviderFlip).
+		Where("created_at <= ?", time.Now().Add(-flipPollDelay)).
+		Order("expires_at").Limit(flipPollBatch).Find(&orders).Error
+	if err != nil {
+		return fmt.Errorf("fetch pending flip orders: %w", err)
+	}
+
+	var failed int
+	for _, order := range orders {
+		notif, err := provider.QueryPayment(order)
+		if err != nil {
+			fmt.Printf("[FlipJob] Failed to query bill %s of order %s: %v\n", order.PaymentReference, order.OrderNumber, err)
+			failed++
+			continue
+		}
+		if notif.Status != utils.OrderStatusPaid {
+			continue
+		}
+
+		if err := utils.VerifyPaymentNotification(order, *notif); err != nil {
+			fmt.Printf("[FlipJob] Order %s not marked paid: %v\n", order.OrderNumber, err)
+			utils.AddOrderNoteOnce(config.DB, order, fmt.Sprintf("Flip status poll: payment not applied: %v", err))
+			continue
+		}
+
+		_, err = utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusPaid, utils.OrderTransitionOptions{
+			Notes: fmt.Sprintf("Verified by Flip status poll (bill %s, Rp %s); callback not received", notif.Reference, utils.FormatPrice(notif.Amount)),
+		})
+		if errors.Is(err, utils.ErrOrderStatusChanged) || errors.Is(err, utils.ErrOrderAlreadyInStatus) {
+			// The callback arrived meanwhile
+			continue
+		}
+		if utils.IsQuotaError(err) {
+			fmt.Printf("[FlipJob] Order %s paid but its seats are gone\n", order.OrderNumber)
+			utils.AddOrderNoteOnce(config.DB, order, fmt.Sprintf("Flip status poll: bill %s paid (Rp %s), but the seats are no longer available. Left for manual review", notif.Reference, utils.FormatPrice(notif.Amount)))
+			continue
+		}
+		if err != nil {
+			fmt.Printf("[FlipJob] Failed to mark order %s paid: %v\n", order.OrderNumber, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[FlipJob] Order %s paid (callback missed)\n", order.OrderNumber)
+	}
+
+	if failed > 0 && failed == len(orders) {
+		return fmt.Errorf("flip status poll failed for all %d orders", failed)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeFlip serves GET /pwf/{link}/payment from payments (link ID -> payments).
+func newFakeFlip(t *testing.T, payments map[string][]map[string]interface{}) *[]string {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		link := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/pwf/"), "/payment")
+		data, ok := payments[link]
+		if r.Method != "GET" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		linkID, _ := strconv.ParseInt(link, 10, 64)
+		json.NewEncoder(w).Encode(map[string]interface{}{"link_id": linkID, "data": data})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("FLIP_API_KEY", "test_api_key")
+	t.Setenv("FLIP_BASE_URL", server.URL)
+	return &calls
+}
+
+func TestReconcileFlipPayments(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	calls := newFakeFlip(t, map[string][]map[string]interface{}{
+		"101": {{"id": "FT1", "amount": 75000, "status": "FAILED"}, {"id": "FT2", "amount": 75000, "status": "SUCCESSFUL"}},
+		"102": {{"id": "FT3", "amount": 7500, "status": "SUCCESSFUL"}},
+		"103": {},
+	})
+
+	created := time.Now().Add(-10 * time.Minute)
+	lost := models.Order{OrderNumber: "ORD-FLIP-LOST", Status: "pending", TotalAmount: 75000, PaymentProvider: "flip", PaymentReference: "101", CreatedAt: created}
+	short := models.Order{OrderNumber: "ORD-FLIP-SHORT", Status: "pending", TotalAmount: 75000, PaymentProvider: "flip", PaymentReference: "102", CreatedAt: created}
+	unpaid := models.Order{OrderNumber: "ORD-FLIP-UNPAID", Status: "pending", TotalAmount: 75000, PaymentProvider: "flip", PaymentReference: "103", CreatedAt: created}
+	fresh := models.Order{OrderNumber: "ORD-FLIP-FRESH", Status: "pending", TotalAmount: 75000, PaymentProvider: "flip", PaymentReference: "104", CreatedAt: time.Now()}
+	manual := models.Order{OrderNumber: "ORD-JAGO", Status: "pending", TotalAmount: 75000, PaymentProvider: "manual_jago", CreatedAt: created}
+	for _, o := range []*models.Order{&lost, &short, &unpaid, &fresh, &manual} {
+		config.DB.Create(o)
+	}
+
+	assert.NoError(t, reconcileFlipPayments())
+	assert.NoError(t, reconcileFlipPayments())
+
+	config.DB.First(&lost, lost.ID)
+	assert.Equal(t, "paid", lost.Status)
+	var history models.OrderStatusHistory
+	config.DB.Where("order_id = ? AND status = ?", lost.ID, "paid").First(&history)
+	assert.Contains(t, history.Notes, "Flip status poll")
+
+	// Underpaid: left pending with a single note
+	config.DB.First(&short, short.ID)
+	assert.Equal(t, "pending", short.Status)
+	var notes int64
+	config.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ?", short.ID).Count(&notes)
+	assert.Equal(t, int64(1), notes)
+
+	config.DB.First(&unpaid, unpaid.ID)
+	assert.Equal(t, "pending", unpaid.Status)
+
+	// Fresh orders wait for their callback, manual transfers aren't Flip's
+	for _, call := range *calls {
+		assert.NotContains(t, call, "/pwf/104/")
+	}
+	assert.Len(t, *calls, 5, "the paid order is not queried again")
+}
+
+func TestReconcileFlipPayments_FlipUnreachable(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	newFakeFlip(t, nil)
+
+	order := models.Order{OrderNumber: "ORD-FLIP-404", Status: "pending", TotalAmount: 75000, PaymentProvider: "flip", PaymentReference: "999", CreatedAt: time.Now().Add(-time.Hour)}
+	config.DB.Create(&order)
+
+	assert.Error(t, reconcileFlipPayments())
+}
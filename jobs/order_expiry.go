@@ -1,65 +1,64 @@
 package jobs
 
 import (
+	"errors"
 	"fmt"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
 	"kartcis-backend/utils"
 	"time"
-
-	"gorm.io/gorm"
 )
 
-// StartOrderExpiryJob starts a background goroutine to expire old pending orders
-func StartOrderExpiryJob() {
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for range ticker.C {
-			expireOrders()
-		}
-	}()
-}
-
-func expireOrders() {
+// expireOrders expires pending orders whose payment window has passed (scheduled every minute)
+func expireOrders() error {
 	if config.DB == nil {
-		return
+		return nil
 	}
 
-	expiryTime := time.Now().Add(-30 * time.Minute)
+	now := time.Now()
+	// Orders created before holds existed have no expires_at; keep the old 30 minute window for them.
+	legacyCutoff := now.Add(-utils.DefaultHoldMinutes * time.Minute)
 	var orders []models.Order
 
-	// Find pending orders older than 30 minutes
-	err := config.DB.Where("status = ? AND created_at <= ?", "pending", expiryTime).Find(&orders).Error
+	// Find pending orders whose payment window has passed
+	err := config.DB.Where("status = ? AND (expires_at <= ? OR (expires_at IS NULL AND created_at <= ?))", "pending", now, legacyCutoff).Find(&orders).Error
 	if err != nil {
-		fmt.Printf("[ExpiryJob] Error fetching orders: %v\n", err)
-		return
+		return fmt.Errorf("fetch expired orders: %w", err)
 	}
 
+	// Release holds that outlived their order (e.g. order already paid/cancelled elsewhere)
+	defer func() {
+		if released, err := utils.ReleaseExpiredHolds(config.DB); err != nil {
+			fmt.Printf("[ExpiryJob] Error releasing holds: %v\n", err)
+		} else if released > 0 {
+			fmt.Printf("[ExpiryJob] Released %d expired seat holds\n", released)
+		}
+	}()
+
+	// Idempotency keys are only replayed for a day
+	config.DB.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{})
+	utils.PruneUniqueCodes(config.DB)
+
 	if len(orders) == 0 {
-		return
+		return nil
 	}
 
 	fmt.Printf("[ExpiryJob] Processing %d expired orders...\n", len(orders))
 
 	for _, order := range orders {
-		err := config.DB.Transaction(func(tx *gorm.DB) error {
-			// 1. Update status to expired
-			if err := tx.Model(&order).Update("status", "expired").Error; err != nil {
-				return err
-			}
-
-			// 2. Restore Quota
-			if err := utils.RestoreQuota(tx, order.ID); err != nil {
-				return err
-			}
-
-			return nil
+		_, err := utils.OrderStates.Transition(config.DB, &order, utils.OrderStatusExpired, utils.OrderTransitionOptions{
+			Notes: "Payment window expired",
 		})
+		if errors.Is(err, utils.ErrOrderStatusChanged) {
+			// Paid or cancelled since the query
+			continue
+		}
 
 		if err != nil {
 			fmt.Printf("[ExpiryJob] Failed to expire Order %s: %v\n", order.OrderNumber, err)
 		} else {
-			fmt.Printf("[ExpiryJob] Order %s successfully expired and quota restored\n", order.OrderNumber)
+			fmt.Printf("[ExpiryJob] Order %s successfully expired and seat holds released\n", order.OrderNumber)
 		}
 	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"kartcis-backend/config"
@@ -8,295 +9,360 @@ import (
 	"kartcis-backend/utils"
 	"log"
 	"os"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/emersion/go-message/mail"
+	"gorm.io/gorm/clause"
 )
 
-func StartPaymentCheckerJob() {
-	// Run every 1 minute
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for range ticker.C {
-			CheckBankJagoEmails("Auto")
-		}
-	}()
+// bankMailbox is the payment inbox the bank notification emails arrive in.
+type bankMailbox struct {
+	addr     string
+	user     string
+	pass     string
+	mailbox  string
+	dial     func(addr string) (*client.Client, error)
+	pollIdle time.Duration // NOOP interval when the server has no IDLE support
 }
 
-func CheckBankJagoEmails(source string) {
+func bankMailboxFromEnv() (*bankMailbox, bool) {
 	host := os.Getenv("IMAP_HOST")
 	port := os.Getenv("IMAP_PORT")
 	user := os.Getenv("IMAP_USER")
 	pass := os.Getenv("IMAP_PASS")
-
 	if host == "" || user == "" || pass == "" {
-		// log.Println("[PaymentJob] IMAP not configured, skipping...")
-		return
+		return nil, false
 	}
-
-	// Connect to server
-	c, err := client.DialTLS(host+":"+port, nil)
-	if err != nil {
-		log.Printf("[%s-PaymentJob] Dial error: %v\n", source, err)
-		return
+	mailbox := os.Getenv("IMAP_MAILBOX")
+	if mailbox == "" {
+		mailbox = "INBOX"
 	}
-	defer c.Logout()
+	return &bankMailbox{
+		addr:     host + ":" + port,
+		user:     user,
+		pass:     pass,
+		mailbox:  mailbox,
+		dial:     func(addr string) (*client.Client, error) { return client.DialTLS(addr, nil) },
+		pollIdle: time.Minute,
+	}, true
+}
 
-	// Login
-	if err := c.Login(user, pass); err != nil {
-		log.Printf("[%s-PaymentJob] Login error: %v\n", source, err)
-		return
-	}
+// checkpointKey identifies the mailbox in mailbox_checkpoints.
+func (m *bankMailbox) checkpointKey() string {
+	return m.user + "@" + m.addr + "/" + m.mailbox
+}
 
-	// Select INBOX
-	mbox, err := c.Select("INBOX", false)
+func (m *bankMailbox) connect() (*client.Client, error) {
+	c, err := m.dial(m.addr)
 	if err != nil {
-		log.Printf("[%s-PaymentJob] Select error: %v\n", source, err)
-		return
+		return nil, fmt.Errorf("imap dial: %w", err)
 	}
-
-	if mbox.Messages == 0 {
-		return
+	if err := c.Login(m.user, m.pass); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("imap login: %w", err)
 	}
+	return c, nil
+}
 
-	criteria := imap.NewSearchCriteria()
-	criteria.Since = time.Now().Add(-24 * time.Hour)
-	criteria.WithoutFlags = []string{imap.SeenFlag} // Hanya cari yang UNSEEN (belum dibaca)
-	// Server-side filter to minimize fetching thousands of emails
-	criteria.Text = []string{"sejumlah uang"}
+const (
+	// Emails looked at on the first sync (or after the mailbox was recreated)
+	bankMailBackfill = 24 * time.Hour
+	// Fetched per sync; the rest waits for the next one
+	bankMailBatch = 200
+	// A watcher session ends after this so the scheduler records a run; the next
+	// session (possibly on another instance) reconnects right away
+	bankWatchSession  = 30 * time.Minute
+	bankWatchMaxDelay = 30 * time.Second
+)
 
-	log.Printf("[%s-PaymentJob] Searching for UNSEEN Jago emails in Inbox...", source)
-	ids, err := c.Search(criteria)
-	if err != nil {
-		log.Println("[PaymentJob] Search error:", err)
-		return
+// CheckBankEmails matches new bank notification emails to orders (scheduled every
+// minute). It is the fallback for the IDLE watcher and does nothing while an instance
+// is running the watcher.
+func CheckBankEmails(source string) error {
+	m, ok := bankMailboxFromEnv()
+	if !ok {
+		return nil
 	}
-	log.Printf("[%s-PaymentJob] Found %d matching unseen emails\n", source, len(ids))
-
-	if len(ids) == 0 {
-		return
+	if source == "Auto" && bankWatcherActive() {
+		return nil
 	}
 
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(ids...)
-
-	// Get the whole message body
-	var section imap.BodySectionName
-	section.Peek = true // PREVENT marking as Seen here, we will mark it manually or let the loop decide.
-	items := []imap.FetchItem{section.FetchItem(), imap.FetchEnvelope}
-
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
-	go func() {
-		done <- c.Fetch(seqset, items, messages)
-	}()
+	c, err := m.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	return m.sync(c, source)
+}
 
-	for msg := range messages {
-		subject := strings.ToLower(msg.Envelope.Subject)
-		from := ""
-		if len(msg.Envelope.From) > 0 {
-			from = strings.ToLower(msg.Envelope.From[0].Address())
-		}
+// bankWatcherActive reports whether some instance holds the payment_watcher lease.
+func bankWatcherActive() bool {
+	var count int64
+	config.DB.Model(&models.ScheduledJob{}).
+		Where("name = ? AND locked_until > ?", "payment_watcher", time.Now()).
+		Count(&count)
+	return count > 0
+}
 
-		log.Printf("[%s-PaymentJob] Scanner: %s (From: %s)\n", source, msg.Envelope.Subject, from)
+// watchBankEmails keeps an IDLE connection to the payment inbox for one session and
+// syncs within seconds of a new email. Set IMAP_IDLE=false to rely on polling only.
+func watchBankEmails() error {
+	m, ok := bankMailboxFromEnv()
+	if !ok || os.Getenv("IMAP_IDLE") == "false" {
+		return nil
+	}
+	return m.watch(bankWatchSession, "Push")
+}
 
-		// Filter manual dalam Go agar tidak tergantung filter server IMAP yang kaku
-		if !strings.Contains(subject, "sejumlah uang") && !strings.Contains(from, "jago.com") {
-			continue
+// watch runs IDLE sessions until the deadline, reconnecting with exponential backoff
+// (1s, 2s, 4s, ...) when the connection drops. When the server stays unreachable for
+// about half a minute it gives up: the lease is released and the payment_checker poll
+// takes over until the scheduler starts the next watcher session.
+func (m *bankMailbox) watch(session time.Duration, source string) error {
+	deadline := time.Now().Add(session)
+	delay := time.Second
+	for {
+		connected, err := m.idle(deadline, source)
+		if err == nil {
+			return nil
 		}
-
-		log.Printf("[%s-PaymentJob] MATCH! Processing: %s\n", source, msg.Envelope.Subject)
-		r := msg.GetBody(&section)
-		if r == nil {
-			continue
+		if connected {
+			delay = time.Second
 		}
-
-		// Create a new mail reader
-		mr, err := mail.CreateReader(r)
-		if err != nil {
-			continue
+		if delay > bankWatchMaxDelay || time.Now().Add(delay).After(deadline) {
+			return err
 		}
+		log.Printf("[%s-PaymentJob] IMAP connection lost (%v), reconnecting in %s\n", source, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
 
-		// Read parts
-		var body string
-		for {
-			p, err := mr.NextPart()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				break
-			}
+// idle connects, catches up from the checkpoint and then syncs on every mailbox update
+// until the deadline. It returns nil at the deadline and reports whether it got as far
+// as a working connection.
+func (m *bankMailbox) idle(deadline time.Time, source string) (bool, error) {
+	c, err := m.connect()
+	if err != nil {
+		return false, err
+	}
 
-			switch h := p.Header.(type) {
-			case *mail.InlineHeader:
-				contentType, _, _ := h.ContentType()
-				if contentType == "text/plain" || contentType == "text/html" {
-					b, _ := io.ReadAll(p.Body)
-					body = string(b)
+	// Updates must always be drained or the client stops reading responses
+	updates := make(chan client.Update, 16)
+	changed := make(chan struct{}, 1)
+	c.Updates = updates
+	go func() {
+		for update := range updates {
+			if _, ok := update.(*client.MailboxUpdate); ok {
+				select {
+				case changed <- struct{}{}:
+				default:
 				}
 			}
 		}
+	}()
+	defer func() {
+		c.Logout()
+		<-c.LoggedOut()
+		close(updates)
+	}()
 
-		if body != "" {
-			ProcessJagoEmail(body, source, msg.Envelope.MessageId, msg.Envelope.Date)
-		}
-
-		// After processing, mark as SEEN manually only if it was indeed our target email
-		// or just finish here since the search will skip it next time if it belongs to Jago.
-		// Actually, let's mark it SEEN now so we don't scan it again next minute.
-		item := new(imap.SeqSet)
-		item.AddNum(msg.SeqNum)
-		c.Store(item, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.SeenFlag}, nil)
+	if err := m.sync(c, source); err != nil {
+		return false, err
 	}
 
-	if err := <-done; err != nil {
-		log.Printf("[%s-PaymentJob] Fetch error: %v\n", source, err)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Idle(stop, &client.IdleOptions{PollInterval: m.pollIdle})
+		}()
+
+		select {
+		case err := <-done:
+			return true, fmt.Errorf("imap idle: %w", err)
+		case <-timer.C:
+			close(stop)
+			<-done
+			return true, nil
+		case <-changed:
+			close(stop)
+			if err := <-done; err != nil {
+				return true, fmt.Errorf("imap idle: %w", err)
+			}
+			if err := m.sync(c, source); err != nil {
+				return true, err
+			}
+		}
 	}
 }
 
-func ProcessJagoEmail(body string, source string, messageID string, emailDate time.Time) {
-	// 1. Clean HTML tags if present
-	body = stripHTML(body)
+// sync processes the emails that arrived after the mailbox checkpoint, in UID order,
+// advancing the checkpoint after each one. The mailbox is opened read-only: whether a
+// human has read an email no longer matters. On the first sync (or when UIDVALIDITY
+// changed) it looks at the emails of the last day.
+func (m *bankMailbox) sync(c *client.Client, source string) error {
+	status, err := c.Select(m.mailbox, true)
+	if err != nil {
+		return fmt.Errorf("imap select: %w", err)
+	}
 
-	// 1.1 Transactional check for message deduplication
-	tx := config.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	checkpoint := models.MailboxCheckpoint{Mailbox: m.checkpointKey()}
+	found := config.DB.Where("mailbox = ?", checkpoint.Mailbox).First(&checkpoint).Error == nil
+	seqset := new(imap.SeqSet)
+	if !found || checkpoint.UIDValidity != status.UidValidity {
+		criteria := imap.NewSearchCriteria()
+		criteria.Since = time.Now().Add(-bankMailBackfill)
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return fmt.Errorf("imap search: %w", err)
 		}
-	}()
-
-	var existingTx models.BankTransaction
-	if tx.Where("reference_id = ?", messageID).First(&existingTx).Error == nil {
-		tx.Rollback()
-		return
+		seqset.AddNum(uids...)
+		checkpoint.UIDValidity = status.UidValidity
+		checkpoint.LastUID = 0
+	} else if status.UidNext == 0 || status.UidNext > checkpoint.LastUID+1 {
+		// "n:*" also returns the newest message when its UID is below n; filtered below
+		seqset.AddRange(checkpoint.LastUID+1, 0)
 	}
 
-	// 2. Parse Email Body with very flexible Regex
-	// Support: Nominal, Total, Jumlah, Amount
-	// Support: Pengirim, Dari
-	re := regexp.MustCompile(`(?i)(?:Nominal|Total|Jumlah|Jumlah\stransaksi)[:\s]*Rp\s?([0-9.]+)`)
-	match := re.FindStringSubmatch(body)
-	if len(match) < 2 {
-		re = regexp.MustCompile(`(?i)Rp\s?([0-9.]+)`)
-		match = re.FindStringSubmatch(body)
-		if len(match) < 2 {
-			tx.Rollback()
-			return
+	var messages []*imap.Message
+	if !seqset.Empty() {
+		section := &imap.BodySectionName{Peek: true}
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, section.FetchItem()}
+		fetched := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(seqset, items, fetched)
+		}()
+		for msg := range fetched {
+			if msg.Uid > checkpoint.LastUID && msg.Envelope != nil {
+				messages = append(messages, msg)
+			}
+		}
+		if err := <-done; err != nil {
+			return fmt.Errorf("imap fetch: %w", err)
 		}
 	}
-
-	amountStr := strings.ReplaceAll(match[1], ".", "")
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		tx.Rollback()
-		return
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Uid < messages[j].Uid })
+	if len(messages) > bankMailBatch {
+		messages = messages[:bankMailBatch]
+	}
+	if len(messages) > 0 {
+		log.Printf("[%s-PaymentJob] %d new email(s) in %s\n", source, len(messages), m.mailbox)
 	}
 
-	// Extract Sender Name (Support Dari or Pengirim)
-	senderRe := regexp.MustCompile(`(?i)(?:Pengirim|Dari)[:\s]*([^\r\n<]+)`)
-	senderMatch := senderRe.FindStringSubmatch(body)
-	senderName := "Unknown"
-	if len(senderMatch) >= 2 {
-		senderName = strings.TrimSpace(senderMatch[1])
+	for _, msg := range messages {
+		if err := handleBankMessage(msg, source); err != nil {
+			// Retried from this email on the next sync
+			return err
+		}
+		checkpoint.LastUID = msg.Uid
+		if err := saveMailboxCheckpoint(checkpoint); err != nil {
+			return err
+		}
 	}
 
-	// 3. Search for matching order
-	var order models.Order
-	statusOptions := []string{"pending"}
+	// Nothing (left) to look at below UIDNEXT: emails older than the backfill are skipped
+	if len(messages) < bankMailBatch && status.UidNext > checkpoint.LastUID+1 {
+		checkpoint.LastUID = status.UidNext - 1
+	}
+	return saveMailboxCheckpoint(checkpoint)
+}
 
-	// Pencarian LEBIH KETAT:
-	// - Status harus pending
-	// - Nominal harus sama persis
-	// - Waktu order harus di sekitar waktu email (misal: 24 jam sebelum s/d 10 menit sesudah email)
-	//   Ini mencegah email hari ini mencocokkan order dengan nominal sama dari setahun lalu.
-	windowStart := emailDate.Add(-24 * time.Hour)
-	windowEnd := emailDate.Add(10 * time.Minute)
+func saveMailboxCheckpoint(checkpoint models.MailboxCheckpoint) error {
+	checkpoint.UpdatedAt = time.Now()
+	return config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "mailbox"}},
+		DoUpdates: clause.AssignmentColumns([]string{"uid_validity", "last_uid", "updated_at"}),
+	}).Create(&checkpoint).Error
+}
 
-	if err := tx.Where("status IN ? AND total_amount = ? AND created_at BETWEEN ? AND ?",
-		statusOptions, amount, windowStart, windowEnd).
-		Order("created_at desc").
-		First(&order).Error; err != nil {
-		// Log matching failed (Maybe already Paid or not our order)
-		log.Printf("[%s-PaymentJob] No pending order matched Amount: %.2f within time window\n", source, amount)
-		// We STILL record this message ID to prevent re-processing every minute
-		tx.Create(&models.BankTransaction{
-			ReferenceID:     messageID,
-			Amount:          amount,
-			Sender:          senderName,
-			BankName:        "Bank Jago (Unmatched)",
-			TransactionDate: emailDate,
-			RawData:         body,
-			CreatedAt:       time.Now(),
-		})
-		tx.Commit()
-		return
+// handleBankMessage parses one fetched email with the parser of its bank and matches it
+// to an order. Emails from a bank's domain that its parser can't read are quarantined;
+// other senders are skipped. Errors are retryable.
+func handleBankMessage(msg *imap.Message, source string) error {
+	from := ""
+	if len(msg.Envelope.From) > 0 {
+		from = strings.ToLower(msg.Envelope.From[0].Address())
 	}
+	log.Printf("[%s-PaymentJob] Scanner: %s (From: %s)\n", source, msg.Envelope.Subject, from)
 
-	// 4. Payment Method Validation
-	if !strings.Contains(order.PaymentMethod, "BANK_TRANSFER_JAGO") && order.PaymentMethod != "MANUAL_JAGO" {
-		log.Printf("[%s-PaymentJob] Ignored order %s. Payment Method mismatch: %s\n", source, order.OrderNumber, order.PaymentMethod)
-		// Still record to log to prevent check every time
-		tx.Create(&models.BankTransaction{
-			OrderID:         &order.ID,
-			ReferenceID:     messageID,
-			Amount:          amount,
-			Sender:          senderName,
-			BankName:        "Bank Jago (Mismatch Method)",
-			TransactionDate: emailDate,
-			RawData:         body,
-			CreatedAt:       time.Now(),
-		})
-		tx.Commit()
-		return
+	email := utils.BankEmail{
+		MessageID: msg.Envelope.MessageId,
+		From:      from,
+		Subject:   msg.Envelope.Subject,
+		Date:      msg.Envelope.Date,
 	}
-
-	// 6. Mark as Paid
-	now := time.Now()
-	order.Status = "paid"
-	order.PaidAt = &now
-	if err := tx.Save(&order).Error; err != nil {
-		tx.Rollback()
-		return
+	for _, literal := range msg.Body {
+		email.Body = readMailBody(literal)
+	}
+	if email.MessageID == "" {
+		email.MessageID = fmt.Sprintf("%s|%s|%d", from, email.Subject, email.Date.Unix())
 	}
 
-	// 7. Record History & Transaction
-	tx.Create(&models.OrderStatusHistory{
-		OrderID:   order.ID,
-		Status:    "paid",
-		Notes:     fmt.Sprintf("Verified %s via Email (%s). Original Status: %s", source, messageID, order.Status),
-		CreatedAt: now,
-	})
+	// Mail from anyone else is not a payment notification, whatever it says
+	parser := utils.FindBankParser(email.From)
+	if parser == nil {
+		return nil
+	}
+	if email.Body == "" {
+		return nil
+	}
 
-	tx.Create(&models.BankTransaction{
-		OrderID:         &order.ID,
-		ReferenceID:     messageID,
-		Amount:          amount,
-		Sender:          senderName,
-		BankName:        "Bank Jago",
-		TransactionDate: emailDate,
-		RawData:         body,
-		CreatedAt:       now,
-	})
+	log.Printf("[%s-PaymentJob] MATCH %s! Processing: %s\n", source, parser.BankName(), email.Subject)
+	_, err := utils.ProcessBankEmail(config.DB, parser, email, source)
+	switch {
+	case errors.Is(err, utils.ErrBankEmailNotParsed):
+		return quarantineBankEmail(email, "not_parsed", parser.BankName())
+	case errors.Is(err, utils.ErrBankEmailNotCredit):
+		return nil
+	}
+	return err
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return
+// readMailBody returns the last text/plain or text/html part of a message.
+func readMailBody(r io.Reader) string {
+	mr, err := mail.CreateReader(r)
+	if err != nil {
+		return ""
 	}
 
-	log.Printf("[%s-PaymentJob] Order %s marked as PAID successfully\n", source, order.OrderNumber)
+	var body string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
 
-	// 8. Send Ticket (Outside transaction)
-	var tickets []models.Ticket
-	config.DB.Preload("Event").Preload("TicketType").Where("order_id = ?", order.ID).Find(&tickets)
-	utils.SendTicketEmail(order, tickets)
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			contentType, _, _ := h.ContentType()
+			if contentType == "text/plain" || contentType == "text/html" {
+				b, _ := io.ReadAll(p.Body)
+				body = string(b)
+			}
+		}
+	}
+	return body
 }
 
-func stripHTML(html string) string {
-	re := regexp.MustCompile(`<[^>]*>`)
-	return re.ReplaceAllString(html, " ")
+// quarantineBankEmail keeps a bank email its parser could not read for admin review.
+func quarantineBankEmail(email utils.BankEmail, reason, bankName string) error {
+	log.Printf("[PaymentJob] Quarantined email %q from %s (%s)\n", email.Subject, email.From, reason)
+	return config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.QuarantinedBankEmail{
+		MessageID:  email.MessageID,
+		From:       email.From,
+		Subject:    email.Subject,
+		ReceivedAt: email.Date,
+		Body:       email.Body,
+		Reason:     reason,
+		BankName:   bankName,
+		Status:     "pending",
+	}).Error
 }
@@ -0,0 +1,343 @@
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupPaymentCheckerDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(
+		&models.User{},
+		&models.Event{},
+		&models.TicketType{},
+		&models.Order{},
+		&models.Ticket{},
+		&models.OrderStatusHistory{},
+		&models.SiteSetting{},
+		&models.FlashSale{},
+		&models.FlashSaleItem{},
+		&models.TicketPriceTier{},
+		&models.BundleItem{},
+		&models.Product{},
+		&models.ProductVariant{},
+		&models.OrderAddOn{},
+		&models.Voucher{},
+		&models.ReferralCode{},
+		&models.TicketHold{},
+		&models.BankTransaction{},
+		&models.QuarantinedBankEmail{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.Job{},
+		&models.UniqueCodeAllocation{},
+		&models.MailboxCheckpoint{},
+		&models.ScheduledJob{},
+	)
+	prev := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = prev })
+}
+
+func sampleBankEmail(t *testing.T, file, messageID string) utils.BankEmail {
+	body, err := os.ReadFile("../utils/testdata/bank_emails/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utils.BankEmail{MessageID: messageID, Body: string(body), Date: time.Now()}
+}
+
+func TestProcessBankEmail_MatchesOrderOfTheSameBank(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	bca := utils.FindBankParserByName("BCA")
+	jago := utils.FindBankParserByName("Bank Jago")
+
+	order := models.Order{OrderNumber: "ORD-BCA-1", Status: "pending", TotalAmount: 150123, PaymentMethod: "MANUAL_BCA"}
+	config.DB.Create(&order)
+
+	// A Jago transfer of the same amount doesn't pay a BCA order
+	record, err := utils.ProcessBankEmail(config.DB, jago, sampleBankEmail(t, "jago_incoming.html", "<jago-1@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "method_mismatch", record.Status)
+	assert.Equal(t, "Bank Jago", record.BankName)
+
+	record, err = utils.ProcessBankEmail(config.DB, bca, sampleBankEmail(t, "bca_transfer_in.txt", "<bca-1@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "matched", record.Status)
+	assert.Equal(t, "BCA", record.BankName)
+	assert.Equal(t, "BUDI SANTOSO", record.Sender)
+	assert.Equal(t, order.ID, *record.OrderID)
+
+	config.DB.First(&order, order.ID)
+	assert.Equal(t, "paid", order.Status)
+
+	// The same email again is a no-op
+	record, err = utils.ProcessBankEmail(config.DB, bca, sampleBankEmail(t, "bca_transfer_in.txt", "<bca-1@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// No pending order left with that amount
+	record, err = utils.ProcessBankEmail(config.DB, bca, sampleBankEmail(t, "bca_transfer_in.txt", "<bca-2@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "unmatched", record.Status)
+	assert.Nil(t, record.OrderID)
+
+	_, err = utils.ProcessBankEmail(config.DB, bca, sampleBankEmail(t, "bca_transfer_out.txt", "<bca-3@mail>"), "Test")
+	assert.ErrorIs(t, err, utils.ErrBankEmailNotCredit)
+
+	var count int64
+	config.DB.Model(&models.BankTransaction{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestQuarantineBankEmail_OncePerMessage(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	email := sampleBankEmail(t, "jago_no_amount.html", "<jago-odd-1@mail>")
+	email.From = "no-reply@jago.com"
+
+	quarantineBankEmail(email, "not_parsed", "Bank Jago")
+	quarantineBankEmail(email, "not_parsed", "Bank Jago")
+
+	var emails []models.QuarantinedBankEmail
+	config.DB.Find(&emails)
+	assert.Len(t, emails, 1)
+	assert.Equal(t, "pending", emails[0].Status)
+	assert.Equal(t, "not_parsed", emails[0].Reason)
+}
+
+func bcaTransferIn(amount, messageID string) utils.BankEmail {
+	body := fmt.Sprintf("Jenis Transaksi : Transfer Masuk\nNominal : IDR %s\nDari : BUDI SANTOSO\n", amount)
+	return utils.BankEmail{MessageID: messageID, Body: body, Date: time.Now()}
+}
+
+func TestProcessBankEmail_UnderpaymentAndLatePayment(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	bca := utils.FindBankParserByName("BCA")
+	expiredAt := time.Now().Add(-time.Hour)
+
+	// Wrong base amount, right unique code: linked to the order but not paid
+	pending := models.Order{OrderNumber: "ORD-PARTIAL", Status: "pending", TotalAmount: 250456, UniqueCode: 456, PaymentMethod: "MANUAL_BCA"}
+	config.DB.Create(&pending)
+	record, err := utils.ProcessBankEmail(config.DB, bca, bcaTransferIn("240,456.00", "<bca-partial@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "partial", record.Status)
+	assert.Equal(t, pending.ID, *record.OrderID)
+	config.DB.First(&pending, pending.ID)
+	assert.Equal(t, "pending", pending.Status)
+
+	var note models.OrderStatusHistory
+	config.DB.Where("order_id = ?", pending.ID).Last(&note)
+	assert.Contains(t, note.Notes, "short by Rp 10.000")
+
+	// Late payment: the expired order is revived while seats are left...
+	tt := models.TicketType{EventID: 1, Name: "Regular", Quota: 1, Available: 1}
+	config.DB.Create(&tt)
+	late := models.Order{OrderNumber: "ORD-LATE", Status: "expired", TotalAmount: 100123, UniqueCode: 123, PaymentMethod: "MANUAL_BCA", ExpiresAt: &expiredAt}
+	config.DB.Create(&late)
+	config.DB.Create(&models.Ticket{OrderID: &late.ID, EventID: 1, TicketTypeID: tt.ID, TicketCode: "T-LATE", Status: "active"})
+
+	record, err = utils.ProcessBankEmail(config.DB, bca, bcaTransferIn("100,123.00", "<bca-late@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "matched", record.Status)
+	config.DB.First(&late, late.ID)
+	assert.Equal(t, "paid", late.Status)
+
+	// ...but not once they are sold out
+	gone := models.Order{OrderNumber: "ORD-GONE", Status: "expired", TotalAmount: 100789, UniqueCode: 789, PaymentMethod: "MANUAL_BCA", ExpiresAt: &expiredAt}
+	config.DB.Create(&gone)
+	config.DB.Create(&models.Ticket{OrderID: &gone.ID, EventID: 1, TicketTypeID: tt.ID, TicketCode: "T-GONE", Status: "active"})
+
+	record, err = utils.ProcessBankEmail(config.DB, bca, bcaTransferIn("100,789.00", "<bca-gone@mail>"), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, "quota_unavailable", record.Status)
+	assert.Equal(t, gone.ID, *record.OrderID)
+	config.DB.First(&gone, gone.ID)
+	assert.Equal(t, "expired", gone.Status)
+	var goneNote models.OrderStatusHistory
+	config.DB.Where("order_id = ?", gone.ID).Last(&goneNote)
+	assert.Contains(t, goneNote.Notes, "seats are no longer available")
+}
+
+// pushBackend is the in-memory IMAP backend with the unilateral updates IDLE needs.
+type pushBackend struct {
+	*memory.Backend
+	updates chan backend.Update
+}
+
+func (b *pushBackend) Updates() <-chan backend.Update { return b.updates }
+
+// startTestIMAPServer serves an in-memory mailbox (user "username", password "password")
+// and returns a bankMailbox pointing at it.
+func startTestIMAPServer(t *testing.T) (*bankMailbox, *pushBackend) {
+	be := &pushBackend{Backend: memory.New(), updates: make(chan backend.Update, 10)}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	return &bankMailbox{
+		addr:     ln.Addr().String(),
+		user:     "username",
+		pass:     "password",
+		mailbox:  "INBOX",
+		dial:     func(addr string) (*client.Client, error) { return client.Dial(addr) },
+		pollIdle: time.Second,
+	}, be
+}
+
+// deliverMail appends an email from BCA to the inbox and notifies IDLE clients.
+func (b *pushBackend) deliverMail(t *testing.T, messageID, body string, flags ...string) {
+	user, err := b.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := "From: BCA <notifikasi@bca.co.id>\r\n" +
+		"To: finance@kartcis.id\r\n" +
+		"Subject: Notifikasi Transaksi\r\n" +
+		"Message-ID: " + messageID + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" + body
+	if err := mbox.CreateMessage(flags, time.Now(), bytes.NewBufferString(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages, imap.StatusUidNext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.updates <- &backend.MailboxUpdate{Update: backend.NewUpdate("username", "INBOX"), MailboxStatus: status}
+}
+
+func TestBankMailboxSync_ProcessesEachEmailOnceRegardlessOfSeen(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	m, be := startTestIMAPServer(t)
+
+	first := models.Order{OrderNumber: "ORD-IMAP-1", Status: "pending", TotalAmount: 150123, PaymentMethod: "MANUAL_BCA"}
+	second := models.Order{OrderNumber: "ORD-IMAP-2", Status: "pending", TotalAmount: 98765, PaymentMethod: "MANUAL_BCA"}
+	config.DB.Create(&first)
+	config.DB.Create(&second)
+
+	// Someone opened the mailbox before the job ran
+	be.deliverMail(t, "<imap-1@bca.co.id>", bcaTransferIn("150,123.00", "").Body, imap.SeenFlag)
+
+	c, err := m.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	assert.NoError(t, m.sync(c, "Test"))
+
+	config.DB.First(&first, first.ID)
+	assert.Equal(t, "paid", first.Status)
+
+	var checkpoint models.MailboxCheckpoint
+	config.DB.First(&checkpoint, "mailbox = ?", "username@"+m.addr+"/INBOX")
+	assert.Equal(t, uint32(7), checkpoint.LastUID) // After the memory backend's sample email (UID 6)
+	assert.NotZero(t, checkpoint.UIDValidity)
+	var quarantined int64
+	config.DB.Model(&models.QuarantinedBankEmail{}).Count(&quarantined)
+	assert.Equal(t, int64(0), quarantined, "the backend's sample email is not from a bank")
+
+	// Nothing new: nothing is processed again
+	assert.NoError(t, m.sync(c, "Test"))
+	var count int64
+	config.DB.Model(&models.BankTransaction{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	be.deliverMail(t, "<imap-2@bca.co.id>", bcaTransferIn("98,765.00", "").Body)
+	assert.NoError(t, m.sync(c, "Test"))
+
+	config.DB.First(&second, second.ID)
+	assert.Equal(t, "paid", second.Status)
+	config.DB.Model(&models.BankTransaction{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+	config.DB.First(&checkpoint, "mailbox = ?", checkpoint.Mailbox)
+	assert.Equal(t, uint32(8), checkpoint.LastUID)
+}
+
+func TestBankMailboxWatch_ReconnectsAndReactsToPushedEmail(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	m, be := startTestIMAPServer(t)
+
+	order := models.Order{OrderNumber: "ORD-IDLE-1", Status: "pending", TotalAmount: 175321, PaymentMethod: "MANUAL_BCA"}
+	config.DB.Create(&order)
+
+	// The first connection attempt fails
+	var dials int32
+	dial := m.dial
+	m.dial = func(addr string) (*client.Client, error) {
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return dial(addr)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.watch(6*time.Second, "Test") }()
+
+	// Wait for the initial sync, then deliver while the watcher is idling
+	assert.Eventually(t, func() bool {
+		var count int64
+		config.DB.Model(&models.MailboxCheckpoint{}).Count(&count)
+		return count == 1
+	}, 4*time.Second, 50*time.Millisecond)
+	be.deliverMail(t, "<idle-1@bca.co.id>", bcaTransferIn("175,321.00", "").Body)
+
+	assert.Eventually(t, func() bool {
+		var current models.Order
+		config.DB.First(&current, order.ID)
+		return current.Status == "paid"
+	}, 2*time.Second, 50*time.Millisecond)
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+}
+
+func TestCheckBankEmails_SkipsWhileWatcherHoldsTheLease(t *testing.T) {
+	setupPaymentCheckerDB(t)
+	t.Setenv("IMAP_HOST", "127.0.0.1")
+	t.Setenv("IMAP_PORT", "1") // Nothing listens here: dialing would fail
+	t.Setenv("IMAP_USER", "finance@kartcis.id")
+	t.Setenv("IMAP_PASS", "secret")
+
+	assert.Error(t, CheckBankEmails("Auto"))
+
+	lockedUntil := time.Now().Add(time.Minute)
+	config.DB.Create(&models.ScheduledJob{Name: "payment_watcher", IntervalSeconds: 60, NextRunAt: time.Now(), LockedUntil: &lockedUntil})
+	assert.NoError(t, CheckBankEmails("Auto"))
+	// A manual check still runs
+	assert.Error(t, CheckBankEmails("Manual"))
+}
@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScheduledJob is a periodic task run by exactly one instance at a time. Each run takes a
+// lease on its scheduled_jobs row; other replicas skip the job until the run finishes (or
+// the lease expires because the instance died) and next_run_at has passed.
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+var scheduledJobs = []ScheduledJob{
+	{Name: "order_expiry", Interval: time.Minute, Run: expireOrders},
+	{Name: "payment_checker", Interval: time.Minute, Run: func() error { return CheckBankEmails("Auto") }},
+	{Name: "payment_watcher", Interval: time.Minute, Run: watchBankEmails},
+	{Name: "event_expiry", Interval: 10 * time.Minute, Run: expireEvents},
+	{Name: "webhook_delivery", Interval: 10 * time.Second, Run: deliverWebhooks},
+	{Name: "flip_reconciliation", Interval: 2 * time.Minute, Run: reconcileFlipPayments},
+	{Name: "event_prices", Interval: time.Minute, Run: syncTieredEventPrices},
+	{Name: "waiting_room_release", Interval: 5 * time.Second, Run: releaseWaitingRooms},
+}
+
+const (
+	schedulerLease     = 2 * time.Minute
+	schedulerHeartbeat = 30 * time.Second
+)
+
+// instanceID identifies this process in scheduled_jobs.locked_by.
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// StartScheduler registers the scheduled jobs and polls them from every instance; only
+// the instance that wins the lease runs a job.
+func StartScheduler() {
+	if config.DB == nil {
+		return
+	}
+
+	for _, job := range scheduledJobs {
+		if err := registerScheduledJob(config.DB, job); err != nil {
+			log.Printf("[Scheduler] Failed to register %s: %v", job.Name, err)
+			continue
+		}
+		go func(job ScheduledJob) {
+			poll := job.Interval / 4
+			if poll < 2*time.Second {
+				poll = 2 * time.Second
+			} else if poll > 15*time.Second {
+				poll = 15 * time.Second
+			}
+			for {
+				runScheduledJob(config.DB, job, instanceID)
+				time.Sleep(poll)
+			}
+		}(job)
+	}
+}
+
+// registerScheduledJob creates the job's row (due immediately) or updates its interval.
+func registerScheduledJob(db *gorm.DB, job ScheduledJob) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"interval_seconds"}),
+	}).Create(&models.ScheduledJob{
+		Name:            job.Name,
+		IntervalSeconds: int(job.Interval / time.Second),
+		NextRunAt:       schedulerNow(db),
+	}).Error
+}
+
+// schedulerNow is the database clock, so leases and due times compare the same way on
+// every instance whatever their local clock says. SQLite runs in-process and shares ours.
+func schedulerNow(db *gorm.DB) time.Time {
+	if db.Dialector.Name() != "postgres" {
+		return time.Now()
+	}
+	var now time.Time
+	if err := db.Raw("SELECT NOW()").Scan(&now).Error; err != nil || now.IsZero() {
+		log.Printf("[Scheduler] Failed to read the database clock, using ours: %v", err)
+		return time.Now()
+	}
+	return now
+}
+
+// runScheduledJob runs the job if it is due and no other instance holds its lease.
+// It reports whether this instance ran it.
+func runScheduledJob(db *gorm.DB, job ScheduledJob, owner string) bool {
+	started := schedulerNow(db)
+	res := db.Model(&models.ScheduledJob{}).
+		Where("name = ? AND next_run_at <= ? AND (locked_until IS NULL OR locked_until < ?)", job.Name, started, started).
+		Updates(map[string]interface{}{
+			"locked_by":       owner,
+			"locked_until":    started.Add(schedulerLease),
+			"last_started_at": started,
+		})
+	if res.Error != nil {
+		log.Printf("[Scheduler] Failed to acquire %s: %v", job.Name, res.Error)
+		return false
+	}
+	if res.RowsAffected == 0 {
+		return false
+	}
+
+	// Keep the lease while the job runs (an IMAP scan can outlast it)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(schedulerHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.Model(&models.ScheduledJob{}).Where("name = ? AND locked_by = ?", job.Name, owner).
+					Update("locked_until", schedulerNow(db).Add(schedulerLease))
+			}
+		}
+	}()
+
+	err := runSafely(job.Run)
+	close(done)
+
+	finished := schedulerNow(db)
+	updates := map[string]interface{}{
+		"locked_by":        "",
+		"locked_until":     nil,
+		"last_finished_at": finished,
+		"last_duration_ms": finished.Sub(started).Milliseconds(),
+		"last_run_by":      owner,
+		"run_count":        gorm.Expr("run_count + 1"),
+		"next_run_at":      started.Add(job.Interval),
+		"last_status":      "succeeded",
+		"last_error":       "",
+		"failure_count":    0,
+	}
+	if err != nil {
+		log.Printf("[Scheduler] %s failed: %v", job.Name, err)
+		updates["last_status"] = "failed"
+		updates["last_error"] = err.Error()
+		updates["failure_count"] = gorm.Expr("failure_count + 1")
+	}
+	if err := db.Model(&models.ScheduledJob{}).Where("name = ? AND locked_by = ?", job.Name, owner).Updates(updates).Error; err != nil {
+		log.Printf("[Scheduler] Failed to record run of %s: %v", job.Name, err)
+	}
+	return true
+}
+
+func runSafely(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupSchedulerDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(&models.ScheduledJob{})
+	return db
+}
+
+func TestScheduler_OneInstancePerRun(t *testing.T) {
+	db := setupSchedulerDB(t)
+	runs := 0
+	job := ScheduledJob{Name: "sweep", Interval: time.Minute, Run: func() error { runs++; return nil }}
+	assert.NoError(t, registerScheduledJob(db, job))
+	assert.NoError(t, registerScheduledJob(db, job), "registering again from another replica is fine")
+
+	assert.True(t, runScheduledJob(db, job, "api-1:1"))
+	assert.False(t, runScheduledJob(db, job, "api-2:1"), "not due again until the interval passes")
+	assert.Equal(t, 1, runs)
+
+	var state models.ScheduledJob
+	db.First(&state, "name = ?", "sweep")
+	assert.Equal(t, "succeeded", state.LastStatus)
+	assert.Equal(t, "api-1:1", state.LastRunBy)
+	assert.Equal(t, int64(1), state.RunCount)
+	assert.Empty(t, state.LockedBy)
+	assert.Nil(t, state.LockedUntil)
+	assert.WithinDuration(t, state.LastStartedAt.Add(time.Minute), state.NextRunAt, time.Second)
+
+	// Another instance holds the lease: due, but skipped
+	until := time.Now().Add(time.Minute)
+	db.Model(&state).Updates(map[string]interface{}{"next_run_at": time.Now().Add(-time.Second), "locked_by": "api-2:1", "locked_until": until})
+	assert.False(t, runScheduledJob(db, job, "api-1:1"))
+
+	// Its lease expired (instance died): taken over
+	db.Model(&state).Update("locked_until", time.Now().Add(-time.Second))
+	assert.True(t, runScheduledJob(db, job, "api-1:1"))
+	assert.Equal(t, 2, runs)
+}
+
+func TestScheduler_RecordsFailures(t *testing.T) {
+	db := setupSchedulerDB(t)
+	job := ScheduledJob{Name: "imap", Interval: time.Minute, Run: func() error { return errors.New("imap login: bad credentials") }}
+	registerScheduledJob(db, job)
+
+	assert.True(t, runScheduledJob(db, job, "api-1:1"))
+	db.Model(&models.ScheduledJob{}).Where("name = ?", "imap").Update("next_run_at", time.Now().Add(-time.Second))
+	job.Run = func() error { panic("nil inbox") }
+	assert.True(t, runScheduledJob(db, job, "api-1:1"))
+
+	var state models.ScheduledJob
+	db.First(&state, "name = ?", "imap")
+	assert.Equal(t, "failed", state.LastStatus)
+	assert.Equal(t, "panic: nil inbox", state.LastError)
+	assert.Equal(t, 2, state.FailureCount)
+	assert.Empty(t, state.LockedBy, "lease released after a failure")
+
+	db.Model(&state).Update("next_run_at", time.Now().Add(-time.Second))
+	job.Run = func() error { return nil }
+	runScheduledJob(db, job, "api-1:1")
+	db.First(&state, "name = ?", "imap")
+	assert.Equal(t, 0, state.FailureCount)
+	assert.Empty(t, state.LastError)
+}
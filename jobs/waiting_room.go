@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+	"kartcis-backend/utils"
+	"kartcis-backend/waitingroom"
+	"time"
+)
+
+// releaseWaitingRooms admits the next positions of every open waiting room, the events'
+// own and those of running flash sale campaigns (scheduled every 5 seconds). It is the
+// only writer of the release counters, so visitors polling their position never contend
+// on them.
+func releaseWaitingRooms() error {
+	if config.DB == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var events []models.Event
+	err := config.DB.Where("waiting_room = ? OR id IN (?)", true,
+		config.DB.Model(&models.FlashSale{}).Select("event_id").
+			Where("waiting_room = ? AND is_active = ? AND starts_at <= ? AND ends_at > ?", true, true, now, now)).
+		Find(&events).Error
+	if err != nil {
+		return fmt.Errorf("load waiting rooms: %w", err)
+	}
+	for _, event := range events {
+		room, running, err := utils.EventWaitingRoom(config.DB, event, now)
+		if err != nil {
+			return fmt.Errorf("load waiting room of event %d: %w", event.ID, err)
+		}
+		if !running {
+			continue
+		}
+		if err := waitingroom.Default().Release(context.Background(), room); err != nil {
+			return fmt.Errorf("release waiting room of event %d: %w", event.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"fmt"
+	"kartcis-backend/config"
+	"kartcis-backend/utils"
+	"time"
+)
+
+var webhookClient = utils.NewWebhookClient(10 * time.Second)
+
+// deliverWebhooks sends queued organizer webhooks that are due (scheduled every 10 seconds)
+func deliverWebhooks() error {
+	if config.DB == nil {
+		return nil
+	}
+
+	// Drain in batches so a backlog doesn't wait a full tick per 50 deliveries
+	for {
+		sent, err := utils.DeliverDueWebhooks(config.DB, webhookClient, 50)
+		if err != nil {
+			return fmt.Errorf("deliver webhooks: %w", err)
+		}
+		if sent < 50 {
+			return nil
+		}
+	}
+}
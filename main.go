@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	_ "time/tzdata" // Embed IANA timezone database ke binary (tidak perlu tzdata di OS)
 
 	"kartcis-backend/config"
 	"kartcis-backend/jobs"
+	"kartcis-backend/middleware"
+	"kartcis-backend/queue"
 	"kartcis-backend/routes"
 	"kartcis-backend/utils"
 )
@@ -17,9 +25,8 @@ func main() {
 	utils.InitWA() // Initialize WhatsApp Client
 
 	// Start Background Jobs
-	jobs.StartOrderExpiryJob()
-	jobs.StartPaymentCheckerJob()
-	jobs.StartEventExpiryJob()
+	queue.Start()         // Workers for queued jobs (emails, WhatsApp broadcasts)
+	jobs.StartScheduler() // Order/event expiry, bank email checker, webhook delivery (one instance at a time)
 
 	// Ensure uploads directory exists and has public read access for Nginx
 	if err := os.MkdirAll("uploads", 0755); err != nil {
@@ -43,5 +50,27 @@ func main() {
 	}
 	fmt.Printf("Server is running on port %s\n", port)
 	fmt.Printf("Access API at: http://localhost:%s%s\n", port, apiPrefix)
-	r.Run(":" + port)
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Server error: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Graceful shutdown: finish in-flight requests, then write the buffered request logs
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	fmt.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("Server shutdown error: %v\n", err)
+	}
+	if err := middleware.FlushRequestLogs(ctx); err != nil {
+		fmt.Printf("Failed to flush request logs: %v\n", err)
+	}
 }
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyTTL is how long a stored response is replayed for a repeated key.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+type responseCapture struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseCapture) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseCapture) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a mutation safe to retry when the client sends an Idempotency-Key
+// header: the first successful response is stored and replayed for the same key, a request
+// still in flight answers 409, and reusing a key for a different request answers 422.
+// Failed responses are not stored so the client may retry with the same key.
+// Must run after the auth middleware so the caller is part of the request fingerprint.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"success": false, "message": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"success": false, "message": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID, _ := c.Get("userID")
+		sum := sha256.Sum256(append([]byte(fmt.Sprintf("%v|", userID)), body...))
+		requestHash := hex.EncodeToString(sum[:])
+		scope := c.Request.Method + " " + c.FullPath()
+		now := time.Now()
+
+		var existing models.IdempotencyKey
+		if err := config.DB.Where("scope = ? AND key = ?", scope, key).First(&existing).Error; err == nil {
+			if existing.ExpiresAt.After(now) {
+				switch {
+				case existing.StatusCode == 0:
+					c.AbortWithStatusJSON(http.StatusConflict, gin.H{"success": false, "message": "A request with this Idempotency-Key is still being processed"})
+				case existing.RequestHash != requestHash:
+					c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"success": false, "message": "Idempotency-Key was already used for a different request"})
+				default:
+					c.Header("Idempotent-Replayed", "true")
+					c.Data(existing.StatusCode, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+					c.Abort()
+				}
+				return
+			}
+			config.DB.Delete(&existing)
+		}
+
+		// The unique index makes concurrent first requests race here; only one wins
+		record := models.IdempotencyKey{Scope: scope, Key: key, RequestHash: requestHash, ExpiresAt: now.Add(IdempotencyKeyTTL)}
+		if err := config.DB.Create(&record).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"success": false, "message": "A request with this Idempotency-Key is still being processed"})
+			return
+		}
+
+		// Release the key unless a success is stored (errors, panics)
+		stored := false
+		defer func() {
+			if !stored {
+				config.DB.Delete(&record)
+			}
+		}()
+
+		capture := &responseCapture{ResponseWriter: c.Writer}
+		c.Writer = capture
+		c.Next()
+
+		if status := capture.Status(); status >= 200 && status < 300 {
+			stored = config.DB.Model(&record).Updates(map[string]interface{}{
+				"status_code":   status,
+				"response_body": capture.body.String(),
+			}).Error == nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupIdempotencyDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(&models.IdempotencyKey{})
+	config.DB = db
+	gin.SetMode(gin.TestMode)
+}
+
+func idempotentRouter(calls *int, status int) *gin.Engine {
+	r := gin.New()
+	r.POST("/orders", Idempotency(), func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"success": status < 300, "calls": *calls})
+	})
+	return r
+}
+
+func postWithKey(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency_ReplaysSuccessfulResponse(t *testing.T) {
+	setupIdempotencyDB(t)
+	calls := 0
+	r := idempotentRouter(&calls, http.StatusCreated)
+
+	first := postWithKey(r, "checkout-1", `{"items":[1]}`)
+	second := postWithKey(r, "checkout-1", `{"items":[1]}`)
+	assert.Equal(t, 1, calls, "handler runs once")
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+
+	// Same key, different request
+	w := postWithKey(r, "checkout-1", `{"items":[2]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// No key: no protection
+	postWithKey(r, "", `{"items":[1]}`)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotency_InFlightAndFailures(t *testing.T) {
+	setupIdempotencyDB(t)
+	calls := 0
+	r := idempotentRouter(&calls, http.StatusBadRequest)
+
+	// Failed requests are not stored: the client may retry with the same key
+	postWithKey(r, "checkout-2", `{}`)
+	postWithKey(r, "checkout-2", `{}`)
+	assert.Equal(t, 2, calls)
+
+	config.DB.Create(&models.IdempotencyKey{Scope: "POST /orders", Key: "running", RequestHash: "x", ExpiresAt: time.Now().Add(time.Hour)})
+	w := postWithKey(r, "running", `{}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -1,14 +1,121 @@
 package middleware
 
 import (
+	"context"
 	"kartcis-backend/config"
 	"kartcis-backend/models"
+	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	requestLogBuffer     = 10000           // Entries waiting for the writer; more are dropped
+	requestLogBatchSize  = 200             // Rows per INSERT
+	requestLogInterval   = 2 * time.Second // Flush at least this often
+	requestLogMaxPending = 5000            // Entries kept for retry while the DB is failing
+)
+
+// requestLogWriter collects request logs in a bounded buffer and writes them in batches
+// from a single goroutine, instead of one goroutine and one INSERT per request. Failed
+// batches are retried on the next flush; FlushRequestLogs writes what is left on shutdown.
+type requestLogWriter struct {
+	entries chan models.RequestLog
+	stop    chan struct{}
+	done    chan struct{}
+	start   sync.Once
+	close   sync.Once
+	dropped atomic.Int64
+}
+
+var requestLogs = newRequestLogWriter()
+
+func newRequestLogWriter() *requestLogWriter {
+	return &requestLogWriter{
+		entries: make(chan models.RequestLog, requestLogBuffer),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *requestLogWriter) add(entry models.RequestLog) {
+	w.start.Do(func() { go w.run() })
+	select {
+	case w.entries <- entry:
+	default:
+		// Buffer full (the DB is far behind): drop rather than block the request
+		w.dropped.Add(1)
+	}
+}
+
+func (w *requestLogWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(requestLogInterval)
+	defer ticker.Stop()
+
+	var batch []models.RequestLog
+	for {
+		select {
+		case entry := <-w.entries:
+			batch = append(batch, entry)
+			if len(batch) >= requestLogBatchSize {
+				batch = w.write(batch)
+			}
+		case <-ticker.C:
+			batch = w.write(batch)
+		case <-w.stop:
+			for {
+				select {
+				case entry := <-w.entries:
+					batch = append(batch, entry)
+				default:
+					w.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// write inserts the batch and returns what is left to retry.
+func (w *requestLogWriter) write(batch []models.RequestLog) []models.RequestLog {
+	if n := w.dropped.Swap(0); n > 0 {
+		log.Printf("[RequestLog] Dropped %d request logs, buffer full", n)
+	}
+	if len(batch) == 0 || config.DB == nil {
+		return batch[:0]
+	}
+	if err := config.DB.CreateInBatches(batch, requestLogBatchSize).Error; err != nil {
+		if len(batch) < requestLogMaxPending {
+			log.Printf("[RequestLog] Failed to write %d request logs, retrying: %v", len(batch), err)
+			return batch
+		}
+		log.Printf("[RequestLog] Dropped %d request logs after write errors: %v", len(batch), err)
+	}
+	return batch[:0]
+}
+
+// FlushRequestLogs stops the request log writer after writing the buffered entries.
+// Call it on shutdown, once the HTTP server stopped taking requests.
+func FlushRequestLogs(ctx context.Context) error {
+	return requestLogs.flush(ctx)
+}
+
+func (w *requestLogWriter) flush(ctx context.Context) error {
+	w.start.Do(func() { go w.run() })
+	w.close.Do(func() { close(w.stop) })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func SmartLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -55,18 +162,16 @@ func SmartLogger() gin.HandlerFunc {
 			}
 		}
 
-		// 5. Async Log to DB (Don't block response)
-		go func(uid *uint, m, p, ip, ua string, s int, l int64) {
-			config.DB.Create(&models.RequestLog{
-				UserID:    uid,
-				Method:    m,
-				Path:      p,
-				IPAddress: ip,
-				UserAgent: ua,
-				Status:    s,
-				Latency:   l,
-				CreatedAt: time.Now(),
-			})
-		}(userID, method, path, c.ClientIP(), c.Request.UserAgent(), status, latency)
+		// 5. Log to DB through the batched writer (doesn't block the response)
+		requestLogs.add(models.RequestLog{
+			UserID:    userID,
+			Method:    method,
+			Path:      path,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Status:    status,
+			Latency:   latency,
+			CreatedAt: time.Now(),
+		})
 	}
 }
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSmartLogger_BatchesAndFlushesOnShutdown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(&models.RequestLog{})
+	config.DB = db
+	gin.SetMode(gin.TestMode)
+	requestLogs = newRequestLogWriter()
+
+	r := gin.New()
+	r.Use(SmartLogger())
+	r.POST("/api/v1/orders", func(c *gin.Context) { c.Status(201) })
+	r.GET("/api/v1/events", func(c *gin.Context) { c.Status(200) })
+
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/orders", nil))
+	}
+	// Public GETs are not logged
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/events", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, FlushRequestLogs(ctx))
+
+	var logs []models.RequestLog
+	db.Find(&logs)
+	assert.Len(t, logs, 3, "buffered logs are written on shutdown")
+	for _, entry := range logs {
+		assert.Equal(t, "POST", entry.Method)
+		assert.Equal(t, 201, entry.Status)
+	}
+}
+
+func TestRequestLogWriter_DropsWhenBufferFull(t *testing.T) {
+	w := newRequestLogWriter()
+	w.start.Do(func() {}) // no writer goroutine: the buffer only fills up
+	for i := 0; i < requestLogBuffer+5; i++ {
+		w.add(models.RequestLog{Path: "/api/v1/orders"})
+	}
+	assert.Len(t, w.entries, requestLogBuffer)
+	assert.Equal(t, int64(5), w.dropped.Load())
+}
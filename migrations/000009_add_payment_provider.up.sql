@@ -0,0 +1,7 @@
+-- Payment provider used to create the charge (manual_jago, flip)
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_provider VARCHAR(50) DEFAULT 'manual_jago';
+
+-- Optional per-method provider selection (payment_provider_<method>), default stays manual Jago
+INSERT INTO site_settings (key, value) VALUES
+('payment_provider_default', 'manual_jago')
+ON CONFLICT (key) DO NOTHING;
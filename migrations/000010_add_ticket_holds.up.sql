@@ -0,0 +1,32 @@
+-- Seat holds: pending orders reserve seats with a TTL instead of decrementing quota
+CREATE TABLE IF NOT EXISTS ticket_holds (
+    id BIGSERIAL PRIMARY KEY,
+    order_id BIGINT NOT NULL DEFAULT 0,
+    ticket_type_id BIGINT NOT NULL REFERENCES ticket_types(id) ON DELETE CASCADE,
+    flash_sale_id BIGINT REFERENCES flash_sales(id) ON DELETE SET NULL,
+    quantity INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'held', -- held, converted, released
+    expires_at TIMESTAMP NOT NULL,
+    released_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_order_id ON ticket_holds(order_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_ticket_type_id ON ticket_holds(ticket_type_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_flash_sale_id ON ticket_holds(flash_sale_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_expires_at ON ticket_holds(expires_at);
+
+-- Per-event hold duration (minutes)
+ALTER TABLE events ADD COLUMN IF NOT EXISTS hold_minutes INTEGER DEFAULT 30;
+
+-- Backfill: pending orders already decremented quota, give them an explicit hold
+UPDATE orders SET expires_at = created_at + INTERVAL '30 minutes'
+WHERE status = 'pending' AND expires_at IS NULL;
+
+INSERT INTO ticket_holds (order_id, ticket_type_id, flash_sale_id, quantity, status, expires_at, created_at, updated_at)
+SELECT t.order_id, t.ticket_type_id, t.flash_sale_id, COUNT(*), 'held', o.expires_at, o.created_at, NOW()
+FROM tickets t
+JOIN orders o ON o.id = t.order_id
+WHERE o.status = 'pending'
+GROUP BY t.order_id, t.ticket_type_id, t.flash_sale_id, o.expires_at, o.created_at;
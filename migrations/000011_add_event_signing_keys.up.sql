@@ -0,0 +1,18 @@
+-- Per-event Ed25519 keys for signed ticket codes (KT1.<payload>.<signature>)
+CREATE TABLE IF NOT EXISTS event_signing_keys (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    key_id VARCHAR(16) NOT NULL UNIQUE,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, retired, revoked
+    retired_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_signing_keys_event_id ON event_signing_keys(event_id);
+
+-- Signed codes are ~110 characters; legacy T-... codes keep working unchanged
+ALTER TABLE tickets ALTER COLUMN ticket_code TYPE VARCHAR(160);
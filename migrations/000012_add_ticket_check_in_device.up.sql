@@ -0,0 +1,3 @@
+-- Device that recorded the winning (earliest) check-in, set by the offline sync API
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS check_in_device_id VARCHAR(100) DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_tickets_event_updated_at ON tickets(event_id, updated_at);
@@ -0,0 +1,19 @@
+-- Audit trail of every scan attempt, undo included
+CREATE TABLE IF NOT EXISTS check_in_logs (
+    id BIGSERIAL PRIMARY KEY,
+    ticket_id BIGINT REFERENCES tickets(id) ON DELETE SET NULL,
+    ticket_code VARCHAR(160),
+    event_id BIGINT,
+    operator_id BIGINT,
+    device_id VARCHAR(100),
+    gate VARCHAR(100),
+    source VARCHAR(20),  -- online, sync, undo
+    result VARCHAR(20),  -- accepted, duplicate, void, wrong_event, invalid, not_found, undone
+    reason TEXT,
+    scanned_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_check_in_logs_ticket_id ON check_in_logs(ticket_id);
+CREATE INDEX IF NOT EXISTS idx_check_in_logs_event_id ON check_in_logs(event_id);
+CREATE INDEX IF NOT EXISTS idx_check_in_logs_operator_id ON check_in_logs(operator_id);
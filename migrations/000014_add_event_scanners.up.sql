@@ -0,0 +1,11 @@
+-- Scanner accounts (users.role = 'scanner') assigned to the events they may check in for
+CREATE TABLE IF NOT EXISTS event_scanners (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    assigned_by BIGINT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_event_scanner ON event_scanners(event_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_event_scanners_user_id ON event_scanners(user_id);
@@ -0,0 +1,32 @@
+-- Refund requests of paid orders; refund_items lists the refunded tickets
+CREATE TABLE IF NOT EXISTS refunds (
+    id BIGSERIAL PRIMARY KEY,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    requested_by BIGINT,
+    status VARCHAR(20) DEFAULT 'requested', -- requested, approved, rejected, completed
+    is_full BOOLEAN DEFAULT FALSE,
+    reason TEXT,
+    amount DECIMAL(15,2) DEFAULT 0,
+    reviewed_by BIGINT,
+    review_notes TEXT,
+    reviewed_at TIMESTAMP,
+    transfer_ref VARCHAR(100),
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds(order_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_status ON refunds(status);
+
+CREATE TABLE IF NOT EXISTS refund_items (
+    id BIGSERIAL PRIMARY KEY,
+    refund_id BIGINT NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+    ticket_id BIGINT NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    amount DECIMAL(15,2) DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_refund_items_refund_id ON refund_items(refund_id);
+CREATE INDEX IF NOT EXISTS idx_refund_items_ticket_id ON refund_items(ticket_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(15,2) DEFAULT 0;
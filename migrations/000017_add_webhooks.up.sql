@@ -0,0 +1,42 @@
+-- Organizer webhook endpoints (per event, or account-wide when event_id is NULL)
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id BIGSERIAL PRIMARY KEY,
+    organizer_id BIGINT NOT NULL REFERENCES users(id),
+    event_id BIGINT REFERENCES events(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    event_types TEXT, -- comma separated, empty = all
+    description TEXT,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_organizer_id ON webhook_endpoints(organizer_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_event_id ON webhook_endpoints(event_id);
+
+-- Queued notifications and their latest attempt (the delivery log)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint_id BIGINT NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_uid VARCHAR(40) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    event_id BIGINT,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) DEFAULT 'pending', -- pending, succeeded, failed
+    attempts INT DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    last_attempt_at TIMESTAMP,
+    response_status INT DEFAULT 0,
+    response_body TEXT,
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    redelivery_of BIGINT REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint_id ON webhook_deliveries(endpoint_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_uid ON webhook_deliveries(event_uid);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_id ON webhook_deliveries(event_id);
@@ -0,0 +1,20 @@
+-- Background job queue (package queue); workers claim rows with FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR(50) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    payload TEXT,
+    status VARCHAR(20) DEFAULT 'pending', -- pending, running, succeeded, dead, cancelled
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 5,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP,
+    locked_by VARCHAR(255),
+    last_error TEXT,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_claim ON jobs(queue, status, run_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_type ON jobs(type);
@@ -0,0 +1,19 @@
+-- Periodic jobs (order/event expiry, bank email checker, webhook delivery) with a lease
+-- so only one instance runs each job at a time
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name VARCHAR(100) PRIMARY KEY,
+    interval_seconds INT NOT NULL,
+    next_run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_by VARCHAR(255),
+    locked_until TIMESTAMP,
+    last_started_at TIMESTAMP,
+    last_finished_at TIMESTAMP,
+    last_duration_ms BIGINT DEFAULT 0,
+    last_status VARCHAR(20), -- succeeded, failed
+    last_error TEXT,
+    last_run_by VARCHAR(255),
+    run_count BIGINT DEFAULT 0,
+    failure_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,29 @@
+-- Bank transactions record the match outcome in a status column instead of a suffix on
+-- bank_name, so bank_name can hold whichever bank the notification came from
+ALTER TABLE bank_transactions ADD COLUMN IF NOT EXISTS status VARCHAR(20) DEFAULT 'matched';
+
+UPDATE bank_transactions SET status = 'unmatched', bank_name = 'Bank Jago' WHERE bank_name = 'Bank Jago (Unmatched)';
+UPDATE bank_transactions SET status = 'method_mismatch', bank_name = 'Bank Jago' WHERE bank_name = 'Bank Jago (Mismatch Method)';
+
+CREATE INDEX IF NOT EXISTS idx_bank_transactions_status ON bank_transactions(status);
+
+-- Payment-inbox emails no bank parser could read, kept for admin review
+CREATE TABLE IF NOT EXISTS quarantined_bank_emails (
+    id SERIAL PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL,
+    "from" VARCHAR(255),
+    subject TEXT,
+    received_at TIMESTAMP,
+    body TEXT,
+    reason VARCHAR(20), -- unrecognised, not_parsed
+    bank_name VARCHAR(50),
+    status VARCHAR(20) DEFAULT 'pending', -- pending, reprocessed, dismissed
+    reviewed_by INT REFERENCES users(id),
+    reviewed_at TIMESTAMP,
+    review_notes TEXT,
+    bank_transaction_id INT REFERENCES bank_transactions(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quarantined_bank_emails_message_id ON quarantined_bank_emails(message_id);
+CREATE INDEX IF NOT EXISTS idx_quarantined_bank_emails_status ON quarantined_bank_emails(status);
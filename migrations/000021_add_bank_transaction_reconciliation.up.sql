@@ -0,0 +1,4 @@
+-- Who linked an unmatched bank transfer to an order from the reconciliation console
+ALTER TABLE bank_transactions ADD COLUMN IF NOT EXISTS reconciled_by INT REFERENCES users(id);
+ALTER TABLE bank_transactions ADD COLUMN IF NOT EXISTS reconciled_at TIMESTAMP;
+ALTER TABLE bank_transactions ADD COLUMN IF NOT EXISTS reconcile_notes TEXT;
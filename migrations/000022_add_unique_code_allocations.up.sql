@@ -0,0 +1,24 @@
+-- Unique codes of manual-transfer orders. A row is live until released_at is set; the
+-- partial unique indexes keep both the (base amount, code) pair and the resulting total
+-- unique among live orders under concurrent checkouts
+CREATE TABLE IF NOT EXISTS unique_code_allocations (
+    id SERIAL PRIMARY KEY,
+    base_amount DECIMAL(10, 2) NOT NULL,
+    code INT NOT NULL,
+    total_amount DECIMAL(10, 2) NOT NULL,
+    order_id INT,
+    held_until TIMESTAMP, -- Expired order: kept for late payments until then
+    released_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_unique_code_live ON unique_code_allocations(base_amount, code) WHERE released_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_unique_code_total_live ON unique_code_allocations(total_amount) WHERE released_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_unique_code_allocations_order_id ON unique_code_allocations(order_id);
+
+-- Codes of orders still waiting for payment
+INSERT INTO unique_code_allocations (base_amount, code, total_amount, order_id, created_at)
+SELECT total_amount - unique_code, unique_code, total_amount, id, created_at
+FROM orders
+WHERE status = 'pending' AND unique_code <> 0
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- Last processed UID per payment mailbox; replaces the Seen flag as the record of
+-- which bank emails were already handled
+CREATE TABLE IF NOT EXISTS mailbox_checkpoints (
+    mailbox VARCHAR(255) PRIMARY KEY,
+    uid_validity BIGINT NOT NULL DEFAULT 0,
+    last_uid BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,7 @@
+-- Provider charge ID (Flip bill_link_id) that payment callbacks and status polls refer to
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_reference VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_orders_payment_reference ON orders(payment_reference);
+
+-- Flip orders kept the bill link in payment_data
+UPDATE orders SET payment_reference = payment_data
+WHERE payment_provider = 'flip' AND (payment_reference IS NULL OR payment_reference = '') AND payment_data <> '';
@@ -0,0 +1,58 @@
+-- Flash sales become time-ranged campaigns over several ticket types
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS ends_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS per_customer_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS waiting_room BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS queue_batch_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE flash_sales ADD COLUMN IF NOT EXISTS queue_batch_every INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_flash_sales_event_id ON flash_sales(event_id);
+CREATE INDEX IF NOT EXISTS idx_flash_sales_starts_at ON flash_sales(starts_at);
+CREATE INDEX IF NOT EXISTS idx_flash_sales_ends_at ON flash_sales(ends_at);
+
+CREATE TABLE IF NOT EXISTS flash_sale_items (
+    id SERIAL PRIMARY KEY,
+    flash_sale_id INTEGER NOT NULL REFERENCES flash_sales(id) ON DELETE CASCADE,
+    ticket_type_id INTEGER NOT NULL REFERENCES ticket_types(id) ON DELETE CASCADE,
+    flash_price NUMERIC(10,2) NOT NULL,
+    quota INTEGER NOT NULL DEFAULT 0,
+    sold INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_flash_sale_item ON flash_sale_items(flash_sale_id, ticket_type_id);
+CREATE INDEX IF NOT EXISTS idx_flash_sale_items_ticket_type_id ON flash_sale_items(ticket_type_id);
+
+CREATE TABLE IF NOT EXISTS flash_sale_queue_entries (
+    id SERIAL PRIMARY KEY,
+    flash_sale_id INTEGER NOT NULL REFERENCES flash_sales(id) ON DELETE CASCADE,
+    customer_key VARCHAR(255) NOT NULL,
+    position INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_flash_queue_customer ON flash_sale_queue_entries(flash_sale_id, customer_key);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_flash_queue_position ON flash_sale_queue_entries(flash_sale_id, position);
+
+-- Each single-ticket-type flash sale becomes a campaign with one item. Its WIB date and
+-- HH:MM window become timestamps; sales without a complete schedule never ran and are
+-- left inactive.
+INSERT INTO flash_sale_items (flash_sale_id, ticket_type_id, flash_price, quota, sold, created_at, updated_at)
+SELECT id, ticket_type_id, flash_price, quota, sold, created_at, updated_at FROM flash_sales
+WHERE ticket_type_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+UPDATE flash_sales SET
+    starts_at = (flash_date + NULLIF(start_time, '')::time) AT TIME ZONE 'Asia/Jakarta',
+    ends_at = (flash_date + NULLIF(end_time, '')::time) AT TIME ZONE 'Asia/Jakarta'
+WHERE starts_at IS NULL AND flash_date IS NOT NULL AND start_time <> '' AND end_time <> '';
+
+UPDATE flash_sales SET starts_at = created_at, ends_at = created_at, is_active = FALSE
+WHERE starts_at IS NULL;
+
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS ticket_type_id;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS flash_price;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS quota;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS sold;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS flash_date;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS start_time;
+ALTER TABLE flash_sales DROP COLUMN IF EXISTS end_time;
@@ -0,0 +1,23 @@
+-- Per-event waiting room in front of checkout
+ALTER TABLE events ADD COLUMN IF NOT EXISTS waiting_room BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS waiting_room_rate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS waiting_room_admit_minutes INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS waiting_room_states (
+    event_id INTEGER PRIMARY KEY REFERENCES events(id) ON DELETE CASCADE,
+    tail BIGINT NOT NULL DEFAULT 0,
+    released BIGINT NOT NULL DEFAULT 0,
+    allowance DOUBLE PRECISION NOT NULL DEFAULT 0,
+    refilled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS waiting_room_entries (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    visitor_id VARCHAR(64) NOT NULL,
+    position BIGINT NOT NULL,
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    admitted_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waiting_room_visitor ON waiting_room_entries(event_id, visitor_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waiting_room_position ON waiting_room_entries(event_id, position);
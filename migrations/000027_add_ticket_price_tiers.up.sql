@@ -0,0 +1,19 @@
+-- Price tiers (early bird, presale, ...) per ticket type
+CREATE TABLE IF NOT EXISTS ticket_price_tiers (
+    id SERIAL PRIMARY KEY,
+    ticket_type_id INTEGER NOT NULL REFERENCES ticket_types(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    quota INTEGER NOT NULL DEFAULT 0,
+    sold INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_ticket_price_tiers_ticket_type_id ON ticket_price_tiers(ticket_type_id);
+
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS price_tier_id INTEGER REFERENCES ticket_price_tiers(id);
+ALTER TABLE ticket_holds ADD COLUMN IF NOT EXISTS price_tier_id INTEGER REFERENCES ticket_price_tiers(id);
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_price_tier_id ON ticket_holds(price_tier_id);
@@ -0,0 +1,6 @@
+-- Sale window, minimum purchase and access-code visibility per ticket type
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS sales_start TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS sales_end TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS min_purchase INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE ticket_types ADD COLUMN IF NOT EXISTS access_code VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,60 @@
+-- Bundle ticket types issue one ticket per admission (e.g. per day of a multi-day pass)
+CREATE TABLE IF NOT EXISTS bundle_items (
+    id SERIAL PRIMARY KEY,
+    bundle_id INTEGER NOT NULL REFERENCES ticket_types(id) ON DELETE CASCADE,
+    ticket_type_id INTEGER NOT NULL REFERENCES ticket_types(id),
+    quantity INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS idx_bundle_items_bundle_id ON bundle_items(bundle_id);
+CREATE INDEX IF NOT EXISTS idx_bundle_items_ticket_type_id ON bundle_items(ticket_type_id);
+
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS bundle_ticket_type_id INTEGER REFERENCES ticket_types(id);
+CREATE INDEX IF NOT EXISTS idx_tickets_bundle_ticket_type_id ON tickets(bundle_ticket_type_id);
+ALTER TABLE ticket_holds ADD COLUMN IF NOT EXISTS bundle_ticket_type_id INTEGER REFERENCES ticket_types(id);
+CREATE INDEX IF NOT EXISTS idx_ticket_holds_bundle_ticket_type_id ON ticket_holds(bundle_ticket_type_id);
+
+-- Add-on products (merch, parking, ...) with their own stock and optional variants
+CREATE TABLE IF NOT EXISTS products (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image TEXT NOT NULL DEFAULT '',
+    price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    stock INTEGER NOT NULL DEFAULT 0,
+    sold INTEGER NOT NULL DEFAULT 0,
+    max_per_order INTEGER NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_products_event_id ON products(event_id);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+    id SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    price DECIMAL(10, 2),
+    stock INTEGER NOT NULL DEFAULT 0,
+    sold INTEGER NOT NULL DEFAULT 0,
+    position INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants(product_id);
+
+CREATE TABLE IF NOT EXISTS order_add_ons (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL DEFAULT 0,
+    event_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL REFERENCES products(id),
+    variant_id INTEGER REFERENCES product_variants(id),
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    quantity INTEGER NOT NULL DEFAULT 1,
+    unit_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'reserved',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_order_add_ons_order_id ON order_add_ons(order_id);
+CREATE INDEX IF NOT EXISTS idx_order_add_ons_product_id ON order_add_ons(product_id);
+CREATE INDEX IF NOT EXISTS idx_order_add_ons_variant_id ON order_add_ons(variant_id);
+CREATE INDEX IF NOT EXISTS idx_order_add_ons_status ON order_add_ons(status);
@@ -0,0 +1,2 @@
+-- Flash sale campaigns queue buyers in the event's waiting room (waiting_room_entries)
+DROP TABLE IF EXISTS flash_sale_queue_entries;
@@ -0,0 +1,3 @@
+-- Random token per claim, so a run that overran its timeout can't record its result over
+-- the run of a later claim by the same worker
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lock_token VARCHAR(32) NOT NULL DEFAULT '';
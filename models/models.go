@@ -1,6 +1,8 @@
 package models
 
 import (
+	"sort"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -20,7 +22,7 @@ type User struct {
 	Email           string          `gorm:"unique" json:"email"`
 	Password        string          `json:"-"` // Can be empty for OAuth users
 	Phone           string          `json:"phone"`
-	Role            string          `json:"role" gorm:"default:user"`     // admin, organizer, user
+	Role            string          `json:"role" gorm:"default:user"`     // admin, organizer, scanner, user
 	CustomFee       *float64        `json:"custom_fee"`                   // Specific fee for this organizer
 	Status          string          `json:"status" gorm:"default:active"` // active, inactive, banned
 	Avatar          string          `json:"avatar"`
@@ -74,105 +76,429 @@ type Voucher struct {
 }
 
 type Event struct {
-	ID                  uint         `gorm:"primaryKey" json:"id"`
-	Title               string       `json:"title"`
-	Slug                string       `json:"slug"`
-	Description         string       `json:"description"`
-	DetailedDescription string       `json:"detailed_description"`
-	EventDate           time.Time    `json:"event_date"`
-	EventTime           string       `json:"event_time"`
-	Venue               string       `json:"venue"`
-	City                string       `json:"city"`
-	Organizer           string       `json:"organizer"` // Display name
-	OrganizerID         uint         `json:"organizer_id"`
-	OrganizerUser       User         `json:"organizer_user" gorm:"foreignKey:OrganizerID"`
-	Image               string       `json:"image"`
-	Quota               int          `json:"quota"`
-	IsFeatured          bool         `json:"is_featured"`
-	Status              string       `json:"status"` // draft, published, completed, cancelled, sold_out
-	CategoryID          uint         `json:"category_id"`
-	Category            Category     `json:"category" gorm:"foreignKey:CategoryID"`
-	MinPrice            float64      `json:"min_price"`
-	MaxPrice            float64      `json:"max_price"`
-	TicketTypes         []TicketType `json:"ticket_types" gorm:"foreignKey:EventID"`
-	CustomFields        string       `json:"custom_fields"` // JSON string for form definition
-	FeePercentage       float64      `json:"fee_percentage"`
-	CreatedAt           time.Time    `json:"created_at"`
-	UpdatedAt           time.Time    `json:"updated_at"`
+	ID                      uint         `gorm:"primaryKey" json:"id"`
+	Title                   string       `json:"title"`
+	Slug                    string       `json:"slug"`
+	Description             string       `json:"description"`
+	DetailedDescription     string       `json:"detailed_description"`
+	EventDate               time.Time    `json:"event_date"`
+	EventTime               string       `json:"event_time"`
+	Venue                   string       `json:"venue"`
+	City                    string       `json:"city"`
+	Organizer               string       `json:"organizer"` // Display name
+	OrganizerID             uint         `json:"organizer_id"`
+	OrganizerUser           User         `json:"organizer_user" gorm:"foreignKey:OrganizerID"`
+	Image                   string       `json:"image"`
+	Quota                   int          `json:"quota"`
+	IsFeatured              bool         `json:"is_featured"`
+	Status                  string       `json:"status"` // draft, published, completed, cancelled, sold_out
+	CategoryID              uint         `json:"category_id"`
+	Category                Category     `json:"category" gorm:"foreignKey:CategoryID"`
+	MinPrice                float64      `json:"min_price"`
+	MaxPrice                float64      `json:"max_price"`
+	TicketTypes             []TicketType `json:"ticket_types" gorm:"foreignKey:EventID"`
+	Products                []Product    `json:"products,omitempty" gorm:"foreignKey:EventID"` // Add-ons (parking, merchandise)
+	CustomFields            string       `json:"custom_fields"`                                // JSON string for form definition
+	FeePercentage           float64      `json:"fee_percentage"`
+	HoldMinutes             int          `json:"hold_minutes" gorm:"default:30"` // Payment window / seat hold TTL for orders of this event
+	WaitingRoom             bool         `json:"waiting_room"`                   // Checkout needs an admitted waiting room token
+	WaitingRoomRate         int          `json:"waiting_room_rate"`              // Visitors admitted per minute, default 100
+	WaitingRoomAdmitMinutes int          `json:"waiting_room_admit_minutes"`     // How long an admitted token can check out, default 15
+	CreatedAt               time.Time    `json:"created_at"`
+	UpdatedAt               time.Time    `json:"updated_at"`
 }
 
 type TicketType struct {
-	ID                 uint      `gorm:"primaryKey" json:"id"`
-	EventID            uint      `json:"event_id"`
-	Event              Event     `json:"event" gorm:"foreignKey:EventID"`
-	Name               string    `json:"name"`
-	Description        string    `json:"description"`
-	Price              float64   `json:"price"`
-	OriginalPrice      float64   `json:"original_price"`
-	Quota              int       `json:"quota"`
-	Available          int       `json:"available"`
-	MaxPurchasePerUser int       `json:"max_purchase_per_user" gorm:"default:0"` // 0 = unlimited
-	Sold               int       `json:"sold" gorm:"-"`                          // Virtual field: Quota - Available
-	CreatedAt          time.Time `json:"created_at"`
-	UpdatedAt          time.Time `json:"updated_at"`
-}
-
-// Hooks to calculate Sold field
+	ID                 uint              `gorm:"primaryKey" json:"id"`
+	EventID            uint              `json:"event_id"`
+	Event              Event             `json:"event" gorm:"foreignKey:EventID"`
+	Name               string            `json:"name"`
+	Description        string            `json:"description"`
+	Price              float64           `json:"price"`
+	OriginalPrice      float64           `json:"original_price"`
+	Quota              int               `json:"quota"`
+	Available          int               `json:"available"`                                                                        // Cached: Quota - paid tickets - live holds (see utils.SyncAvailability)
+	MaxPurchasePerUser int               `json:"max_purchase_per_user" gorm:"default:0"`                                           // 0 = unlimited
+	SalesStart         *time.Time        `json:"sales_start"`                                                                      // Not on sale before, nil = as soon as the event is published
+	SalesEnd           *time.Time        `json:"sales_end"`                                                                        // Not on sale after, nil = until the event ends
+	MinPurchase        int               `json:"min_purchase" gorm:"default:0"`                                                    // Fewest tickets per order, 0 = 1
+	Hidden             bool              `json:"hidden"`                                                                           // Left out of public listings unless AccessCode is given
+	AccessCode         string            `json:"access_code,omitempty"`                                                            // Unlocks a hidden ticket type; never shown publicly
+	BundleItems        []BundleItem      `json:"bundle_items,omitempty" gorm:"foreignKey:BundleID;constraint:OnDelete:CASCADE"`    // Admissions a bundle (multi-day pass) issues per purchase
+	PriceTiers         []TicketPriceTier `json:"price_tiers,omitempty" gorm:"foreignKey:TicketTypeID;constraint:OnDelete:CASCADE"` // Early bird, presale, ...; Price applies when none is live
+	Sold               int               `json:"sold" gorm:"-"`                                                                    // Virtual field: Quota - Available
+	SalesStatus        string            `json:"sales_status" gorm:"-"`                                                            // Virtual field: scheduled, on_sale or closed
+	CurrentPrice       float64           `json:"current_price" gorm:"-"`                                                           // Virtual field: price of the live tier when tiers are loaded, else Price
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+// TicketPriceTier is a price step of a ticket type (early bird, presale 1, normal). Tiers
+// are tried in Position order; a tier is live inside its optional date range while it
+// has seats left of its optional quota. The first live tier sets the price.
+type TicketPriceTier struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	TicketTypeID uint       `json:"ticket_type_id" gorm:"index"`
+	Name         string     `json:"name"`
+	Price        float64    `json:"price"`
+	Quota        int        `json:"quota"`                 // Seats sold at this tier, 0 = no limit
+	Sold         int        `json:"sold" gorm:"default:0"` // Cached: paid tickets plus live holds in this tier
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	Position     int        `json:"position"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// BundleItem is an admission a bundle ticket type issues per purchase: a "Day 1 + Day 2"
+// pass has an item for each day's ticket type. Buying the bundle takes seats of those
+// ticket types and issues one ticket per admission.
+type BundleItem struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	BundleID     uint        `json:"bundle_id" gorm:"index"`
+	TicketTypeID uint        `json:"ticket_type_id" gorm:"index"`
+	TicketType   *TicketType `json:"ticket_type,omitempty" gorm:"foreignKey:TicketTypeID"`
+	Quantity     int         `json:"quantity" gorm:"default:1"` // Tickets of the ticket type per bundle
+}
+
+// Open reports whether now falls in the tier's date range.
+func (t TicketPriceTier) Open(now time.Time) bool {
+	if t.StartsAt != nil && now.Before(*t.StartsAt) {
+		return false
+	}
+	return t.EndsAt == nil || now.Before(*t.EndsAt)
+}
+
+// LiveTier returns the tier that currently sets the price, judged by the cached Sold
+// counts, or nil when the ticket type sells at Price. PriceTiers must be loaded.
+func (tt *TicketType) LiveTier(now time.Time) *TicketPriceTier {
+	tiers := make([]TicketPriceTier, len(tt.PriceTiers))
+	copy(tiers, tt.PriceTiers)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		if tiers[i].Position != tiers[j].Position {
+			return tiers[i].Position < tiers[j].Position
+		}
+		return tiers[i].ID < tiers[j].ID
+	})
+	for _, tier := range tiers {
+		if tier.Open(now) && (tier.Quota == 0 || tier.Sold < tier.Quota) {
+			return &tier
+		}
+	}
+	return nil
+}
+
+// Ticket type sales states
+const (
+	SalesScheduled = "scheduled"
+	SalesOnSale    = "on_sale"
+	SalesClosed    = "closed"
+)
+
+// SalesState returns whether the ticket type is on sale at now by its sales window.
+func (tt *TicketType) SalesState(now time.Time) string {
+	if tt.SalesStart != nil && now.Before(*tt.SalesStart) {
+		return SalesScheduled
+	}
+	if tt.SalesEnd != nil && !now.Before(*tt.SalesEnd) {
+		return SalesClosed
+	}
+	return SalesOnSale
+}
+
+// Unlocks reports whether code is the ticket type's access code (case-insensitive).
+func (tt *TicketType) Unlocks(code string) bool {
+	return tt.AccessCode != "" && strings.EqualFold(strings.TrimSpace(code), tt.AccessCode)
+}
+
+// IsBundle reports whether the ticket type is a bundle of other ticket types. Its
+// BundleItems must be loaded.
+func (tt *TicketType) IsBundle() bool {
+	return len(tt.BundleItems) > 0
+}
+
+// Hooks to calculate Sold, SalesStatus and CurrentPrice fields
 func (tt *TicketType) AfterFind(tx *gorm.DB) (err error) {
-	tt.Sold = tt.Quota - tt.Available
+	tt.setVirtualFields()
 	return
 }
 
 func (tt *TicketType) AfterSave(tx *gorm.DB) (err error) {
-	tt.Sold = tt.Quota - tt.Available
+	tt.setVirtualFields()
 	return
 }
 
+func (tt *TicketType) setVirtualFields() {
+	now := time.Now()
+	tt.Sold = tt.Quota - tt.Available
+	// A bundle is also limited by the seats left of its admissions, when they are loaded
+	for _, item := range tt.BundleItems {
+		if item.TicketType != nil && item.Quantity > 0 && item.TicketType.Available/item.Quantity < tt.Available {
+			tt.Available = item.TicketType.Available / item.Quantity
+		}
+	}
+	tt.SalesStatus = tt.SalesState(now)
+	tt.CurrentPrice = tt.Price
+	if tier := tt.LiveTier(now); tier != nil {
+		tt.CurrentPrice = tier.Price
+	}
+}
+
 type Order struct {
-	ID                   uint       `gorm:"primaryKey" json:"id"`
-	UserID               *uint      `json:"user_id"`
-	OrderNumber          string     `json:"order_number"`
-	CustomerName         string     `json:"customer_name"`
-	CustomerEmail        string     `json:"customer_email"`
-	CustomerPhone        string     `json:"customer_phone"`
-	TotalAmount          float64    `json:"total_amount"`
-	AdminFee             float64    `json:"admin_fee"`
-	DiscountAmount       float64    `json:"discount_amount"` // New field
-	VoucherCode          string     `json:"voucher_code"`    // New field
-	ReferralCode         string     `json:"referral_code"`   // New field for Referral Code
-	UniqueCode           int        `json:"unique_code"`
-	Status               string     `json:"status"`
-	PaymentMethod        string     `json:"payment_method"`
-	VirtualAccountNumber string     `json:"virtual_account_number"`
-	PaymentURL           string     `json:"payment_url"`  // URL for E-Wallet redirect / QRIS
-	PaymentData          string     `json:"payment_data"` // JSON string for raw gateway response
-	PaymentInstructions  string     `json:"payment_instructions"`
-	PaidAt               *time.Time `json:"paid_at"`
-	ExpiresAt            *time.Time `json:"expires_at"`
-	CreatedAt            time.Time  `json:"created_at"`
-	UpdatedAt            time.Time  `json:"updated_at"`
-	Tickets              []Ticket   `json:"tickets" gorm:"foreignKey:OrderID"`
+	ID                   uint         `gorm:"primaryKey" json:"id"`
+	UserID               *uint        `json:"user_id"`
+	OrderNumber          string       `json:"order_number"`
+	CustomerName         string       `json:"customer_name"`
+	CustomerEmail        string       `json:"customer_email"`
+	CustomerPhone        string       `json:"customer_phone"`
+	TotalAmount          float64      `json:"total_amount"`
+	AdminFee             float64      `json:"admin_fee"`
+	DiscountAmount       float64      `json:"discount_amount"` // New field
+	VoucherCode          string       `json:"voucher_code"`    // New field
+	ReferralCode         string       `json:"referral_code"`   // New field for Referral Code
+	UniqueCode           int          `json:"unique_code"`
+	Status               string       `json:"status"`
+	PaymentMethod        string       `json:"payment_method"`
+	PaymentProvider      string       `json:"payment_provider"` // manual_jago, flip
+	VirtualAccountNumber string       `json:"virtual_account_number"`
+	PaymentURL           string       `json:"payment_url"`                    // URL for E-Wallet redirect / QRIS
+	PaymentData          string       `json:"payment_data"`                   // JSON string for raw gateway response
+	PaymentReference     string       `gorm:"index" json:"payment_reference"` // Provider charge ID (Flip bill_link_id) callbacks refer to
+	PaymentInstructions  string       `json:"payment_instructions"`
+	PaidAt               *time.Time   `json:"paid_at"`
+	RefundedAmount       float64      `json:"refunded_amount"` // Sum of approved refunds
+	ExpiresAt            *time.Time   `json:"expires_at"`
+	CreatedAt            time.Time    `json:"created_at"`
+	UpdatedAt            time.Time    `json:"updated_at"`
+	Tickets              []Ticket     `json:"tickets" gorm:"foreignKey:OrderID"`
+	AddOns               []OrderAddOn `json:"add_ons" gorm:"foreignKey:OrderID"`
 }
 
 type Ticket struct {
-	ID                   uint       `gorm:"primaryKey" json:"id"`
-	OrderID              *uint      `json:"order_id"`
-	Order                Order      `json:"order" gorm:"foreignKey:OrderID"`
-	EventID              uint       `json:"event_id"`
-	Event                Event      `json:"event" gorm:"foreignKey:EventID"`
-	TicketTypeID         uint       `json:"ticket_type_id"`
-	TicketType           TicketType `json:"ticket_type" gorm:"foreignKey:TicketTypeID"`
-	TicketCode           string     `json:"ticket_code"`
-	AttendeeName         string     `json:"attendee_name"`
-	AttendeeEmail        string     `json:"attendee_email"`
-	AttendeePhone        string     `json:"attendee_phone"`
-	PurchasedPrice       float64    `json:"purchased_price"` // Price paid for this specific ticket
-	FlashSaleID          *uint      `json:"flash_sale_id"`   // Linked flash sale (optional)
-	Status               string     `json:"status"`          // active, used
-	CheckInAt            *time.Time `json:"check_in_at"`
-	CustomFieldResponses string     `json:"custom_field_responses"` // JSON string with responses
-	CreatedAt            time.Time  `json:"created_at"`
-	UpdatedAt            time.Time  `json:"updated_at"`
+	ID                   uint        `gorm:"primaryKey" json:"id"`
+	OrderID              *uint       `json:"order_id"`
+	Order                Order       `json:"order" gorm:"foreignKey:OrderID"`
+	EventID              uint        `json:"event_id"`
+	Event                Event       `json:"event" gorm:"foreignKey:EventID"`
+	TicketTypeID         uint        `json:"ticket_type_id"`
+	TicketType           TicketType  `json:"ticket_type" gorm:"foreignKey:TicketTypeID"`
+	TicketCode           string      `json:"ticket_code"`
+	AttendeeName         string      `json:"attendee_name"`
+	AttendeeEmail        string      `json:"attendee_email"`
+	AttendeePhone        string      `json:"attendee_phone"`
+	PurchasedPrice       float64     `json:"purchased_price"`                    // Price paid for this specific ticket
+	FlashSaleID          *uint       `json:"flash_sale_id"`                      // Linked flash sale (optional)
+	PriceTierID          *uint       `json:"price_tier_id"`                      // Price tier the ticket was sold in (optional)
+	BundleTicketTypeID   *uint       `json:"bundle_ticket_type_id" gorm:"index"` // Bundle the ticket was issued for (optional)
+	BundleTicketType     *TicketType `json:"bundle_ticket_type,omitempty" gorm:"foreignKey:BundleTicketTypeID"`
+	Status               string      `json:"status"` // active, used, refunded
+	CheckInAt            *time.Time  `json:"check_in_at"`
+	CheckInDeviceID      string      `json:"check_in_device_id"`     // Scanner device that recorded the (first) check-in
+	CustomFieldResponses string      `json:"custom_field_responses"` // JSON string with responses
+	CreatedAt            time.Time   `json:"created_at"`
+	UpdatedAt            time.Time   `json:"updated_at"`
+}
+
+// TypeName is the ticket type shown on the ticket: "Bundle - Admission" for tickets
+// issued by a bundle (when BundleTicketType is loaded).
+func (t *Ticket) TypeName() string {
+	if t.BundleTicketType != nil {
+		return t.BundleTicketType.Name + " - " + t.TicketType.Name
+	}
+	return t.TicketType.Name
+}
+
+// TicketHold reserves seats of one ticket type for a pending order until ExpiresAt.
+type TicketHold struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	OrderID            uint       `json:"order_id" gorm:"index"`
+	TicketTypeID       uint       `json:"ticket_type_id" gorm:"index"`
+	FlashSaleID        *uint      `json:"flash_sale_id" gorm:"index"`
+	PriceTierID        *uint      `json:"price_tier_id" gorm:"index"`
+	BundleTicketTypeID *uint      `json:"bundle_ticket_type_id" gorm:"index"` // Bundle the seats were held for
+	Quantity           int        `json:"quantity"`
+	Status             string     `json:"status" gorm:"index;default:held"` // held, converted, released
+	ExpiresAt          time.Time  `json:"expires_at" gorm:"index"`
+	ReleasedAt         *time.Time `json:"released_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Product is an add-on sold with an event's tickets that is not an admission: parking,
+// merchandise. Stock 0 means unlimited. A product with variants (sizes, vehicle types)
+// is bought per variant, and each variant can have its own stock as well.
+type Product struct {
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	EventID     uint             `json:"event_id" gorm:"index"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	Price       float64          `json:"price"`
+	Stock       int              `json:"stock"`         // 0 = unlimited
+	Sold        int              `json:"sold"`          // Cached: reserved and paid add-ons (see utils.SyncProductStock)
+	MaxPerOrder int              `json:"max_per_order"` // 0 = no limit
+	IsActive    bool             `json:"is_active"`
+	Variants    []ProductVariant `json:"variants" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// ProductVariant is a choice of a product, e.g. a t-shirt size.
+type ProductVariant struct {
+	ID        uint     `gorm:"primaryKey" json:"id"`
+	ProductID uint     `json:"product_id" gorm:"index"`
+	Name      string   `json:"name"`
+	Price     *float64 `json:"price"` // nil = the product's price
+	Stock     int      `json:"stock"` // 0 = only the product's stock applies
+	Sold      int      `json:"sold"`
+	Position  int      `json:"position"`
+}
+
+// OrderAddOn is an add-on line of an order. Name and UnitPrice are copied at checkout.
+// Reserved lines take stock like seat holds; they are paid with the order or released
+// when it is cancelled, expires or is refunded.
+type OrderAddOn struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	OrderID   uint      `json:"order_id" gorm:"index"`
+	EventID   uint      `json:"event_id"`
+	ProductID uint      `json:"product_id" gorm:"index"`
+	VariantID *uint     `json:"variant_id" gorm:"index"`
+	Name      string    `json:"name"` // Product and variant, e.g. "Kaos Official (L)"
+	Quantity  int       `json:"quantity"`
+	UnitPrice float64   `json:"unit_price"`
+	Status    string    `json:"status" gorm:"index;default:reserved"` // reserved, paid, released
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subtotal is the line's price before fees and discounts.
+func (a OrderAddOn) Subtotal() float64 {
+	return a.UnitPrice * float64(a.Quantity)
+}
+
+// IdempotencyKey stores the response of a request sent with an Idempotency-Key header so a
+// retried request (double-click, network retry) replays it instead of running again.
+type IdempotencyKey struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Scope        string    `json:"scope" gorm:"uniqueIndex:idx_idempotency_scope_key;size:100"` // e.g. "POST /api/v1/orders"
+	Key          string    `json:"key" gorm:"uniqueIndex:idx_idempotency_scope_key;size:255"`
+	RequestHash  string    `json:"request_hash"` // sha256 of caller + body; a reused key with another request is rejected
+	StatusCode   int       `json:"status_code"`  // 0 while the first request is still running
+	ResponseBody string    `json:"response_body"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// WebhookEndpoint is an organizer's URL that receives signed event notifications
+// (order.paid, order.cancelled, order.expired, order.refunded, ticket.checked_in).
+type WebhookEndpoint struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrganizerID uint      `json:"organizer_id" gorm:"index"`
+	EventID     *uint     `json:"event_id" gorm:"index"` // nil = every event of the organizer
+	URL         string    `json:"url"`
+	Secret      string    `json:"-"`           // HMAC-SHA256 key, only shown on create/rotate
+	EventTypes  string    `json:"event_types"` // Comma separated; empty = all
+	Description string    `json:"description"`
+	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one notification queued for one endpoint, with the result of its
+// latest attempt. Rows double as the delivery log.
+type WebhookDelivery struct {
+	ID             uint             `gorm:"primaryKey" json:"id"`
+	EndpointID     uint             `json:"endpoint_id" gorm:"index"`
+	Endpoint       *WebhookEndpoint `json:"endpoint,omitempty" gorm:"foreignKey:EndpointID"`
+	EventUID       string           `json:"event_uid" gorm:"index;size:40"` // Same for every endpoint notified of one event
+	EventType      string           `json:"event_type"`
+	EventID        uint             `json:"event_id" gorm:"index"` // Kartcis event the notification belongs to
+	Payload        string           `json:"payload"`
+	Status         string           `json:"status" gorm:"index;default:pending"` // pending, succeeded, failed
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  time.Time        `json:"next_attempt_at" gorm:"index"`
+	LastAttemptAt  *time.Time       `json:"last_attempt_at"`
+	ResponseStatus int              `json:"response_status"`
+	ResponseBody   string           `json:"response_body"` // Truncated
+	LastError      string           `json:"last_error"`
+	DeliveredAt    *time.Time       `json:"delivered_at"`
+	RedeliveryOf   *uint            `json:"redelivery_of"` // Set on manual redeliveries
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// Refund is a customer request to refund some or all tickets of a paid order.
+// Flow: requested -> approved -> completed (money sent), or requested -> rejected.
+type Refund struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	OrderID     uint         `json:"order_id" gorm:"index"`
+	Order       Order        `json:"order,omitempty" gorm:"foreignKey:OrderID"`
+	RequestedBy *uint        `json:"requested_by"`                          // nil for guest orders
+	Status      string       `json:"status" gorm:"index;default:requested"` // requested, approved, rejected, completed
+	IsFull      bool         `json:"is_full"`                               // Covers every remaining ticket of the order
+	Reason      string       `json:"reason"`
+	Amount      float64      `json:"amount"`       // Amount to return to the customer
+	ReviewedBy  *uint        `json:"reviewed_by"`  // Admin/organizer who approved or rejected
+	ReviewNotes string       `json:"review_notes"` // Rejection reason or approval notes
+	ReviewedAt  *time.Time   `json:"reviewed_at"`
+	TransferRef string       `json:"transfer_ref"` // Bank transfer reference of the payout
+	CompletedAt *time.Time   `json:"completed_at"`
+	Items       []RefundItem `json:"items" gorm:"foreignKey:RefundID"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// RefundItem is one ticket covered by a refund.
+type RefundItem struct {
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	RefundID uint    `json:"refund_id" gorm:"index"`
+	TicketID uint    `json:"ticket_id" gorm:"index"`
+	Ticket   *Ticket `json:"ticket,omitempty" gorm:"foreignKey:TicketID"`
+	Amount   float64 `json:"amount"`
+}
+
+// EventScanner assigns a scanner account to an event it may check tickets in for.
+type EventScanner struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	EventID    uint      `json:"event_id" gorm:"uniqueIndex:idx_event_scanner"`
+	UserID     uint      `json:"user_id" gorm:"uniqueIndex:idx_event_scanner;index"`
+	User       User      `json:"user" gorm:"foreignKey:UserID"`
+	AssignedBy uint      `json:"assigned_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CheckInLog records every scan attempt (online check-in, offline sync upload) and undo.
+type CheckInLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TicketID   *uint     `json:"ticket_id" gorm:"index"` // nil when the code did not resolve to a ticket
+	Ticket     *Ticket   `json:"ticket,omitempty" gorm:"foreignKey:TicketID"`
+	TicketCode string    `json:"ticket_code"`
+	EventID    uint      `json:"event_id" gorm:"index"` // Event selected on the scanner
+	OperatorID uint      `json:"operator_id" gorm:"index"`
+	Operator   *User     `json:"operator,omitempty" gorm:"foreignKey:OperatorID"`
+	DeviceID   string    `json:"device_id"`
+	Gate       string    `json:"gate"`
+	Source     string    `json:"source"` // online, sync, undo
+	Result     string    `json:"result"` // accepted, duplicate, void, wrong_event, invalid, not_found, undone
+	Reason     string    `json:"reason"`
+	ScannedAt  time.Time `json:"scanned_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// EventSigningKey is an Ed25519 key pair used to sign the ticket codes of one event.
+// Scanners fetch the public keys ahead of time to validate QR codes offline.
+type EventSigningKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	EventID    uint       `json:"event_id" gorm:"index"`
+	KeyID      string     `json:"key_id" gorm:"uniqueIndex;size:16"` // 8 hex chars, embedded in every signed code
+	PublicKey  string     `json:"public_key"`                        // base64 (std) raw 32-byte Ed25519 key
+	PrivateKey string     `json:"-"`                                 // base64 (std) Ed25519 seed, never exposed
+	Status     string     `json:"status" gorm:"default:active"`      // active, retired, revoked
+	RetiredAt  *time.Time `json:"retired_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
 type ActivityLog struct {
@@ -207,6 +533,47 @@ type RequestLog struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Job is a unit of background work in the Postgres-backed queue (package queue).
+// Flow: pending -> running -> succeeded, or back to pending with a later run_at until
+// max_attempts is reached, then dead. Pending jobs can be cancelled.
+type Job struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Queue       string     `json:"queue" gorm:"size:50;index:idx_jobs_claim,priority:1"`
+	Type        string     `json:"type" gorm:"size:100;index"`
+	Payload     string     `json:"payload"`                                                               // JSON
+	Status      string     `json:"status" gorm:"size:20;default:pending;index:idx_jobs_claim,priority:2"` // pending, running, succeeded, dead, cancelled
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       time.Time  `json:"run_at" gorm:"index:idx_jobs_claim,priority:3"`
+	LockedAt    *time.Time `json:"locked_at"`
+	LockedBy    string     `json:"locked_by"`        // host:pid of the worker running it
+	LockToken   string     `json:"-" gorm:"size:32"` // Random per claim; only that run may record the result
+	LastError   string     `json:"last_error"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ScheduledJob is the cluster-wide state of a periodic job (jobs.StartScheduler). The
+// instance holding the lease (locked_by until locked_until) is the only one running it.
+type ScheduledJob struct {
+	Name            string     `gorm:"primaryKey;size:100" json:"name"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	NextRunAt       time.Time  `json:"next_run_at"`
+	LockedBy        string     `json:"locked_by"` // host:pid while running
+	LockedUntil     *time.Time `json:"locked_until"`
+	LastStartedAt   *time.Time `json:"last_started_at"`
+	LastFinishedAt  *time.Time `json:"last_finished_at"`
+	LastDurationMs  int64      `json:"last_duration_ms"`
+	LastStatus      string     `json:"last_status"` // succeeded, failed
+	LastError       string     `json:"last_error"`
+	LastRunBy       string     `json:"last_run_by"`
+	RunCount        int64      `json:"run_count"`
+	FailureCount    int        `json:"failure_count"` // Consecutive failures
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
 type PasswordReset struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Email     string    `json:"email" gorm:"index"`
@@ -215,32 +582,117 @@ type PasswordReset struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// FlashSale is a time-ranged flash sale campaign over one or more ticket types of an
+// event. Each ticket type's flash price and quota is an item; quantity beyond the flash
+// quota or the customer's cap is sold at the normal price.
 type FlashSale struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	EventID          uint            `json:"event_id" gorm:"index"`
+	Event            Event           `json:"event" gorm:"foreignKey:EventID"`
+	Name             string          `json:"name"`
+	StartsAt         time.Time       `json:"starts_at" gorm:"index"`
+	EndsAt           time.Time       `json:"ends_at" gorm:"index"`
+	PerCustomerLimit int             `json:"per_customer_limit"` // Flash-priced tickets per customer over the campaign, 0 = no cap
+	WaitingRoom      bool            `json:"waiting_room"`       // Opens the event's waiting room until the campaign ends
+	QueueBatchSize   int             `json:"queue_batch_size"`   // Buyers admitted per batch
+	QueueBatchEvery  int             `json:"queue_batch_every"`  // Seconds between batches
+	IsActive         bool            `json:"is_active" gorm:"default:true"`
+	Items            []FlashSaleItem `json:"items" gorm:"foreignKey:FlashSaleID"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// FlashSaleItem is the flash price and quota of one ticket type in a campaign. Tickets
+// sold through it carry the campaign's FlashSaleID.
+type FlashSaleItem struct {
 	ID           uint       `gorm:"primaryKey" json:"id"`
-	EventID      uint       `json:"event_id"`
-	Event        Event      `json:"event" gorm:"foreignKey:EventID"`
-	TicketTypeID uint       `json:"ticket_type_id"`
+	FlashSaleID  uint       `json:"flash_sale_id" gorm:"uniqueIndex:idx_flash_sale_item"`
+	TicketTypeID uint       `json:"ticket_type_id" gorm:"uniqueIndex:idx_flash_sale_item;index"`
 	TicketType   TicketType `json:"ticket_type" gorm:"foreignKey:TicketTypeID"`
 	FlashPrice   float64    `json:"flash_price"`
 	Quota        int        `json:"quota"`
-	Sold         int        `json:"sold" gorm:"default:0"`
-	FlashDate    *time.Time `json:"flash_date"` // Specific date for the flash sale
-	StartTime    string     `json:"start_time"` // HH:MM "10:00"
-	EndTime      string     `json:"end_time"`   // HH:MM "14:00"
-	IsActive     bool       `json:"is_active" gorm:"default:true"`
+	Sold         int        `json:"sold" gorm:"default:0"` // Paid tickets plus live holds
 	CreatedAt    time.Time  `json:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at"`
 }
+
+// WaitingRoomEntry is a visitor's place in an event's waiting room. VisitorID is random
+// and carried in the signed queue token.
+type WaitingRoomEntry struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	EventID    uint       `json:"event_id" gorm:"uniqueIndex:idx_waiting_room_visitor;uniqueIndex:idx_waiting_room_position"`
+	VisitorID  string     `json:"-" gorm:"uniqueIndex:idx_waiting_room_visitor;size:64"`
+	Position   int64      `json:"position" gorm:"uniqueIndex:idx_waiting_room_position"`
+	JoinedAt   time.Time  `json:"joined_at"`
+	AdmittedAt *time.Time `json:"admitted_at"`
+}
+
+// WaitingRoomState is the release counter of an event's waiting room: positions up to
+// Released are admitted, Allowance accrues at the event's rate (see package waitingroom).
+type WaitingRoomState struct {
+	EventID    uint      `gorm:"primaryKey;autoIncrement:false" json:"event_id"`
+	Tail       int64     `json:"tail"`     // Last position handed out
+	Released   int64     `json:"released"` // Last admitted position
+	Allowance  float64   `json:"allowance"`
+	RefilledAt time.Time `json:"refilled_at"`
+}
+
 type BankTransaction struct {
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	OrderID         *uint     `json:"order_id"`                        // Linked order if found
-	ReferenceID     string    `gorm:"uniqueIndex" json:"reference_id"` // Message ID or Unique Hash
-	Amount          float64   `json:"amount"`
-	Sender          string    `json:"sender"`
-	BankName        string    `json:"bank_name"`
-	TransactionDate time.Time `json:"transaction_date"`
-	RawData         string    `json:"raw_data"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	OrderID         *uint      `json:"order_id"`                        // Linked order if found
+	ReferenceID     string     `gorm:"uniqueIndex" json:"reference_id"` // Message ID or Unique Hash
+	Amount          float64    `json:"amount"`
+	Sender          string     `json:"sender"`
+	BankName        string     `json:"bank_name"`
+	Status          string     `gorm:"default:'matched';index" json:"status"` // matched, unmatched, method_mismatch, partial, overpaid, quota_unavailable
+	TransactionDate time.Time  `json:"transaction_date"`
+	RawData         string     `json:"raw_data"`
+	ReconciledBy    *uint      `json:"reconciled_by"` // Admin who linked it to an order by hand
+	ReconciledAt    *time.Time `json:"reconciled_at"`
+	ReconcileNotes  string     `json:"reconcile_notes"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// UniqueCodeAllocation reserves the unique code added to a manual-transfer order so no
+// two live orders share a total. A row is live while released_at is NULL; the partial
+// unique indexes stop concurrent checkouts from taking the same code or total.
+type UniqueCodeAllocation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	BaseAmount  float64    `gorm:"uniqueIndex:idx_unique_code_live,where:released_at IS NULL" json:"base_amount"`
+	Code        int        `gorm:"uniqueIndex:idx_unique_code_live,where:released_at IS NULL" json:"code"`
+	TotalAmount float64    `gorm:"uniqueIndex:idx_unique_code_total_live,where:released_at IS NULL" json:"total_amount"`
+	OrderID     uint       `gorm:"index" json:"order_id"`
+	HeldUntil   *time.Time `json:"held_until"` // Set when the order expires: kept for late payments until then
+	ReleasedAt  *time.Time `json:"released_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// MailboxCheckpoint is the last IMAP UID the payment checker processed in a mailbox.
+// UIDs are only comparable while UIDVALIDITY stays the same.
+type MailboxCheckpoint struct {
+	Mailbox     string    `gorm:"primaryKey;size:255" json:"mailbox"` // user@host:port/MAILBOX
+	UIDValidity uint32    `json:"uid_validity"`
+	LastUID     uint32    `json:"last_uid"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// QuarantinedBankEmail is a payment-inbox email no bank parser could read, kept for
+// an admin to reprocess (e.g. after a parser fix) or dismiss.
+type QuarantinedBankEmail struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	MessageID         string     `gorm:"uniqueIndex" json:"message_id"`
+	From              string     `json:"from"`
+	Subject           string     `json:"subject"`
+	ReceivedAt        time.Time  `json:"received_at"`
+	Body              string     `json:"body"`
+	Reason            string     `json:"reason"`                                // not_parsed (unrecognised: older rows)
+	BankName          string     `json:"bank_name"`                             // Parser that failed, empty if none matched
+	Status            string     `gorm:"default:'pending';index" json:"status"` // pending, reprocessed, dismissed
+	ReviewedBy        *uint      `json:"reviewed_by"`
+	ReviewedAt        *time.Time `json:"reviewed_at"`
+	ReviewNotes       string     `json:"review_notes"`
+	BankTransactionID *uint      `json:"bank_transaction_id"`
+	CreatedAt         time.Time  `json:"created_at"`
 }
 
 type ReferralCode struct {
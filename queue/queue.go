@@ -0,0 +1,219 @@
+// Package queue is a Postgres-backed background job queue. Jobs are rows in the jobs
+// table, claimed with FOR UPDATE SKIP LOCKED so several replicas can run workers
+// without processing a job twice. Failed jobs are retried with exponential backoff
+// and end up "dead" after MaxAttempts, where an admin can retry or inspect them.
+//
+// Define a job type once, at package level, then enqueue typed payloads:
+//
+//	var sendReceipt = queue.Define("email.receipt", queue.QueueEmail, 5,
+//		func(ctx context.Context, p ReceiptPayload) error { ... })
+//
+//	sendReceipt.Enqueue(ReceiptPayload{OrderID: order.ID})
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Queue names. Each queue has its own worker pool (see Concurrency).
+const (
+	QueueDefault  = "default"
+	QueueEmail    = "email"
+	QueueWhatsApp = "whatsapp"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	baseBackoff        = 10 * time.Second
+	maxBackoff         = time.Hour
+	lastErrorLimit     = 2000
+)
+
+// Options tweak a single Enqueue call.
+type Options struct {
+	Tx    *gorm.DB  // Enqueue inside this transaction (the job only exists if it commits)
+	RunAt time.Time // Not before this time; zero = now
+}
+
+// Job is a registered job type whose payload is T (JSON encoded in the jobs table).
+type Job[T any] struct {
+	Type        string
+	Queue       string
+	MaxAttempts int
+	handler     func(ctx context.Context, payload T) error
+}
+
+type definition struct {
+	queue   string
+	timeout time.Duration
+	run     func(ctx context.Context, payload []byte) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]definition{}
+)
+
+// HandlerTimeout bounds a single job run; running jobs whose lock is older than this
+// (plus a margin) are assumed to belong to a crashed worker and are requeued.
+var HandlerTimeout = 10 * time.Minute
+
+// Define registers a job type. Call it from a package-level var so every type is
+// registered before workers start.
+func Define[T any](jobType, queueName string, maxAttempts int, handler func(ctx context.Context, payload T) error) *Job[T] {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	j := &Job[T]{Type: jobType, Queue: queueName, MaxAttempts: maxAttempts, handler: handler}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[jobType]; exists {
+		panic("queue: job type registered twice: " + jobType)
+	}
+	registry[jobType] = definition{
+		queue:   queueName,
+		timeout: HandlerTimeout,
+		run: func(ctx context.Context, raw []byte) error {
+			var payload T
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				return Permanent(fmt.Errorf("decode payload: %w", err))
+			}
+			return handler(ctx, payload)
+		},
+	}
+	return j
+}
+
+// Enqueue stores a job for the workers. Without a database (scripts, some tests) the
+// handler runs in a goroutine instead, like before the queue existed.
+func (j *Job[T]) Enqueue(payload T, opts ...Options) error {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	db := o.Tx
+	if db == nil {
+		db = config.DB
+	}
+	if db == nil {
+		go func() {
+			if err := j.handler(context.Background(), payload); err != nil {
+				log.Printf("[Queue] %s failed (no database, not retried): %v", j.Type, err)
+			}
+		}()
+		return nil
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	runAt := o.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+	if err := db.Create(&models.Job{
+		Queue:       j.Queue,
+		Type:        j.Type,
+		Payload:     string(raw),
+		Status:      StatusPending,
+		MaxAttempts: j.MaxAttempts,
+		RunAt:       runAt,
+	}).Error; err != nil {
+		log.Printf("[Queue] Failed to enqueue %s: %v", j.Type, err)
+		return err
+	}
+	wake(j.Queue)
+	return nil
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job goes straight to dead instead of being retried.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Backoff is the wait before retrying after `attempts` failed runs: 10s, 20s, 40s, ... up to 1h.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Retry moves a dead or cancelled job back to pending with a fresh set of attempts.
+func Retry(db *gorm.DB, id uint) error {
+	res := db.Model(&models.Job{}).Where("id = ? AND status IN ?", id, []string{StatusDead, StatusCancelled}).Updates(map[string]interface{}{
+		"status":      StatusPending,
+		"attempts":    0,
+		"run_at":      time.Now(),
+		"locked_at":   nil,
+		"locked_by":   "",
+		"finished_at": nil,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobNotRetryable
+	}
+	var job models.Job
+	if db.Select("queue").First(&job, id).Error == nil {
+		wake(job.Queue)
+	}
+	return nil
+}
+
+// Cancel stops a pending job from running. Running jobs can't be interrupted.
+func Cancel(db *gorm.DB, id uint) error {
+	now := time.Now()
+	res := db.Model(&models.Job{}).Where("id = ? AND status = ?", id, StatusPending).
+		Updates(map[string]interface{}{"status": StatusCancelled, "finished_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobNotCancellable
+	}
+	return nil
+}
+
+var (
+	ErrJobNotRetryable   = errors.New("only dead or cancelled jobs can be retried")
+	ErrJobNotCancellable = errors.New("only pending jobs can be cancelled")
+)
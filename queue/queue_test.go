@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+var (
+	greeted   []string
+	failUntil int
+	calls     int
+)
+
+var (
+	greetJob = Define("test.greet", "test", 3, func(ctx context.Context, p greeting) error {
+		calls++
+		if calls <= failUntil {
+			return errors.New("smtp timeout")
+		}
+		greeted = append(greeted, p.Name)
+		return nil
+	})
+	brokenJob = Define("test.broken", "test", 5, func(ctx context.Context, p greeting) error {
+		return Permanent(errors.New("template error"))
+	})
+)
+
+func setupQueueDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(&models.Job{})
+	greeted, failUntil, calls = nil, 0, 0
+	return db
+}
+
+func lastJob(db *gorm.DB) models.Job {
+	var job models.Job
+	db.Order("id DESC").First(&job)
+	return job
+}
+
+func TestQueue_EnqueueAndRun(t *testing.T) {
+	db := setupQueueDB(t)
+
+	assert.NoError(t, greetJob.Enqueue(greeting{Name: "Budi"}, Options{Tx: db}))
+	assert.NoError(t, greetJob.Enqueue(greeting{Name: "Later"}, Options{Tx: db, RunAt: time.Now().Add(time.Hour)}))
+
+	ran, err := RunPending(db, "test", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ran, "the scheduled job is not due yet")
+	assert.Equal(t, []string{"Budi"}, greeted)
+
+	var done models.Job
+	db.Where("status = ?", StatusSucceeded).First(&done)
+	assert.Equal(t, "test.greet", done.Type)
+	assert.Equal(t, 1, done.Attempts)
+	assert.NotNil(t, done.FinishedAt)
+	assert.Empty(t, done.LockedBy)
+}
+
+func TestQueue_RetryBackoffDeadAndAdminRetry(t *testing.T) {
+	db := setupQueueDB(t)
+	failUntil = 3
+	greetJob.Enqueue(greeting{Name: "Sari"}, Options{Tx: db})
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		ran, _ := RunPending(db, "test", 10)
+		assert.Equal(t, 1, ran)
+
+		job := lastJob(db)
+		assert.Equal(t, attempt, job.Attempts)
+		assert.Equal(t, "smtp timeout", job.LastError)
+		if attempt < 3 {
+			assert.Equal(t, StatusPending, job.Status)
+			assert.WithinDuration(t, before.Add(Backoff(attempt)), job.RunAt, time.Second)
+			ran, _ = RunPending(db, "test", 10)
+			assert.Equal(t, 0, ran, "waits for the backoff")
+			db.Model(&job).Update("run_at", time.Now().Add(-time.Second))
+		} else {
+			assert.Equal(t, StatusDead, job.Status)
+		}
+	}
+	assert.Empty(t, greeted)
+
+	// Admin retry starts over and the job now succeeds
+	job := lastJob(db)
+	assert.ErrorIs(t, Cancel(db, job.ID), ErrJobNotCancellable)
+	assert.NoError(t, Retry(db, job.ID))
+	assert.ErrorIs(t, Retry(db, job.ID), ErrJobNotRetryable)
+	RunPending(db, "test", 10)
+	job = lastJob(db)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, []string{"Sari"}, greeted)
+
+	assert.Equal(t, 10*time.Second, Backoff(1))
+	assert.Equal(t, 40*time.Second, Backoff(3))
+	assert.Equal(t, time.Hour, Backoff(30))
+}
+
+func TestQueue_PermanentUnknownCancelAndStale(t *testing.T) {
+	db := setupQueueDB(t)
+
+	brokenJob.Enqueue(greeting{Name: "x"}, Options{Tx: db})
+	RunPending(db, "test", 10)
+	job := lastJob(db)
+	assert.Equal(t, StatusDead, job.Status)
+	assert.Equal(t, 1, job.Attempts, "permanent errors are not retried")
+
+	db.Create(&models.Job{Queue: "test", Type: "test.removed", Payload: "{}", Status: StatusPending, MaxAttempts: 5, RunAt: time.Now()})
+	RunPending(db, "test", 10)
+	job = lastJob(db)
+	assert.Equal(t, StatusDead, job.Status)
+	assert.Contains(t, job.LastError, "unknown job type")
+
+	greetJob.Enqueue(greeting{Name: "Batal"}, Options{Tx: db})
+	job = lastJob(db)
+	assert.NoError(t, Cancel(db, job.ID))
+	ran, _ := RunPending(db, "test", 10)
+	assert.Equal(t, 0, ran)
+	assert.Empty(t, greeted)
+
+	// A worker that died mid-run leaves the job running; maintenance requeues it
+	lockedAt := time.Now().Add(-HandlerTimeout - 2*time.Minute)
+	stale := models.Job{Queue: "test", Type: "test.greet", Payload: `{"name":"Stale"}`, Status: StatusRunning, Attempts: 1, MaxAttempts: 3, RunAt: lockedAt, LockedAt: &lockedAt, LockedBy: "gone:1"}
+	db.Create(&stale)
+	maintain(db)
+	db.First(&stale, stale.ID)
+	assert.Equal(t, StatusPending, stale.Status)
+	RunPending(db, "test", 10)
+	assert.Equal(t, []string{"Stale"}, greeted)
+}
+
+func TestQueue_OverrunRunCannotRecordOverNewClaim(t *testing.T) {
+	db := setupQueueDB(t)
+	greetJob.Enqueue(greeting{Name: "Lambat"}, Options{Tx: db})
+
+	first, err := claim(db, "test")
+	assert.NoError(t, err)
+
+	// The first run overruns; maintenance requeues it and this same worker claims it again
+	lockedAt := time.Now().Add(-HandlerTimeout - 2*time.Minute)
+	db.Model(&models.Job{}).Where("id = ?", first.ID).Update("locked_at", lockedAt)
+	maintain(db)
+	second, err := claim(db, "test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, second) {
+		assert.Equal(t, first.LockedBy, second.LockedBy)
+		assert.NotEqual(t, first.LockToken, second.LockToken)
+	}
+
+	failUntil = 1
+	execute(db, first)
+	job := lastJob(db)
+	assert.Equal(t, StatusRunning, job.Status, "the overrun run's failure is not recorded")
+	assert.Equal(t, 2, job.Attempts)
+
+	execute(db, second)
+	job = lastJob(db)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Empty(t, job.LockToken)
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Concurrency is how many jobs of each queue one process runs at once. Queues not
+// listed here get one worker. WhatsApp stays at 1 so broadcasts keep their pacing.
+var Concurrency = map[string]int{
+	QueueDefault:  4,
+	QueueEmail:    4,
+	QueueWhatsApp: 1,
+}
+
+const (
+	pollInterval   = 2 * time.Second
+	staleMargin    = time.Minute
+	finishedMaxAge = 7 * 24 * time.Hour
+)
+
+// workerID identifies this process in jobs.locked_by.
+var workerID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+var wakers = map[string]chan struct{}{}
+
+// wake nudges the local pool of a queue so a new job doesn't wait for the next poll.
+func wake(queueName string) {
+	registryMu.RLock()
+	ch := wakers[queueName]
+	registryMu.RUnlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches a worker pool per queue plus the maintenance loop (stale lock
+// recovery, pruning of old finished jobs).
+func Start() {
+	if config.DB == nil {
+		return
+	}
+
+	queues := map[string]int{}
+	for name, n := range Concurrency {
+		queues[name] = n
+	}
+	registryMu.Lock()
+	for _, def := range registry {
+		if _, ok := queues[def.queue]; !ok {
+			queues[def.queue] = 1
+		}
+	}
+	for name := range queues {
+		wakers[name] = make(chan struct{}, 1)
+	}
+	registryMu.Unlock()
+
+	for name, n := range queues {
+		go runQueue(config.DB, name, n)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			maintain(config.DB)
+		}
+	}()
+}
+
+func runQueue(db *gorm.DB, name string, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	registryMu.RLock()
+	wakeCh := wakers[name]
+	registryMu.RUnlock()
+
+	slots := make(chan struct{}, concurrency)
+	ticker := time.NewTicker(pollInterval)
+	for {
+		for len(slots) < cap(slots) {
+			job, err := claim(db, name)
+			if err != nil {
+				log.Printf("[Queue] Error claiming %s job: %v", name, err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			slots <- struct{}{}
+			go func(job *models.Job) {
+				defer func() {
+					<-slots
+					wake(name)
+				}()
+				execute(db, job)
+			}(job)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-wakeCh:
+		}
+	}
+}
+
+// RunPending synchronously runs up to limit due jobs of a queue and returns how many ran.
+// Workers don't need it; it is meant for tests and one-off scripts.
+func RunPending(db *gorm.DB, queueName string, limit int) (int, error) {
+	ran := 0
+	for ran < limit {
+		job, err := claim(db, queueName)
+		if err != nil {
+			return ran, err
+		}
+		if job == nil {
+			break
+		}
+		execute(db, job)
+		ran++
+	}
+	return ran, nil
+}
+
+// claim locks the next due job of a queue and marks it running. SKIP LOCKED lets other
+// replicas claim the next row instead of waiting; the status condition on the update
+// keeps databases without row locks (SQLite in tests) from double-claiming.
+func claim(db *gorm.DB, queueName string) (*models.Job, error) {
+	var claimed *models.Job
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var jobs []models.Job
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("queue = ? AND status = ? AND run_at <= ?", queueName, StatusPending, time.Now()).
+			Order("run_at ASC, id ASC").Limit(1).Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		job := jobs[0]
+		now := time.Now()
+		token, err := newLockToken()
+		if err != nil {
+			return err
+		}
+		res := tx.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, StatusPending).Updates(map[string]interface{}{
+			"status":     StatusRunning,
+			"attempts":   job.Attempts + 1,
+			"locked_at":  now,
+			"locked_by":  workerID,
+			"lock_token": token,
+		})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		job.Status = StatusRunning
+		job.Attempts++
+		job.LockedAt = &now
+		job.LockedBy = workerID
+		job.LockToken = token
+		claimed = &job
+		return nil
+	})
+	return claimed, err
+}
+
+// newLockToken identifies one claim of a job.
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func execute(db *gorm.DB, job *models.Job) {
+	registryMu.RLock()
+	def, ok := registry[job.Type]
+	registryMu.RUnlock()
+
+	var err error
+	if !ok {
+		err = Permanent(fmt.Errorf("unknown job type %q", job.Type))
+	} else {
+		err = runHandler(def, job)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"locked_at": nil, "locked_by": "", "lock_token": ""}
+	switch {
+	case err == nil:
+		updates["status"] = StatusSucceeded
+		updates["finished_at"] = now
+		updates["last_error"] = ""
+	case isPermanent(err) || job.Attempts >= job.MaxAttempts:
+		updates["status"] = StatusDead
+		updates["finished_at"] = now
+		updates["last_error"] = truncateError(err)
+		log.Printf("[Queue] Job %d (%s) is dead after %d attempt(s): %v", job.ID, job.Type, job.Attempts, err)
+	default:
+		updates["status"] = StatusPending
+		updates["run_at"] = now.Add(Backoff(job.Attempts))
+		updates["last_error"] = truncateError(err)
+	}
+
+	// Only the claim still holding the lock records the result; maintenance may have
+	// requeued a job that overran its timeout, and this worker may have claimed it again
+	if err := db.Model(&models.Job{}).Where("id = ? AND status = ? AND lock_token = ?", job.ID, StatusRunning, job.LockToken).
+		Updates(updates).Error; err != nil {
+		log.Printf("[Queue] Failed to record result of job %d: %v", job.ID, err)
+	}
+}
+
+func runHandler(def definition, job *models.Job) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), def.timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return def.run(ctx, []byte(job.Payload))
+}
+
+func truncateError(err error) string {
+	msg := err.Error()
+	if len(msg) > lastErrorLimit {
+		msg = msg[:lastErrorLimit]
+	}
+	return msg
+}
+
+// maintain requeues jobs whose worker died mid-run and prunes old finished jobs.
+func maintain(db *gorm.DB) {
+	now := time.Now()
+	staleBefore := now.Add(-HandlerTimeout - staleMargin)
+
+	db.Model(&models.Job{}).
+		Where("status = ? AND locked_at < ? AND attempts >= max_attempts", StatusRunning, staleBefore).
+		Updates(map[string]interface{}{"status": StatusDead, "finished_at": now, "locked_at": nil, "locked_by": "", "lock_token": "", "last_error": "worker stopped while running the job"})
+	res := db.Model(&models.Job{}).
+		Where("status = ? AND locked_at < ?", StatusRunning, staleBefore).
+		Updates(map[string]interface{}{"status": StatusPending, "run_at": now, "locked_at": nil, "locked_by": "", "lock_token": "", "last_error": "worker stopped while running the job"})
+	if res.Error == nil && res.RowsAffected > 0 {
+		log.Printf("[Queue] Requeued %d stale job(s)", res.RowsAffected)
+	}
+
+	db.Where("status IN ? AND finished_at < ?", []string{StatusSucceeded, StatusCancelled}, now.Add(-finishedMaxAge)).Delete(&models.Job{})
+}
@@ -73,12 +73,13 @@ func SetupRouter() *gin.Engine {
 	{
 		tickets.GET("/my-tickets", middleware.AuthMiddleware(), controllers.GetMyTickets)
 		tickets.GET("/:code", controllers.GetTicketDetail)
-		tickets.GET("/:code/verify", controllers.VerifyTicket)
+		tickets.GET("/:code/verify", middleware.OptionalAuthMiddleware(), controllers.VerifyTicket)
 		tickets.GET("/:code/download", controllers.DownloadTicketPDF)
 
 		// Check-in (Admin/Scanner)
 		// Spec says 👑 Admin Only or Scanner
-		tickets.POST("/check-in", middleware.AuthMiddleware(), requireAdmin(), controllers.CheckInTicket)
+		tickets.POST("/check-in", middleware.AuthMiddleware(), requireCheckInAccess(), controllers.CheckInTicket)
+		tickets.POST("/:code/undo-check-in", middleware.AuthMiddleware(), requireAdmin(), controllers.UndoCheckIn)
 	}
 
 	// Orders (User)
@@ -90,14 +91,19 @@ func SetupRouter() *gin.Engine {
 	// Orders (Guest or Auth)
 	v1.GET("/vouchers/validate", controllers.ValidateVoucher)       // Public access for checking codes
 	v1.GET("/referrals/validate", controllers.ValidateReferralCode) // Public: validate referral code
-	v1.POST("/orders", middleware.OptionalAuthMiddleware(), controllers.CreateOrder)
+	v1.POST("/orders", middleware.OptionalAuthMiddleware(), middleware.Idempotency(), controllers.CreateOrder)
 	v1.GET("/orders/:order_number", middleware.OptionalAuthMiddleware(), controllers.GetOrderDetail)
 	v1.GET("/orders/:order_number/tickets", middleware.OptionalAuthMiddleware(), controllers.GetOrderTickets)
+	v1.GET("/orders/:order_number/tickets.pdf", middleware.OptionalAuthMiddleware(), controllers.DownloadOrderTicketsPDF)
 	v1.POST("/orders/:order_number/cancel", controllers.UserCancelOrder)
+	v1.POST("/orders/:order_number/refunds", middleware.OptionalAuthMiddleware(), controllers.RequestRefund)
+	v1.GET("/orders/:order_number/refunds", middleware.OptionalAuthMiddleware(), controllers.GetOrderRefunds)
 
 	// User/Public Uploads (For Custom Field Attachments like Student ID)
 	v1.POST("/upload", controllers.UploadFile)
 	v1.GET("/flash-sales", controllers.GetFlashSales) // Added for public viewing during checkout
+	v1.POST("/events/:id/queue", controllers.JoinWaitingRoom)
+	v1.GET("/events/:id/queue", controllers.GetWaitingRoomStatus)
 
 	userOrders.Use(middleware.AuthMiddleware())
 	{
@@ -124,6 +130,16 @@ func SetupRouter() *gin.Engine {
 		admin.PATCH("/events/:id/status", controllers.UpdateEventStatus)
 		admin.GET("/events/:id/analytics", controllers.GetEventAnalytics)
 
+		// Ticket signing keys (offline verification)
+		admin.POST("/events/:id/ticket-keys/rotate", controllers.RotateEventTicketKey)
+		admin.POST("/events/:id/ticket-keys/:key_id/revoke", controllers.RevokeEventTicketKey)
+
+		// Check-in audit and scanner accounts
+		admin.GET("/events/:id/check-in/logs", controllers.GetCheckInLogs)
+		admin.GET("/events/:id/scanners", controllers.GetEventScanners)
+		admin.POST("/events/:id/scanners", controllers.AssignEventScanner)
+		admin.DELETE("/events/:id/scanners/:user_id", controllers.RevokeEventScanner)
+
 		// Ticket Types (Scoped)
 		admin.GET("/ticket-types", controllers.AdminGetTicketTypes)
 		admin.POST("/ticket-types", controllers.CreateTicketType)
@@ -146,6 +162,12 @@ func SetupRouter() *gin.Engine {
 		admin.PUT("/flash-sales/:id", controllers.UpdateFlashSale)
 		admin.DELETE("/flash-sales/:id", controllers.DeleteFlashSale)
 
+		// Add-on Products (Scoped)
+		admin.GET("/products", controllers.AdminGetProducts)
+		admin.POST("/products", controllers.CreateProduct)
+		admin.PUT("/products/:id", controllers.UpdateProduct)
+		admin.DELETE("/products/:id", controllers.DeleteProduct)
+
 		// Referral Codes (Scoped: Admin can manage all, Organizer can manage their own)
 		admin.GET("/referrals", controllers.AdminGetReferralCodes)
 		admin.POST("/referrals", controllers.CreateReferralCode)
@@ -174,12 +196,39 @@ func SetupRouter() *gin.Engine {
 		admin.POST("/transactions/:id/mark-paid", controllers.MarkTransactionPaid)
 		admin.PUT("/transactions/:id/status", controllers.UpdateTransactionStatus)
 		admin.GET("/transactions/:id/timeline", controllers.GetTransactionTimeline)
+
+		// Refunds (Scoped)
+		admin.GET("/refunds", controllers.AdminGetRefunds)
+		admin.GET("/refunds/:id", controllers.AdminGetRefundDetail)
+		admin.POST("/refunds/:id/approve", controllers.ApproveRefund)
+		admin.POST("/refunds/:id/reject", controllers.RejectRefund)
+		admin.POST("/refunds/:id/complete", controllers.CompleteRefund)
+
+		// Webhooks (Scoped)
+		admin.GET("/webhooks", controllers.GetWebhookEndpoints)
+		admin.POST("/webhooks", controllers.CreateWebhookEndpoint)
+		admin.PUT("/webhooks/:id", controllers.UpdateWebhookEndpoint)
+		admin.DELETE("/webhooks/:id", controllers.DeleteWebhookEndpoint)
+		admin.POST("/webhooks/:id/rotate-secret", controllers.RotateWebhookSecret)
+		admin.POST("/webhooks/:id/test", controllers.SendTestWebhook)
+		admin.GET("/webhooks/:id/deliveries", controllers.GetWebhookDeliveries)
+		admin.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook)
 		// admin.POST("/transactions/trigger-scraping", controllers.AdminTriggerScraping) // Scraping is system level
 
 		// Upload
 		admin.POST("/upload", controllers.UploadFile)
 	}
 
+	// Door scanning (Admin, Organizer, or Scanner assigned to the event)
+	scanner := v1.Group("/admin")
+	scanner.Use(middleware.AuthMiddleware(), requireCheckInAccess())
+	{
+		scanner.GET("/scanner/events", controllers.GetMyScannerEvents)
+		scanner.GET("/events/:id/ticket-keys", controllers.GetEventTicketKeys)
+		scanner.GET("/events/:id/check-in/manifest", controllers.GetCheckInManifest)
+		scanner.POST("/events/:id/check-in/sync", controllers.SyncCheckIns)
+	}
+
 	// Super Admin Only Routes
 	superAdmin := v1.Group("/admin")
 	superAdmin.Use(middleware.AuthMiddleware(), requireAdmin())
@@ -219,6 +268,29 @@ func SetupRouter() *gin.Engine {
 		// WhatsApp Broadcast
 		superAdmin.GET("/broadcast/wa/qr", controllers.GetWAStatus)
 		superAdmin.POST("/broadcast/wa/send", controllers.BroadcastWA)
+
+		// Background Jobs
+		superAdmin.GET("/jobs", controllers.AdminGetJobs)
+		superAdmin.GET("/jobs/:id", controllers.AdminGetJobDetail)
+		superAdmin.POST("/jobs/:id/retry", controllers.RetryJob)
+		superAdmin.POST("/jobs/:id/cancel", controllers.CancelJob)
+
+		// Scheduled Jobs
+		superAdmin.GET("/scheduler", controllers.AdminGetScheduledJobs)
+		superAdmin.POST("/scheduler/:name/run", controllers.TriggerScheduledJob)
+
+		// Quarantined Bank Emails
+		superAdmin.GET("/bank-emails", controllers.AdminGetQuarantinedBankEmails)
+		superAdmin.GET("/bank-emails/:id", controllers.AdminGetQuarantinedBankEmail)
+		superAdmin.POST("/bank-emails/:id/reprocess", controllers.ReprocessQuarantinedBankEmail)
+		superAdmin.POST("/bank-emails/:id/dismiss", controllers.DismissQuarantinedBankEmail)
+
+		// Bank Transaction Reconciliation
+		superAdmin.GET("/bank-transactions/unmatched", controllers.AdminGetUnmatchedBankTransactions)
+		superAdmin.GET("/bank-transactions/:id", controllers.AdminGetBankTransactionDetail)
+		superAdmin.GET("/bank-transactions/:id/candidates", controllers.GetReconcileCandidates)
+		superAdmin.POST("/bank-transactions/:id/link", controllers.LinkBankTransaction)
+		superAdmin.POST("/bank-transactions/import", controllers.ImportBankStatement)
 	}
 
 	// Public Settings (Already outside)
@@ -238,6 +310,19 @@ func requireAdmin() gin.HandlerFunc {
 	}
 }
 
+// requireCheckInAccess admits roles that may scan tickets. Per-event scoping (organizer
+// owns the event, scanner is assigned to it) is checked in the handlers.
+func requireCheckInAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, exists := c.Get("userRole")
+		if !exists || (role != "admin" && role != "organizer" && role != "scanner") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"success": false, "message": "Check-in access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
 func requireAdminOrOrganizer() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("userRole")
@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidAddOn      = errors.New("invalid add-on")
+	ErrInsufficientStock = errors.New("insufficient add-on stock")
+	ErrInvalidVariant    = errors.New("invalid product variant")
+	ErrVariantInUse      = errors.New("product variant has orders")
+)
+
+// AddOnLine is an add-on the customer asks for at checkout.
+type AddOnLine struct {
+	ProductID uint
+	VariantID *uint
+	Quantity  int
+}
+
+type addOnKey struct {
+	productID uint
+	variantID uint
+}
+
+// takenStock sums the reserved and paid quantity of a product, or of one of its variants.
+func takenStock(tx *gorm.DB, productID uint, variantID *uint) (int, error) {
+	query := tx.Model(&models.OrderAddOn{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("product_id = ? AND status IN ?", productID, []string{"reserved", "paid"})
+	if variantID != nil {
+		query = query.Where("variant_id = ?", *variantID)
+	}
+	var taken int64
+	if err := query.Scan(&taken).Error; err != nil {
+		return 0, err
+	}
+	return int(taken), nil
+}
+
+// ReserveAddOns checks and reserves the add-ons of a checkout. Lines for the same product
+// and variant are merged; products must be active and belong to one of eventIDs (the
+// events the order buys tickets for). Product rows are locked in ID order while their
+// stock is checked. The reserved lines are created with OrderID 0, to be attached to the
+// order within the same transaction. Nothing is reserved unless every line is.
+func ReserveAddOns(tx *gorm.DB, lines []AddOnLine, eventIDs map[uint]bool) ([]models.OrderAddOn, error) {
+	quantities := make(map[addOnKey]int)
+	var keys []addOnKey
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity must be at least 1", ErrInvalidAddOn)
+		}
+		key := addOnKey{productID: line.ProductID}
+		if line.VariantID != nil {
+			key.variantID = *line.VariantID
+		}
+		if _, ok := quantities[key]; !ok {
+			keys = append(keys, key)
+		}
+		quantities[key] += line.Quantity
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].productID != keys[j].productID {
+			return keys[i].productID < keys[j].productID
+		}
+		return keys[i].variantID < keys[j].variantID
+	})
+
+	var reserved []models.OrderAddOn
+	products := make(map[uint]*models.Product)
+	perProduct := make(map[uint]int)
+	for _, key := range keys {
+		product, ok := products[key.productID]
+		if !ok {
+			product = &models.Product{}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Variants").
+				First(product, key.productID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, fmt.Errorf("%w: product %d not found", ErrInvalidAddOn, key.productID)
+				}
+				return nil, err
+			}
+			if !product.IsActive || !eventIDs[product.EventID] {
+				return nil, fmt.Errorf("%w: '%s' is not available with these tickets", ErrInvalidAddOn, product.Name)
+			}
+			products[key.productID] = product
+		}
+
+		qty := quantities[key]
+		perProduct[product.ID] += qty
+		if product.MaxPerOrder > 0 && perProduct[product.ID] > product.MaxPerOrder {
+			return nil, fmt.Errorf("%w: at most %d of '%s' per order", ErrInvalidAddOn, product.MaxPerOrder, product.Name)
+		}
+
+		line := models.OrderAddOn{EventID: product.EventID, ProductID: product.ID, Name: product.Name, Quantity: qty, UnitPrice: product.Price, Status: "reserved"}
+		var variant *models.ProductVariant
+		for i := range product.Variants {
+			if product.Variants[i].ID == key.variantID {
+				variant = &product.Variants[i]
+			}
+		}
+		switch {
+		case len(product.Variants) > 0 && variant == nil:
+			return nil, fmt.Errorf("%w: choose a variant of '%s'", ErrInvalidAddOn, product.Name)
+		case len(product.Variants) == 0 && key.variantID != 0:
+			return nil, fmt.Errorf("%w: '%s' has no variants", ErrInvalidAddOn, product.Name)
+		case variant != nil:
+			line.VariantID = &variant.ID
+			line.Name = fmt.Sprintf("%s (%s)", product.Name, variant.Name)
+			if variant.Price != nil {
+				line.UnitPrice = *variant.Price
+			}
+		}
+
+		// Product stock covers every variant of it in this checkout
+		if product.Stock > 0 {
+			taken, err := takenStock(tx, product.ID, nil)
+			if err != nil {
+				return nil, err
+			}
+			if product.Stock-taken < perProduct[product.ID] {
+				return nil, fmt.Errorf("%w: '%s'", ErrInsufficientStock, product.Name)
+			}
+		}
+		if variant != nil && variant.Stock > 0 {
+			taken, err := takenStock(tx, product.ID, &variant.ID)
+			if err != nil {
+				return nil, err
+			}
+			if variant.Stock-taken < qty {
+				return nil, fmt.Errorf("%w: '%s'", ErrInsufficientStock, line.Name)
+			}
+		}
+		reserved = append(reserved, line)
+	}
+
+	for i := range reserved {
+		if err := tx.Create(&reserved[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for id := range products {
+		if err := SyncProductStock(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return reserved, nil
+}
+
+// SyncProductStock recomputes the cached sold counts of a product and its variants.
+func SyncProductStock(tx *gorm.DB, productID uint) error {
+	sold, err := takenStock(tx, productID, nil)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Update("sold", sold).Error; err != nil {
+		return err
+	}
+
+	var variants []models.ProductVariant
+	if err := tx.Where("product_id = ?", productID).Find(&variants).Error; err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		sold, err := takenStock(tx, productID, &variant.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ProductVariant{}).Where("id = ?", variant.ID).Update("sold", sold).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOrderAddOnStatus moves the order's add-ons from one of the given statuses to status
+// and resyncs the stock of their products.
+func setOrderAddOnStatus(tx *gorm.DB, orderID uint, from []string, status string) error {
+	var productIDs []uint
+	if err := tx.Model(&models.OrderAddOn{}).Where("order_id = ? AND status IN ?", orderID, from).
+		Distinct().Pluck("product_id", &productIDs).Error; err != nil {
+		return err
+	}
+	if len(productIDs) == 0 {
+		return nil
+	}
+	if err := tx.Model(&models.OrderAddOn{}).Where("order_id = ? AND status IN ?", orderID, from).
+		Update("status", status).Error; err != nil {
+		return err
+	}
+	for _, id := range productIDs {
+		if err := SyncProductStock(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfirmAddOns marks the order's reserved add-ons as paid.
+func ConfirmAddOns(tx *gorm.DB, orderID uint) error {
+	return setOrderAddOnStatus(tx, orderID, []string{"reserved"}, "paid")
+}
+
+// ReleaseAddOns gives the stock of a cancelled, expired or refunded order's add-ons back.
+func ReleaseAddOns(tx *gorm.DB, orderID uint) error {
+	return setOrderAddOnStatus(tx, orderID, []string{"reserved", "paid"}, "released")
+}
+
+// ReclaimAddOns reserves the released add-ons of an order that is being revived (a late
+// payment for an expired order) again. It fails with ErrInsufficientStock when the stock
+// is gone.
+func ReclaimAddOns(tx *gorm.DB, orderID uint) error {
+	var lines []models.OrderAddOn
+	if err := tx.Where("order_id = ? AND status = ?", orderID, "released").Order("product_id").Find(&lines).Error; err != nil {
+		return err
+	}
+	for _, line := range lines {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, line.ProductID).Error; err != nil {
+			return err
+		}
+		if product.Stock > 0 {
+			taken, err := takenStock(tx, product.ID, nil)
+			if err != nil {
+				return err
+			}
+			if product.Stock-taken < line.Quantity {
+				return fmt.Errorf("%w: '%s'", ErrInsufficientStock, line.Name)
+			}
+		}
+		if line.VariantID != nil {
+			var variant models.ProductVariant
+			if err := tx.First(&variant, *line.VariantID).Error; err == nil && variant.Stock > 0 {
+				taken, err := takenStock(tx, product.ID, line.VariantID)
+				if err != nil {
+					return err
+				}
+				if variant.Stock-taken < line.Quantity {
+					return fmt.Errorf("%w: '%s'", ErrInsufficientStock, line.Name)
+				}
+			}
+		}
+		if err := tx.Model(&models.OrderAddOn{}).Where("id = ?", line.ID).Update("status", "reserved").Error; err != nil {
+			return err
+		}
+		if err := SyncProductStock(tx, product.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveProductVariants replaces the product's variants with the given list: variants with
+// an ID are updated, new ones created and missing ones deleted. A variant that was
+// ordered can't be deleted (ErrVariantInUse).
+func SaveProductVariants(tx *gorm.DB, productID uint, variants []models.ProductVariant) error {
+	for _, variant := range variants {
+		switch {
+		case variant.Name == "":
+			return fmt.Errorf("%w: every variant needs a name", ErrInvalidVariant)
+		case variant.Stock < 0 || (variant.Price != nil && *variant.Price < 0):
+			return fmt.Errorf("%w: %q needs a price and stock of 0 or more", ErrInvalidVariant, variant.Name)
+		}
+	}
+
+	var existing []models.ProductVariant
+	if err := tx.Where("product_id = ?", productID).Find(&existing).Error; err != nil {
+		return err
+	}
+	kept := make(map[uint]bool)
+	for _, variant := range variants {
+		if variant.ID != 0 {
+			kept[variant.ID] = true
+		}
+	}
+	for _, variant := range existing {
+		if kept[variant.ID] {
+			continue
+		}
+		var used int64
+		if err := tx.Model(&models.OrderAddOn{}).Where("variant_id = ?", variant.ID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return fmt.Errorf("%w: %q", ErrVariantInUse, variant.Name)
+		}
+		if err := tx.Delete(&variant).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, variant := range variants {
+		variant.ProductID = productID
+		if variant.ID == 0 {
+			if err := tx.Create(&variant).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		result := tx.Model(&models.ProductVariant{}).Where("id = ? AND product_id = ?", variant.ID, productID).
+			Select("Name", "Price", "Stock", "Position").
+			Updates(variant)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: variant %d is not part of this product", ErrInvalidVariant, variant.ID)
+		}
+	}
+	return SyncProductStock(tx, productID)
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"testing"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReserveAddOns_StockAndVariants(t *testing.T) {
+	db, _ := setupQuotaDB(t)
+	shirt := models.Product{EventID: 1, Name: "Kaos", Price: 150000, Stock: 3, MaxPerOrder: 2, IsActive: true}
+	db.Create(&shirt)
+	xl := 175000.0
+	small := models.ProductVariant{ProductID: shirt.ID, Name: "S", Stock: 1}
+	large := models.ProductVariant{ProductID: shirt.ID, Name: "XL", Price: &xl}
+	db.Create(&small)
+	db.Create(&large)
+	parking := models.Product{EventID: 2, Name: "Parkir", Price: 20000, IsActive: true}
+	db.Create(&parking)
+	events := map[uint]bool{1: true}
+
+	// Products of other events, missing variants and quantities above the limit are refused
+	_, err := ReserveAddOns(db, []AddOnLine{{ProductID: parking.ID, Quantity: 1}}, events)
+	assert.ErrorIs(t, err, ErrInvalidAddOn)
+	_, err = ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, Quantity: 1}}, events)
+	assert.ErrorIs(t, err, ErrInvalidAddOn)
+	_, err = ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, VariantID: &small.ID, Quantity: 1}, {ProductID: shirt.ID, VariantID: &large.ID, Quantity: 2}}, events)
+	assert.ErrorIs(t, err, ErrInvalidAddOn)
+
+	lines, err := ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, VariantID: &small.ID, Quantity: 1}, {ProductID: shirt.ID, VariantID: &large.ID, Quantity: 1}}, events)
+	assert.NoError(t, err)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "Kaos (S)", lines[0].Name)
+		assert.Equal(t, 150000.0, lines[0].UnitPrice)
+		assert.Equal(t, "Kaos (XL)", lines[1].Name)
+		assert.Equal(t, 175000.0, lines[1].UnitPrice)
+	}
+	for _, line := range lines {
+		db.Model(&models.OrderAddOn{}).Where("id = ?", line.ID).Update("order_id", 9)
+	}
+	db.First(&shirt, shirt.ID)
+	assert.Equal(t, 2, shirt.Sold)
+
+	// Variant stock, then product stock
+	_, err = ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, VariantID: &small.ID, Quantity: 1}}, events)
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	_, err = ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, VariantID: &large.ID, Quantity: 2}}, events)
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+
+	// Released stock can be bought again, and reclaimed only while it lasts
+	assert.NoError(t, ReleaseAddOns(db, 9))
+	db.First(&shirt, shirt.ID)
+	assert.Equal(t, 0, shirt.Sold)
+	_, err = ReserveAddOns(db, []AddOnLine{{ProductID: shirt.ID, VariantID: &small.ID, Quantity: 1}}, events)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, ReclaimAddOns(db, 9), ErrInsufficientStock)
+}
@@ -0,0 +1,399 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Site settings tuning how bank transfers are matched to orders.
+const (
+	SettingBankMatchLateHours         = "bank_match_late_payment_hours"         // Revive orders expired at most this long before the transfer; 0 = off
+	SettingBankMatchMissingUniqueCode = "bank_match_missing_unique_code"        // "false" = don't accept transfers without the unique code
+	SettingBankMatchSuffixMaxDiff     = "bank_match_suffix_max_difference"      // Rp; match by unique code suffix within this difference; 0 = off
+	SettingBankMatchAcceptOverpayment = "bank_match_accept_overpayment"         // "false" = overpaid transfers without the unique code are left for review
+	SettingBankMatchMaxShortfall      = "bank_match_missing_code_max_shortfall" // Rp; transfers without the unique code may be this much short
+)
+
+// BankMatchRules are the tolerance rules applied after an exact amount match fails.
+type BankMatchRules struct {
+	LatePaymentWindow  time.Duration
+	MissingUniqueCode  bool
+	SuffixMaxDiff      float64
+	AcceptOverpayment  bool
+	MaxShortfall       float64       // Accepted shortfall of a transfer without the unique code
+	OrderLookbackAfter time.Duration // Orders created up to this long after the transfer still count (clock skew)
+	OrderLookback      time.Duration // Orders created up to this long before the transfer are considered
+}
+
+// DefaultBankMatchRules are used for settings that are missing or invalid.
+var DefaultBankMatchRules = BankMatchRules{
+	LatePaymentWindow:  24 * time.Hour,
+	MissingUniqueCode:  true,
+	SuffixMaxDiff:      50000,
+	AcceptOverpayment:  true,
+	MaxShortfall:       999,
+	OrderLookbackAfter: 10 * time.Minute,
+	OrderLookback:      24 * time.Hour,
+}
+
+// LoadBankMatchRules reads the rules from site settings.
+func LoadBankMatchRules(db *gorm.DB) BankMatchRules {
+	rules := DefaultBankMatchRules
+
+	var settings []models.SiteSetting
+	db.Where("key IN ?", []string{SettingBankMatchLateHours, SettingBankMatchMissingUniqueCode, SettingBankMatchSuffixMaxDiff, SettingBankMatchAcceptOverpayment, SettingBankMatchMaxShortfall}).Find(&settings)
+	for _, s := range settings {
+		switch s.Key {
+		case SettingBankMatchLateHours:
+			if v, err := strconv.ParseFloat(s.Value, 64); err == nil && v >= 0 {
+				rules.LatePaymentWindow = time.Duration(v * float64(time.Hour))
+			}
+		case SettingBankMatchMissingUniqueCode:
+			if v, err := strconv.ParseBool(s.Value); err == nil {
+				rules.MissingUniqueCode = v
+			}
+		case SettingBankMatchSuffixMaxDiff:
+			if v, err := strconv.ParseFloat(s.Value, 64); err == nil && v >= 0 {
+				rules.SuffixMaxDiff = v
+			}
+		case SettingBankMatchAcceptOverpayment:
+			if v, err := strconv.ParseBool(s.Value); err == nil {
+				rules.AcceptOverpayment = v
+			}
+		case SettingBankMatchMaxShortfall:
+			if v, err := strconv.ParseFloat(s.Value, 64); err == nil && v >= 0 {
+				rules.MaxShortfall = v
+			}
+		}
+	}
+	return rules
+}
+
+// How a transfer was matched to an order.
+const (
+	BankMatchExact             = "exact"
+	BankMatchMissingUniqueCode = "missing_unique_code" // Paid the base amount without the unique code
+	BankMatchOverpaid          = "overpaid"            // Unique code suffix matches, more than the total
+	BankMatchUnderpaid         = "underpaid"           // Unique code suffix matches, less than the total
+)
+
+// BankMatch is the order a transfer belongs to and how it was found.
+type BankMatch struct {
+	Order      models.Order
+	Kind       string
+	Late       bool    // The order had expired and must be revived
+	Difference float64 // Transferred amount minus the order total
+}
+
+// Pays reports whether the match settles the order. Suffix matches never do: the last
+// three digits alone can't tell the order's payment from an unrelated transfer (or a
+// second payment of a paid order), so they are linked to the order as a suggestion for
+// the reconciliation console. A transfer without the unique code may be short by at most
+// MaxShortfall.
+func (m BankMatch) Pays(rules BankMatchRules) bool {
+	switch m.Kind {
+	case BankMatchOverpaid, BankMatchUnderpaid:
+		return false
+	case BankMatchMissingUniqueCode:
+		if m.Difference < 0 {
+			return -m.Difference <= rules.MaxShortfall
+		}
+		return rules.AcceptOverpayment
+	}
+	return true
+}
+
+// Describe is the order timeline note for the match.
+func (m BankMatch) Describe() string {
+	var note string
+	switch m.Kind {
+	case BankMatchExact:
+		note = "Exact amount"
+	case BankMatchMissingUniqueCode:
+		if m.Difference < 0 {
+			note = fmt.Sprintf("Transfer without unique code %d (short by Rp %s)", m.Order.UniqueCode, FormatPrice(-m.Difference))
+		} else {
+			note = fmt.Sprintf("Transfer without unique code %d (over by Rp %s)", m.Order.UniqueCode, FormatPrice(m.Difference))
+		}
+	case BankMatchOverpaid:
+		note = fmt.Sprintf("Overpaid by Rp %s (matched by unique code suffix)", FormatPrice(m.Difference))
+	case BankMatchUnderpaid:
+		note = fmt.Sprintf("Partial payment: short by Rp %s (matched by unique code suffix)", FormatPrice(-m.Difference))
+	}
+	if m.Late {
+		note += "; late payment for an expired order"
+	}
+	return note
+}
+
+// FindBankMatch looks for the order a transfer of amount at time `at` pays, trying in order:
+//  1. a pending order with exactly that total (the newest one, as before)
+//  2. an order that expired within LatePaymentWindow with exactly that total
+//  3. a single order whose total minus its unique code is the amount (code forgotten)
+//  4. a single order whose total ends in the same three digits (the unique code suffix),
+//     within SuffixMaxDiff; only suggested, see Pays
+//
+// Rules 3 and 4 give up when several orders qualify; those transfers are reconciled by hand.
+// It returns nil when nothing matches.
+func FindBankMatch(tx *gorm.DB, rules BankMatchRules, amount float64, at time.Time) (*BankMatch, error) {
+	candidates := func() *gorm.DB {
+		q := tx.Model(&models.Order{}).Where("created_at BETWEEN ? AND ?", at.Add(-rules.OrderLookback), at.Add(rules.OrderLookbackAfter))
+		if rules.LatePaymentWindow > 0 {
+			return q.Where("(status = ? OR (status = ? AND expires_at >= ?))", OrderStatusPending, OrderStatusExpired, at.Add(-rules.LatePaymentWindow))
+		}
+		return q.Where("status = ?", OrderStatusPending)
+	}
+
+	var orders []models.Order
+	if err := candidates().Where("total_amount = ?", amount).
+		Order("CASE WHEN status = 'pending' THEN 0 ELSE 1 END, created_at DESC").Limit(1).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	if len(orders) == 1 {
+		return newBankMatch(orders[0], BankMatchExact, amount), nil
+	}
+
+	if rules.MissingUniqueCode {
+		if err := candidates().Where("unique_code <> 0 AND total_amount - unique_code = ?", amount).Limit(2).Find(&orders).Error; err != nil {
+			return nil, err
+		}
+		if len(orders) == 1 {
+			return newBankMatch(orders[0], BankMatchMissingUniqueCode, amount), nil
+		}
+	}
+
+	// The unique code makes the last three digits of a total unique among recent orders
+	if suffix := int(math.Mod(amount, 1000)); rules.SuffixMaxDiff > 0 && suffix != 0 {
+		if err := candidates().Where("unique_code <> 0 AND CAST(total_amount AS BIGINT) % 1000 = ? AND total_amount BETWEEN ? AND ?", suffix, amount-rules.SuffixMaxDiff, amount+rules.SuffixMaxDiff).
+			Limit(2).Find(&orders).Error; err != nil {
+			return nil, err
+		}
+		if len(orders) == 1 {
+			kind := BankMatchOverpaid
+			if amount < orders[0].TotalAmount {
+				kind = BankMatchUnderpaid
+			}
+			return newBankMatch(orders[0], kind, amount), nil
+		}
+	}
+	return nil, nil
+}
+
+func newBankMatch(order models.Order, kind string, amount float64) *BankMatch {
+	return &BankMatch{
+		Order:      order,
+		Kind:       kind,
+		Late:       order.Status == OrderStatusExpired,
+		Difference: amount - order.TotalAmount,
+	}
+}
+
+// BankEmail is a notification email fetched from the payment inbox.
+type BankEmail struct {
+	MessageID string
+	From      string
+	Subject   string
+	Body      string
+	Date      time.Time
+}
+
+// ProcessBankEmail parses a notification with the given parser and matches the transfer
+// like RecordBankTransfer. It returns the parser's ErrBankEmailNotCredit and
+// ErrBankEmailNotParsed unchanged, and a nil transaction for emails already recorded.
+func ProcessBankEmail(db *gorm.DB, parser BankNotificationParser, email BankEmail, source string) (*models.BankTransaction, error) {
+	mutation, err := parser.Parse(email.Subject, email.Body)
+	if err != nil {
+		return nil, err
+	}
+	return RecordBankTransfer(db, parser, BankTransfer{
+		ReferenceID: email.MessageID,
+		Amount:      mutation.Amount,
+		Sender:      mutation.Sender,
+		Date:        email.Date,
+		RawData:     email.Body,
+		Via:         parser.BankName() + " Email",
+	}, source)
+}
+
+// BankTransfer is an incoming transfer read from a notification email or a statement.
+type BankTransfer struct {
+	ReferenceID string // Message ID or statement row hash; a transfer is recorded once
+	Amount      float64
+	Sender      string
+	Date        time.Time
+	RawData     string
+	Via         string // Where it was read, for the order timeline ("BCA Email")
+}
+
+// RecordBankTransfer records a transfer into the parser's bank and, if it matches an
+// order (see FindBankMatch), marks that order paid. Transfers that match no order,
+// an order paid with another bank's method, an underpayment or a late payment whose
+// seats are gone are still recorded (with a status for the reconciliation console) so
+// the transfer is not processed twice. It returns a nil transaction for transfers
+// already recorded.
+func RecordBankTransfer(db *gorm.DB, parser BankNotificationParser, transfer BankTransfer, source string) (*models.BankTransaction, error) {
+	amount := transfer.Amount
+
+	// Transactional check for message deduplication
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var existingTx models.BankTransaction
+	if tx.Where("reference_id = ?", transfer.ReferenceID).First(&existingTx).Error == nil {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	record := models.BankTransaction{
+		ReferenceID:     transfer.ReferenceID,
+		Amount:          amount,
+		Sender:          transfer.Sender,
+		BankName:        parser.BankName(),
+		Status:          "matched",
+		TransactionDate: transfer.Date,
+		RawData:         transfer.RawData,
+		CreatedAt:       time.Now(),
+	}
+
+	// Search for matching order: exact total first, then the tolerance rules (late
+	// payment, missing unique code, wrong base amount). Only orders created around the
+	// transfer count, so today's transfer can't match an order of the same amount from last year.
+	rules := LoadBankMatchRules(tx)
+	match, err := FindBankMatch(tx, rules, amount, transfer.Date)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if match == nil {
+		// Log matching failed (Maybe already Paid or not our order)
+		log.Printf("[%s-PaymentJob] No pending order matched %s Amount: %.2f within time window\n", source, parser.BankName(), amount)
+		// We STILL record this message ID to prevent re-processing every minute
+		record.Status = "unmatched"
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return &record, tx.Commit().Error
+	}
+	order := match.Order
+
+	// Payment Method Validation
+	record.OrderID = &order.ID
+	if !parser.AcceptsPaymentMethod(order.PaymentMethod) {
+		log.Printf("[%s-PaymentJob] Ignored order %s. Payment Method mismatch: %s (%s transfer)\n", source, order.OrderNumber, order.PaymentMethod, parser.BankName())
+		// Still record to log to prevent check every time
+		record.Status = "method_mismatch"
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return &record, tx.Commit().Error
+	}
+
+	// Suffix matches (and overpayments, if not accepted) wait for an admin
+	if !match.Pays(rules) {
+		log.Printf("[%s-PaymentJob] Order %s: %s\n", source, order.OrderNumber, match.Describe())
+		record.Status = "partial"
+		if match.Difference > 0 {
+			record.Status = "overpaid"
+		}
+		note := fmt.Sprintf("%s transfer of Rp %s from %s (%s): %s. Left for manual review",
+			parser.BankName(), FormatPrice(amount), transfer.Sender, transfer.ReferenceID, match.Describe())
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := AddOrderNote(tx, order, note); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return &record, tx.Commit().Error
+	}
+
+	// Mark as Paid (no-op if another path paid it since the lookup). Reviving an expired
+	// order, or one whose seat holds lapsed, re-holds its seats; if they are gone the
+	// transfer is left for an admin.
+	tx.SavePoint("bank_match")
+	transition, err := OrderStates.TransitionTx(tx, &order, OrderStatusPaid, OrderTransitionOptions{
+		Notes: fmt.Sprintf("Verified %s via %s (%s): %s. Original Status: %s", source, transfer.Via, transfer.ReferenceID, match.Describe(), order.Status),
+	})
+	if err != nil && IsQuotaError(err) {
+		tx.RollbackTo("bank_match")
+		log.Printf("[%s-PaymentJob] Payment for order %s but seats are gone\n", source, order.OrderNumber)
+		record.Status = "quota_unavailable"
+		note := fmt.Sprintf("%s transfer of Rp %s from %s (%s) received, but the seats are no longer available. Left for manual review",
+			parser.BankName(), FormatPrice(amount), transfer.Sender, transfer.ReferenceID)
+		if match.Late {
+			note = "Late " + note
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := AddOrderNote(tx, order, note); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return &record, tx.Commit().Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Record Transaction
+	if err := tx.Create(&record).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	log.Printf("[%s-PaymentJob] Order %s marked as PAID successfully\n", source, order.OrderNumber)
+
+	// Send Ticket (Outside transaction)
+	OrderStates.Notify(db, transition, OrderTransitionOptions{})
+	return &record, nil
+}
+
+// AddOrderNote writes a timeline entry without changing the order status.
+func AddOrderNote(tx *gorm.DB, order models.Order, notes string) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:   order.ID,
+		Status:    order.Status,
+		Notes:     notes,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// AddOrderNoteOnce is AddOrderNote for notes that retried callbacks and polls would
+// otherwise repeat.
+func AddOrderNoteOnce(tx *gorm.DB, order models.Order, notes string) error {
+	var count int64
+	if err := tx.Model(&models.OrderStatusHistory{}).Where("order_id = ? AND notes = ?", order.ID, notes).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return AddOrderNote(tx, order, notes)
+}
+
+// IsQuotaError reports whether a payment couldn't re-hold the order's seats or add-on stock
+// (a revival, or a pending order whose holds lapsed) because they are gone.
+func IsQuotaError(err error) bool {
+	return errors.Is(err, ErrInsufficientQuota) || errors.Is(err, ErrInsufficientFlashQuota) || errors.Is(err, ErrInsufficientStock)
+}
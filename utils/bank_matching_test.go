@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindBankMatch_ToleranceRules(t *testing.T) {
+	db := newTestDB(t, append(checkoutTables, &models.SiteSetting{})...)
+	now := time.Now()
+	expiredAt := now.Add(-2 * time.Hour)
+
+	orders := []models.Order{
+		{OrderNumber: "EXACT", Status: "pending", TotalAmount: 100123, UniqueCode: 123, CreatedAt: now.Add(-time.Hour)},
+		{OrderNumber: "LATE", Status: "expired", TotalAmount: 200456, UniqueCode: 456, CreatedAt: now.Add(-3 * time.Hour), ExpiresAt: &expiredAt},
+		{OrderNumber: "SUFFIX", Status: "pending", TotalAmount: 305789, UniqueCode: 789, CreatedAt: now.Add(-time.Hour)},
+		{OrderNumber: "TWIN-1", Status: "pending", TotalAmount: 400111, UniqueCode: 111, CreatedAt: now.Add(-time.Hour)},
+		{OrderNumber: "TWIN-2", Status: "pending", TotalAmount: 400222, UniqueCode: 222, CreatedAt: now.Add(-time.Hour)},
+		{OrderNumber: "OLD", Status: "pending", TotalAmount: 500333, UniqueCode: 333, CreatedAt: now.Add(-48 * time.Hour)},
+	}
+	for i := range orders {
+		db.Create(&orders[i])
+	}
+
+	cases := []struct {
+		name      string
+		amount    float64
+		wantOrder string // "" = no match
+		wantKind  string
+		wantLate  bool
+	}{
+		{"exact total", 100123, "EXACT", BankMatchExact, false},
+		{"late payment of an expired order", 200456, "LATE", BankMatchExact, true},
+		{"unique code forgotten", 100000, "EXACT", BankMatchMissingUniqueCode, false},
+		{"right suffix, base too high", 310789, "SUFFIX", BankMatchOverpaid, false},
+		{"right suffix, base too low", 300789, "SUFFIX", BankMatchUnderpaid, false},
+		{"suffix beyond the max difference", 405789, "", "", false},
+		{"ambiguous base amount", 400000, "", "", false},
+		{"order outside the time window", 500333, "", "", false},
+		{"unknown amount", 77000, "", "", false},
+	}
+	rules := LoadBankMatchRules(db)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			match, err := FindBankMatch(db, rules, tc.amount, now)
+			assert.NoError(t, err)
+			if tc.wantOrder == "" {
+				assert.Nil(t, match)
+				return
+			}
+			if assert.NotNil(t, match) {
+				assert.Equal(t, tc.wantOrder, match.Order.OrderNumber)
+				assert.Equal(t, tc.wantKind, match.Kind)
+				assert.Equal(t, tc.wantLate, match.Late)
+				assert.Equal(t, tc.amount-match.Order.TotalAmount, match.Difference)
+			}
+		})
+	}
+
+	// Rules come from site settings
+	db.Create(&models.SiteSetting{Key: SettingBankMatchLateHours, Value: "1"})
+	db.Create(&models.SiteSetting{Key: SettingBankMatchMissingUniqueCode, Value: "false"})
+	db.Create(&models.SiteSetting{Key: SettingBankMatchAcceptOverpayment, Value: "false"})
+	rules = LoadBankMatchRules(db)
+	assert.Equal(t, time.Hour, rules.LatePaymentWindow)
+
+	match, _ := FindBankMatch(db, rules, 200456, now)
+	assert.Nil(t, match, "expired 2h ago, outside the 1h late window")
+	match, _ = FindBankMatch(db, rules, 100000, now)
+	assert.Nil(t, match)
+	match, _ = FindBankMatch(db, rules, 310789, now)
+	if assert.NotNil(t, match) {
+		assert.False(t, match.Pays(rules), "overpayments wait for review")
+	}
+}
+
+func TestBankMatch_SuffixMatchesNeverPay(t *testing.T) {
+	rules := DefaultBankMatchRules
+	rules.AcceptOverpayment = true
+
+	over := BankMatch{Kind: BankMatchOverpaid, Order: models.Order{TotalAmount: 305789}, Difference: 5000}
+	assert.False(t, over.Pays(rules), "a transfer sharing the last three digits may belong to someone else")
+	under := BankMatch{Kind: BankMatchUnderpaid, Order: models.Order{TotalAmount: 305789}, Difference: -5000}
+	assert.False(t, under.Pays(rules))
+}
+
+func TestBankMatch_MissingUniqueCodeShortfall(t *testing.T) {
+	rules := DefaultBankMatchRules
+	short := func(code int) BankMatch {
+		return BankMatch{Kind: BankMatchMissingUniqueCode, Order: models.Order{UniqueCode: code}, Difference: -float64(code)}
+	}
+
+	assert.True(t, short(999).Pays(rules))
+	assert.False(t, short(5000).Pays(rules), "overflow codes are too much to waive")
+
+	rules.MaxShortfall = 0
+	assert.False(t, short(123).Pays(rules))
+
+	// A negative code means the base amount is more than the total
+	over := BankMatch{Kind: BankMatchMissingUniqueCode, Order: models.Order{UniqueCode: -250}, Difference: 250}
+	assert.True(t, over.Pays(rules))
+	rules.AcceptOverpayment = false
+	assert.False(t, over.Pays(rules))
+}
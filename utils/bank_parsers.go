@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"errors"
+	"html"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BankMutation is an incoming transfer read from a bank notification email.
+type BankMutation struct {
+	Amount float64
+	Sender string
+}
+
+// BankNotificationParser reads one bank's transfer notification emails.
+type BankNotificationParser interface {
+	// BankName is stored in BankTransaction.BankName.
+	BankName() string
+	// Matches reports whether an email is from this bank, by the domain of its From address.
+	Matches(from string) bool
+	// Parse extracts the transfer. It returns ErrBankEmailNotCredit for outgoing or other
+	// non-credit notifications and ErrBankEmailNotParsed when the format is not understood.
+	Parse(subject, body string) (*BankMutation, error)
+	// AcceptsPaymentMethod reports whether a transfer to this bank can pay an order
+	// placed with the given payment method.
+	AcceptsPaymentMethod(method string) bool
+}
+
+var (
+	ErrBankEmailNotParsed = errors.New("bank email format not recognised")
+	ErrBankEmailNotCredit = errors.New("bank email is not an incoming transfer")
+)
+
+// emailBankParser is a BankNotificationParser driven by sender domains and regexes.
+type emailBankParser struct {
+	bank        string
+	domains     []string         // Sender domains; subdomains of them match too
+	creditWords []string         // Subject or body must contain one; empty = every email is a credit
+	amountRes   []*regexp.Regexp // Tried in order; group 1 is the amount
+	senderRe    *regexp.Regexp   // Group 1 is the sender name
+	methodKey   string           // MANUAL_<key> and BANK_TRANSFER_<key> orders are paid into this bank
+}
+
+func (p *emailBankParser) BankName() string { return p.bank }
+
+// Matches compares the sender's domain exactly: a subject or a look-alike address
+// ("jago.com.example.net") could be sent by anyone who knows the inbox.
+func (p *emailBankParser) Matches(from string) bool {
+	domain := senderDomain(from)
+	for _, d := range p.domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// senderDomain returns the lowercased domain of a From address ("BCA <bca@bca.co.id>"
+// or "bca@bca.co.id"), or "" if there is none.
+func senderDomain(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	at := strings.LastIndex(from, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(from[at+1:])), ".")
+}
+
+func (p *emailBankParser) Parse(subject, body string) (*BankMutation, error) {
+	text := bankEmailText(body)
+
+	if len(p.creditWords) > 0 {
+		haystack := strings.ToLower(subject + "\n" + text)
+		credit := false
+		for _, w := range p.creditWords {
+			if strings.Contains(haystack, w) {
+				credit = true
+				break
+			}
+		}
+		if !credit {
+			return nil, ErrBankEmailNotCredit
+		}
+	}
+
+	var amount float64
+	for _, re := range p.amountRes {
+		if m := re.FindStringSubmatch(text); len(m) >= 2 {
+			if v, err := parseIDRAmount(m[1]); err == nil && v > 0 {
+				amount = v
+				break
+			}
+		}
+	}
+	if amount == 0 {
+		return nil, ErrBankEmailNotParsed
+	}
+
+	sender := "Unknown"
+	if m := p.senderRe.FindStringSubmatch(text); len(m) >= 2 && strings.TrimSpace(m[1]) != "" {
+		sender = strings.TrimSpace(m[1])
+	}
+	return &BankMutation{Amount: amount, Sender: sender}, nil
+}
+
+// AcceptsPaymentMethod only accepts direct transfers: gateway methods such as a Flip BCA
+// virtual account are paid into the gateway's account, not ours.
+func (p *emailBankParser) AcceptsPaymentMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return method == "MANUAL_"+p.methodKey || strings.Contains(method, "BANK_TRANSFER_"+p.methodKey)
+}
+
+var (
+	labeledAmountRe = regexp.MustCompile(`(?i)\b(?:Nominal|Jumlah|Total|Amount)\b[^0-9]{0,30}?(?:Rp\.?|IDR)\s?([0-9][0-9.,]*)`)
+	anyAmountRe     = regexp.MustCompile(`(?i)(?:Rp\.?|IDR)\s?([0-9][0-9.,]*)`)
+)
+
+// BankParsers are tried in order; the first whose Matches accepts an email parses it.
+var BankParsers = []BankNotificationParser{
+	&emailBankParser{
+		bank:      "Bank Jago",
+		domains:   []string{"jago.com"},
+		amountRes: []*regexp.Regexp{labeledAmountRe, anyAmountRe},
+		senderRe:  regexp.MustCompile(`(?im)^(?:Pengirim|Dari)\s*:?\s*([^\n]+)$`),
+		methodKey: "JAGO",
+	},
+	&emailBankParser{
+		bank:        "BCA",
+		domains:     []string{"bca.co.id"},
+		creditWords: []string{"transfer masuk", "kredit", "dana masuk"},
+		amountRes:   []*regexp.Regexp{labeledAmountRe},
+		senderRe:    regexp.MustCompile(`(?im)^(?:Dari|Pengirim|Nama Pengirim)\s*:?\s*([^\n]+)$`),
+		methodKey:   "BCA",
+	},
+	&emailBankParser{
+		bank:        "Mandiri",
+		domains:     []string{"bankmandiri.co.id"},
+		creditWords: []string{"dana masuk", "transfer masuk", "kredit"},
+		amountRes:   []*regexp.Regexp{labeledAmountRe},
+		senderRe:    regexp.MustCompile(`(?im)^(?:Nama Pengirim|Pengirim|Dari)\s*:?\s*([^\n]+)$`),
+		methodKey:   "MANDIRI",
+	},
+	&emailBankParser{
+		bank:        "BRI",
+		domains:     []string{"bri.co.id"},
+		creditWords: []string{"transfer masuk", "dana masuk", "kredit"},
+		amountRes:   []*regexp.Regexp{labeledAmountRe},
+		senderRe:    regexp.MustCompile(`(?im)^(?:Dari|Pengirim|Nama Pengirim)\s*:?\s*([^\n]+)$`),
+		methodKey:   "BRI",
+	},
+}
+
+// FindBankParser returns the parser for an email's From address, or nil if it isn't
+// from a known bank.
+func FindBankParser(from string) BankNotificationParser {
+	for _, p := range BankParsers {
+		if p.Matches(from) {
+			return p
+		}
+	}
+	return nil
+}
+
+// FindBankParserByName returns the parser with the given BankName (case-insensitive).
+func FindBankParserByName(name string) BankNotificationParser {
+	for _, p := range BankParsers {
+		if strings.EqualFold(p.BankName(), name) {
+			return p
+		}
+	}
+	return nil
+}
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</(?:p|div|tr|td|th|li|h[1-6])>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// bankEmailText turns an HTML or plain-text email body into trimmed, non-empty lines,
+// so table cells and "Label : value" rows end up one per line.
+func bankEmailText(body string) string {
+	body = htmlBreakRe.ReplaceAllString(body, "\n")
+	body = htmlTagRe.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(body)
+
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseIDRAmount reads "150.123", "150,123.00" or "150.123,00" as 150123. Two digits
+// after the last separator are cents and dropped.
+func parseIDRAmount(s string) (float64, error) {
+	s = strings.TrimRight(s, ".,")
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && len(s)-i-1 == 2 {
+		s = s[:i]
+	}
+	s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	return strconv.ParseFloat(s, 64)
+}
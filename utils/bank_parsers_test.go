@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBankParsers_SampleEmails(t *testing.T) {
+	cases := []struct {
+		file       string
+		from       string
+		subject    string
+		wantBank   string // "" = no parser recognises the email
+		wantErr    error
+		wantAmount float64
+		wantSender string
+	}{
+		{"jago_incoming.html", "no-reply@jago.com", "Kamu menerima sejumlah uang", "Bank Jago", nil, 150123, "BUDI SANTOSO"},
+		{"jago_legacy.txt", "notifikasi@mail.jago.com", "Kamu menerima sejumlah uang", "Bank Jago", nil, 75042, "SITI AMINAH"},
+		{"jago_no_amount.html", "no-reply@jago.com", "Info transaksi", "Bank Jago", ErrBankEmailNotParsed, 0, ""},
+		{"bca_transfer_in.txt", "BCA <bca@bca.co.id>", "Informasi Transaksi", "BCA", nil, 150123, "BUDI SANTOSO"},
+		{"bca_transfer_out.txt", "bca@bca.co.id", "Informasi Transaksi", "BCA", ErrBankEmailNotCredit, 0, ""},
+		{"mandiri_dana_masuk.html", "noreply.livin@bankmandiri.co.id", "Dana Masuk ke Rekening Anda", "Mandiri", nil, 250456, "SITI RAHAYU"},
+		{"bri_transfer_in.txt", "notifikasi@bri.co.id", "Notifikasi Transaksi BRImo", "BRI", nil, 150789, "ANDI WIJAYA"},
+		{"unknown_promo.html", "promo@tokoonline.example", "Flash Sale 10.10", "", nil, 0, ""},
+		{"jago_incoming.html", "no-reply@jago.com.tokoonline.example", "Kamu menerima sejumlah uang", "", nil, 0, ""},
+		{"jago_incoming.html", "no-reply@notjago.com", "Kamu menerima sejumlah uang", "", nil, 0, ""},
+		{"bca_transfer_in.txt", "\"bca.co.id\" <bca@tokoonline.example>", "Informasi Transaksi", "", nil, 0, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.file, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "bank_emails", tc.file))
+			assert.NoError(t, err)
+
+			parser := FindBankParser(tc.from)
+			if tc.wantBank == "" {
+				assert.Nil(t, parser)
+				return
+			}
+			if !assert.NotNil(t, parser) {
+				return
+			}
+			assert.Equal(t, tc.wantBank, parser.BankName())
+
+			mutation, err := parser.Parse(tc.subject, string(body))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantAmount, mutation.Amount)
+			assert.Equal(t, tc.wantSender, mutation.Sender)
+		})
+	}
+}
+
+func TestBankParsers_PaymentMethodsAndAmounts(t *testing.T) {
+	jago := FindBankParserByName("bank jago")
+	assert.True(t, jago.AcceptsPaymentMethod("MANUAL_JAGO"))
+	assert.True(t, jago.AcceptsPaymentMethod("BANK_TRANSFER_JAGO"))
+	assert.False(t, jago.AcceptsPaymentMethod("MANUAL_BCA"))
+	assert.True(t, FindBankParserByName("BCA").AcceptsPaymentMethod("MANUAL_BCA"))
+	assert.False(t, FindBankParserByName("BCA").AcceptsPaymentMethod("bca_va"), "gateway VA transfers go to the gateway")
+	assert.Nil(t, FindBankParserByName("BNI"))
+
+	for in, want := range map[string]float64{
+		"150.123":      150123,
+		"150,123.00":   150123,
+		"150.123,00":   150123,
+		"1.250.000.":   1250000,
+		"75042":        75042,
+		"2,500,000.00": 2500000,
+	} {
+		got, err := parseIDRAmount(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+}
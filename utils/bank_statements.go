@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// StatementRow is an incoming transfer read from a bank statement (mutasi rekening).
+type StatementRow struct {
+	Line        int // Line of the CSV file or row of the sheet
+	Date        time.Time
+	DateOnly    bool // The statement has no time; Date is the end of that day
+	Description string
+	Sender      string
+	Amount      float64
+}
+
+// BankStatement is the credit rows of a statement file.
+type BankStatement struct {
+	Rows    []StatementRow
+	Skipped int // Debits, balances and other lines that aren't incoming transfers
+}
+
+var (
+	ErrStatementFormat = errors.New("unsupported statement file, upload a .csv, .xlsx or .xls file")
+	ErrStatementHeader = errors.New("no header row with a date and an amount/credit column found")
+)
+
+// Header names, lowercased, of the columns a statement export can have.
+var statementColumns = map[string][]string{
+	"date":        {"tanggal", "tgl", "date", "tanggal transaksi", "tgl transaksi", "transaction date", "posting date", "tanggal mutasi", "waktu transaksi"},
+	"description": {"keterangan", "deskripsi", "description", "remark", "remarks", "uraian", "uraian transaksi", "berita", "detail transaksi"},
+	"sender":      {"nama pengirim", "pengirim", "sender", "sender name", "nama", "counterparty"},
+	"amount":      {"jumlah", "nominal", "amount", "mutasi", "nilai"},
+	"credit":      {"kredit", "credit", "cr", "kredit (idr)", "credit (idr)", "dana masuk"},
+	"debit":       {"debit", "debet", "db", "debit (idr)", "dana keluar"},
+	"type":        {"cr/db", "db/cr", "d/k", "k/d", "tipe", "type", "jenis", "jenis transaksi", "mutasi (d/k)"},
+}
+
+// Layouts tried for the date column, day first as in Indonesian statements.
+var statementDateLayouts = []string{
+	"02/01/2006 15:04:05", "02/01/2006 15:04", "02/01/2006",
+	"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+	"02-01-2006 15:04:05", "02-01-2006 15:04", "02-01-2006",
+	"02 Jan 2006 15:04", "02 Jan 2006", "2 Jan 2006", "02-Jan-2006", "02/01/06",
+}
+
+// ParseBankStatement reads the incoming transfers of a statement exported from internet
+// banking as CSV (comma or semicolon separated) or Excel. The header row is found by its
+// column names; credits come from a credit column, or an amount column with a CR/DB
+// marker, a type column or a minus sign for debits.
+func ParseBankStatement(filename string, r io.Reader) (*BankStatement, error) {
+	var rows [][]string
+	var lines []int // Line (CSV) or row (Excel) number of each row
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.Comma = statementDelimiter(data)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %w", err)
+			}
+			line, _ := reader.FieldPos(0)
+			rows, lines = append(rows, row), append(lines, line)
+		}
+	case ".xlsx", ".xls":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Excel file: %w", err)
+		}
+		defer f.Close()
+		if rows, err = f.GetRows(f.GetSheetName(0)); err != nil {
+			return nil, fmt.Errorf("invalid Excel file: %w", err)
+		}
+		for i := range rows {
+			lines = append(lines, i+1)
+		}
+	default:
+		return nil, ErrStatementFormat
+	}
+	return parseStatementRows(rows, lines)
+}
+
+// statementDelimiter picks ';' for files whose first lines have more semicolons than commas.
+func statementDelimiter(data []byte) rune {
+	head := data
+	if len(head) > 2048 {
+		head = head[:2048]
+	}
+	if bytes.Count(head, []byte(";")) > bytes.Count(head, []byte(",")) {
+		return ';'
+	}
+	return ','
+}
+
+func parseStatementRows(rows [][]string, lines []int) (*BankStatement, error) {
+	header, columns := -1, map[string]int{}
+	for i := 0; i < len(rows) && i < 30 && header < 0; i++ {
+		found := map[string]int{}
+		for j, cell := range rows[i] {
+			name := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(cell, ".", "")))
+			for column, names := range statementColumns {
+				if _, ok := found[column]; ok {
+					continue
+				}
+				for _, n := range names {
+					if name == n {
+						found[column] = j
+					}
+				}
+			}
+		}
+		_, hasDate := found["date"]
+		_, hasAmount := found["amount"]
+		_, hasCredit := found["credit"]
+		if hasDate && (hasAmount || hasCredit) {
+			header, columns = i, found
+		}
+	}
+	if header < 0 {
+		return nil, ErrStatementHeader
+	}
+
+	cell := func(row []string, column string) string {
+		if j, ok := columns[column]; ok && j < len(row) {
+			return strings.TrimSpace(row[j])
+		}
+		return ""
+	}
+
+	statement := &BankStatement{}
+	for i := header + 1; i < len(rows); i++ {
+		row := rows[i]
+		date, dateOnly, ok := parseStatementDate(cell(row, "date"))
+		if !ok {
+			statement.Skipped++ // Blank lines, opening/closing balance, totals
+			continue
+		}
+
+		amount, credit := statementCredit(cell(row, "credit"), cell(row, "debit"), cell(row, "amount"), cell(row, "type"))
+		if !credit || amount <= 0 {
+			statement.Skipped++
+			continue
+		}
+
+		description := cell(row, "description")
+		sender := cell(row, "sender")
+		if sender == "" {
+			sender = description
+		}
+		statement.Rows = append(statement.Rows, StatementRow{
+			Line:        lines[i],
+			Date:        date,
+			DateOnly:    dateOnly,
+			Description: description,
+			Sender:      sender,
+			Amount:      amount,
+		})
+	}
+	return statement, nil
+}
+
+// statementCredit returns the incoming amount of a row and whether it is a credit.
+func statementCredit(creditCell, debitCell, amountCell, typeCell string) (float64, bool) {
+	if creditCell != "" || debitCell != "" {
+		if v, err := parseStatementAmount(creditCell); err == nil && v > 0 {
+			return v, true
+		}
+		if amountCell == "" {
+			return 0, false
+		}
+	}
+
+	upper := strings.ToUpper(amountCell)
+	kind := strings.ToUpper(strings.TrimSpace(typeCell))
+	switch {
+	case strings.HasSuffix(upper, "CR"), kind == "CR", kind == "K", kind == "C", strings.HasPrefix(kind, "KREDIT"), strings.HasPrefix(kind, "CREDIT"):
+		upper = strings.TrimSpace(strings.TrimSuffix(upper, "CR"))
+	case strings.HasSuffix(upper, "DB"), kind == "DB", kind == "D", strings.HasPrefix(kind, "DEBIT"), strings.HasPrefix(kind, "DEBET"):
+		return 0, false
+	case strings.HasPrefix(upper, "-"), strings.HasPrefix(upper, "("):
+		return 0, false
+	}
+	v, err := parseStatementAmount(upper)
+	return v, err == nil
+}
+
+// parseStatementAmount reads "Rp 150.123,00", "150,123.00" or "+150123" as 150123.
+func parseStatementAmount(s string) (float64, error) {
+	s = strings.TrimSpace(strings.NewReplacer("Rp", "", "RP", "", "IDR", "", " ", "", "+", "").Replace(s))
+	if s == "" {
+		return 0, strconv.ErrSyntax
+	}
+	return parseIDRAmount(s)
+}
+
+// statementLocation is the time zone of statement dates (WIB).
+var statementLocation = func() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Jakarta"); err == nil {
+		return loc
+	}
+	return time.Local
+}()
+
+// parseStatementDate reads a statement date (WIB) and returns it in local time like the
+// rest of the timestamps. Date-only values are moved to the end of the day so orders
+// created that day fall before the transfer when matching.
+func parseStatementDate(s string) (time.Time, bool, bool) {
+	t, dateOnly, ok := parseStatementWallClock(s)
+	return t.In(time.Local), dateOnly, ok
+}
+
+func parseStatementWallClock(s string) (time.Time, bool, bool) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "'"))
+	if s == "" {
+		return time.Time{}, false, false
+	}
+	// KlikBCA leaves out the year ("17/10"): the last such date not in the future
+	if t, err := time.ParseInLocation("02/01", s, statementLocation); err == nil {
+		now := time.Now().In(statementLocation)
+		t = time.Date(now.Year(), t.Month(), t.Day(), 0, 0, 0, 0, statementLocation)
+		if t.After(now) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t.Add(24*time.Hour - time.Second), true, true
+	}
+	// Excel cells stored as serial numbers
+	if serial, err := strconv.ParseFloat(s, 64); err == nil && serial > 30000 && serial < 80000 {
+		t, err := excelize.ExcelDateToTime(serial, false)
+		if err != nil {
+			return time.Time{}, false, false
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, statementLocation)
+		if serial == float64(int(serial)) {
+			return t.Add(24*time.Hour - time.Second), true, true
+		}
+		return t, false, true
+	}
+
+	for _, layout := range statementDateLayouts {
+		t, err := time.ParseInLocation(layout, s, statementLocation)
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(layout, "15:04") {
+			return t.Add(24*time.Hour - time.Second), true, true
+		}
+		return t, false, true
+	}
+	return time.Time{}, false, false
+}
+
+// StatementReference is the BankTransaction.ReferenceID of a statement row: a hash of
+// the bank, date, amount and description, plus the occurrence number for identical rows
+// in the same file, so uploading overlapping statements records every transfer once.
+func StatementReference(bank string, row StatementRow, occurrence int) string {
+	key := fmt.Sprintf("%s|%s|%.2f|%s|%d", strings.ToUpper(bank), row.Date.UTC().Format(time.RFC3339),
+		row.Amount, strings.ToUpper(strings.Join(strings.Fields(row.Description), " ")), occurrence)
+	sum := sha256.Sum256([]byte(key))
+	return "stmt:" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func readStatement(t *testing.T, file string) *BankStatement {
+	f, err := os.Open("testdata/bank_statements/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	statement, err := ParseBankStatement(file, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statement
+}
+
+func TestParseBankStatement_KlikBCA(t *testing.T) {
+	statement := readStatement(t, "bca_klikbca.csv")
+
+	if assert.Len(t, statement.Rows, 2) {
+		row := statement.Rows[0]
+		assert.Equal(t, 8, row.Line)
+		assert.Equal(t, 150123.0, row.Amount)
+		assert.Contains(t, row.Sender, "BUDI SANTOSO")
+		assert.True(t, row.DateOnly)
+
+		wib := row.Date.In(statementLocation)
+		assert.Equal(t, time.January, wib.Month())
+		assert.Equal(t, 1, wib.Day())
+		assert.Equal(t, time.Now().In(statementLocation).Year(), wib.Year())
+		assert.Equal(t, 23, wib.Hour(), "date-only rows count as the end of the day")
+
+		assert.Equal(t, 75042.0, statement.Rows[1].Amount)
+	}
+	// Admin fee (DB), pending row and the four summary lines
+	assert.Equal(t, 6, statement.Skipped)
+}
+
+func TestParseBankStatement_SplitCreditDebitColumns(t *testing.T) {
+	statement := readStatement(t, "mandiri_split.csv")
+
+	if assert.Len(t, statement.Rows, 2) {
+		assert.Equal(t, 150789.0, statement.Rows[0].Amount)
+		assert.Equal(t, "ANDI WIJAYA", statement.Rows[0].Sender)
+		assert.False(t, statement.Rows[0].DateOnly)
+		assert.Equal(t, "2026-10-17 10:15:32", statement.Rows[0].Date.In(statementLocation).Format("2006-01-02 15:04:05"))
+
+		assert.Equal(t, 250456.0, statement.Rows[1].Amount)
+		assert.Equal(t, "Setoran tunai", statement.Rows[1].Sender, "description when there is no sender column")
+		assert.True(t, statement.Rows[1].DateOnly)
+	}
+	assert.Equal(t, 1, statement.Skipped)
+}
+
+func TestParseBankStatement_Excel(t *testing.T) {
+	f := excelize.NewFile()
+	rows := [][]interface{}{
+		{"Date", "Description", "Amount", "Type"},
+		{"2026-10-17 09:00:00", "BI-FAST CR BUDI", "150.123", "CR"},
+		{"2026-10-17 09:30:00", "QRIS fee", "1.000", "DB"},
+		{"2026-10-17 10:00:00", "Refund", "-50.000", ""},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		f.SetSheetRow("Sheet1", cell, &row)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := ParseBankStatement("mutasi.xlsx", &buf)
+	assert.NoError(t, err)
+	if assert.Len(t, statement.Rows, 1) {
+		assert.Equal(t, 150123.0, statement.Rows[0].Amount)
+		assert.Equal(t, "BI-FAST CR BUDI", statement.Rows[0].Sender)
+	}
+	assert.Equal(t, 2, statement.Skipped)
+}
+
+func TestParseBankStatement_Rejects(t *testing.T) {
+	_, err := ParseBankStatement("mutasi.pdf", bytes.NewBufferString("%PDF"))
+	assert.ErrorIs(t, err, ErrStatementFormat)
+
+	_, err = ParseBankStatement("mutasi.csv", bytes.NewBufferString("Nama,Alamat\nBudi,Jakarta\n"))
+	assert.ErrorIs(t, err, ErrStatementHeader)
+}
+
+func TestStatementReference(t *testing.T) {
+	row := StatementRow{Date: time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC), Description: "TRSF  CR BUDI", Amount: 150123}
+	same := row
+	same.Description = "trsf cr budi"
+	same.Line = 12
+
+	assert.Equal(t, StatementReference("BCA", row, 0), StatementReference("BCA", same, 0), "line number and spacing don't matter")
+	assert.NotEqual(t, StatementReference("BCA", row, 0), StatementReference("BCA", row, 1), "identical rows in one file are kept apart")
+	assert.NotEqual(t, StatementReference("BCA", row, 0), StatementReference("Mandiri", row, 0))
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidBundle = errors.New("invalid bundle")
+	ErrBundleInUse   = errors.New("bundle has tickets")
+)
+
+// BundleAdmission is a ticket a bundle issues per purchase, with its share of the price.
+type BundleAdmission struct {
+	TicketTypeID uint
+	Price        float64
+}
+
+// BundleAdmissions lists the tickets one purchase of the bundle issues. The bundle's price
+// is split evenly over them (the last one takes the rounding), so refunds and reports
+// per ticket add up to what was paid.
+func BundleAdmissions(bundle models.TicketType, price float64) []BundleAdmission {
+	var admissions []BundleAdmission
+	for _, item := range bundle.BundleItems {
+		for i := 0; i < item.Quantity; i++ {
+			admissions = append(admissions, BundleAdmission{TicketTypeID: item.TicketTypeID})
+		}
+	}
+	if len(admissions) == 0 {
+		return nil
+	}
+	share := math.Floor(price / float64(len(admissions)))
+	for i := range admissions {
+		admissions[i].Price = share
+	}
+	admissions[len(admissions)-1].Price = price - share*float64(len(admissions)-1)
+	return admissions
+}
+
+func bundleSize(items []models.BundleItem) int {
+	size := 0
+	for _, item := range items {
+		size += item.Quantity
+	}
+	return size
+}
+
+// takenBundles counts purchases of a bundle that are paid or held, from the tickets and
+// holds of its admissions. A partly refunded bundle still counts.
+func takenBundles(tx *gorm.DB, bundleID uint, size int, now time.Time) (int, error) {
+	var paid int64
+	if err := tx.Model(&models.Ticket{}).
+		Joins("JOIN orders ON orders.id = tickets.order_id").
+		Where("tickets.bundle_ticket_type_id = ? AND orders.status = ? AND tickets.status IN ?", bundleID, "paid", []string{"active", "used"}).
+		Count(&paid).Error; err != nil {
+		return 0, err
+	}
+	var held int64
+	if err := tx.Model(&models.TicketHold{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("bundle_ticket_type_id = ? AND status = ? AND expires_at > ?", bundleID, "held", now).
+		Scan(&held).Error; err != nil {
+		return 0, err
+	}
+	return int(math.Ceil(float64(paid+held) / float64(size))), nil
+}
+
+// HoldBundle reserves qty purchases of a bundle ticket type: it checks the bundle's own
+// quota under lock, then holds the seats of every admission with HoldTickets. The bundle
+// must have BundleItems loaded.
+func HoldBundle(tx *gorm.DB, orderID uint, bundle models.TicketType, qty int, expiresAt time.Time) ([]*models.TicketHold, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.TicketType{}, bundle.ID).Error; err != nil {
+		return nil, err
+	}
+	taken, err := takenBundles(tx, bundle.ID, bundleSize(bundle.BundleItems), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Quota-taken < qty {
+		return nil, ErrInsufficientQuota
+	}
+
+	var holds []*models.TicketHold
+	for _, item := range bundle.BundleItems {
+		hold, err := HoldTickets(tx, orderID, item.TicketTypeID, nil, nil, qty*item.Quantity, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		hold.BundleTicketTypeID = &bundle.ID
+		if err := tx.Model(hold).Update("bundle_ticket_type_id", bundle.ID).Error; err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, SyncAvailability(tx, bundle.ID)
+}
+
+// syncBundleAvailability is SyncAvailability for a bundle ticket type: purchases left of
+// its own quota. The seats of its admissions are checked when it is bought.
+func syncBundleAvailability(tx *gorm.DB, ticketType models.TicketType, items []models.BundleItem) error {
+	taken, err := takenBundles(tx, ticketType.ID, bundleSize(items), time.Now())
+	if err != nil {
+		return err
+	}
+	available := ticketType.Quota - taken
+	if available < 0 {
+		available = 0
+	}
+	return tx.Model(&models.TicketType{}).Where("id = ?", ticketType.ID).Update("available", available).Error
+}
+
+// SaveBundleItems replaces the admissions of a bundle ticket type; an empty list makes it
+// a regular ticket type again. The admissions must be regular ticket types of the same
+// event, and a bundle that was sold can't change.
+func SaveBundleItems(tx *gorm.DB, bundle models.TicketType, items []models.BundleItem) error {
+	var current []models.BundleItem
+	if err := tx.Where("bundle_id = ?", bundle.ID).Order("id").Find(&current).Error; err != nil {
+		return err
+	}
+	if sameBundleItems(current, items) {
+		return nil
+	}
+	var sold int64
+	if err := tx.Model(&models.Ticket{}).Where("bundle_ticket_type_id = ?", bundle.ID).Count(&sold).Error; err != nil {
+		return err
+	}
+	if sold > 0 {
+		return fmt.Errorf("%w: '%s'", ErrBundleInUse, bundle.Name)
+	}
+
+	seen := make(map[uint]bool)
+	for i := range items {
+		item := &items[i]
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		if item.Quantity < 0 || item.TicketTypeID == bundle.ID || seen[item.TicketTypeID] {
+			return fmt.Errorf("%w: '%s' needs distinct ticket types other than itself", ErrInvalidBundle, bundle.Name)
+		}
+		seen[item.TicketTypeID] = true
+
+		var admission models.TicketType
+		if err := tx.Where("id = ? AND event_id = ?", item.TicketTypeID, bundle.EventID).First(&admission).Error; err != nil {
+			return fmt.Errorf("%w: ticket type %d does not belong to the event", ErrInvalidBundle, item.TicketTypeID)
+		}
+		var nested int64
+		if err := tx.Model(&models.BundleItem{}).Where("bundle_id = ?", admission.ID).Count(&nested).Error; err != nil {
+			return err
+		}
+		if nested > 0 {
+			return fmt.Errorf("%w: '%s' is a bundle itself", ErrInvalidBundle, admission.Name)
+		}
+	}
+	if len(items) > 0 {
+		var parents int64
+		if err := tx.Model(&models.BundleItem{}).Where("ticket_type_id = ?", bundle.ID).Count(&parents).Error; err != nil {
+			return err
+		}
+		if parents > 0 {
+			return fmt.Errorf("%w: '%s' is part of another bundle", ErrInvalidBundle, bundle.Name)
+		}
+	}
+
+	if err := tx.Where("bundle_id = ?", bundle.ID).Delete(&models.BundleItem{}).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		row := models.BundleItem{BundleID: bundle.ID, TicketTypeID: item.TicketTypeID, Quantity: item.Quantity}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+	}
+	return SyncAvailability(tx, bundle.ID)
+}
+
+func sameBundleItems(current, items []models.BundleItem) bool {
+	if len(current) != len(items) {
+		return false
+	}
+	quantities := make(map[uint]int)
+	for _, item := range current {
+		quantities[item.TicketTypeID] = item.Quantity
+	}
+	for _, item := range items {
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		if quantities[item.TicketTypeID] != quantity {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleAdmissions_SplitsPrice(t *testing.T) {
+	bundle := models.TicketType{BundleItems: []models.BundleItem{{TicketTypeID: 1, Quantity: 1}, {TicketTypeID: 2, Quantity: 2}}}
+
+	admissions := BundleAdmissions(bundle, 250000)
+	if assert.Len(t, admissions, 3) {
+		assert.Equal(t, uint(1), admissions[0].TicketTypeID)
+		assert.Equal(t, uint(2), admissions[2].TicketTypeID)
+		assert.Equal(t, 83333.0, admissions[0].Price)
+		assert.Equal(t, 83334.0, admissions[2].Price)
+	}
+	assert.Nil(t, BundleAdmissions(models.TicketType{}, 100000))
+}
+
+func TestHoldBundle_HoldsEveryAdmission(t *testing.T) {
+	db, day1 := setupQuotaDB(t)
+	day2 := models.TicketType{EventID: 1, Name: "Day 2", Price: 100000, Quota: 5, Available: 5}
+	db.Create(&day2)
+	pass := models.TicketType{EventID: 1, Name: "2-Day Pass", Price: 180000, Quota: 3, Available: 3}
+	db.Create(&pass)
+
+	// Only regular ticket types of the same event can be bundled
+	assert.ErrorIs(t, SaveBundleItems(db, pass, []models.BundleItem{{TicketTypeID: pass.ID}}), ErrInvalidBundle)
+	assert.ErrorIs(t, SaveBundleItems(db, pass, []models.BundleItem{{TicketTypeID: 999}}), ErrInvalidBundle)
+	assert.NoError(t, SaveBundleItems(db, pass, []models.BundleItem{{TicketTypeID: day1.ID}, {TicketTypeID: day2.ID}}))
+	assert.ErrorIs(t, SaveBundleItems(db, day1, []models.BundleItem{{TicketTypeID: day2.ID}}), ErrInvalidBundle)
+	db.Preload("BundleItems").First(&pass, pass.ID)
+
+	expires := time.Now().Add(30 * time.Minute)
+	holds, err := HoldBundle(db, 0, pass, 2, expires)
+	assert.NoError(t, err)
+	assert.Len(t, holds, 2)
+	assert.Equal(t, 3, available(db, day1.ID))
+	assert.Equal(t, 3, available(db, day2.ID))
+	assert.Equal(t, 1, available(db, pass.ID))
+
+	// The bundle's own quota caps it before its admissions run out
+	_, err = HoldBundle(db, 0, pass, 2, expires)
+	assert.ErrorIs(t, err, ErrInsufficientQuota)
+
+	// Released holds give the bundle back
+	for _, hold := range holds {
+		db.Model(hold).Update("order_id", 7)
+	}
+	assert.NoError(t, RestoreQuota(db, 7))
+	assert.Equal(t, 5, available(db, day1.ID))
+	assert.Equal(t, 3, available(db, pass.ID))
+}
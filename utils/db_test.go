@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"kartcis-backend/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB opens an in-memory SQLite database with the tables of the given models.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+// checkoutTables are the tables an order touches from checkout to refund.
+var checkoutTables = []interface{}{
+	&models.Event{}, &models.TicketType{}, &models.FlashSale{}, &models.FlashSaleItem{}, &models.TicketPriceTier{},
+	&models.BundleItem{}, &models.Product{}, &models.ProductVariant{}, &models.OrderAddOn{},
+	&models.Order{}, &models.Ticket{}, &models.TicketHold{},
+}
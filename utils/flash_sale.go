@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"strings"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Waiting room defaults when a campaign enables it without batch settings.
+const (
+	DefaultQueueBatchSize  = 100
+	DefaultQueueBatchEvery = 60 // seconds
+)
+
+// FlashSaleCustomer identifies a buyer for the per-customer cap: by account when logged
+// in, otherwise by email.
+type FlashSaleCustomer struct {
+	UserID *uint
+	Email  string
+}
+
+// FlashSaleOffer is the flash-priced part of an order line. The rest of the line is
+// sold at the ticket type's normal price.
+type FlashSaleOffer struct {
+	Sale     models.FlashSale
+	Item     models.FlashSaleItem
+	Quantity int
+}
+
+// PlanFlashSale works out how many of qty tickets of the ticket type get a flash price at
+// now. It takes the cheapest item with quota left among the running campaigns, limited
+// by that quota and the customer's cap; planned holds flash tickets already given to
+// the customer earlier in the same order, per campaign. It returns nil when no flash
+// price applies. The quota is only checked here; HoldTickets enforces it under lock. A
+// capped campaign's row stays locked for the rest of tx, so call it in the order's
+// transaction.
+func PlanFlashSale(tx *gorm.DB, ticketTypeID uint, qty int, customer FlashSaleCustomer, planned map[uint]int, now time.Time) (*FlashSaleOffer, error) {
+	var items []models.FlashSaleItem
+	err := tx.Joins("JOIN flash_sales ON flash_sales.id = flash_sale_items.flash_sale_id").
+		Where("flash_sale_items.ticket_type_id = ? AND flash_sales.is_active = ?", ticketTypeID, true).
+		Where("flash_sales.starts_at <= ? AND flash_sales.ends_at > ?", now, now).
+		Order("flash_sale_items.flash_price, flash_sales.starts_at").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		taken, err := takenSeats(tx, ticketTypeID, "flash_sale_id", item.FlashSaleID, now)
+		if err != nil {
+			return nil, err
+		}
+		flashQty := min(qty, item.Quota-taken)
+		if flashQty <= 0 {
+			continue
+		}
+
+		var sale models.FlashSale
+		if err := tx.First(&sale, item.FlashSaleID).Error; err != nil {
+			return nil, err
+		}
+		if sale.PerCustomerLimit > 0 {
+			// Lock the campaign until the order commits, so two checkouts of the same
+			// customer can't both count the tickets bought before either of them
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sale, sale.ID).Error; err != nil {
+				return nil, err
+			}
+			bought, err := FlashSaleCustomerCount(tx, sale.ID, customer)
+			if err != nil {
+				return nil, err
+			}
+			flashQty = min(flashQty, sale.PerCustomerLimit-bought-planned[sale.ID])
+			if flashQty <= 0 {
+				// The cap covers the whole campaign, so no other of its items apply either
+				continue
+			}
+		}
+
+		return &FlashSaleOffer{Sale: sale, Item: item, Quantity: flashQty}, nil
+	}
+	return nil, nil
+}
+
+// FlashSaleCustomerCount counts the customer's flash-priced tickets of the campaign in
+// pending or paid orders.
+func FlashSaleCustomerCount(tx *gorm.DB, flashSaleID uint, customer FlashSaleCustomer) (int, error) {
+	query := tx.Model(&models.Ticket{}).
+		Joins("JOIN orders ON orders.id = tickets.order_id").
+		Where("tickets.flash_sale_id = ? AND tickets.status IN ?", flashSaleID, []string{"active", "used"}).
+		Where("orders.status IN ?", []string{OrderStatusPending, OrderStatusPaid})
+	email := strings.ToLower(strings.TrimSpace(customer.Email))
+	if customer.UserID != nil {
+		query = query.Where("(orders.user_id = ? OR LOWER(orders.customer_email) = ?)", *customer.UserID, email)
+	} else {
+		query = query.Where("LOWER(orders.customer_email) = ?", email)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// EventWaitingRoom returns the event as its waiting room (package waitingroom) sees it at
+// now. A campaign with a waiting room opens the event's room until it ends, so buyers can
+// queue before the drop; from starts_at it releases queue_batch_size buyers per
+// queue_batch_every seconds. running reports whether the room releases positions and
+// gates checkout now: while the event's own room is on, or a campaign with one runs.
+func EventWaitingRoom(tx *gorm.DB, event models.Event, now time.Time) (room models.Event, running bool, err error) {
+	var sale models.FlashSale
+	err = tx.Where("event_id = ? AND waiting_room = ? AND is_active = ? AND ends_at > ?", event.ID, true, true, now).
+		Order("starts_at").Limit(1).Find(&sale).Error
+	if err != nil || sale.ID == 0 {
+		return event, event.WaitingRoom, err
+	}
+
+	running = !now.Before(sale.StartsAt)
+	if event.WaitingRoom && !running {
+		return event, true, nil
+	}
+	size, every := sale.QueueBatchSize, sale.QueueBatchEvery
+	if size <= 0 {
+		size = DefaultQueueBatchSize
+	}
+	if every <= 0 {
+		every = DefaultQueueBatchEvery
+	}
+	room = event
+	room.WaitingRoom = true
+	room.WaitingRoomRate = max(1, size*60/every)
+	return room, running || event.WaitingRoom, nil
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestPlanFlashSale_QuotaAndCustomerCap(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	now := time.Now()
+	vip := models.TicketType{EventID: 1, Name: "VIP", Price: 300000, Quota: 10, Available: 10}
+	db.Create(&vip)
+	sale := models.FlashSale{EventID: 1, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour), PerCustomerLimit: 3, IsActive: true,
+		Items: []models.FlashSaleItem{{TicketTypeID: tt.ID, FlashPrice: 50000, Quota: 2}, {TicketTypeID: vip.ID, FlashPrice: 150000, Quota: 5}}}
+	db.Create(&sale)
+	// A finished campaign with a cheaper price doesn't apply
+	db.Create(&models.FlashSale{EventID: 1, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour), IsActive: true,
+		Items: []models.FlashSaleItem{{TicketTypeID: tt.ID, FlashPrice: 10000, Quota: 5}}})
+
+	budi := FlashSaleCustomer{Email: "budi@example.com"}
+	planned := map[uint]int{}
+
+	// Quota of 2 left for Regular: the third ticket is at the normal price
+	offer, err := PlanFlashSale(db, tt.ID, 3, budi, planned, now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, offer) {
+		assert.Equal(t, sale.ID, offer.Sale.ID)
+		assert.Equal(t, 2, offer.Quantity)
+		assert.Equal(t, 50000.0, offer.Item.FlashPrice)
+		planned[sale.ID] += offer.Quantity
+	}
+
+	// The cap of 3 is shared by the campaign's ticket types
+	offer, err = PlanFlashSale(db, vip.ID, 4, budi, planned, now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, offer) {
+		assert.Equal(t, 1, offer.Quantity)
+	}
+
+	// Flash tickets of earlier live orders count towards the cap
+	orderID := uint(7)
+	db.Create(&models.Order{ID: orderID, OrderNumber: "ORD-7", CustomerEmail: "Budi@Example.com", Status: "pending"})
+	for i := 0; i < 3; i++ {
+		db.Create(&models.Ticket{OrderID: &orderID, EventID: 1, TicketTypeID: vip.ID, FlashSaleID: &sale.ID, TicketCode: "F" + string(rune('A'+i)), Status: "active"})
+	}
+	offer, err = PlanFlashSale(db, vip.ID, 1, budi, map[uint]int{}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+
+	// Nothing before the campaign starts
+	offer, err = PlanFlashSale(db, tt.ID, 1, FlashSaleCustomer{Email: "siti@example.com"}, map[uint]int{}, now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+}
+
+func TestPlanFlashSale_CapHoldsAcrossCheckouts(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	now := time.Now()
+	sale := models.FlashSale{EventID: 1, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour), PerCustomerLimit: 2, IsActive: true,
+		Items: []models.FlashSaleItem{{TicketTypeID: tt.ID, FlashPrice: 50000, Quota: 5}}}
+	db.Create(&sale)
+	budi := FlashSaleCustomer{Email: "budi@example.com"}
+
+	// Record which tables are read under a row lock, in order
+	var reads []string
+	db.Callback().Query().Before("gorm:query").Register("test:record_locks", func(tx *gorm.DB) {
+		table := tx.Statement.Table
+		if _, locked := tx.Statement.Clauses["FOR"]; locked {
+			table += " FOR UPDATE"
+		}
+		reads = append(reads, table)
+	})
+
+	checkout := func(number string) int {
+		var flashQty int
+		err := db.Transaction(func(tx *gorm.DB) error {
+			offer, err := PlanFlashSale(tx, tt.ID, 2, budi, map[uint]int{}, now)
+			if err != nil || offer == nil {
+				return err
+			}
+			flashQty = offer.Quantity
+			order := models.Order{OrderNumber: number, CustomerEmail: budi.Email, Status: "pending"}
+			if err := tx.Create(&order).Error; err != nil {
+				return err
+			}
+			for i := 0; i < offer.Quantity; i++ {
+				ticket := models.Ticket{OrderID: &order.ID, EventID: 1, TicketTypeID: tt.ID, FlashSaleID: &sale.ID, TicketCode: fmt.Sprintf("%s-%d", number, i), Status: "active"}
+				if err := tx.Create(&ticket).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		return flashQty
+	}
+
+	assert.Equal(t, 2, checkout("ORD-1"))
+	assert.Equal(t, 0, checkout("ORD-2"), "the second checkout sees the first one's tickets")
+
+	// The campaign row is locked before the customer's tickets are counted, so a
+	// concurrent checkout waits for the first to commit instead of reading the same count
+	locked, counted := -1, -1
+	for i, table := range reads {
+		if table == "flash_sales FOR UPDATE" && locked < 0 {
+			locked = i
+		}
+		if table == "tickets" && locked >= 0 && counted < 0 {
+			counted = i
+		}
+	}
+	assert.GreaterOrEqual(t, locked, 0, "the campaign is locked")
+	assert.Greater(t, counted, locked, "the customer's tickets are counted under the lock")
+}
+
+func TestEventWaitingRoom_FlashSaleOpensRoom(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	event := models.Event{ID: 1, WaitingRoomRate: 500}
+	start := time.Now().Add(time.Minute)
+	sale := models.FlashSale{EventID: 1, StartsAt: start, EndsAt: start.Add(time.Hour), IsActive: true,
+		WaitingRoom: true, QueueBatchSize: 2, QueueBatchEvery: 30,
+		Items: []models.FlashSaleItem{{TicketTypeID: tt.ID, FlashPrice: 50000, Quota: 5}}}
+	db.Create(&sale)
+
+	// Buyers can queue before the drop; nobody is released until it opens
+	room, running, err := EventWaitingRoom(db, event, time.Now())
+	assert.NoError(t, err)
+	assert.True(t, room.WaitingRoom)
+	assert.False(t, running)
+
+	room, running, err = EventWaitingRoom(db, event, start.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, running)
+	assert.Equal(t, 4, room.WaitingRoomRate, "2 buyers every 30 seconds")
+
+	room, running, err = EventWaitingRoom(db, event, start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, room.WaitingRoom)
+	assert.False(t, running)
+}
@@ -42,12 +42,6 @@ type FlipBillResponse struct {
 }
 
 func CreateFlipBill(orderID string, amount int, name, email, phone, redirectURL string, expiredAt *time.Time) (*FlipBillResponse, error) {
-	apiKey := os.Getenv("FLIP_API_KEY")
-	baseURL := os.Getenv("FLIP_BASE_URL")
-	if baseURL == "" {
-		baseURL = "https://bigflip.id/api/v2"
-	}
-
 	data := url.Values{}
 	data.Set("title", fmt.Sprintf("Pembayaran Order %s", orderID))
 	data.Set("amount", fmt.Sprintf("%d", amount))
@@ -72,20 +66,86 @@ func CreateFlipBill(orderID string, amount int, name, email, phone, redirectURL
 		data.Set("expired_date", expiredWIB.Format("2006-01-02 15:04"))
 	}
 
-	req, err := http.NewRequest("POST", baseURL+"/pwf/bill", strings.NewReader(data.Encode()))
+	body, err := flipRequest("POST", "/pwf/bill", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var flipResp FlipBillResponse
+	if err := json.Unmarshal(body, &flipResp); err != nil {
+		return nil, err
+	}
+
+	return &flipResp, nil
+}
+
+type FlipPayment struct {
+	ID         string `json:"id"`
+	BillLinkID int64  `json:"bill_link_id"`
+	Amount     int    `json:"amount"`
+	Status     string `json:"status"` // SUCCESSFUL, FAILED, PENDING
+	SenderName string `json:"sender_name"`
+	SenderBank string `json:"sender_bank"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type FlipBillPaymentsResponse struct {
+	LinkID int64         `json:"link_id"`
+	Data   []FlipPayment `json:"data"`
+}
+
+// GetFlipBillPayments lists payments made against a bill link (used for status polling).
+func GetFlipBillPayments(linkID string) (*FlipBillPaymentsResponse, error) {
+	body, err := flipRequest("GET", "/pwf/"+linkID+"/payment", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var flipResp FlipBillPaymentsResponse
+	if err := json.Unmarshal(body, &flipResp); err != nil {
+		return nil, err
+	}
+	return &flipResp, nil
+}
+
+// DeactivateFlipBill sets a bill link to INACTIVE so it can no longer be paid.
+func DeactivateFlipBill(linkID string) error {
+	data := url.Values{}
+	data.Set("status", "INACTIVE")
+	_, err := flipRequest("PUT", "/pwf/"+linkID+"/bill", data)
+	return err
+}
+
+func flipRequest(method, path string, data url.Values) ([]byte, error) {
+	apiKey := os.Getenv("FLIP_API_KEY")
+	baseURL := os.Getenv("FLIP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://bigflip.id/api/v2"
+	}
+
+	var payload io.Reader
+	if data != nil {
+		payload = strings.NewReader(data.Encode())
+	}
+
+	req, err := http.NewRequest(method, baseURL+path, payload)
 	if err != nil {
 		return nil, err
 	}
 
 	req.SetBasicAuth(apiKey, "")
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
 
 	// Debug Logs
-	log.Printf("[Flip-Debug] Request URL: %s/pwf/bill", baseURL)
+	log.Printf("[Flip-Debug] Request: %s %s%s", method, baseURL, path)
 	log.Printf("[Flip-Debug] Authorization: Basic (API Key present)")
-	log.Printf("[Flip-Debug] Payload: %s", data.Encode())
+	if data != nil {
+		log.Printf("[Flip-Debug] Payload: %s", data.Encode())
+	}
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[Flip-Debug] Connection Error: %v", err)
@@ -100,12 +160,5 @@ func CreateFlipBill(orderID string, amount int, name, email, phone, redirectURL
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		return nil, fmt.Errorf("flip api error: %s (status %d)", string(body), resp.StatusCode)
 	}
-
-	var flipResp FlipBillResponse
-	if err := json.Unmarshal(body, &flipResp); err != nil {
-		return nil, err
-	}
-
-	return &flipResp, nil
+	return body, nil
 }
-
@@ -2,12 +2,17 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"kartcis-backend/models"
+	"kartcis-backend/queue"
 	"log"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"os"
 	"strings"
 	"time"
@@ -23,6 +28,13 @@ type TicketEmailData struct {
 	Venue        string
 	City         string
 	Tickets      []TicketItemData
+	AddOns       []AddOnItemData
+}
+
+type AddOnItemData struct {
+	Name     string
+	Quantity int
+	Subtotal string
 }
 
 type TicketItemData struct {
@@ -62,11 +74,71 @@ type CancellationEmailData struct {
 	Reason       string
 }
 
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
 type EventEmailGroupKey struct {
 	Email   string
 	EventID uint
 }
 
+// Emails are sent by the job queue, so a failed SMTP send is retried and survives a restart.
+type ticketEmailPayload struct {
+	Order     models.Order    `json:"order"`
+	Tickets   []models.Ticket `json:"tickets"`
+	Recipient string          `json:"recipient"`
+}
+
+type cancellationEmailPayload struct {
+	Order  models.Order `json:"order"`
+	Reason string       `json:"reason"`
+}
+
+type accountEmailPayload struct {
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	Token      string `json:"token"`
+	EventTitle string `json:"event_title,omitempty"`
+}
+
+type refundEmailPayload struct {
+	Order  models.Order  `json:"order"`
+	Refund models.Refund `json:"refund"`
+}
+
+var (
+	ticketEmailJob = queue.Define("email.tickets", queue.QueueEmail, 5, func(ctx context.Context, p ticketEmailPayload) error {
+		return sendGroupedTicketsEmail(p.Order, p.Tickets, p.Recipient)
+	})
+	paymentEmailJob = queue.Define("email.payment_instruction", queue.QueueEmail, 5, func(ctx context.Context, order models.Order) error {
+		return sendPaymentEmail(order)
+	})
+	cancellationEmailJob = queue.Define("email.order_cancelled", queue.QueueEmail, 5, func(ctx context.Context, p cancellationEmailPayload) error {
+		return sendCancellationEmail(p.Order, p.Reason)
+	})
+	resetPasswordEmailJob = queue.Define("email.reset_password", queue.QueueEmail, 5, func(ctx context.Context, p accountEmailPayload) error {
+		return sendResetPasswordEmail(p.Email, p.Name, p.Token)
+	})
+	verificationEmailJob = queue.Define("email.verification", queue.QueueEmail, 5, func(ctx context.Context, p accountEmailPayload) error {
+		return sendEmailVerificationEmail(p.Email, p.Name, p.Token)
+	})
+	scannerInviteEmailJob = queue.Define("email.scanner_invite", queue.QueueEmail, 5, func(ctx context.Context, p accountEmailPayload) error {
+		return sendScannerInviteEmail(p.Email, p.Name, p.EventTitle, p.Token)
+	})
+	refundEmailJob = queue.Define("email.refund", queue.QueueEmail, 5, func(ctx context.Context, p refundEmailPayload) error {
+		return sendRefundEmail(p.Order, p.Refund)
+	})
+)
+
+// emailOrder drops associations the templates don't use, keeping job payloads small.
+func emailOrder(order models.Order) models.Order {
+	order.Tickets = nil
+	return order
+}
+
 func SendTicketEmail(order models.Order, tickets []models.Ticket) {
 	if len(tickets) == 0 {
 		return
@@ -82,20 +154,102 @@ func SendTicketEmail(order models.Order, tickets []models.Ticket) {
 		groupedTickets[key] = append(groupedTickets[key], t)
 	}
 
+	// Add-ons go to the customer with their tickets of the event, or to every recipient of
+	// the event when the customer has none
+	customerEvents := make(map[uint]bool)
+	for key := range groupedTickets {
+		if key.Email == order.CustomerEmail {
+			customerEvents[key.EventID] = true
+		}
+	}
+
 	for key, group := range groupedTickets {
-		go sendGroupedTicketsEmail(order, group, key.Email)
+		for i := range group {
+			group[i].Order = models.Order{}
+		}
+		groupOrder := emailOrder(order)
+		groupOrder.AddOns = nil
+		if key.Email == order.CustomerEmail || !customerEvents[key.EventID] {
+			for _, addOn := range order.AddOns {
+				if addOn.EventID == key.EventID {
+					groupOrder.AddOns = append(groupOrder.AddOns, addOn)
+				}
+			}
+		}
+		logEmailQueueError(ticketEmailJob.Type, key.Email, ticketEmailJob.Enqueue(ticketEmailPayload{Order: groupOrder, Tickets: group, Recipient: key.Email}))
+	}
+}
+
+// buildMailMessage builds an HTML email, as multipart/mixed when there are attachments.
+func buildMailMessage(from, to, subject, htmlBody string, attachments []MailAttachment) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + to + "\r\n")
+	buf.WriteString("Subject: " + subject + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+		buf.WriteString(htmlBody)
+		return buf.Bytes()
+	}
+
+	mw := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/mixed; boundary=" + mw.Boundary() + "\r\n\r\n")
+
+	htmlPart, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=UTF-8"},
+	})
+	htmlPart.Write([]byte(htmlBody))
+
+	for _, a := range attachments {
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; name=%q", a.ContentType, a.Filename)},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", a.Filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		// RFC 2045: base64 lines must not exceed 76 characters
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	mw.Close()
+	return buf.Bytes()
+}
+
+// logEmailQueueError logs an email that could not be queued; callers don't fail on it.
+func logEmailQueueError(jobType, recipient string, err error) {
+	if err != nil {
+		log.Printf("[Mailer] Failed to queue %s for %s: %v\n", jobType, recipient, err)
 	}
 }
 
 func SendPaymentInstructionEmail(order models.Order) {
-	go sendPaymentEmail(order)
+	logEmailQueueError(paymentEmailJob.Type, order.CustomerEmail, paymentEmailJob.Enqueue(emailOrder(order)))
 }
 
 func SendOrderCancelledEmail(order models.Order, reason string) {
-	go sendCancellationEmail(order, reason)
+	logEmailQueueError(cancellationEmailJob.Type, order.CustomerEmail, cancellationEmailJob.Enqueue(cancellationEmailPayload{Order: emailOrder(order), Reason: reason}))
+}
+
+func SendResetPasswordEmail(email, name, token string) {
+	logEmailQueueError(resetPasswordEmailJob.Type, email, resetPasswordEmailJob.Enqueue(accountEmailPayload{Email: email, Name: name, Token: token}))
+}
+
+func SendEmailVerificationEmail(email, name, token string) {
+	logEmailQueueError(verificationEmailJob.Type, email, verificationEmailJob.Enqueue(accountEmailPayload{Email: email, Name: name, Token: token}))
 }
 
-func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipientEmail string) {
+// SendScannerInviteEmail tells a scanner account it was assigned to an event. New accounts
+// get a link to set their password (a password reset token created by the caller).
+func SendScannerInviteEmail(email, name, eventTitle, resetToken string) {
+	logEmailQueueError(scannerInviteEmailJob.Type, email, scannerInviteEmailJob.Enqueue(accountEmailPayload{Email: email, Name: name, Token: resetToken, EventTitle: eventTitle}))
+}
+
+func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipientEmail string) error {
 	// Load SMTP config
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
@@ -105,7 +259,7 @@ func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipi
 
 	if smtpHost == "" || smtpUser == "" {
 		log.Println("[Mailer] SMTP not configured, skipping email send for Order:", order.OrderNumber)
-		return
+		return nil
 	}
 
 	firstTicket := tickets[0]
@@ -147,7 +301,7 @@ func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipi
 
 		items = append(items, TicketItemData{
 			AttendeeName:         ticket.AttendeeName,
-			TicketTypeName:       ticket.TicketType.Name,
+			TicketTypeName:       ticket.TypeName(),
 			TicketCode:           ticket.TicketCode,
 			CustomFieldResponses: responses,
 		})
@@ -171,17 +325,24 @@ func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipi
 		City:         firstTicket.Event.City,
 		Tickets:      items,
 	}
+	for _, addOn := range order.AddOns {
+		data.AddOns = append(data.AddOns, AddOnItemData{
+			Name:     addOn.Name,
+			Quantity: addOn.Quantity,
+			Subtotal: fmt.Sprintf("Rp %s", FormatPrice(addOn.Subtotal())),
+		})
+	}
 
 	tmpl, err := template.New("ticket").Parse(htmlTemplate)
 	if err != nil {
 		log.Println("[Mailer] Template Parse Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
 		log.Println("[Mailer] Template Execute Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
@@ -192,22 +353,30 @@ func sendGroupedTicketsEmail(order models.Order, tickets []models.Ticket, recipi
 		subject = fmt.Sprintf("[Berhasil] E-Tiket Anda untuk %s - #%s", firstTicket.Event.Title, order.OrderNumber)
 	}
 
-	msg := []byte("From: " + from + "\r\n" +
-		"To: " + recipientEmail + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-Version: 1.0\r\n" +
-		"Content-Type: text/html; charset=UTF-8\r\n\r\n" +
-		body.String())
+	var attachments []MailAttachment
+	if pdf, err := GenerateTicketsPDF(order.OrderNumber, tickets); err != nil {
+		log.Println("[Mailer] Ticket PDF Error:", err)
+	} else {
+		attachments = append(attachments, MailAttachment{
+			Filename:    fmt.Sprintf("e-tiket-%s.pdf", order.OrderNumber),
+			ContentType: "application/pdf",
+			Data:        pdf,
+		})
+	}
+
+	msg := buildMailMessage(from, recipientEmail, subject, body.String(), attachments)
 
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
 	if err != nil {
 		log.Println("[Mailer] SendMail Error:", err)
+		return err
 	} else {
 		log.Printf("[Mailer] Email sent successfully to %s for %d tickets\n", recipientEmail, len(tickets))
 	}
+	return nil
 }
 
-func sendPaymentEmail(order models.Order) {
+func sendPaymentEmail(order models.Order) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -215,7 +384,7 @@ func sendPaymentEmail(order models.Order) {
 	from := os.Getenv("SMTP_FROM")
 
 	if smtpHost == "" || smtpUser == "" {
-		return
+		return nil
 	}
 
 	expiryTime := order.CreatedAt.Add(24 * time.Hour)
@@ -239,13 +408,13 @@ func sendPaymentEmail(order models.Order) {
 	tmpl, err := template.New("payment").Parse(paymentHtmlTemplate)
 	if err != nil {
 		log.Println("[Mailer] Payment Template Parse Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
 		log.Println("[Mailer] Payment Template Execute Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
@@ -262,10 +431,12 @@ func sendPaymentEmail(order models.Order) {
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
 	if err != nil {
 		log.Println("[Mailer] SendMail Payment Error:", err)
+		return err
 	}
+	return nil
 }
 
-func sendCancellationEmail(order models.Order, reason string) {
+func sendCancellationEmail(order models.Order, reason string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -273,7 +444,7 @@ func sendCancellationEmail(order models.Order, reason string) {
 	from := os.Getenv("SMTP_FROM")
 
 	if smtpHost == "" || smtpUser == "" {
-		return
+		return nil
 	}
 
 	data := CancellationEmailData{
@@ -286,13 +457,13 @@ func sendCancellationEmail(order models.Order, reason string) {
 	tmpl, err := template.New("cancellation").Parse(cancellationHtmlTemplate)
 	if err != nil {
 		log.Println("[Mailer] Cancellation Template Parse Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
 		log.Println("[Mailer] Cancellation Template Execute Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
@@ -309,7 +480,9 @@ func sendCancellationEmail(order models.Order, reason string) {
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
 	if err != nil {
 		log.Println("[Mailer] SendMail Cancellation Error:", err)
+		return err
 	}
+	return nil
 }
 
 func FormatPrice(price float64) string {
@@ -454,6 +627,23 @@ const htmlTemplate = `
                     </div>
                 </div>
                 {{end}}
+
+                {{if .AddOns}}
+                <div class="ticket-item">
+                    <div class="ticket-details">
+                        <div class="badge badge-sky">Add-on</div>
+                        <p style="font-size: 14px; color: #64748b;">Tunjukkan email ini untuk mengambil add-on Anda di lokasi event.</p>
+                        <table width="100%" style="font-size: 14px; border-collapse: collapse;">
+                            {{range .AddOns}}
+                            <tr>
+                                <td style="color: #1e293b; padding: 4px 0;">{{.Quantity}}x {{.Name}}</td>
+                                <td style="font-weight: 600; color: #1e293b; text-align: right; padding: 4px 0;">{{.Subtotal}}</td>
+                            </tr>
+                            {{end}}
+                        </table>
+                    </div>
+                </div>
+                {{end}}
             </div>
             <div class="legal-footer">
                 Pesanan ini diproses secara aman oleh <b>Kartcis.ID</b>.<br>
@@ -552,7 +742,7 @@ const paymentHtmlTemplate = `
 </html>
 `
 
-func SendResetPasswordEmail(email, name, token string) {
+func sendResetPasswordEmail(email, name, token string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -562,7 +752,7 @@ func SendResetPasswordEmail(email, name, token string) {
 	if smtpHost == "" || smtpUser == "" {
 		// Log but don't crash
 		log.Println("[Mailer] SMTP not configured, Reset Password link: ", fmt.Sprintf("%s/reset-password?token=%s&email=%s", os.Getenv("FRONTEND_URL"), token, email))
-		return
+		return nil
 	}
 
 	// Construct Link
@@ -580,13 +770,13 @@ func SendResetPasswordEmail(email, name, token string) {
 	tmpl, err := template.New("reset_password").Parse(passwordResetHtmlTemplate)
 	if err != nil {
 		log.Println("[Mailer] Reset Template Parse Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
 		log.Println("[Mailer] Reset Template Execute Error:", err)
-		return
+		return queue.Permanent(err)
 	}
 
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
@@ -603,9 +793,11 @@ func SendResetPasswordEmail(email, name, token string) {
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
 	if err != nil {
 		log.Println("[Mailer] SendMail Reset Error:", err)
+		return err
 	} else {
 		log.Printf("[Mailer] Reset Password email sent to %s\n", email)
 	}
+	return nil
 }
 
 const passwordResetHtmlTemplate = `
@@ -701,7 +893,7 @@ type EmailVerificationData struct {
 	VerifyURL    string
 }
 
-func SendEmailVerificationEmail(email, name, token string) {
+func sendEmailVerificationEmail(email, name, token string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -711,7 +903,7 @@ func SendEmailVerificationEmail(email, name, token string) {
 	if smtpHost == "" || smtpUser == "" {
 		// Log but don't crash
 log.Println("[Mailer] SMTP not configured, Email Verification link: ", fmt.Sprintf("%s/verify-email?token=%s&email=%s", os.Getenv("FRONTEND_URL"), token, email))
-return
+return nil
 }
 
 // Construct Link
@@ -729,13 +921,13 @@ VerifyURL:    verifyLink,
 tmpl, err := template.New("email_verification").Parse(emailVerificationHtmlTemplate)
 if err != nil {
 log.Println("[Mailer] Verification Template Parse Error:", err)
-return
+return queue.Permanent(err)
 }
 
 var body bytes.Buffer
 if err := tmpl.Execute(&body, data); err != nil {
 log.Println("[Mailer] Verification Template Execute Error:", err)
-return
+return queue.Permanent(err)
 }
 
 auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
@@ -752,9 +944,11 @@ body.String())
 err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, msg)
 if err != nil {
 log.Println("[Mailer] SendMail Verification Error:", err)
+return err
 } else {
 log.Printf("[Mailer] Email verification sent to %s\n", email)
 }
+return nil
 }
 
 const emailVerificationHtmlTemplate = `
@@ -791,3 +985,253 @@ const emailVerificationHtmlTemplate = `
 </body>
 </html>
 `
+
+type ScannerInviteEmailData struct {
+	Name       string
+	EventTitle string
+	ActionURL  string
+	NewAccount bool
+}
+
+func sendScannerInviteEmail(email, name, eventTitle, resetToken string) error {
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	smtpUser := os.Getenv("SMTP_USER")
+	smtpPass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "https://kartcis.id"
+	}
+	actionURL := frontendURL + "/login"
+	if resetToken != "" {
+		actionURL = fmt.Sprintf("%s/reset-password?token=%s&email=%s", frontendURL, resetToken, email)
+	}
+
+	if smtpHost == "" || smtpUser == "" {
+		log.Println("[Mailer] SMTP not configured, scanner invite link:", actionURL)
+		return nil
+	}
+
+	data := ScannerInviteEmailData{
+		Name:       name,
+		EventTitle: eventTitle,
+		ActionURL:  actionURL,
+		NewAccount: resetToken != "",
+	}
+
+	tmpl, err := template.New("scanner_invite").Parse(scannerInviteHtmlTemplate)
+	if err != nil {
+		log.Println("[Mailer] Scanner Invite Template Parse Error:", err)
+		return queue.Permanent(err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Println("[Mailer] Scanner Invite Template Execute Error:", err)
+		return queue.Permanent(err)
+	}
+
+	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	subject := fmt.Sprintf("Undangan Petugas Scan Tiket - %s", eventTitle)
+	msg := buildMailMessage(from, email, subject, body.String(), nil)
+
+	if err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{email}, msg); err != nil {
+		log.Println("[Mailer] SendMail Scanner Invite Error:", err)
+		return err
+	}
+	return nil
+}
+
+const scannerInviteHtmlTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Undangan Petugas Scan - Kartcis.ID</title>
+    <style>
+        body { font-family: 'Inter', Arial, sans-serif; background-color: #f3f4f6; margin: 0; padding: 0; }
+        .wrapper { padding: 40px 20px; }
+        .container { max-width: 500px; margin: 0 auto; background-color: #ffffff; border-radius: 12px; box-shadow: 0 4px 6px -1px rgba(0,0,0,0.1); padding: 40px; text-align: center; }
+        h2 { color: #1e293b; margin-top: 0; }
+        p { color: #64748b; font-size: 16px; line-height: 1.5; margin-bottom: 24px; }
+        .btn { display: inline-block; background-color: #b31356; color: #ffffff !important; padding: 14px 24px; border-radius: 6px; text-decoration: none; font-weight: 600; font-size: 16px; border-bottom: 3px solid #ffd54c; }
+        .footer { margin-top: 32px; font-size: 12px; color: #94a3b8; }
+    </style>
+</head>
+<body>
+    <div class="wrapper">
+        <div class="container">
+            <h2>Undangan Petugas Scan</h2>
+            <p>Halo <b>{{.Name}}</b>,<br>Anda ditugaskan sebagai petugas scan tiket untuk event <b>{{.EventTitle}}</b>.</p>
+            {{if .NewAccount}}
+            <a href="{{.ActionURL}}" class="btn">Buat Password</a>
+            <p style="margin-top: 24px; font-size: 14px;">Link ini berlaku selama 7 hari. Setelah membuat password, login untuk mulai memindai tiket.</p>
+            {{else}}
+            <a href="{{.ActionURL}}" class="btn">Login</a>
+            {{end}}
+        </div>
+        <div class="footer">
+            &copy; 2026 Kartcis.ID
+        </div>
+    </div>
+</body>
+</html>
+`
+
+type RefundEmailData struct {
+	CustomerName string
+	OrderNumber  string
+	Status       string
+	Title        string
+	Message      string
+	TicketCount  int
+	Amount       string
+	Reason       string
+	Notes        string
+	TransferRef  string
+}
+
+// SendRefundEmail notifies the customer about a refund status change
+// (requested, approved, rejected, completed).
+func SendRefundEmail(order models.Order, refund models.Refund) {
+	refund.Order = models.Order{}
+	logEmailQueueError(refundEmailJob.Type, order.CustomerEmail, refundEmailJob.Enqueue(refundEmailPayload{Order: emailOrder(order), Refund: refund}))
+}
+
+func sendRefundEmail(order models.Order, refund models.Refund) error {
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	smtpUser := os.Getenv("SMTP_USER")
+	smtpPass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if smtpHost == "" || smtpUser == "" {
+		return nil
+	}
+
+	data := RefundEmailData{
+		CustomerName: order.CustomerName,
+		OrderNumber:  order.OrderNumber,
+		Status:       refund.Status,
+		TicketCount:  len(refund.Items),
+		Amount:       fmt.Sprintf("Rp %s", FormatPrice(refund.Amount)),
+		Reason:       refund.Reason,
+		Notes:        refund.ReviewNotes,
+		TransferRef:  refund.TransferRef,
+	}
+	switch refund.Status {
+	case "requested":
+		data.Title = "Pengajuan Refund Diterima"
+		data.Message = "Pengajuan refund Anda sedang ditinjau oleh penyelenggara."
+	case "approved":
+		data.Title = "Refund Disetujui"
+		data.Message = "Pengajuan refund Anda disetujui. Tiket yang di-refund sudah tidak berlaku dan dana akan segera ditransfer."
+	case "rejected":
+		data.Title = "Refund Ditolak"
+		data.Message = "Mohon maaf, pengajuan refund Anda ditolak. Tiket Anda tetap berlaku."
+	case "completed":
+		data.Title = "Refund Selesai"
+		data.Message = "Dana refund telah ditransfer ke rekening Anda."
+	default:
+		return nil
+	}
+
+	tmpl, err := template.New("refund").Parse(refundHtmlTemplate)
+	if err != nil {
+		log.Println("[Mailer] Refund Template Parse Error:", err)
+		return queue.Permanent(err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Println("[Mailer] Refund Template Execute Error:", err)
+		return queue.Permanent(err)
+	}
+
+	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	subject := fmt.Sprintf("%s - %s", data.Title, order.OrderNumber)
+	msg := buildMailMessage(from, order.CustomerEmail, subject, body.String(), nil)
+
+	if err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{order.CustomerEmail}, msg); err != nil {
+		log.Println("[Mailer] SendMail Refund Error:", err)
+		return err
+	}
+	return nil
+}
+
+const refundHtmlTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}} - Kartcis.ID</title>
+    <style>
+        body { font-family: 'Inter', Arial, sans-serif; background-color: #f3f4f6; margin: 0; padding: 0; }
+        .wrapper { padding: 40px 20px; }
+        .container { max-width: 500px; margin: 0 auto; background-color: #ffffff; border-radius: 12px; box-shadow: 0 4px 6px -1px rgba(0,0,0,0.1); overflow: hidden; }
+        .header { background-color: #1e293b; padding: 24px; text-align: center; color: #ffffff; }
+        .content { padding: 32px 24px; text-align: center; }
+        h2 { color: #1e293b; margin-top: 0; }
+        h2.rejected { color: #e11d48; }
+        p { color: #64748b; font-size: 16px; line-height: 1.5; margin-bottom: 24px; }
+        .order-info { background-color: #f8fafc; border: 1px solid #e2e8f0; border-radius: 8px; padding: 16px; margin-bottom: 24px; text-align: left; }
+        .label { font-size: 13px; color: #64748b; }
+        .value { font-size: 15px; font-weight: 600; color: #1e293b; }
+        .footer { text-align: center; padding: 24px; font-size: 12px; color: #94a3b8; }
+    </style>
+</head>
+<body>
+    <div class="wrapper">
+        <div class="container">
+            <div class="header">
+                <h3 style="margin:0">Kartcis.ID</h3>
+            </div>
+            <div class="content">
+                <h2 class="{{.Status}}">{{.Title}}</h2>
+                <p>Halo <b>{{.CustomerName}}</b>,<br>{{.Message}}</p>
+
+                <div class="order-info">
+                    <div style="margin-bottom: 12px;">
+                        <span class="label">No. Pesanan:</span><br>
+                        <span class="value">#{{.OrderNumber}}</span>
+                    </div>
+                    <div style="margin-bottom: 12px;">
+                        <span class="label">Jumlah Tiket:</span><br>
+                        <span class="value">{{.TicketCount}} tiket</span>
+                    </div>
+                    <div style="margin-bottom: 12px;">
+                        <span class="label">Nominal Refund:</span><br>
+                        <span class="value">{{.Amount}}</span>
+                    </div>
+                    <div>
+                        <span class="label">Alasan Pengajuan:</span><br>
+                        <span class="value">{{.Reason}}</span>
+                    </div>
+                    {{if .Notes}}
+                    <div style="margin-top: 12px;">
+                        <span class="label">Catatan Penyelenggara:</span><br>
+                        <span class="value">{{.Notes}}</span>
+                    </div>
+                    {{end}}
+                    {{if .TransferRef}}
+                    <div style="margin-top: 12px;">
+                        <span class="label">Referensi Transfer:</span><br>
+                        <span class="value">{{.TransferRef}}</span>
+                    </div>
+                    {{end}}
+                </div>
+
+                <p style="font-size: 14px;">Jika ada pertanyaan, silakan hubungi tim support kami dengan menyertakan nomor pesanan.</p>
+            </div>
+            <div class="footer">
+                &copy; 2026 Kartcis.ID. Seluruh hak cipta dilindungi.
+            </div>
+        </div>
+    </div>
+</body>
+</html>
+`
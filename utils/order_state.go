@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Order statuses. Providers report their own strings (SUCCESSFUL, CANCELLED, ...); callers
+// map them to these before asking the state machine for a transition.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusExpired   = "expired"
+	OrderStatusRefunded  = "refunded"
+)
+
+var (
+	ErrOrderTransitionNotAllowed = errors.New("order status transition not allowed")
+	ErrOrderAlreadyInStatus      = errors.New("order is already in the requested status")
+	ErrOrderStatusChanged        = errors.New("order status changed concurrently")
+)
+
+// orderTransitions lists the allowed status changes. Cancelled and expired orders can still
+// become paid when a late payment arrives (their seats are re-held first).
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled, OrderStatusExpired},
+	OrderStatusCancelled: {OrderStatusPaid},
+	OrderStatusExpired:   {OrderStatusPaid},
+	OrderStatusPaid:      {OrderStatusRefunded},
+}
+
+// CanTransitionOrder reports whether an order may move from one status to another.
+func CanTransitionOrder(from, to string) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderTransition describes one applied status change.
+type OrderTransition struct {
+	Order  models.Order // Order after the change
+	From   string
+	To     string
+	Notes  string // Written to the order history
+	Reason string // Customer-facing reason for cancellation emails
+}
+
+// OrderTransitionHook runs inside the transaction of every applied transition, after the
+// built-in side effects. Returning an error rolls the transition back.
+type OrderTransitionHook func(tx *gorm.DB, t OrderTransition) error
+
+// OrderTransitionOptions tunes one transition.
+type OrderTransitionOptions struct {
+	Notes     string // History note; defaults to "Status changed to <to>"
+	Reason    string // Cancellation email reason; defaults per status
+	SkipEmail bool   // Caller sends its own notification (e.g. refunds)
+}
+
+// OrderStateMachine is the only place order statuses change. Each transition:
+//   - updates the status conditionally on the status that was read (no double transitions)
+//   - paid: stamps paid_at, re-holds seats of a revived order (or of a pending order whose
+//     holds lapsed), converts holds
+//   - cancelled/expired/refunded: releases seats, reverses voucher and referral usage
+//   - writes an order history row and runs the registered hooks (webhooks)
+//
+// and, once committed (Notify): sends the ticket or cancellation email and cancels the
+// provider charge of cancelled/expired orders.
+type OrderStateMachine struct {
+	hooks []OrderTransitionHook
+}
+
+// OrderStates is the state machine used by every handler and job.
+var OrderStates = &OrderStateMachine{}
+
+// OnTransition registers a hook that runs for every applied transition.
+func (m *OrderStateMachine) OnTransition(hook OrderTransitionHook) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// Transition applies a status change in its own transaction and sends the notifications.
+// ErrOrderAlreadyInStatus means the change already happened (e.g. a retried callback).
+func (m *OrderStateMachine) Transition(db *gorm.DB, order *models.Order, to string, opts OrderTransitionOptions) (*OrderTransition, error) {
+	var applied *OrderTransition
+	err := db.Transaction(func(tx *gorm.DB) error {
+		t, err := m.TransitionTx(tx, order, to, opts)
+		applied = t
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.Notify(db, applied, opts)
+	return applied, nil
+}
+
+// TransitionTx applies a status change inside the caller's transaction. The caller must
+// call Notify after committing.
+func (m *OrderStateMachine) TransitionTx(tx *gorm.DB, order *models.Order, to string, opts OrderTransitionOptions) (*OrderTransition, error) {
+	from := order.Status
+	if from == to {
+		return nil, ErrOrderAlreadyInStatus
+	}
+	if !CanTransitionOrder(from, to) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrOrderTransitionNotAllowed, from, to)
+	}
+
+	// A revived order gave its seats and add-on stock back when it died. Hold them again
+	// while it still isn't paid, or its own tickets would count against the quota it needs
+	if to == OrderStatusPaid && (from == OrderStatusCancelled || from == OrderStatusExpired) {
+		if err := DeductQuota(tx, order.ID); err != nil {
+			return nil, err
+		}
+		if err := ReclaimAddOns(tx, order.ID); err != nil {
+			return nil, err
+		}
+	}
+	// A pending order's holds may have lapsed before the payment arrived
+	if to == OrderStatusPaid && from == OrderStatusPending {
+		if err := RenewLapsedHolds(tx, order.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": to}
+	if to == OrderStatusPaid {
+		updates["paid_at"] = now
+	}
+	res := tx.Model(&models.Order{}).Where("id = ? AND status = ?", order.ID, from).Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		var current models.Order
+		if err := tx.Select("status").First(&current, order.ID).Error; err == nil {
+			order.Status = current.Status
+			if current.Status == to {
+				return nil, ErrOrderAlreadyInStatus
+			}
+		}
+		return nil, ErrOrderStatusChanged
+	}
+	order.Status = to
+	if to == OrderStatusPaid {
+		order.PaidAt = &now
+	}
+
+	if err := applyOrderSideEffects(tx, *order, from, to); err != nil {
+		return nil, err
+	}
+
+	notes := opts.Notes
+	if notes == "" {
+		notes = "Status changed to " + to
+	}
+	if err := tx.Create(&models.OrderStatusHistory{
+		OrderID:   order.ID,
+		Status:    to,
+		Notes:     notes,
+		CreatedAt: now,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	t := &OrderTransition{Order: *order, From: from, To: to, Notes: notes, Reason: opts.Reason}
+	for _, hook := range m.hooks {
+		if err := hook(tx, *t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func applyOrderSideEffects(tx *gorm.DB, order models.Order, from, to string) error {
+	switch to {
+	case OrderStatusPaid:
+		if from == OrderStatusCancelled || from == OrderStatusExpired {
+			// Voucher usage was given back when the order died (seats are re-held in TransitionTx)
+			if err := adjustVoucherAndReferral(tx, order, 1); err != nil {
+				return err
+			}
+		}
+		if err := ConfirmHolds(tx, order.ID); err != nil {
+			return err
+		}
+		return ConfirmAddOns(tx, order.ID)
+	case OrderStatusCancelled, OrderStatusExpired, OrderStatusRefunded:
+		if err := RestoreQuota(tx, order.ID); err != nil {
+			return err
+		}
+		if err := ReleaseAddOns(tx, order.ID); err != nil {
+			return err
+		}
+		return adjustVoucherAndReferral(tx, order, -1)
+	}
+	return nil
+}
+
+// adjustVoucherAndReferral changes the used counts of the order's voucher and referral code.
+func adjustVoucherAndReferral(tx *gorm.DB, order models.Order, delta int) error {
+	if order.VoucherCode != "" {
+		if err := tx.Model(&models.Voucher{}).Where("code = ?", order.VoucherCode).
+			Update("used_count", gorm.Expr("used_count + ?", delta)).Error; err != nil {
+			return err
+		}
+	}
+	if order.ReferralCode != "" {
+		if err := tx.Model(&models.ReferralCode{}).Where("code = ?", order.ReferralCode).
+			Update("used_count", gorm.Expr("used_count + ?", delta)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Notify sends the customer notifications of a committed transition. A nil transition
+// (nothing applied) is ignored.
+func (m *OrderStateMachine) Notify(db *gorm.DB, t *OrderTransition, opts OrderTransitionOptions) {
+	if t == nil || opts.SkipEmail {
+		return
+	}
+
+	switch t.To {
+	case OrderStatusPaid:
+		var tickets []models.Ticket
+		if err := db.Preload("Event").Preload("TicketType").Preload("BundleTicketType").Where("order_id = ?", t.Order.ID).Find(&tickets).Error; err != nil {
+			log.Printf("[Order] Failed to load tickets of order %s: %v", t.Order.OrderNumber, err)
+			return
+		}
+		order := t.Order
+		if err := db.Where("order_id = ? AND status = ?", order.ID, "paid").Find(&order.AddOns).Error; err != nil {
+			log.Printf("[Order] Failed to load add-ons of order %s: %v", order.OrderNumber, err)
+		}
+		SendTicketEmail(order, tickets)
+		db.Create(&models.OrderStatusHistory{
+			OrderID:   t.Order.ID,
+			Status:    OrderStatusPaid,
+			Notes:     "E-Ticket email sent to customer",
+			CreatedAt: time.Now(),
+		})
+	case OrderStatusCancelled, OrderStatusExpired:
+		cancelPaymentCharge(t.Order)
+		reason := t.Reason
+		if reason == "" {
+			reason = "Pesanan telah dibatalkan"
+			if t.To == OrderStatusExpired {
+				reason = "Waktu pembayaran telah habis (Expired)"
+			}
+		}
+		SendOrderCancelledEmail(t.Order, reason)
+	}
+}
+
+// cancelPaymentCharge invalidates the provider charge of a cancelled order (best effort).
+func cancelPaymentCharge(order models.Order) {
+	provider, ok := GetPaymentProvider(order.PaymentProvider)
+	if !ok {
+		return
+	}
+	if err := provider.Cancel(order); err != nil {
+		log.Printf("[Payment] Failed to cancel %s charge for order %s: %v", provider.Name(), order.OrderNumber, err)
+	}
+}
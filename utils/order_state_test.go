@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// seedStateOrder creates a pending order holding two seats, paid with a voucher.
+func seedStateOrder(t *testing.T) (*gorm.DB, models.TicketType, models.Order) {
+	db, tt := setupQuotaDB(t)
+	db.AutoMigrate(&models.OrderStatusHistory{}, &models.Voucher{}, &models.ReferralCode{})
+	db.Create(&models.Voucher{Code: "HEMAT", UsedCount: 1})
+
+	order := models.Order{OrderNumber: "ORD-SM", Status: OrderStatusPending, VoucherCode: "HEMAT"}
+	db.Create(&order)
+	for i := 0; i < 2; i++ {
+		db.Create(&models.Ticket{OrderID: &order.ID, EventID: 1, TicketTypeID: tt.ID, TicketCode: "T-SM" + string(rune('A'+i)), Status: "active"})
+	}
+	_, err := HoldTickets(db, order.ID, tt.ID, nil, nil, 2, time.Now().Add(30*time.Minute))
+	assert.NoError(t, err)
+	return db, tt, order
+}
+
+func voucherUses(db *gorm.DB) int {
+	var v models.Voucher
+	db.Where("code = ?", "HEMAT").First(&v)
+	return v.UsedCount
+}
+
+func TestOrderStateMachine_CancelRestoresQuotaAndVoucher(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+	assert.Equal(t, 3, available(db, tt.ID))
+
+	tr, err := m.Transition(db, &order, OrderStatusCancelled, OrderTransitionOptions{Notes: "Cancelled by Admin", SkipEmail: true})
+	assert.NoError(t, err)
+	assert.Equal(t, OrderStatusPending, tr.From)
+	assert.Equal(t, 5, available(db, tt.ID))
+	assert.Equal(t, 0, voucherUses(db))
+
+	var history models.OrderStatusHistory
+	db.Where("order_id = ?", order.ID).First(&history)
+	assert.Equal(t, "cancelled", history.Status)
+	assert.Equal(t, "Cancelled by Admin", history.Notes)
+
+	// Second cancel is reported, not re-applied
+	_, err = m.Transition(db, &order, OrderStatusCancelled, OrderTransitionOptions{SkipEmail: true})
+	assert.ErrorIs(t, err, ErrOrderAlreadyInStatus)
+	assert.Equal(t, 0, voucherUses(db))
+}
+
+func TestOrderStateMachine_PaidOnceAndNotCancellable(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+
+	var hooked []string
+	m.OnTransition(func(tx *gorm.DB, tr OrderTransition) error {
+		hooked = append(hooked, tr.From+"->"+tr.To)
+		return nil
+	})
+
+	_, err := m.Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	assert.NotNil(t, order.PaidAt)
+	assert.Equal(t, 3, available(db, tt.ID))
+
+	// A stale copy of the order (e.g. a retried callback) cannot pay twice
+	stale := order
+	stale.Status = OrderStatusPending
+	_, err = m.Transition(db, &stale, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.ErrorIs(t, err, ErrOrderAlreadyInStatus)
+
+	// ...nor let the expiry job release the seats of an order paid since it loaded it
+	stale = order
+	stale.Status = OrderStatusPending
+	_, err = m.Transition(db, &stale, OrderStatusExpired, OrderTransitionOptions{SkipEmail: true})
+	assert.ErrorIs(t, err, ErrOrderStatusChanged)
+	assert.Equal(t, OrderStatusPaid, stale.Status)
+	assert.Equal(t, 3, available(db, tt.ID))
+
+	_, err = m.Transition(db, &order, OrderStatusCancelled, OrderTransitionOptions{SkipEmail: true})
+	assert.ErrorIs(t, err, ErrOrderTransitionNotAllowed)
+	assert.Equal(t, []string{"pending->paid"}, hooked)
+}
+
+func TestOrderStateMachine_LatePaymentRevivesExpiredOrder(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+
+	_, err := m.Transition(db, &order, OrderStatusExpired, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, voucherUses(db))
+
+	_, err = m.Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, voucherUses(db), "voucher usage is taken again")
+	assert.Equal(t, 3, available(db, tt.ID), "seats are taken again")
+}
+
+func TestOrderStateMachine_LatePaymentTakesTheLastSeats(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+
+	_, err := m.Transition(db, &order, OrderStatusExpired, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	_, err = HoldTickets(db, 99, tt.ID, nil, nil, 3, time.Now().Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, available(db, tt.ID))
+
+	_, err = m.Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err, "the order's own tickets don't count against its revival")
+	assert.Equal(t, 0, available(db, tt.ID))
+}
+
+func TestOrderStateMachine_PaymentAfterHoldsLapsedReholdsSeats(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+
+	// The holds lapse and the sweep frees the seats before the order expiry job runs
+	db.Model(&models.TicketHold{}).Where("order_id = ?", order.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err := ReleaseExpiredHolds(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, available(db, tt.ID))
+
+	_, err = m.Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, available(db, tt.ID), "seats are held again before the order is paid")
+
+	var converted int64
+	db.Model(&models.TicketHold{}).Where("order_id = ? AND status = ?", order.ID, "converted").Count(&converted)
+	assert.Equal(t, int64(1), converted)
+}
+
+func TestOrderStateMachine_PaymentAfterHoldsLapsedAndSeatsSold(t *testing.T) {
+	db, tt, order := seedStateOrder(t)
+	m := &OrderStateMachine{}
+
+	// The holds lapse (not swept yet) and another buyer takes the freed seats
+	db.Model(&models.TicketHold{}).Where("order_id = ?", order.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err := HoldTickets(db, 99, tt.ID, nil, nil, 4, time.Now().Add(30*time.Minute))
+	assert.NoError(t, err)
+
+	_, err = m.Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.ErrorIs(t, err, ErrInsufficientQuota)
+	assert.True(t, IsQuotaError(err))
+
+	var current models.Order
+	db.First(&current, order.ID)
+	assert.Equal(t, OrderStatusPending, current.Status, "the payment is left for manual review")
+	assert.Equal(t, 1, available(db, tt.ID))
+}
+
+func TestOrderStateMachine_PaymentReholdsOnlyTheLapsedEvent(t *testing.T) {
+	db := newTestDB(t, append(checkoutTables, &models.OrderStatusHistory{}, &models.Voucher{}, &models.ReferralCode{})...)
+	short := models.Event{Title: "Konser", HoldMinutes: 10}
+	long := models.Event{Title: "Festival", HoldMinutes: 60}
+	db.Create(&short)
+	db.Create(&long)
+	shortType := models.TicketType{EventID: short.ID, Name: "Regular", Quota: 2, Available: 2}
+	longType := models.TicketType{EventID: long.ID, Name: "Regular", Quota: 2, Available: 2}
+	db.Create(&shortType)
+	db.Create(&longType)
+
+	created := time.Now().Add(-20 * time.Minute)
+	order := models.Order{OrderNumber: "ORD-2EV", Status: OrderStatusPending}
+	db.Create(&order)
+	db.Create(&models.Ticket{OrderID: &order.ID, EventID: short.ID, TicketTypeID: shortType.ID, TicketCode: "T-2EV-A", Status: "active"})
+	db.Create(&models.Ticket{OrderID: &order.ID, EventID: long.ID, TicketTypeID: longType.ID, TicketCode: "T-2EV-B", Status: "active"})
+	_, err := HoldTickets(db, order.ID, shortType.ID, nil, nil, 1, HoldExpiry(short, created))
+	assert.NoError(t, err)
+	_, err = HoldTickets(db, order.ID, longType.ID, nil, nil, 1, HoldExpiry(long, created))
+	assert.NoError(t, err)
+
+	// The short event's hold lapsed and was swept; the long one is still live
+	_, err = ReleaseExpiredHolds(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, available(db, shortType.ID))
+	assert.Equal(t, 1, available(db, longType.ID))
+
+	_, err = (&OrderStateMachine{}).Transition(db, &order, OrderStatusPaid, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, available(db, shortType.ID), "the lapsed event's seat is taken again")
+	assert.Equal(t, 1, available(db, longType.ID))
+
+	var holds []models.TicketHold
+	db.Where("order_id = ? AND status = ?", order.ID, "converted").Find(&holds)
+	assert.Len(t, holds, 2, "one hold per ticket type, none doubled")
+}
+
+func TestOrderStateMachine_RevivalHoldsForTheEventWindow(t *testing.T) {
+	db := newTestDB(t, append(checkoutTables, &models.OrderStatusHistory{}, &models.Voucher{}, &models.ReferralCode{})...)
+	event := models.Event{Title: "Konser", HoldMinutes: 90}
+	db.Create(&event)
+	tt := models.TicketType{EventID: event.ID, Name: "Regular", Quota: 2, Available: 2}
+	db.Create(&tt)
+	order := models.Order{OrderNumber: "ORD-REV", Status: OrderStatusExpired}
+	db.Create(&order)
+	db.Create(&models.Ticket{OrderID: &order.ID, EventID: event.ID, TicketTypeID: tt.ID, TicketCode: "T-REV", Status: "active"})
+
+	before := time.Now()
+	assert.NoError(t, DeductQuota(db, order.ID))
+	var hold models.TicketHold
+	db.Where("order_id = ?", order.ID).First(&hold)
+	assert.WithinDuration(t, HoldExpiry(event, before), hold.ExpiresAt, time.Minute)
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"kartcis-backend/models"
+)
+
+// FlipProvider collects payment through a Flip bill link (e-wallets, VA, QRIS).
+type FlipProvider struct{}
+
+func (FlipProvider) Name() string { return ProviderFlip }
+
+// Configured reports whether Flip credentials are present.
+func (FlipProvider) Configured() bool {
+	return os.Getenv("FLIP_API_KEY") != ""
+}
+
+func (FlipProvider) CreateCharge(order *models.Order) error {
+	redirectURL := os.Getenv("FRONTEND_URL") + "/orders/" + order.OrderNumber
+	resp, err := CreateFlipBill(order.OrderNumber, int(order.TotalAmount), order.CustomerName, order.CustomerEmail, order.CustomerPhone, redirectURL, order.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Flip API Error: %w", err)
+	}
+	order.PaymentURL = resp.PaymentURL
+	order.PaymentReference = fmt.Sprintf("%d", resp.ID)
+	order.PaymentData = order.PaymentReference
+	order.PaymentInstructions = "Silakan selesaikan pembayaran melalui link pembayaran yang tersedia."
+	return nil
+}
+
+// flipLinkID is the order's bill link; orders from before payment_reference kept it in
+// payment_data.
+func flipLinkID(order models.Order) string {
+	if order.PaymentReference != "" {
+		return order.PaymentReference
+	}
+	return order.PaymentData
+}
+
+func (p FlipProvider) QueryStatus(order models.Order) (string, error) {
+	notif, err := p.QueryPayment(order)
+	if err != nil {
+		return "", err
+	}
+	return notif.Status, nil
+}
+
+// QueryPayment reports the bill link's successful payment (with the amount Flip
+// received) as a paid notification, or a pending one when nothing was paid yet.
+func (FlipProvider) QueryPayment(order models.Order) (*PaymentNotification, error) {
+	linkID := flipLinkID(order)
+	if linkID == "" {
+		return nil, fmt.Errorf("order %s has no Flip bill link", order.OrderNumber)
+	}
+	resp, err := GetFlipBillPayments(linkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range resp.Data {
+		if p.Status == "SUCCESSFUL" {
+			return &PaymentNotification{Reference: linkID, Status: "paid", RawStatus: p.Status, Amount: float64(p.Amount)}, nil
+		}
+	}
+	return &PaymentNotification{Reference: linkID, Status: "pending", RawStatus: "PENDING"}, nil
+}
+
+func (FlipProvider) Cancel(order models.Order) error {
+	linkID := flipLinkID(order)
+	if linkID == "" {
+		return nil
+	}
+	return DeactivateFlipBill(linkID)
+}
+
+// ParseWebhook decodes Flip's form-encoded callback:
+// data  = JSON string with bill details
+// token = validation token (separate form field, not inside data)
+func (FlipProvider) ParseWebhook(r *http.Request) (*PaymentNotification, error) {
+	dataStr := r.PostFormValue("data")
+	tokenStr := r.PostFormValue("token")
+
+	if dataStr == "" {
+		return nil, ErrWebhookEmptyPayload
+	}
+
+	callbackToken := os.Getenv("FLIP_WEBHOOK_TOKEN")
+	if callbackToken != "" && tokenStr != callbackToken {
+		return nil, ErrWebhookInvalidToken
+	}
+
+	var bill struct {
+		BillLinkID int64       `json:"bill_link_id"` // int64! Format berubah jadi 19 digit per April 10, 2026
+		Status     string      `json:"status"`
+		Amount     json.Number `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(dataStr), &bill); err != nil {
+		return nil, err
+	}
+
+	notif := &PaymentNotification{
+		Reference: strconv.FormatInt(bill.BillLinkID, 10),
+		RawStatus: bill.Status,
+		Status:    "pending",
+	}
+	if bill.Amount != "" {
+		notif.Amount, _ = bill.Amount.Float64()
+	}
+
+	switch bill.Status {
+	case "SUCCESSFUL":
+		notif.Status = "paid"
+	case "CANCELLED", "FAILED":
+		notif.Status = "cancelled"
+	}
+	return notif, nil
+}
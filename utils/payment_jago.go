@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"kartcis-backend/models"
+)
+
+// JagoManualProvider collects payment via manual transfer to a Bank Jago account.
+// Transfers are confirmed by the bank email checker (jobs/payment_checker.go),
+// so there is no remote charge to query or cancel.
+type JagoManualProvider struct{}
+
+func (JagoManualProvider) Name() string { return ProviderManualJago }
+
+func (JagoManualProvider) CreateCharge(order *models.Order) error {
+	order.PaymentMethod = "MANUAL_JAGO"
+	accNo := os.Getenv("JAGO_ACCOUNT_NUMBER")
+	accName := os.Getenv("JAGO_ACCOUNT_NAME")
+	if accNo == "" {
+		accNo = "1010101020" // Default Demo
+		accName = "Kartcis Demo Account"
+	}
+	order.VirtualAccountNumber = accNo
+	order.PaymentData = accName
+	order.PaymentInstructions = fmt.Sprintf(
+		"Silakan transfer ke Bank Jago: %s a/n %s. Pastikan nominal sampai 3 digit terakhir (Rp %v) agar dapat diverifikasi otomatis.",
+		accNo, accName, FormatPrice(order.TotalAmount),
+	)
+	return nil
+}
+
+// QueryStatus returns the order's own status; the email checker is the source of truth.
+func (JagoManualProvider) QueryStatus(order models.Order) (string, error) {
+	return order.Status, nil
+}
+
+func (JagoManualProvider) Cancel(order models.Order) error {
+	return nil
+}
+
+func (JagoManualProvider) ParseWebhook(r *http.Request) (*PaymentNotification, error) {
+	return nil, ErrWebhookNotSupported
+}
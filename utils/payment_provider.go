@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Provider names as stored in orders.payment_provider and in site settings.
+const (
+	ProviderManualJago = "manual_jago"
+	ProviderFlip       = "flip"
+)
+
+var (
+	ErrWebhookNotSupported = errors.New("payment provider does not support webhooks")
+	ErrWebhookEmptyPayload = errors.New("empty webhook payload")
+	ErrWebhookInvalidToken = errors.New("invalid webhook token")
+
+	ErrPaymentOrderFinal     = errors.New("order is no longer awaiting payment")
+	ErrPaymentAmountMismatch = errors.New("paid amount does not match the order total")
+)
+
+// PaymentNotification is the provider-agnostic result of parsing a webhook.
+// Status is normalised to the order vocabulary: "paid", "cancelled" or "pending".
+type PaymentNotification struct {
+	Reference string  // Provider reference stored in order.PaymentReference (e.g. Flip bill_link_id)
+	Status    string  // paid, cancelled, pending
+	RawStatus string  // Status as sent by the provider
+	Amount    float64 // Amount reported by the provider (0 if not sent, which fails verification of a payment)
+}
+
+// VerifyPaymentNotification checks a paid or cancelled notification against the order
+// before it is applied. It returns ErrOrderAlreadyInStatus for a repeated notification,
+// ErrPaymentOrderFinal when the order already ended otherwise (paid, cancelled, expired,
+// refunded) and ErrPaymentAmountMismatch when the provider received another amount than
+// the order total, or reported no amount.
+func VerifyPaymentNotification(order models.Order, notif PaymentNotification) error {
+	if notif.Status == order.Status {
+		return ErrOrderAlreadyInStatus
+	}
+	if order.Status != OrderStatusPending {
+		return fmt.Errorf("%w (%s)", ErrPaymentOrderFinal, order.Status)
+	}
+	// A paid notification without an amount proves nothing
+	if notif.Status == OrderStatusPaid && notif.Amount <= 0 {
+		return fmt.Errorf("%w: no amount reported, order total Rp %s", ErrPaymentAmountMismatch, FormatPrice(order.TotalAmount))
+	}
+	// Charges are created for the whole rupiah amount
+	if notif.Status == OrderStatusPaid && int64(notif.Amount) != int64(order.TotalAmount) {
+		return fmt.Errorf("%w: Rp %s received, order total Rp %s", ErrPaymentAmountMismatch, FormatPrice(notif.Amount), FormatPrice(order.TotalAmount))
+	}
+	return nil
+}
+
+// PaymentProvider is implemented by every payment channel that can collect money for an order.
+type PaymentProvider interface {
+	Name() string
+	// CreateCharge fills the payment fields of the (not yet saved) order.
+	CreateCharge(order *models.Order) error
+	// QueryStatus asks the provider for the current normalised status of the order's charge.
+	QueryStatus(order models.Order) (string, error)
+	// Cancel invalidates the charge so the customer can no longer pay it.
+	Cancel(order models.Order) error
+	// ParseWebhook validates and decodes an incoming provider callback.
+	ParseWebhook(r *http.Request) (*PaymentNotification, error)
+}
+
+var paymentProviders = map[string]PaymentProvider{
+	ProviderManualJago: JagoManualProvider{},
+	ProviderFlip:       FlipProvider{},
+}
+
+// GetPaymentProvider returns a registered provider by name.
+func GetPaymentProvider(name string) (PaymentProvider, bool) {
+	p, ok := paymentProviders[strings.ToLower(strings.TrimSpace(name))]
+	return p, ok
+}
+
+// PaymentProviderSettingKey returns the site setting key that selects the provider
+// for a payment method, e.g. "OVO" -> "payment_provider_ovo".
+func PaymentProviderSettingKey(paymentMethod string) string {
+	m := strings.ToLower(strings.TrimSpace(paymentMethod))
+	m = strings.NewReplacer(" ", "_", "-", "_").Replace(m)
+	return "payment_provider_" + m
+}
+
+// ResolvePaymentProvider picks the provider for a payment method from site settings.
+// Lookup order: payment_provider_<method>, payment_provider_default, then manual Jago.
+// A provider that is not configured (e.g. Flip without FLIP_API_KEY) falls back to manual Jago.
+func ResolvePaymentProvider(db *gorm.DB, paymentMethod string) PaymentProvider {
+	fallback := paymentProviders[ProviderManualJago]
+	if db == nil {
+		return fallback
+	}
+
+	for _, key := range []string{PaymentProviderSettingKey(paymentMethod), "payment_provider_default"} {
+		var setting models.SiteSetting
+		if err := db.Where("key = ?", key).First(&setting).Error; err != nil || setting.Value == "" {
+			continue
+		}
+		p, ok := GetPaymentProvider(setting.Value)
+		if !ok {
+			log.Printf("[Payment] Unknown provider %q in setting %s, using %s", setting.Value, key, fallback.Name())
+			return fallback
+		}
+		if c, ok := p.(interface{ Configured() bool }); ok && !c.Configured() {
+			log.Printf("[Payment] Provider %s is not configured, using %s", p.Name(), fallback.Name())
+			return fallback
+		}
+		return p
+	}
+	return fallback
+}
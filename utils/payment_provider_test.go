@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeFlip starts an httptest stand-in for the Flip v2 bill API.
+func newFakeFlip(t *testing.T, payments []map[string]interface{}) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/pwf/bill":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"link_id":  4242,
+				"link_url": "https://flip.id/p/fake",
+				"status":   "ACTIVE",
+			})
+		case r.Method == "GET" && r.URL.Path == "/pwf/4242/payment":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"link_id": 4242,
+				"data":    payments,
+			})
+		case r.Method == "PUT" && r.URL.Path == "/pwf/4242/bill":
+			r.ParseForm()
+			assert.Equal(t, "INACTIVE", r.FormValue("status"))
+			json.NewEncoder(w).Encode(map[string]interface{}{"link_id": 4242, "status": "INACTIVE"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	os.Setenv("FLIP_API_KEY", "test_api_key")
+	os.Setenv("FLIP_BASE_URL", server.URL)
+	t.Cleanup(func() {
+		server.Close()
+		os.Unsetenv("FLIP_API_KEY")
+		os.Unsetenv("FLIP_BASE_URL")
+	})
+	return server, &calls
+}
+
+func TestResolvePaymentProvider(t *testing.T) {
+	t.Run("defaults to manual Jago without settings", func(t *testing.T) {
+		db := newTestDB(t, &models.SiteSetting{})
+		assert.Equal(t, ProviderManualJago, ResolvePaymentProvider(db, "OVO").Name())
+		assert.Equal(t, ProviderManualJago, ResolvePaymentProvider(nil, "OVO").Name())
+	})
+
+	t.Run("per method setting selects Flip", func(t *testing.T) {
+		os.Setenv("FLIP_API_KEY", "k")
+		defer os.Unsetenv("FLIP_API_KEY")
+
+		db := newTestDB(t, &models.SiteSetting{})
+		db.Create(&models.SiteSetting{Key: "payment_provider_ovo", Value: "flip"})
+		db.Create(&models.SiteSetting{Key: "payment_provider_default", Value: "manual_jago"})
+		assert.Equal(t, ProviderFlip, ResolvePaymentProvider(db, "OVO").Name())
+		assert.Equal(t, ProviderManualJago, ResolvePaymentProvider(db, "MANUAL_JAGO").Name())
+	})
+
+	t.Run("unconfigured Flip falls back to manual Jago", func(t *testing.T) {
+		os.Unsetenv("FLIP_API_KEY")
+		db := newTestDB(t, &models.SiteSetting{})
+		db.Create(&models.SiteSetting{Key: "payment_provider_default", Value: "flip"})
+		assert.Equal(t, ProviderManualJago, ResolvePaymentProvider(db, "GoPay").Name())
+	})
+
+	assert.Equal(t, "payment_provider_bca_virtual_account", PaymentProviderSettingKey("BCA Virtual Account"))
+}
+
+func TestJagoManualProvider(t *testing.T) {
+	os.Setenv("JAGO_ACCOUNT_NUMBER", "123456789")
+	os.Setenv("JAGO_ACCOUNT_NAME", "Kartcis")
+	defer os.Unsetenv("JAGO_ACCOUNT_NUMBER")
+	defer os.Unsetenv("JAGO_ACCOUNT_NAME")
+
+	p := JagoManualProvider{}
+	order := models.Order{OrderNumber: "ORD-1", TotalAmount: 50123, PaymentMethod: "OVO", Status: "pending"}
+
+	assert.NoError(t, p.CreateCharge(&order))
+	assert.Equal(t, "MANUAL_JAGO", order.PaymentMethod)
+	assert.Equal(t, "123456789", order.VirtualAccountNumber)
+	assert.Contains(t, order.PaymentInstructions, "50.123")
+
+	status, err := p.QueryStatus(order)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", status)
+	assert.NoError(t, p.Cancel(order))
+
+	req := httptest.NewRequest("POST", "/callback", nil)
+	_, err = p.ParseWebhook(req)
+	assert.ErrorIs(t, err, ErrWebhookNotSupported)
+}
+
+func TestFlipProvider_ChargeStatusCancel(t *testing.T) {
+	_, calls := newFakeFlip(t, []map[string]interface{}{
+		{"id": "FT1", "bill_link_id": 4242, "amount": 50000, "status": "FAILED"},
+		{"id": "FT2", "bill_link_id": 4242, "amount": 50000, "status": "SUCCESSFUL"},
+	})
+
+	p := FlipProvider{}
+	assert.True(t, p.Configured())
+
+	order := models.Order{OrderNumber: "ORD-FLIP", TotalAmount: 50000, CustomerName: "Budi", CustomerEmail: "budi@test.com"}
+	assert.NoError(t, p.CreateCharge(&order))
+	assert.Equal(t, "https://flip.id/p/fake", order.PaymentURL)
+	assert.Equal(t, "4242", order.PaymentReference)
+	assert.Equal(t, "4242", order.PaymentData)
+
+	status, err := p.QueryStatus(order)
+	assert.NoError(t, err)
+	assert.Equal(t, "paid", status)
+
+	notif, err := p.QueryPayment(order)
+	assert.NoError(t, err)
+	assert.Equal(t, 50000.0, notif.Amount)
+
+	assert.NoError(t, p.Cancel(order))
+	assert.Equal(t, []string{"POST /pwf/bill", "GET /pwf/4242/payment", "GET /pwf/4242/payment", "PUT /pwf/4242/bill"}, *calls)
+}
+
+func TestFlipProvider_QueryStatusPending(t *testing.T) {
+	newFakeFlip(t, []map[string]interface{}{})
+
+	status, err := FlipProvider{}.QueryStatus(models.Order{OrderNumber: "ORD-FLIP", PaymentData: "4242"})
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", status)
+}
+
+func TestFlipProvider_ParseWebhook(t *testing.T) {
+	os.Setenv("FLIP_WEBHOOK_TOKEN", "test-token-123")
+	defer os.Unsetenv("FLIP_WEBHOOK_TOKEN")
+
+	makeRequest := func(data, token string) *http.Request {
+		form := url.Values{}
+		form.Set("data", data)
+		form.Set("token", token)
+		req := httptest.NewRequest("POST", "/callback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	p := FlipProvider{}
+
+	notif, err := p.ParseWebhook(makeRequest(`{"bill_link_id":1234567890123456789,"amount":"50000","status":"SUCCESSFUL"}`, "test-token-123"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890123456789", notif.Reference)
+	assert.Equal(t, "paid", notif.Status)
+	assert.Equal(t, 50000.0, notif.Amount)
+
+	notif, err = p.ParseWebhook(makeRequest(`{"bill_link_id":99,"amount":50000,"status":"CANCELLED"}`, "test-token-123"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", notif.Status)
+
+	_, err = p.ParseWebhook(makeRequest(`{"bill_link_id":99}`, "WRONG"))
+	assert.ErrorIs(t, err, ErrWebhookInvalidToken)
+
+	_, err = p.ParseWebhook(makeRequest("", "test-token-123"))
+	assert.ErrorIs(t, err, ErrWebhookEmptyPayload)
+}
+
+func TestVerifyPaymentNotification(t *testing.T) {
+	pending := models.Order{Status: "pending", TotalAmount: 50000}
+	paid := models.Order{Status: "paid", TotalAmount: 50000}
+	expired := models.Order{Status: "expired", TotalAmount: 50000}
+
+	assert.NoError(t, VerifyPaymentNotification(pending, PaymentNotification{Status: "paid", Amount: 50000}))
+	assert.ErrorIs(t, VerifyPaymentNotification(pending, PaymentNotification{Status: "paid"}), ErrPaymentAmountMismatch, "amount not sent")
+	assert.NoError(t, VerifyPaymentNotification(pending, PaymentNotification{Status: "cancelled"}))
+
+	assert.ErrorIs(t, VerifyPaymentNotification(pending, PaymentNotification{Status: "paid", Amount: 5000}), ErrPaymentAmountMismatch)
+	assert.ErrorIs(t, VerifyPaymentNotification(paid, PaymentNotification{Status: "paid", Amount: 50000}), ErrOrderAlreadyInStatus)
+	assert.ErrorIs(t, VerifyPaymentNotification(paid, PaymentNotification{Status: "cancelled"}), ErrPaymentOrderFinal)
+	assert.ErrorIs(t, VerifyPaymentNotification(expired, PaymentNotification{Status: "paid", Amount: 50000}), ErrPaymentOrderFinal)
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidPriceTier = errors.New("invalid price tier")
+	ErrPriceTierInUse   = errors.New("price tier has tickets")
+)
+
+// PricePortion is the part of an order line sold at one price. Tier is nil for the
+// ticket type's own Price.
+type PricePortion struct {
+	Tier     *models.TicketPriceTier
+	Price    float64
+	Quantity int
+}
+
+// PlanPriceTiers splits qty tickets of the ticket type over its price tiers at now: the
+// open tiers fill up in Position order, each to what is left of its quota, and anything
+// beyond the last tier is sold at the ticket type's Price. The ticket type row is locked
+// so the split holds until HoldTickets reserves it in the same transaction.
+func PlanPriceTiers(tx *gorm.DB, ticketType models.TicketType, qty int, now time.Time) ([]PricePortion, error) {
+	var tiers []models.TicketPriceTier
+	if err := tx.Where("ticket_type_id = ?", ticketType.ID).Order("position, id").Find(&tiers).Error; err != nil {
+		return nil, err
+	}
+	if len(tiers) == 0 || qty <= 0 {
+		return []PricePortion{{Price: ticketType.Price, Quantity: qty}}, nil
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.TicketType{}, ticketType.ID).Error; err != nil {
+		return nil, err
+	}
+
+	var portions []PricePortion
+	remaining := qty
+	for i := range tiers {
+		tier := tiers[i]
+		if remaining == 0 {
+			break
+		}
+		if !tier.Open(now) {
+			continue
+		}
+		take := remaining
+		if tier.Quota > 0 {
+			taken, err := takenSeats(tx, ticketType.ID, "price_tier_id", tier.ID, now)
+			if err != nil {
+				return nil, err
+			}
+			if left := tier.Quota - taken; left < take {
+				take = left
+			}
+		}
+		if take <= 0 {
+			continue
+		}
+		portions = append(portions, PricePortion{Tier: &tier, Price: tier.Price, Quantity: take})
+		remaining -= take
+	}
+	if remaining > 0 {
+		portions = append(portions, PricePortion{Price: ticketType.Price, Quantity: remaining})
+	}
+	return portions, nil
+}
+
+// SyncEventPrices recomputes the event's min_price and max_price from the current price
+// of each public ticket type (its live tier, else Price). Events without public ticket
+// types keep their manual prices.
+func SyncEventPrices(tx *gorm.DB, eventID uint, now time.Time) error {
+	var ticketTypes []models.TicketType
+	if err := tx.Preload("PriceTiers").Where("event_id = ? AND hidden = ?", eventID, false).Find(&ticketTypes).Error; err != nil {
+		return err
+	}
+	if len(ticketTypes) == 0 {
+		return nil
+	}
+
+	var minPrice, maxPrice float64
+	for i, tt := range ticketTypes {
+		price := tt.Price
+		if tier := tt.LiveTier(now); tier != nil {
+			price = tier.Price
+		}
+		if i == 0 || price < minPrice {
+			minPrice = price
+		}
+		if i == 0 || price > maxPrice {
+			maxPrice = price
+		}
+	}
+	return tx.Model(&models.Event{}).Where("id = ?", eventID).Updates(map[string]interface{}{
+		"min_price": minPrice,
+		"max_price": maxPrice,
+	}).Error
+}
+
+// SavePriceTiers replaces the ticket type's tiers with the given list: tiers with an ID
+// are updated, new ones created and missing ones deleted. A tier that tickets were sold
+// in can't be deleted (ErrPriceTierInUse).
+func SavePriceTiers(tx *gorm.DB, ticketTypeID uint, tiers []models.TicketPriceTier) error {
+	for _, tier := range tiers {
+		switch {
+		case tier.Price < 0 || tier.Quota < 0:
+			return fmt.Errorf("%w: %q needs a price and quota of 0 or more", ErrInvalidPriceTier, tier.Name)
+		case tier.StartsAt != nil && tier.EndsAt != nil && !tier.EndsAt.After(*tier.StartsAt):
+			return fmt.Errorf("%w: %q ends before it starts", ErrInvalidPriceTier, tier.Name)
+		}
+	}
+
+	var existing []models.TicketPriceTier
+	if err := tx.Where("ticket_type_id = ?", ticketTypeID).Find(&existing).Error; err != nil {
+		return err
+	}
+	kept := make(map[uint]bool)
+	for _, tier := range tiers {
+		if tier.ID != 0 {
+			kept[tier.ID] = true
+		}
+	}
+	for _, tier := range existing {
+		if kept[tier.ID] {
+			continue
+		}
+		var used int64
+		if err := tx.Model(&models.Ticket{}).Where("price_tier_id = ?", tier.ID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used == 0 {
+			if err := tx.Model(&models.TicketHold{}).Where("price_tier_id = ? AND status = ?", tier.ID, "held").Count(&used).Error; err != nil {
+				return err
+			}
+		}
+		if used > 0 {
+			return fmt.Errorf("%w: %q", ErrPriceTierInUse, tier.Name)
+		}
+		if err := tx.Delete(&tier).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, tier := range tiers {
+		tier.TicketTypeID = ticketTypeID
+		if tier.ID == 0 {
+			if err := tx.Create(&tier).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		result := tx.Model(&models.TicketPriceTier{}).Where("id = ? AND ticket_type_id = ?", tier.ID, ticketTypeID).
+			Select("Name", "Price", "Quota", "StartsAt", "EndsAt", "Position").
+			Updates(tier)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: tier %d is not part of this ticket type", ErrInvalidPriceTier, tier.ID)
+		}
+	}
+
+	if err := SyncAvailability(tx, ticketTypeID); err != nil {
+		return err
+	}
+	var ticketType models.TicketType
+	if err := tx.First(&ticketType, ticketTypeID).Error; err != nil {
+		return err
+	}
+	return SyncEventPrices(tx, ticketType.EventID, time.Now())
+}
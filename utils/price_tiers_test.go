@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanPriceTiers_SplitsAcrossTiers(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	db.Model(&tt).Updates(map[string]interface{}{"quota": 10, "available": 10})
+	tt.Quota = 10
+	now := time.Now()
+	yesterday, tomorrow := now.Add(-24*time.Hour), now.Add(24*time.Hour)
+	closed := models.TicketPriceTier{TicketTypeID: tt.ID, Name: "Super Early", Price: 30000, EndsAt: &yesterday, Position: 0}
+	early := models.TicketPriceTier{TicketTypeID: tt.ID, Name: "Early Bird", Price: 50000, Quota: 2, Position: 1}
+	presale := models.TicketPriceTier{TicketTypeID: tt.ID, Name: "Presale", Price: 75000, Quota: 3, Position: 2}
+	later := models.TicketPriceTier{TicketTypeID: tt.ID, Name: "Presale 2", Price: 90000, StartsAt: &tomorrow, Position: 3}
+	for _, tier := range []*models.TicketPriceTier{&closed, &early, &presale, &later} {
+		db.Create(tier)
+	}
+
+	portions, err := PlanPriceTiers(db, tt, 3, now)
+	assert.NoError(t, err)
+	if assert.Len(t, portions, 2) {
+		assert.Equal(t, early.ID, portions[0].Tier.ID)
+		assert.Equal(t, 2, portions[0].Quantity)
+		assert.Equal(t, presale.ID, portions[1].Tier.ID)
+		assert.Equal(t, 1, portions[1].Quantity)
+	}
+
+	// Held seats use up a tier
+	_, err = HoldTickets(db, 0, tt.ID, nil, &early.ID, 2, now.Add(time.Hour))
+	assert.NoError(t, err)
+	_, err = HoldTickets(db, 0, tt.ID, nil, &early.ID, 1, now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrPriceTierSoldOut)
+
+	// Beyond the last open tier the ticket type's own price applies
+	portions, err = PlanPriceTiers(db, tt, 5, now)
+	assert.NoError(t, err)
+	if assert.Len(t, portions, 2) {
+		assert.Equal(t, presale.ID, portions[0].Tier.ID)
+		assert.Equal(t, 3, portions[0].Quantity)
+		assert.Nil(t, portions[1].Tier)
+		assert.Equal(t, 100000.0, portions[1].Price)
+		assert.Equal(t, 2, portions[1].Quantity)
+	}
+
+	var synced models.TicketPriceTier
+	db.First(&synced, early.ID)
+	assert.Equal(t, 2, synced.Sold)
+}
+
+func TestSyncEventPrices_UsesLiveTiers(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	event := models.Event{ID: 1, Title: "Konser", MinPrice: 100000, MaxPrice: 300000}
+	db.Create(&event)
+	db.Create(&models.TicketType{EventID: 1, Name: "VIP", Price: 300000, Quota: 5, Available: 5})
+	now := time.Now()
+
+	assert.NoError(t, SavePriceTiers(db, tt.ID, []models.TicketPriceTier{
+		{Name: "Early Bird", Price: 60000, Quota: 1},
+		{Name: "Presale", Price: 80000, Quota: 1, Position: 1},
+	}))
+	db.First(&event, 1)
+	assert.Equal(t, 60000.0, event.MinPrice)
+	assert.Equal(t, 300000.0, event.MaxPrice)
+
+	// Selling out the early bird moves the price on
+	var early models.TicketPriceTier
+	db.Where("name = ?", "Early Bird").First(&early)
+	_, err := HoldTickets(db, 0, tt.ID, nil, &early.ID, 1, now.Add(time.Hour))
+	assert.NoError(t, err)
+	db.First(&event, 1)
+	assert.Equal(t, 80000.0, event.MinPrice)
+
+	var loaded models.TicketType
+	db.Preload("PriceTiers").First(&loaded, tt.ID)
+	assert.Equal(t, 80000.0, loaded.CurrentPrice)
+
+	// A tier that tickets were sold in can't be dropped
+	err = SavePriceTiers(db, tt.ID, []models.TicketPriceTier{{Name: "Normal", Price: 90000}})
+	assert.ErrorIs(t, err, ErrPriceTierInUse)
+	err = SavePriceTiers(db, tt.ID, []models.TicketPriceTier{{ID: early.ID, Name: "Early Bird", Price: 60000, Quota: 1}, {Name: "Bad", StartsAt: &now, EndsAt: &now}})
+	assert.ErrorIs(t, err, ErrInvalidPriceTier)
+}
@@ -1,79 +1,416 @@
 package utils
 
 import (
+	"errors"
+	"sort"
+	"time"
+
 	"kartcis-backend/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// RestoreQuota restores ticket quotas when an order is cancelled or expired
-func RestoreQuota(tx *gorm.DB, orderID uint) error {
-	var tickets []models.Ticket
-	if err := tx.Where("order_id = ?", orderID).Find(&tickets).Error; err != nil {
+// DefaultHoldMinutes is the seat hold / payment window when an event does not configure one.
+const DefaultHoldMinutes = 30
+
+var (
+	ErrInsufficientQuota      = errors.New("insufficient ticket quota")
+	ErrInsufficientFlashQuota = errors.New("insufficient flash sale quota")
+	ErrPriceTierSoldOut       = errors.New("price tier sold out")
+)
+
+// HoldExpiry returns when a hold created now for the event should expire.
+func HoldExpiry(event models.Event, now time.Time) time.Time {
+	minutes := event.HoldMinutes
+	if minutes <= 0 {
+		minutes = DefaultHoldMinutes
+	}
+	return now.Add(time.Duration(minutes) * time.Minute)
+}
+
+// takenSeats counts seats that are no longer available for a ticket type: tickets of
+// paid orders plus live (unexpired) holds. A column ("flash_sale_id", "price_tier_id")
+// narrows the count to the seats of one flash sale or price tier.
+func takenSeats(tx *gorm.DB, ticketTypeID uint, column string, id uint, now time.Time) (int, error) {
+	tickets := tx.Model(&models.Ticket{}).
+		Joins("JOIN orders ON orders.id = tickets.order_id").
+		Where("tickets.ticket_type_id = ? AND orders.status = ? AND tickets.status IN ?", ticketTypeID, "paid", []string{"active", "used"})
+	holds := tx.Model(&models.TicketHold{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("ticket_type_id = ? AND status = ? AND expires_at > ?", ticketTypeID, "held", now)
+	if column != "" {
+		tickets = tickets.Where("tickets."+column+" = ?", id)
+		holds = holds.Where(column+" = ?", id)
+	}
+
+	var paid int64
+	if err := tickets.Count(&paid).Error; err != nil {
+		return 0, err
+	}
+	var held int64
+	if err := holds.Scan(&held).Error; err != nil {
+		return 0, err
+	}
+	return int(paid + held), nil
+}
+
+// HoldTickets locks the ticket type (and flash sale item) row, checks that quota minus paid
+// minus live holds covers qty, also within the flash sale or price tier, and records a
+// hold. OrderID may be 0 and attached later within the same transaction.
+func HoldTickets(tx *gorm.DB, orderID, ticketTypeID uint, flashSaleID, priceTierID *uint, qty int, expiresAt time.Time) (*models.TicketHold, error) {
+	now := time.Now()
+
+	var ticketType models.TicketType
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ticketType, ticketTypeID).Error; err != nil {
+		return nil, err
+	}
+	taken, err := takenSeats(tx, ticketTypeID, "", 0, now)
+	if err != nil {
+		return nil, err
+	}
+	if ticketType.Quota-taken < qty {
+		return nil, ErrInsufficientQuota
+	}
+
+	if flashSaleID != nil {
+		var item models.FlashSaleItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("flash_sale_id = ? AND ticket_type_id = ?", *flashSaleID, ticketTypeID).
+			First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInsufficientFlashQuota
+			}
+			return nil, err
+		}
+		flashTaken, err := takenSeats(tx, ticketTypeID, "flash_sale_id", *flashSaleID, now)
+		if err != nil {
+			return nil, err
+		}
+		if item.Quota-flashTaken < qty {
+			return nil, ErrInsufficientFlashQuota
+		}
+	}
+
+	if priceTierID != nil {
+		var tier models.TicketPriceTier
+		if err := tx.Where("id = ? AND ticket_type_id = ?", *priceTierID, ticketTypeID).First(&tier).Error; err != nil {
+			return nil, err
+		}
+		if tier.Quota > 0 {
+			tierTaken, err := takenSeats(tx, ticketTypeID, "price_tier_id", tier.ID, now)
+			if err != nil {
+				return nil, err
+			}
+			if tier.Quota-tierTaken < qty {
+				return nil, ErrPriceTierSoldOut
+			}
+		}
+	}
+
+	hold := models.TicketHold{
+		OrderID:      orderID,
+		TicketTypeID: ticketTypeID,
+		FlashSaleID:  flashSaleID,
+		PriceTierID:  priceTierID,
+		Quantity:     qty,
+		Status:       "held",
+		ExpiresAt:    expiresAt,
+	}
+	if err := tx.Create(&hold).Error; err != nil {
+		return nil, err
+	}
+	return &hold, SyncAvailability(tx, ticketTypeID)
+}
+
+// SyncAvailability recomputes the cached ticket_types.available, flash_sale_items.sold and
+// ticket_price_tiers.sold columns from paid tickets and live holds. Bundles count their
+// purchases instead (see syncBundleAvailability).
+func SyncAvailability(tx *gorm.DB, ticketTypeID uint) error {
+	now := time.Now()
+
+	var ticketType models.TicketType
+	if err := tx.First(&ticketType, ticketTypeID).Error; err != nil {
+		return err
+	}
+	var bundleItems []models.BundleItem
+	if err := tx.Where("bundle_id = ?", ticketTypeID).Find(&bundleItems).Error; err != nil {
+		return err
+	}
+	if len(bundleItems) > 0 {
+		return syncBundleAvailability(tx, ticketType, bundleItems)
+	}
+	taken, err := takenSeats(tx, ticketTypeID, "", 0, now)
+	if err != nil {
+		return err
+	}
+	available := ticketType.Quota - taken
+	if available < 0 {
+		available = 0
+	}
+	if err := tx.Model(&models.TicketType{}).Where("id = ?", ticketTypeID).
+		Update("available", available).Error; err != nil {
 		return err
 	}
 
-	// Group by ticket type and flash sale
-	ticketTypeCounts := make(map[uint]int)
-	flashSaleCounts := make(map[uint]int)
+	var items []models.FlashSaleItem
+	if err := tx.Where("ticket_type_id = ?", ticketTypeID).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		sold, err := takenSeats(tx, ticketTypeID, "flash_sale_id", item.FlashSaleID, now)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.FlashSaleItem{}).Where("id = ?", item.ID).Update("sold", sold).Error; err != nil {
+			return err
+		}
+	}
 
-	for _, t := range tickets {
-		ticketTypeCounts[t.TicketTypeID]++
-		if t.FlashSaleID != nil {
-			flashSaleCounts[*t.FlashSaleID]++
+	var tiers []models.TicketPriceTier
+	if err := tx.Where("ticket_type_id = ?", ticketTypeID).Find(&tiers).Error; err != nil {
+		return err
+	}
+	for _, tier := range tiers {
+		sold, err := takenSeats(tx, ticketTypeID, "price_tier_id", tier.ID, now)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.TicketPriceTier{}).Where("id = ?", tier.ID).Update("sold", sold).Error; err != nil {
+			return err
+		}
+	}
+	if len(tiers) > 0 {
+		// A tier selling out moves the ticket type to its next price
+		return SyncEventPrices(tx, ticketType.EventID, now)
+	}
+	return nil
+}
+
+// syncOrderTicketTypes resyncs every ticket type (and bundle) that has tickets or holds
+// on the order.
+func syncOrderTicketTypes(tx *gorm.DB, orderID uint) error {
+	var ids []uint
+	for _, model := range []interface{}{&models.Ticket{}, &models.TicketHold{}} {
+		for _, column := range []string{"ticket_type_id", "bundle_ticket_type_id"} {
+			var found []uint
+			if err := tx.Model(model).Where("order_id = ? AND "+column+" IS NOT NULL", orderID).
+				Distinct().Pluck(column, &found).Error; err != nil {
+				return err
+			}
+			ids = append(ids, found...)
 		}
 	}
 
-	// Restore normal ticket type available quota
-	for ttID, count := range ticketTypeCounts {
-		if err := tx.Model(&models.TicketType{}).Where("id = ?", ttID).
-			Update("available", gorm.Expr("available + ?", count)).Error; err != nil {
+	seen := make(map[uint]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := SyncAvailability(tx, id); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// Restore Flash Sale sold count
-	for fsID, count := range flashSaleCounts {
-		if err := tx.Model(&models.FlashSale{}).Where("id = ?", fsID).
-			Update("sold", gorm.Expr("sold - ?", count)).Error; err != nil {
+// ConfirmHolds converts the order's holds once it is paid. The seats stay taken,
+// now counted as paid tickets instead of holds.
+func ConfirmHolds(tx *gorm.DB, orderID uint) error {
+	if err := tx.Model(&models.TicketHold{}).
+		Where("order_id = ? AND status = ?", orderID, "held").
+		Update("status", "converted").Error; err != nil {
+		return err
+	}
+	return syncOrderTicketTypes(tx, orderID)
+}
+
+// RestoreQuota releases the order's holds when it is cancelled or expired and
+// recomputes availability (which also returns seats of a previously paid order).
+func RestoreQuota(tx *gorm.DB, orderID uint) error {
+	now := time.Now()
+	if err := tx.Model(&models.TicketHold{}).
+		Where("order_id = ? AND status = ?", orderID, "held").
+		Updates(map[string]interface{}{"status": "released", "released_at": now}).Error; err != nil {
+		return err
+	}
+	return syncOrderTicketTypes(tx, orderID)
+}
+
+// RestoreTicketQuota returns the seats of individual tickets (e.g. refunded ones) to
+// their ticket types. The tickets must already be out of active/used status.
+func RestoreTicketQuota(tx *gorm.DB, ticketIDs []uint) error {
+	if len(ticketIDs) == 0 {
+		return nil
+	}
+	var typeIDs []uint
+	if err := tx.Model(&models.Ticket{}).Where("id IN ?", ticketIDs).Distinct().Pluck("ticket_type_id", &typeIDs).Error; err != nil {
+		return err
+	}
+	var bundleIDs []uint
+	if err := tx.Model(&models.Ticket{}).Where("id IN ? AND bundle_ticket_type_id IS NOT NULL", ticketIDs).Distinct().Pluck("bundle_ticket_type_id", &bundleIDs).Error; err != nil {
+		return err
+	}
+	typeIDs = append(typeIDs, bundleIDs...)
+	for _, id := range typeIDs {
+		if err := SyncAvailability(tx, id); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// DeductQuota re-deducts ticket quotas when an order is revived
+// DeductQuota re-holds the seats of an order that is being revived (e.g. a late payment
+// for an expired order). It fails with ErrInsufficientQuota when the seats are gone.
 func DeductQuota(tx *gorm.DB, orderID uint) error {
+	return reholdSeats(tx, orderID, nil)
+}
+
+// reholdSeats holds the seats of the order's tickets again (only those of onlyTypes, when
+// set) until the hold expiry of each ticket's event.
+func reholdSeats(tx *gorm.DB, orderID uint, onlyTypes map[uint]bool) error {
 	var tickets []models.Ticket
 	if err := tx.Where("order_id = ?", orderID).Find(&tickets).Error; err != nil {
 		return err
 	}
 
-	// Group by ticket type and flash sale
-	ticketTypeCounts := make(map[uint]int)
-	flashSaleCounts := make(map[uint]int)
-
+	type holdKey struct {
+		ticketTypeID uint
+		flashSaleID  uint
+		eventID      uint
+	}
+	counts := make(map[holdKey]int)
+	var keys []holdKey
 	for _, t := range tickets {
-		ticketTypeCounts[t.TicketTypeID]++
+		if onlyTypes != nil && !onlyTypes[t.TicketTypeID] {
+			continue
+		}
+		key := holdKey{ticketTypeID: t.TicketTypeID, eventID: t.EventID}
 		if t.FlashSaleID != nil {
-			flashSaleCounts[*t.FlashSaleID]++
+			key.flashSaleID = *t.FlashSaleID
+		}
+		if _, ok := counts[key]; !ok {
+			keys = append(keys, key)
 		}
+		counts[key]++
 	}
+	// Same lock order as RenewLapsedHolds
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ticketTypeID != keys[j].ticketTypeID {
+			return keys[i].ticketTypeID < keys[j].ticketTypeID
+		}
+		return keys[i].flashSaleID < keys[j].flashSaleID
+	})
 
-	// Deduct normal ticket type available quota
-	for ttID, count := range ticketTypeCounts {
-		if err := tx.Model(&models.TicketType{}).Where("id = ?", ttID).
-			Update("available", gorm.Expr("available - ?", count)).Error; err != nil {
+	now := time.Now()
+	events := make(map[uint]models.Event)
+	for _, key := range keys {
+		event, ok := events[key.eventID]
+		if !ok {
+			if err := tx.Where("id = ?", key.eventID).Limit(1).Find(&event).Error; err != nil {
+				return err
+			}
+			events[key.eventID] = event
+		}
+		var flashSaleID *uint
+		if key.flashSaleID != 0 {
+			id := key.flashSaleID
+			flashSaleID = &id
+		}
+		// The order keeps the price it was sold at, so a sold-out tier doesn't block it
+		if _, err := HoldTickets(tx, orderID, key.ticketTypeID, flashSaleID, nil, counts[key], HoldExpiry(event, now)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// RenewLapsedHolds makes sure a pending order that is being paid still holds its seats.
+// A payment can land after ReleaseExpiredHolds gave the seats back but before the order
+// expiry sweep, when other buyers may hold or have bought them. The order's ticket type
+// rows are locked (in ID order) before the holds are checked, so no checkout can take a
+// seat meanwhile. Holds are checked per ticket type, since a cart over several events has
+// a hold window per event: the types without a live hold are held again, which fails
+// with ErrInsufficientQuota when their seats are gone.
+func RenewLapsedHolds(tx *gorm.DB, orderID uint) error {
+	var ticketTypeIDs []uint
+	if err := tx.Model(&models.Ticket{}).Where("order_id = ?", orderID).
+		Distinct().Pluck("ticket_type_id", &ticketTypeIDs).Error; err != nil {
+		return err
+	}
+	if len(ticketTypeIDs) == 0 {
+		return nil
+	}
+	var locked []models.TicketType
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		Where("id IN ?", ticketTypeIDs).Order("id").Find(&locked).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var liveTypeIDs []uint
+	if err := tx.Model(&models.TicketHold{}).
+		Where("order_id = ? AND status = ? AND expires_at > ?", orderID, "held", now).
+		Distinct().Pluck("ticket_type_id", &liveTypeIDs).Error; err != nil {
+		return err
+	}
+	lapsed := make(map[uint]bool)
+	for _, id := range ticketTypeIDs {
+		lapsed[id] = true
+	}
+	for _, id := range liveTypeIDs {
+		delete(lapsed, id)
+	}
+	if len(lapsed) == 0 {
+		return nil
+	}
+
+	lapsedIDs := make([]uint, 0, len(lapsed))
+	for id := range lapsed {
+		lapsedIDs = append(lapsedIDs, id)
+	}
+	// Lapsed holds the sweep hasn't reached yet are released, so only the new holds convert
+	if err := tx.Model(&models.TicketHold{}).
+		Where("order_id = ? AND ticket_type_id IN ? AND status = ?", orderID, lapsedIDs, "held").
+		Updates(map[string]interface{}{"status": "released", "released_at": now}).Error; err != nil {
+		return err
+	}
+	return reholdSeats(tx, orderID, lapsed)
+}
+
+// ReleaseExpiredHolds releases holds whose TTL has passed and resyncs availability.
+func ReleaseExpiredHolds(db *gorm.DB) (int, error) {
+	now := time.Now()
+	var holds []models.TicketHold
+	if err := db.Where("status = ? AND expires_at <= ?", "held", now).Find(&holds).Error; err != nil {
+		return 0, err
+	}
+	if len(holds) == 0 {
+		return 0, nil
+	}
 
-	// Deduct Flash Sale sold count
-	for fsID, count := range flashSaleCounts {
-		if err := tx.Model(&models.FlashSale{}).Where("id = ?", fsID).
-			Update("sold", gorm.Expr("sold + ?", count)).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids := make([]uint, 0, len(holds))
+		ticketTypes := make(map[uint]bool)
+		for _, h := range holds {
+			ids = append(ids, h.ID)
+			ticketTypes[h.TicketTypeID] = true
+		}
+		if err := tx.Model(&models.TicketHold{}).
+			Where("id IN ? AND status = ?", ids, "held").
+			Updates(map[string]interface{}{"status": "released", "released_at": now}).Error; err != nil {
 			return err
 		}
+		for id := range ticketTypes {
+			if err := SyncAvailability(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return len(holds), nil
 }
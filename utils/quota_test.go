@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupQuotaDB(t *testing.T) (*gorm.DB, models.TicketType) {
+	db := newTestDB(t, checkoutTables...)
+	tt := models.TicketType{EventID: 1, Name: "Regular", Price: 100000, Quota: 5, Available: 5}
+	db.Create(&tt)
+	return db, tt
+}
+
+func available(db *gorm.DB, id uint) int {
+	var tt models.TicketType
+	db.First(&tt, id)
+	return tt.Available
+}
+
+func TestHoldTickets_ReserveConfirmRelease(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	expires := time.Now().Add(30 * time.Minute)
+
+	hold, err := HoldTickets(db, 1, tt.ID, nil, nil, 3, expires)
+	assert.NoError(t, err)
+	assert.Equal(t, "held", hold.Status)
+	assert.Equal(t, 2, available(db, tt.ID))
+
+	_, err = HoldTickets(db, 2, tt.ID, nil, nil, 3, expires)
+	assert.ErrorIs(t, err, ErrInsufficientQuota)
+
+	// Order 2 holds the rest, then gets cancelled
+	_, err = HoldTickets(db, 2, tt.ID, nil, nil, 2, expires)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, available(db, tt.ID))
+	assert.NoError(t, RestoreQuota(db, 2))
+	assert.Equal(t, 2, available(db, tt.ID))
+
+	// Order 1 pays: holds convert, seats stay taken via paid tickets
+	db.Create(&models.Order{ID: 1, OrderNumber: "ORD-1", Status: "paid"})
+	orderID := uint(1)
+	for i := 0; i < 3; i++ {
+		db.Create(&models.Ticket{OrderID: &orderID, EventID: 1, TicketTypeID: tt.ID, TicketCode: "T" + string(rune('A'+i)), Status: "active"})
+	}
+	assert.NoError(t, ConfirmHolds(db, 1))
+	assert.Equal(t, 2, available(db, tt.ID))
+
+	var converted int64
+	db.Model(&models.TicketHold{}).Where("order_id = ? AND status = ?", 1, "converted").Count(&converted)
+	assert.Equal(t, int64(1), converted)
+}
+
+func TestReleaseExpiredHolds(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+
+	_, err := HoldTickets(db, 1, tt.ID, nil, nil, 4, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	// An expired hold no longer blocks seats even before the sweep runs
+	_, err = HoldTickets(db, 2, tt.ID, nil, nil, 5, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, RestoreQuota(db, 2))
+
+	released, err := ReleaseExpiredHolds(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assert.Equal(t, 5, available(db, tt.ID))
+}
+
+func TestHoldTickets_FlashSale(t *testing.T) {
+	db, tt := setupQuotaDB(t)
+	fs := models.FlashSale{EventID: 1, IsActive: true, Items: []models.FlashSaleItem{{TicketTypeID: tt.ID, Quota: 2, FlashPrice: 50000}}}
+	db.Create(&fs)
+
+	_, err := HoldTickets(db, 1, tt.ID, &fs.ID, nil, 2, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	_, err = HoldTickets(db, 2, tt.ID, &fs.ID, nil, 1, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInsufficientFlashQuota)
+
+	var item models.FlashSaleItem
+	db.First(&item, fs.Items[0].ID)
+	assert.Equal(t, 2, item.Sold)
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"kartcis-backend/models"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+var ErrNoTickets = errors.New("no tickets to render")
+
+// GenerateTicketsPDF renders one A4 page per ticket with event details and a
+// scannable QR code of the ticket code. Tickets must have Event and TicketType preloaded.
+func GenerateTicketsPDF(orderNumber string, tickets []models.Ticket) ([]byte, error) {
+	if len(tickets) == 0 {
+		return nil, ErrNoTickets
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("E-Tiket "+orderNumber, true)
+	pdf.SetAuthor("KARTCIS.ID", true)
+	pdf.SetAutoPageBreak(false, 0)
+	// Core fonts are cp1252; translate UTF-8 input so names with accents render
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	for i, ticket := range tickets {
+		png, err := qrcode.Encode(ticket.TicketCode, qrcode.Medium, 512)
+		if err != nil {
+			return nil, fmt.Errorf("qr code for %s: %w", ticket.TicketCode, err)
+		}
+		imageName := fmt.Sprintf("qr-%d", i)
+		pdf.RegisterImageOptionsReader(imageName, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+
+		pdf.AddPage()
+		drawTicketPage(pdf, tr, orderNumber, ticket, imageName)
+	}
+
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawTicketPage(pdf *fpdf.Fpdf, tr func(string) string, orderNumber string, ticket models.Ticket, qrImage string) {
+	const margin = 15.0
+	pageW, _ := pdf.GetPageSize()
+	contentW := pageW - 2*margin
+
+	// Header band
+	pdf.SetFillColor(79, 70, 229)
+	pdf.Rect(0, 0, pageW, 28, "F")
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.SetXY(margin, 9)
+	pdf.CellFormat(contentW/2, 10, "KARTCIS.ID", "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.CellFormat(contentW/2, 10, "E-TIKET", "", 0, "R", false, 0, "")
+
+	// Event
+	pdf.SetTextColor(17, 24, 39)
+	pdf.SetXY(margin, 38)
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.MultiCell(contentW, 9, tr(ticket.Event.Title), "", "L", false)
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.SetTextColor(75, 85, 99)
+	when := ticket.Event.EventDate.Format("Monday, 02 January 2006")
+	if ticket.Event.EventTime != "" {
+		when += " - " + ticket.Event.EventTime
+	}
+	venue := ticket.Event.Venue
+	if ticket.Event.City != "" {
+		venue += ", " + ticket.Event.City
+	}
+	pdf.SetX(margin)
+	pdf.MultiCell(contentW, 6, tr(when), "", "L", false)
+	pdf.SetX(margin)
+	pdf.MultiCell(contentW, 6, tr(venue), "", "L", false)
+
+	// Ticket details (left) and QR code (right)
+	top := pdf.GetY() + 8
+	pdf.SetDrawColor(209, 213, 219)
+	pdf.SetDashPattern([]float64{2, 1.5}, 0)
+	pdf.Rect(margin, top, contentW, 90, "D")
+	pdf.SetDashPattern([]float64{}, 0)
+
+	attendee := ticket.AttendeeName
+	if attendee == "" {
+		attendee = "-"
+	}
+	rows := [][2]string{
+		{"Nama Peserta", attendee},
+		{"Jenis Tiket", ticket.TypeName()},
+		{"No. Pesanan", orderNumber},
+		{"Kode Tiket", ticket.TicketCode},
+	}
+	y := top + 8
+	for _, row := range rows {
+		pdf.SetXY(margin+8, y)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(107, 114, 128)
+		pdf.CellFormat(90, 5, row[0], "", 2, "L", false, 0, "")
+		pdf.SetX(margin + 8)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetTextColor(17, 24, 39)
+		pdf.CellFormat(90, 7, tr(row[1]), "", 0, "L", false, 0, "")
+		y += 18
+	}
+
+	const qrSize = 70.0
+	qrX := margin + contentW - qrSize - 8
+	pdf.ImageOptions(qrImage, qrX, top+6, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetXY(qrX, top+6+qrSize)
+	pdf.SetFont("Courier", "B", 10)
+	pdf.CellFormat(qrSize, 6, ticket.TicketCode, "", 0, "C", false, 0, "")
+
+	// Footer note
+	pdf.SetXY(margin, top+100)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(107, 114, 128)
+	pdf.MultiCell(contentW, 5, "Tunjukkan QR code ini kepada petugas di pintu masuk. Satu kode hanya berlaku untuk satu kali masuk. Jangan bagikan tiket ini kepada orang lain.", "", "L", false)
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleTickets(n int) []models.Ticket {
+	event := models.Event{
+		Title:     "Konser Musik Akhir Tahun",
+		EventDate: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+		EventTime: "19:00",
+		Venue:     "Istora Senayan",
+		City:      "Jakarta",
+	}
+	var tickets []models.Ticket
+	for i := 0; i < n; i++ {
+		tickets = append(tickets, models.Ticket{
+			TicketCode:   "TIX-" + string(rune('A'+i)),
+			AttendeeName: "José Budi",
+			Event:        event,
+			TicketType:   models.TicketType{Name: "VIP"},
+		})
+	}
+	return tickets
+}
+
+func TestGenerateTicketsPDF(t *testing.T) {
+	pdf, err := GenerateTicketsPDF("ORD-123", sampleTickets(3))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+
+	pages := regexp.MustCompile(`/Type /Page\b[^s]`).FindAll(pdf, -1)
+	assert.Len(t, pages, 3)
+
+	_, err = GenerateTicketsPDF("ORD-123", nil)
+	assert.ErrorIs(t, err, ErrNoTickets)
+}
+
+func TestBuildMailMessage_WithAttachment(t *testing.T) {
+	msg := string(buildMailMessage("noreply@kartcis.id", "budi@test.com", "E-Tiket", "<p>Hi</p>", []MailAttachment{
+		{Filename: "e-tiket-ORD-1.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte("x"), 200)},
+	}))
+
+	assert.Contains(t, msg, "Content-Type: multipart/mixed; boundary=")
+	assert.Contains(t, msg, "<p>Hi</p>")
+	assert.Contains(t, msg, `attachment; filename="e-tiket-ORD-1.pdf"`)
+	for _, line := range strings.Split(msg, "\r\n") {
+		assert.LessOrEqual(t, len(line), 998)
+	}
+
+	plain := string(buildMailMessage("a@b.c", "d@e.f", "s", "<p>x</p>", nil))
+	assert.Contains(t, plain, "Content-Type: text/html; charset=UTF-8\r\n\r\n<p>x</p>")
+}
@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Signed ticket codes look like:
+//
+//	KT1.<base64url(payload)>.<base64url(ed25519 signature over payload)>
+//
+// payload = version(1) | key id (4 bytes) | uvarint ticket id | uvarint event id | uvarint ticket type id
+//
+// A scanner holding the event's public keys can decode and verify the code without a connection.
+const (
+	SignedTicketPrefix  = "KT1."
+	signedTicketVersion = 1
+	ticketKeyIDBytes    = 4
+)
+
+var (
+	ErrInvalidTicketCode       = errors.New("invalid ticket code")
+	ErrTicketSignature         = errors.New("ticket signature does not match")
+	ErrTicketKeyRevoked        = errors.New("ticket signing key has been revoked")
+	ErrTicketKeyNotFound       = errors.New("ticket signing key not found")
+	ErrTicketKeyAlreadyRevoked = errors.New("signing key is already revoked")
+	ErrTicketCodeSuperseded    = errors.New("ticket code has been reissued")
+)
+
+// TicketClaims is the content of a signed ticket code.
+type TicketClaims struct {
+	KeyID        string `json:"key_id"`
+	TicketID     uint   `json:"ticket_id"`
+	EventID      uint   `json:"event_id"`
+	TicketTypeID uint   `json:"ticket_type_id"`
+}
+
+// IsSignedTicketCode reports whether the code uses the signed format (vs the legacy T-... codes).
+func IsSignedTicketCode(code string) bool {
+	return strings.HasPrefix(code, SignedTicketPrefix)
+}
+
+func newEventSigningKey(eventID uint) (*models.EventSigningKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := make([]byte, ticketKeyIDBytes)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+	return &models.EventSigningKey{
+		EventID:    eventID,
+		KeyID:      hex.EncodeToString(kid),
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		PrivateKey: base64.StdEncoding.EncodeToString(priv.Seed()),
+		Status:     "active",
+	}, nil
+}
+
+// EnsureEventSigningKey returns the event's active key, creating one on first use.
+func EnsureEventSigningKey(tx *gorm.DB, eventID uint) (*models.EventSigningKey, error) {
+	var key models.EventSigningKey
+	err := tx.Where("event_id = ? AND status = ?", eventID, "active").Order("id DESC").First(&key).Error
+	if err == nil {
+		return &key, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	newKey, err := newEventSigningKey(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(newKey).Error; err != nil {
+		return nil, err
+	}
+	return newKey, nil
+}
+
+// RotateEventSigningKey retires the current active key and creates a new one. Codes signed
+// with retired keys stay valid; new tickets are signed with the new key.
+func RotateEventSigningKey(tx *gorm.DB, eventID uint) (*models.EventSigningKey, error) {
+	now := time.Now()
+	if err := tx.Model(&models.EventSigningKey{}).
+		Where("event_id = ? AND status = ?", eventID, "active").
+		Updates(map[string]interface{}{"status": "retired", "retired_at": now}).Error; err != nil {
+		return nil, err
+	}
+	return EnsureEventSigningKey(tx, eventID)
+}
+
+// RevokeEventSigningKey invalidates a key and re-signs every ticket that carries a code
+// from it with the event's active key (rotating first when the revoked key was active).
+// It returns the tickets that received a new code.
+func RevokeEventSigningKey(tx *gorm.DB, eventID uint, keyID string) ([]models.Ticket, error) {
+	var key models.EventSigningKey
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("event_id = ? AND key_id = ?", eventID, keyID).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTicketKeyNotFound
+		}
+		return nil, err
+	}
+	if key.Status == "revoked" {
+		return nil, ErrTicketKeyAlreadyRevoked
+	}
+
+	now := time.Now()
+	if err := tx.Model(&key).Updates(map[string]interface{}{"status": "revoked", "revoked_at": now}).Error; err != nil {
+		return nil, err
+	}
+
+	var tickets []models.Ticket
+	if err := tx.Where("event_id = ? AND ticket_code LIKE ?", eventID, SignedTicketPrefix+"%").Find(&tickets).Error; err != nil {
+		return nil, err
+	}
+	var reissued []models.Ticket
+	for i := range tickets {
+		claims, err := DecodeTicketCode(tickets[i].TicketCode)
+		if err != nil || claims.KeyID != keyID {
+			continue
+		}
+		if err := IssueTicketCode(tx, &tickets[i]); err != nil {
+			return reissued, err
+		}
+		reissued = append(reissued, tickets[i])
+	}
+	return reissued, nil
+}
+
+// ResendReissuedTickets queues the e-ticket email again for every paid order that owns
+// reissued tickets (see RevokeEventSigningKey), since the QR codes customers already have
+// no longer scan. Call it after the revocation is committed. It returns how many orders
+// were sent their new codes.
+func ResendReissuedTickets(db *gorm.DB, reissued []models.Ticket) (int, error) {
+	var ticketIDs, orderIDs []uint
+	for _, ticket := range reissued {
+		ticketIDs = append(ticketIDs, ticket.ID)
+		if ticket.OrderID != nil {
+			orderIDs = append(orderIDs, *ticket.OrderID)
+		}
+	}
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+
+	var orders []models.Order
+	if err := db.Where("id IN ? AND status = ?", orderIDs, OrderStatusPaid).Find(&orders).Error; err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, order := range orders {
+		var tickets []models.Ticket
+		if err := db.Preload("Event").Preload("TicketType").Preload("BundleTicketType").
+			Where("order_id = ? AND id IN ? AND status <> ?", order.ID, ticketIDs, "refunded").
+			Find(&tickets).Error; err != nil {
+			return sent, err
+		}
+		if len(tickets) == 0 {
+			continue
+		}
+		if err := db.Where("order_id = ? AND status = ?", order.ID, "paid").Find(&order.AddOns).Error; err != nil {
+			return sent, err
+		}
+		SendTicketEmail(order, tickets)
+		AddOrderNote(db, order, "E-Ticket email re-sent with new ticket codes (signing key revoked)")
+		sent++
+	}
+	return sent, nil
+}
+
+// SignTicketCode builds the signed code for a ticket with the given key.
+func SignTicketCode(key *models.EventSigningKey, ticket models.Ticket) (string, error) {
+	seed, err := base64.StdEncoding.DecodeString(key.PrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("signing key %s is corrupt", key.KeyID)
+	}
+	kid, err := hex.DecodeString(key.KeyID)
+	if err != nil || len(kid) != ticketKeyIDBytes {
+		return "", fmt.Errorf("signing key id %s is corrupt", key.KeyID)
+	}
+
+	payload := []byte{signedTicketVersion}
+	payload = append(payload, kid...)
+	payload = binary.AppendUvarint(payload, uint64(ticket.ID))
+	payload = binary.AppendUvarint(payload, uint64(ticket.EventID))
+	payload = binary.AppendUvarint(payload, uint64(ticket.TicketTypeID))
+
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(seed), payload)
+	return SignedTicketPrefix + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// IssueTicketCode signs a saved ticket (ID must be set) with its event's active key and
+// stores the code.
+func IssueTicketCode(tx *gorm.DB, ticket *models.Ticket) error {
+	key, err := EnsureEventSigningKey(tx, ticket.EventID)
+	if err != nil {
+		return err
+	}
+	code, err := SignTicketCode(key, *ticket)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Ticket{}).Where("id = ?", ticket.ID).Update("ticket_code", code).Error; err != nil {
+		return err
+	}
+	ticket.TicketCode = code
+	return nil
+}
+
+func splitTicketCode(code string) (payload, sig []byte, err error) {
+	if !IsSignedTicketCode(code) {
+		return nil, nil, ErrInvalidTicketCode
+	}
+	parts := strings.Split(strings.TrimPrefix(code, SignedTicketPrefix), ".")
+	if len(parts) != 2 {
+		return nil, nil, ErrInvalidTicketCode
+	}
+	payload, err = base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrInvalidTicketCode
+	}
+	sig, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, nil, ErrInvalidTicketCode
+	}
+	return payload, sig, nil
+}
+
+// DecodeTicketCode reads the claims of a signed code WITHOUT checking the signature.
+func DecodeTicketCode(code string) (*TicketClaims, error) {
+	payload, _, err := splitTicketCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 1+ticketKeyIDBytes || payload[0] != signedTicketVersion {
+		return nil, ErrInvalidTicketCode
+	}
+
+	claims := &TicketClaims{KeyID: hex.EncodeToString(payload[1 : 1+ticketKeyIDBytes])}
+	r := bytes.NewReader(payload[1+ticketKeyIDBytes:])
+	for _, field := range []*uint{&claims.TicketID, &claims.EventID, &claims.TicketTypeID} {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidTicketCode
+		}
+		*field = uint(v)
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidTicketCode
+	}
+	return claims, nil
+}
+
+// VerifyTicketCodeWithKey checks a signed code against a base64 public key, the same
+// check a scanner performs offline.
+func VerifyTicketCodeWithKey(code, publicKey string) (*TicketClaims, error) {
+	claims, err := DecodeTicketCode(code)
+	if err != nil {
+		return nil, err
+	}
+	payload, sig, _ := splitTicketCode(code)
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), payload, sig) {
+		return nil, ErrTicketSignature
+	}
+	return claims, nil
+}
+
+// VerifyTicketCode checks a signed code against the stored event key. Codes signed with
+// revoked keys are rejected.
+func VerifyTicketCode(db *gorm.DB, code string) (*TicketClaims, error) {
+	claims, err := DecodeTicketCode(code)
+	if err != nil {
+		return nil, err
+	}
+	var key models.EventSigningKey
+	if err := db.Where("key_id = ? AND event_id = ?", claims.KeyID, claims.EventID).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTicketKeyNotFound
+		}
+		return nil, err
+	}
+	if key.Status == "revoked" {
+		return nil, ErrTicketKeyRevoked
+	}
+	return VerifyTicketCodeWithKey(code, key.PublicKey)
+}
+
+// FindTicketByCode looks up a ticket by its code. Signed codes are verified first and must
+// match the ticket's current code (a re-signed ticket invalidates its previous code);
+// legacy codes are looked up as-is.
+func FindTicketByCode(db *gorm.DB, code string, preloads ...string) (*models.Ticket, error) {
+	query := db
+	for _, p := range preloads {
+		query = query.Preload(p)
+	}
+
+	var ticket models.Ticket
+	if !IsSignedTicketCode(code) {
+		if err := query.Where("ticket_code = ?", code).First(&ticket).Error; err != nil {
+			return nil, err
+		}
+		return &ticket, nil
+	}
+
+	claims, err := VerifyTicketCode(db, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.Where("id = ? AND event_id = ?", claims.TicketID, claims.EventID).First(&ticket).Error; err != nil {
+		return nil, err
+	}
+	if ticket.TicketCode != code {
+		return nil, ErrTicketCodeSuperseded
+	}
+	return &ticket, nil
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func createSignedTicket(t *testing.T, db *gorm.DB, eventID uint) *models.Ticket {
+	ticket := models.Ticket{EventID: eventID, TicketTypeID: 3, TicketCode: fmt.Sprintf("T-%d", time.Now().UnixNano()), Status: "active"}
+	assert.NoError(t, db.Create(&ticket).Error)
+	assert.NoError(t, IssueTicketCode(db, &ticket))
+	return &ticket
+}
+
+func TestSignedTicketCode_RoundTrip(t *testing.T) {
+	db := newTestDB(t, &models.Ticket{}, &models.EventSigningKey{})
+	ticket := createSignedTicket(t, db, 7)
+
+	assert.True(t, IsSignedTicketCode(ticket.TicketCode))
+	assert.LessOrEqual(t, len(ticket.TicketCode), 160)
+
+	claims, err := VerifyTicketCode(db, ticket.TicketCode)
+	assert.NoError(t, err)
+	assert.Equal(t, ticket.ID, claims.TicketID)
+	assert.Equal(t, uint(7), claims.EventID)
+	assert.Equal(t, uint(3), claims.TicketTypeID)
+
+	// Offline path: only the public key is needed
+	var key models.EventSigningKey
+	db.Where("key_id = ?", claims.KeyID).First(&key)
+	_, err = VerifyTicketCodeWithKey(ticket.TicketCode, key.PublicKey)
+	assert.NoError(t, err)
+
+	found, err := FindTicketByCode(db, ticket.TicketCode)
+	assert.NoError(t, err)
+	assert.Equal(t, ticket.ID, found.ID)
+}
+
+func TestSignedTicketCode_Tampered(t *testing.T) {
+	db := newTestDB(t, &models.Ticket{}, &models.EventSigningKey{})
+	ticket := createSignedTicket(t, db, 7)
+
+	// Forge a code for another ticket id by re-encoding the payload with the old signature
+	parts := strings.Split(strings.TrimPrefix(ticket.TicketCode, SignedTicketPrefix), ".")
+	other := models.Ticket{ID: ticket.ID + 1, EventID: 7, TicketTypeID: 3}
+	var key models.EventSigningKey
+	db.First(&key)
+	forged, _ := SignTicketCode(&key, other)
+	forgedParts := strings.Split(strings.TrimPrefix(forged, SignedTicketPrefix), ".")
+	tampered := SignedTicketPrefix + forgedParts[0] + "." + parts[1]
+
+	_, err := VerifyTicketCode(db, tampered)
+	assert.ErrorIs(t, err, ErrTicketSignature)
+
+	_, err = DecodeTicketCode("KT1.garbage")
+	assert.ErrorIs(t, err, ErrInvalidTicketCode)
+}
+
+func TestSignedTicketCode_RotateAndRevoke(t *testing.T) {
+	db := newTestDB(t, &models.Ticket{}, &models.EventSigningKey{})
+	oldTicket := createSignedTicket(t, db, 9)
+	oldClaims, _ := DecodeTicketCode(oldTicket.TicketCode)
+
+	newKey, err := RotateEventSigningKey(db, 9)
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldClaims.KeyID, newKey.KeyID)
+
+	// Retired key still verifies
+	_, err = FindTicketByCode(db, oldTicket.TicketCode)
+	assert.NoError(t, err)
+
+	reissued, err := RevokeEventSigningKey(db, 9, oldClaims.KeyID)
+	assert.NoError(t, err)
+	if assert.Len(t, reissued, 1) {
+		assert.Equal(t, oldTicket.ID, reissued[0].ID)
+		assert.NotEqual(t, oldTicket.TicketCode, reissued[0].TicketCode)
+	}
+
+	_, err = FindTicketByCode(db, oldTicket.TicketCode)
+	assert.ErrorIs(t, err, ErrTicketKeyRevoked)
+
+	var current models.Ticket
+	db.First(&current, oldTicket.ID)
+	claims, err := VerifyTicketCode(db, current.TicketCode)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey.KeyID, claims.KeyID)
+
+	_, err = RevokeEventSigningKey(db, 9, oldClaims.KeyID)
+	assert.ErrorIs(t, err, ErrTicketKeyAlreadyRevoked)
+}
+
+func TestFindTicketByCode_Legacy(t *testing.T) {
+	db := newTestDB(t, &models.Ticket{}, &models.EventSigningKey{})
+	db.Create(&models.Ticket{EventID: 1, TicketTypeID: 1, TicketCode: "T-1700000000000000000-1-0", Status: "active"})
+
+	ticket, err := FindTicketByCode(db, "T-1700000000000000000-1-0")
+	assert.NoError(t, err)
+	assert.Equal(t, "active", ticket.Status)
+
+	_, err = FindTicketByCode(db, "T-unknown")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
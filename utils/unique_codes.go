@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SettingUniqueCodeOverflow chooses what happens when every three-digit code of a base
+// amount is live: "" refuses the checkout, "four_digits" continues with 1000-9999 and
+// "negative" with -999..-101 (the customer pays slightly less than the base amount).
+const SettingUniqueCodeOverflow = "unique_code_overflow"
+
+var ErrUniqueCodesExhausted = errors.New("no unique code available for this amount")
+
+// Tried per code range before moving to the overflow range; conflicts only happen when
+// concurrent checkouts race for the same code or another base amount has the same total.
+const uniqueCodeAttempts = 20
+
+func init() {
+	OrderStates.OnTransition(releaseUniqueCodeOnTransition)
+}
+
+// AllocateUniqueCode reserves a unique code for an order of baseAmount inside the checkout
+// transaction. Codes are picked at random among the free ones so concurrent checkouts
+// rarely collide; when they do, the unique indexes reject the insert and the next code is
+// tried. Negative codes that would leave nothing to pay are skipped. Attach the order
+// with AttachUniqueCode once it has an ID.
+func AllocateUniqueCode(tx *gorm.DB, baseAmount float64) (*models.UniqueCodeAllocation, error) {
+	now := time.Now()
+	ranges := [][2]int{{101, 999}}
+	var setting models.SiteSetting
+	if tx.Where("key = ?", SettingUniqueCodeOverflow).First(&setting).Error == nil {
+		switch setting.Value {
+		case "four_digits":
+			ranges = append(ranges, [2]int{1000, 9999})
+		case "negative":
+			ranges = append(ranges, [2]int{-999, -101})
+		}
+	}
+
+	for _, r := range ranges {
+		var live []int
+		if err := tx.Model(&models.UniqueCodeAllocation{}).
+			Where("base_amount = ? AND released_at IS NULL AND code BETWEEN ? AND ?", baseAmount, r[0], r[1]).
+			Pluck("code", &live).Error; err != nil {
+			return nil, err
+		}
+		taken := make(map[int]bool, len(live))
+		for _, code := range live {
+			taken[code] = true
+		}
+		free := make([]int, 0, r[1]-r[0]+1-len(live))
+		for code := r[0]; code <= r[1]; code++ {
+			if !taken[code] && baseAmount+float64(code) > 0 {
+				free = append(free, code)
+			}
+		}
+		rand.Shuffle(len(free), func(i, j int) { free[i], free[j] = free[j], free[i] })
+		if len(free) > uniqueCodeAttempts {
+			free = free[:uniqueCodeAttempts]
+		}
+
+		for _, code := range free {
+			allocation := models.UniqueCodeAllocation{
+				BaseAmount:  baseAmount,
+				Code:        code,
+				TotalAmount: baseAmount + float64(code),
+				CreatedAt:   now,
+			}
+			// DO NOTHING keeps a conflict from aborting the checkout transaction
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&allocation)
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			if res.RowsAffected == 1 {
+				return &allocation, nil
+			}
+		}
+	}
+	return nil, ErrUniqueCodesExhausted
+}
+
+// AttachUniqueCode links an allocation to the order created with its code.
+func AttachUniqueCode(tx *gorm.DB, allocation *models.UniqueCodeAllocation, orderID uint) error {
+	allocation.OrderID = orderID
+	return tx.Model(allocation).Update("order_id", orderID).Error
+}
+
+// releaseUniqueCodeOnTransition frees the order's code once it can no longer be paid by
+// an exact transfer. Expired orders keep it for the late-payment window (see
+// BankMatchRules) so a new order can't take over the total a late payer will send.
+func releaseUniqueCodeOnTransition(tx *gorm.DB, t OrderTransition) error {
+	if t.Order.UniqueCode == 0 {
+		return nil
+	}
+	live := tx.Model(&models.UniqueCodeAllocation{}).Where("order_id = ? AND released_at IS NULL", t.Order.ID)
+
+	now := time.Now()
+	switch t.To {
+	case OrderStatusExpired:
+		if window := LoadBankMatchRules(tx).LatePaymentWindow; window > 0 {
+			return live.Update("held_until", now.Add(window)).Error
+		}
+		return live.Update("released_at", now).Error
+	case OrderStatusPaid, OrderStatusCancelled, OrderStatusRefunded:
+		return live.Update("released_at", now).Error
+	}
+	return nil
+}
+
+// PruneUniqueCodes releases the codes of expired orders whose late-payment window has
+// passed and deletes allocations released more than a week ago. The expiry job runs it
+// every minute, so checkouts don't sweep the table themselves.
+func PruneUniqueCodes(db *gorm.DB) error {
+	now := time.Now()
+	if err := db.Model(&models.UniqueCodeAllocation{}).
+		Where("released_at IS NULL AND held_until < ?", now).
+		Update("released_at", now).Error; err != nil {
+		return err
+	}
+	return db.Where("released_at < ?", now.Add(-7*24*time.Hour)).Delete(&models.UniqueCodeAllocation{}).Error
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateUniqueCode_UniqueTotalsAndOverflow(t *testing.T) {
+	db := newTestDB(t, &models.UniqueCodeAllocation{}, &models.SiteSetting{})
+
+	seen := map[int]bool{}
+	for i := 0; i < 899; i++ {
+		allocation, err := AllocateUniqueCode(db, 100000)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, allocation.Code >= 101 && allocation.Code <= 999)
+		assert.False(t, seen[allocation.Code], "code %d allocated twice", allocation.Code)
+		seen[allocation.Code] = true
+	}
+	_, err := AllocateUniqueCode(db, 100000)
+	assert.ErrorIs(t, err, ErrUniqueCodesExhausted)
+
+	// Another base amount can't take a total that is already live
+	var live int64
+	db.Model(&models.UniqueCodeAllocation{}).Where("total_amount = ?", 100500).Count(&live)
+	assert.Equal(t, int64(1), live)
+	for i := 0; i < 20; i++ {
+		allocation, err := AllocateUniqueCode(db, 100400)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 100500.0, allocation.TotalAmount)
+	}
+
+	db.Create(&models.SiteSetting{Key: SettingUniqueCodeOverflow, Value: "negative"})
+	allocation, err := AllocateUniqueCode(db, 100000)
+	assert.NoError(t, err)
+	assert.True(t, allocation.Code <= -101 && allocation.Code >= -999)
+	assert.Equal(t, 100000+float64(allocation.Code), allocation.TotalAmount)
+
+	// A negative code never takes the total to zero or below
+	for code := 101; code <= 999; code++ {
+		db.Create(&models.UniqueCodeAllocation{BaseAmount: 500, Code: code, TotalAmount: 500 + float64(code)})
+	}
+	for i := 0; i < 20; i++ {
+		allocation, err = AllocateUniqueCode(db, 500)
+		assert.NoError(t, err)
+		assert.Greater(t, allocation.TotalAmount, 0.0)
+	}
+
+	db.Model(&models.SiteSetting{}).Where("key = ?", SettingUniqueCodeOverflow).Update("value", "four_digits")
+	allocation, err = AllocateUniqueCode(db, 100000)
+	assert.NoError(t, err)
+	assert.True(t, allocation.Code >= 1000 && allocation.Code <= 9999)
+}
+
+func TestUniqueCode_ReleasedWithTheOrder(t *testing.T) {
+	db := newTestDB(t, &models.UniqueCodeAllocation{}, &models.SiteSetting{})
+
+	allocate := func(orderID uint) models.UniqueCodeAllocation {
+		allocation, err := AllocateUniqueCode(db, 50000)
+		assert.NoError(t, err)
+		assert.NoError(t, AttachUniqueCode(db, allocation, orderID))
+		return *allocation
+	}
+	liveCount := func() int64 {
+		var n int64
+		db.Model(&models.UniqueCodeAllocation{}).Where("base_amount = ? AND released_at IS NULL", 50000).Count(&n)
+		return n
+	}
+
+	cancelled := allocate(1)
+	expired := allocate(2)
+	assert.Equal(t, int64(2), liveCount())
+
+	assert.NoError(t, releaseUniqueCodeOnTransition(db, OrderTransition{Order: models.Order{ID: 1, UniqueCode: cancelled.Code}, To: OrderStatusCancelled}))
+	assert.Equal(t, int64(1), liveCount(), "cancelled orders free their code")
+
+	// Expired orders keep it for the late-payment window
+	assert.NoError(t, releaseUniqueCodeOnTransition(db, OrderTransition{Order: models.Order{ID: 2, UniqueCode: expired.Code}, To: OrderStatusExpired}))
+	db.First(&expired, expired.ID)
+	assert.Nil(t, expired.ReleasedAt)
+	if assert.NotNil(t, expired.HeldUntil) {
+		assert.WithinDuration(t, time.Now().Add(DefaultBankMatchRules.LatePaymentWindow), *expired.HeldUntil, time.Minute)
+	}
+
+	// ...and the expiry job frees it once the window has passed
+	db.Model(&expired).Update("held_until", time.Now().Add(-time.Minute))
+	assert.NoError(t, PruneUniqueCodes(db))
+	db.First(&expired, expired.ID)
+	assert.NotNil(t, expired.ReleasedAt)
+	assert.Equal(t, int64(0), liveCount())
+}
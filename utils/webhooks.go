@@ -0,0 +1,460 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbound organizer webhooks. Notifications are written to webhook_deliveries in the same
+// transaction as the change they describe, then POSTed by the delivery job with retries.
+//
+// Each request carries:
+//
+//	X-Kartcis-Event:     order.paid
+//	X-Kartcis-Delivery:  <delivery id>
+//	X-Kartcis-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256(secret, "<t>.<body>")>
+const (
+	WebhookOrderPaid       = "order.paid"
+	WebhookOrderCancelled  = "order.cancelled"
+	WebhookOrderExpired    = "order.expired"
+	WebhookOrderRefunded   = "order.refunded"
+	WebhookTicketCheckedIn = "ticket.checked_in"
+	WebhookTest            = "webhook.test"
+
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = 6 * time.Hour
+	webhookClaimLease   = 5 * time.Minute
+	webhookBodyLogLimit = 1024
+)
+
+// WebhookEventTypes lists the event types endpoints can subscribe to.
+var WebhookEventTypes = []string{WebhookOrderPaid, WebhookOrderCancelled, WebhookOrderExpired, WebhookOrderRefunded, WebhookTicketCheckedIn}
+
+// WebhookEnvelope is the JSON body of every webhook request.
+type WebhookEnvelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	EventID   uint        `json:"event_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func init() {
+	OrderStates.OnTransition(enqueueOrderTransitionWebhooks)
+}
+
+// NewWebhookSecret returns a random signing secret for an endpoint.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func newWebhookEventUID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// SignWebhookPayload returns the X-Kartcis-Signature header value for a body.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyWebhookSignature checks a signature header against a body, as a receiver would.
+func VerifyWebhookSignature(secret, header string, body []byte) bool {
+	var ts int64
+	for _, part := range strings.Split(header, ",") {
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		}
+	}
+	if ts == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(SignWebhookPayload(secret, ts, body)), []byte(header))
+}
+
+func endpointWants(endpoint models.WebhookEndpoint, eventType string) bool {
+	if eventType == WebhookTest || strings.TrimSpace(endpoint.EventTypes) == "" {
+		return true
+	}
+	for _, t := range strings.Split(endpoint.EventTypes, ",") {
+		if strings.TrimSpace(t) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// EnqueueWebhookEvent queues a notification for every active endpoint of the event's
+// organizer that subscribes to eventType. Call it inside the transaction of the change.
+func EnqueueWebhookEvent(tx *gorm.DB, eventType string, eventID uint, data interface{}) error {
+	var endpoints []models.WebhookEndpoint
+	if err := tx.Joins("JOIN events ON events.organizer_id = webhook_endpoints.organizer_id AND events.id = ?", eventID).
+		Where("webhook_endpoints.is_active = ? AND (webhook_endpoints.event_id IS NULL OR webhook_endpoints.event_id = ?)", true, eventID).
+		Find(&endpoints).Error; err != nil {
+		return err
+	}
+
+	uid := newWebhookEventUID()
+	for _, endpoint := range endpoints {
+		if !endpointWants(endpoint, eventType) {
+			continue
+		}
+		if err := enqueueWebhookDelivery(tx, endpoint.ID, uid, eventType, eventID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueTestWebhook queues a webhook.test notification for a single endpoint.
+func EnqueueTestWebhook(tx *gorm.DB, endpoint models.WebhookEndpoint) (*models.WebhookDelivery, error) {
+	var eventID uint
+	if endpoint.EventID != nil {
+		eventID = *endpoint.EventID
+	}
+	if err := enqueueWebhookDelivery(tx, endpoint.ID, newWebhookEventUID(), WebhookTest, eventID, map[string]interface{}{
+		"message": "Webhook endpoint is reachable",
+	}); err != nil {
+		return nil, err
+	}
+	var delivery models.WebhookDelivery
+	err := tx.Where("endpoint_id = ?", endpoint.ID).Order("id DESC").First(&delivery).Error
+	return &delivery, err
+}
+
+func enqueueWebhookDelivery(tx *gorm.DB, endpointID uint, uid, eventType string, eventID uint, data interface{}) error {
+	now := time.Now()
+	payload, err := json.Marshal(WebhookEnvelope{ID: uid, Type: eventType, EventID: eventID, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.WebhookDelivery{
+		EndpointID:    endpointID,
+		EventUID:      uid,
+		EventType:     eventType,
+		EventID:       eventID,
+		Payload:       string(payload),
+		Status:        "pending",
+		NextAttemptAt: now,
+	}).Error
+}
+
+// RedeliverWebhook queues a fresh copy of a delivery (same payload and event id).
+func RedeliverWebhook(tx *gorm.DB, original models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{
+		EndpointID:    original.EndpointID,
+		EventUID:      original.EventUID,
+		EventType:     original.EventType,
+		EventID:       original.EventID,
+		Payload:       original.Payload,
+		Status:        "pending",
+		NextAttemptAt: time.Now(),
+		RedeliveryOf:  &original.ID,
+	}
+	if err := tx.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// orderWebhookData is the order payload, limited to the tickets of one event so an
+// organizer never sees other organizers' tickets of a mixed order.
+func orderWebhookData(order models.Order, tickets []models.Ticket) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(tickets))
+	for _, t := range tickets {
+		items = append(items, map[string]interface{}{
+			"ticket_id":       t.ID,
+			"ticket_code":     t.TicketCode,
+			"ticket_type_id":  t.TicketTypeID,
+			"ticket_type":     t.TicketType.Name,
+			"attendee_name":   t.AttendeeName,
+			"attendee_email":  t.AttendeeEmail,
+			"attendee_phone":  t.AttendeePhone,
+			"purchased_price": t.PurchasedPrice,
+			"status":          t.Status,
+		})
+	}
+	return map[string]interface{}{
+		"order_number":    order.OrderNumber,
+		"status":          order.Status,
+		"customer_name":   order.CustomerName,
+		"customer_email":  order.CustomerEmail,
+		"customer_phone":  order.CustomerPhone,
+		"total_amount":    order.TotalAmount,
+		"refunded_amount": order.RefundedAmount,
+		"payment_method":  order.PaymentMethod,
+		"paid_at":         order.PaidAt,
+		"created_at":      order.CreatedAt,
+		"tickets":         items,
+	}
+}
+
+// EnqueueOrderWebhooks queues an order notification per event on the order. extra is
+// merged into each payload (e.g. refund details).
+func EnqueueOrderWebhooks(tx *gorm.DB, order models.Order, eventType string, extra map[string]interface{}) error {
+	var tickets []models.Ticket
+	if err := tx.Preload("TicketType").Where("order_id = ?", order.ID).Order("id ASC").Find(&tickets).Error; err != nil {
+		return err
+	}
+
+	byEvent := make(map[uint][]models.Ticket)
+	var eventIDs []uint
+	for _, t := range tickets {
+		if _, ok := byEvent[t.EventID]; !ok {
+			eventIDs = append(eventIDs, t.EventID)
+		}
+		byEvent[t.EventID] = append(byEvent[t.EventID], t)
+	}
+
+	for _, eventID := range eventIDs {
+		data := orderWebhookData(order, byEvent[eventID])
+		for k, v := range extra {
+			data[k] = v
+		}
+		if err := EnqueueWebhookEvent(tx, eventType, eventID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var orderWebhookTypes = map[string]string{
+	OrderStatusPaid:      WebhookOrderPaid,
+	OrderStatusCancelled: WebhookOrderCancelled,
+	OrderStatusExpired:   WebhookOrderExpired,
+	OrderStatusRefunded:  WebhookOrderRefunded,
+}
+
+func enqueueOrderTransitionWebhooks(tx *gorm.DB, t OrderTransition) error {
+	eventType, ok := orderWebhookTypes[t.To]
+	if !ok {
+		return nil
+	}
+	return EnqueueOrderWebhooks(tx, t.Order, eventType, map[string]interface{}{"previous_status": t.From})
+}
+
+// webhookBackoff is the wait before the next attempt after `attempts` failures.
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// DeliverDueWebhooks sends up to limit due deliveries. Rows are claimed with
+// FOR UPDATE SKIP LOCKED and a short lease, so several instances can run it and a crash
+// mid-request only delays the retry. It returns how many deliveries were attempted.
+func DeliverDueWebhooks(db *gorm.DB, client *http.Client, limit int) (int, error) {
+	now := time.Now()
+	var due []models.WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", "pending", now).
+			Order("next_attempt_at ASC").Limit(limit).Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(due))
+		for _, d := range due {
+			ids = append(ids, d.ID)
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(webhookClaimLease)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range due {
+		attemptWebhookDelivery(db, client, &due[i])
+	}
+	return len(due), nil
+}
+
+func attemptWebhookDelivery(db *gorm.DB, client *http.Client, delivery *models.WebhookDelivery) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":        delivery.Attempts + 1,
+		"last_attempt_at": now,
+		"response_status": 0,
+		"response_body":   "",
+		"last_error":      "",
+	}
+
+	status, body, err := sendWebhook(db, client, delivery, now)
+	updates["response_status"] = status
+	updates["response_body"] = body
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		updates["status"] = "succeeded"
+		updates["delivered_at"] = now
+	default:
+		if err != nil {
+			updates["last_error"] = err.Error()
+		} else {
+			updates["last_error"] = fmt.Sprintf("endpoint responded %d", status)
+		}
+		if delivery.Attempts+1 >= webhookMaxAttempts {
+			updates["status"] = "failed"
+		} else {
+			updates["next_attempt_at"] = now.Add(webhookBackoff(delivery.Attempts + 1))
+		}
+	}
+
+	if err := db.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Printf("[Webhook] Failed to record delivery %d: %v", delivery.ID, err)
+	}
+}
+
+func sendWebhook(db *gorm.DB, client *http.Client, delivery *models.WebhookDelivery, now time.Time) (int, string, error) {
+	var endpoint models.WebhookEndpoint
+	if err := db.First(&endpoint, delivery.EndpointID).Error; err != nil {
+		return 0, "", fmt.Errorf("endpoint not found")
+	}
+	if !endpoint.IsActive {
+		return 0, "", fmt.Errorf("endpoint is disabled")
+	}
+	if u, err := url.Parse(endpoint.URL); err != nil || u.Scheme != "https" {
+		return 0, "", ErrWebhookURLNotAllowed
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Kartcis-Webhooks/1.0")
+	req.Header.Set("X-Kartcis-Event", delivery.EventType)
+	req.Header.Set("X-Kartcis-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Kartcis-Signature", SignWebhookPayload(endpoint.Secret, now.Unix(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookBodyLogLimit))
+	return resp.StatusCode, string(respBody), nil
+}
+
+// ErrWebhookURLNotAllowed is returned for endpoint URLs that aren't https or point at
+// loopback, private, link-local or cloud metadata addresses.
+var ErrWebhookURLNotAllowed = errors.New("webhook URL must be a public https URL")
+
+// blockedWebhookHosts are names that resolve to internal services.
+var blockedWebhookHosts = map[string]bool{
+	"localhost":                true,
+	"metadata":                 true,
+	"metadata.google.internal": true,
+}
+
+// cgnatRange is the carrier-grade NAT block (100.64.0.0/10), private in practice.
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedWebhookIP reports whether webhooks may not be sent to ip: loopback, private,
+// link-local (which covers the 169.254.169.254 metadata service), unspecified or multicast.
+func blockedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatRange.Contains(ip) ||
+		(ip.To4() != nil && ip.To4()[0] == 0)
+}
+
+// ValidateWebhookURL checks an endpoint URL at registration: https, and no internal host
+// names or addresses. Names are resolved again when sending, see NewWebhookClient.
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return ErrWebhookURLNotAllowed
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if blockedWebhookHosts[host] || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return ErrWebhookURLNotAllowed
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedWebhookIP(ip) {
+		return ErrWebhookURLNotAllowed
+	}
+	return nil
+}
+
+// NewWebhookClient returns the HTTP client for webhook deliveries. Its dialer refuses
+// internal addresses after DNS resolution, so a public name pointing (or rebinding) to
+// one is caught at send time. Proxies from the environment are not used, as they would
+// hide the real destination from the check.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookURLNotAllowed, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// EnqueueCheckInWebhook queues ticket.checked_in for an accepted check-in.
+func EnqueueCheckInWebhook(tx *gorm.DB, ticket models.Ticket, source, gate, deviceID string, checkInAt time.Time) error {
+	return EnqueueWebhookEvent(tx, WebhookTicketCheckedIn, ticket.EventID, map[string]interface{}{
+		"ticket_id":      ticket.ID,
+		"ticket_code":    ticket.TicketCode,
+		"ticket_type_id": ticket.TicketTypeID,
+		"order_id":       ticket.OrderID,
+		"attendee_name":  ticket.AttendeeName,
+		"attendee_email": ticket.AttendeeEmail,
+		"check_in_at":    checkInAt,
+		"source":         source,
+		"gate":           gate,
+		"device_id":      deviceID,
+	})
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// webhookReceiver records signed requests and answers with the next queued status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	w.Write([]byte("ok"))
+}
+
+func setupWebhookDB(t *testing.T, url string) (*gorm.DB, models.WebhookEndpoint) {
+	db, _ := setupQuotaDB(t)
+	db.AutoMigrate(&models.WebhookEndpoint{}, &models.WebhookDelivery{})
+	db.Create(&models.Event{ID: 1, OrganizerID: 7, Title: "Konser"})
+	db.Create(&models.Event{ID: 2, OrganizerID: 8, Title: "Other organizer"})
+
+	endpoint := models.WebhookEndpoint{OrganizerID: 7, URL: url, Secret: "whsec_test", IsActive: true}
+	db.Create(&endpoint)
+	return db, endpoint
+}
+
+func TestWebhooks_EnqueueAndDeliverSigned(t *testing.T) {
+	receiver := &webhookReceiver{}
+	srv := httptest.NewTLSServer(receiver)
+	defer srv.Close()
+	db, endpoint := setupWebhookDB(t, srv.URL)
+
+	// Subscribed only to check-ins: order events are skipped
+	eventOne := uint(1)
+	scoped := models.WebhookEndpoint{OrganizerID: 7, EventID: &eventOne, URL: srv.URL, Secret: "whsec_other", EventTypes: WebhookTicketCheckedIn, IsActive: true}
+	db.Create(&scoped)
+
+	assert.NoError(t, EnqueueWebhookEvent(db, WebhookOrderPaid, 1, map[string]interface{}{"order_number": "ORD-1"}))
+	assert.NoError(t, EnqueueWebhookEvent(db, WebhookOrderPaid, 2, map[string]interface{}{"order_number": "ORD-2"}))
+
+	var queued []models.WebhookDelivery
+	db.Find(&queued)
+	assert.Len(t, queued, 1)
+	assert.Equal(t, endpoint.ID, queued[0].EndpointID)
+
+	sent, err := DeliverDueWebhooks(db, srv.Client(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	assert.Len(t, receiver.bodies, 1)
+	assert.Equal(t, WebhookOrderPaid, receiver.headers[0].Get("X-Kartcis-Event"))
+	assert.True(t, VerifyWebhookSignature("whsec_test", receiver.headers[0].Get("X-Kartcis-Signature"), receiver.bodies[0]))
+	assert.False(t, VerifyWebhookSignature("whsec_other", receiver.headers[0].Get("X-Kartcis-Signature"), receiver.bodies[0]))
+
+	var envelope WebhookEnvelope
+	assert.NoError(t, json.Unmarshal(receiver.bodies[0], &envelope))
+	assert.Equal(t, queued[0].EventUID, envelope.ID)
+	assert.Equal(t, uint(1), envelope.EventID)
+
+	var delivery models.WebhookDelivery
+	db.First(&delivery, queued[0].ID)
+	assert.Equal(t, "succeeded", delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, 200, delivery.ResponseStatus)
+	assert.NotNil(t, delivery.DeliveredAt)
+}
+
+func TestWebhooks_RetryBackoffThenFail(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{500, 500, 500, 500, 500, 500, 500, 500}}
+	srv := httptest.NewTLSServer(receiver)
+	defer srv.Close()
+	db, _ := setupWebhookDB(t, srv.URL)
+
+	assert.NoError(t, EnqueueWebhookEvent(db, WebhookOrderCancelled, 1, nil))
+
+	var delivery models.WebhookDelivery
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		before := time.Now()
+		sent, err := DeliverDueWebhooks(db, srv.Client(), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, sent)
+
+		db.First(&delivery)
+		assert.Equal(t, attempt, delivery.Attempts)
+		assert.Equal(t, 500, delivery.ResponseStatus)
+		if attempt < webhookMaxAttempts {
+			assert.Equal(t, "pending", delivery.Status)
+			assert.WithinDuration(t, before.Add(webhookBackoff(attempt)), delivery.NextAttemptAt, 2*time.Second)
+
+			// Not due yet: nothing is sent until the backoff passes
+			sent, _ = DeliverDueWebhooks(db, srv.Client(), 10)
+			assert.Equal(t, 0, sent)
+			db.Model(&delivery).Update("next_attempt_at", time.Now().Add(-time.Second))
+		}
+	}
+	assert.Equal(t, "failed", delivery.Status)
+	assert.Contains(t, delivery.LastError, "500")
+
+	assert.Equal(t, 30*time.Second, webhookBackoff(1))
+	assert.Equal(t, time.Minute, webhookBackoff(2))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(20))
+
+	// Manual redelivery reuses the payload and event id
+	redelivery, err := RedeliverWebhook(db, delivery)
+	assert.NoError(t, err)
+	sent, _ := DeliverDueWebhooks(db, srv.Client(), 10)
+	assert.Equal(t, 1, sent)
+	db.First(redelivery, redelivery.ID)
+	assert.Equal(t, "succeeded", redelivery.Status)
+	assert.Equal(t, delivery.ID, *redelivery.RedeliveryOf)
+	assert.Equal(t, receiver.bodies[0], receiver.bodies[len(receiver.bodies)-1])
+}
+
+func TestWebhooks_OrderTransitionScopesTicketsPerEvent(t *testing.T) {
+	db, endpoint := setupWebhookDB(t, "http://example.invalid")
+	db.AutoMigrate(&models.OrderStatusHistory{}, &models.Voucher{}, &models.ReferralCode{})
+
+	order := models.Order{OrderNumber: "ORD-WH", Status: OrderStatusPending}
+	db.Create(&order)
+	db.Create(&models.Ticket{OrderID: &order.ID, EventID: 1, TicketTypeID: 1, TicketCode: "T-WH1", Status: "active"})
+	db.Create(&models.Ticket{OrderID: &order.ID, EventID: 2, TicketTypeID: 1, TicketCode: "T-WH2", Status: "active"})
+
+	m := &OrderStateMachine{}
+	m.OnTransition(enqueueOrderTransitionWebhooks)
+	_, err := m.Transition(db, &order, OrderStatusCancelled, OrderTransitionOptions{SkipEmail: true})
+	assert.NoError(t, err)
+
+	var deliveries []models.WebhookDelivery
+	db.Find(&deliveries)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, endpoint.ID, deliveries[0].EndpointID)
+	assert.Equal(t, WebhookOrderCancelled, deliveries[0].EventType)
+
+	var envelope struct {
+		Data struct {
+			Status         string `json:"status"`
+			PreviousStatus string `json:"previous_status"`
+			Tickets        []struct {
+				TicketCode string `json:"ticket_code"`
+			} `json:"tickets"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(deliveries[0].Payload), &envelope))
+	assert.Equal(t, OrderStatusCancelled, envelope.Data.Status)
+	assert.Equal(t, OrderStatusPending, envelope.Data.PreviousStatus)
+	assert.Len(t, envelope.Data.Tickets, 1)
+	assert.Equal(t, "T-WH1", envelope.Data.Tickets[0].TicketCode)
+}
+
+func TestWebhooks_ValidateURLRejectsInternalHosts(t *testing.T) {
+	for _, raw := range []string{
+		"http://hooks.example.com/kartcis",
+		"https://127.0.0.1/hook",
+		"https://localhost:8080/hook",
+		"https://10.0.0.5/hook",
+		"https://192.168.1.10/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://metadata.google.internal/computeMetadata",
+		"https://[::1]/hook",
+		"https://0.0.0.0/hook",
+		"ftp://hooks.example.com",
+	} {
+		assert.ErrorIs(t, ValidateWebhookURL(raw), ErrWebhookURLNotAllowed, raw)
+	}
+	assert.NoError(t, ValidateWebhookURL("https://hooks.example.com/kartcis"))
+	assert.NoError(t, ValidateWebhookURL("https://203.0.113.10:8443/hook"))
+}
+
+func TestWebhooks_ClientRefusesInternalAddressesAtSendTime(t *testing.T) {
+	receiver := &webhookReceiver{}
+	srv := httptest.NewTLSServer(receiver)
+	defer srv.Close()
+	db, endpoint := setupWebhookDB(t, srv.URL)
+	delivery, _ := EnqueueTestWebhook(db, endpoint)
+
+	// The test server listens on 127.0.0.1, which the delivery client must not dial
+	sent, _ := DeliverDueWebhooks(db, NewWebhookClient(time.Second), 10)
+	assert.Equal(t, 1, sent)
+	db.First(delivery, delivery.ID)
+	assert.Equal(t, "pending", delivery.Status)
+	assert.Contains(t, delivery.LastError, "public https URL")
+	assert.Empty(t, receiver.bodies)
+}
@@ -0,0 +1,169 @@
+package waitingroom
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"kartcis-backend/config"
+	"kartcis-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormStore keeps queue state in the waiting_room_entries and waiting_room_states
+// tables. The state row of the event is locked while positions are handed out or
+// released, so every replica sees one queue; reading it takes no lock.
+type GormStore struct {
+	DB *gorm.DB // nil uses config.DB
+}
+
+func (s *GormStore) db(ctx context.Context) *gorm.DB {
+	if s.DB != nil {
+		return s.DB.WithContext(ctx)
+	}
+	return config.DB.WithContext(ctx)
+}
+
+// lockState loads (creating it if needed) and locks the event's state row.
+func lockState(tx *gorm.DB, eventID uint) (models.WaitingRoomState, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.WaitingRoomState{EventID: eventID}).Error; err != nil {
+		return models.WaitingRoomState{}, err
+	}
+	var state models.WaitingRoomState
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, "event_id = ?", eventID).Error
+	return state, err
+}
+
+func (s *GormStore) Join(ctx context.Context, eventID uint, visitorID string, now time.Time) (Entry, error) {
+	var entry models.WaitingRoomEntry
+	err := s.db(ctx).Transaction(func(tx *gorm.DB) error {
+		state, err := lockState(tx, eventID)
+		if err != nil {
+			return err
+		}
+		entry = models.WaitingRoomEntry{EventID: eventID, VisitorID: visitorID, Position: state.Tail + 1, JoinedAt: now}
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.WaitingRoomState{}).Where("event_id = ?", eventID).Update("tail", entry.Position).Error
+	})
+	return entryFromModel(entry), err
+}
+
+func (s *GormStore) Get(ctx context.Context, eventID uint, visitorID string) (Entry, error) {
+	var entry models.WaitingRoomEntry
+	err := s.db(ctx).Where("event_id = ? AND visitor_id = ?", eventID, visitorID).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Entry{}, ErrNotInQueue
+	}
+	return entryFromModel(entry), err
+}
+
+func (s *GormStore) State(ctx context.Context, eventID uint) (State, error) {
+	var row models.WaitingRoomState
+	err := s.db(ctx).Where("event_id = ?", eventID).Limit(1).Find(&row).Error
+	return State{Tail: row.Tail, Released: row.Released, Allowance: row.Allowance, RefilledAt: row.RefilledAt}, err
+}
+
+func (s *GormStore) Release(ctx context.Context, eventID uint, ratePerMinute int, now time.Time) (State, error) {
+	var result State
+	err := s.db(ctx).Transaction(func(tx *gorm.DB) error {
+		row, err := lockState(tx, eventID)
+		if err != nil {
+			return err
+		}
+		state := State{Tail: row.Tail, Released: row.Released, Allowance: row.Allowance, RefilledAt: row.RefilledAt}
+		previous := state.Released
+		refill(&state, ratePerMinute, now)
+		result = state
+
+		if state.Released > previous {
+			if err := tx.Model(&models.WaitingRoomEntry{}).
+				Where("event_id = ? AND position > ? AND position <= ?", eventID, previous, state.Released).
+				Update("admitted_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.WaitingRoomState{}).Where("event_id = ?", eventID).Updates(map[string]interface{}{
+			"released":    state.Released,
+			"allowance":   state.Allowance,
+			"refilled_at": state.RefilledAt,
+		}).Error
+	})
+	return result, err
+}
+
+func entryFromModel(m models.WaitingRoomEntry) Entry {
+	return Entry{EventID: m.EventID, VisitorID: m.VisitorID, Position: m.Position, JoinedAt: m.JoinedAt, AdmittedAt: m.AdmittedAt}
+}
+
+// MemoryStore keeps queue state in process. It is only correct with a single API
+// instance, and the queue is lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	states  map[uint]*State
+	entries map[uint]map[string]*Entry
+	order   map[uint][]*Entry // By position
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states:  make(map[uint]*State),
+		entries: make(map[uint]map[string]*Entry),
+		order:   make(map[uint][]*Entry),
+	}
+}
+
+func (s *MemoryStore) state(eventID uint) *State {
+	if s.states[eventID] == nil {
+		s.states[eventID] = &State{}
+		s.entries[eventID] = make(map[string]*Entry)
+	}
+	return s.states[eventID]
+}
+
+func (s *MemoryStore) Join(ctx context.Context, eventID uint, visitorID string, now time.Time) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state(eventID)
+	state.Tail++
+	entry := &Entry{EventID: eventID, VisitorID: visitorID, Position: state.Tail, JoinedAt: now}
+	s.entries[eventID][visitorID] = entry
+	s.order[eventID] = append(s.order[eventID], entry)
+	return *entry, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, eventID uint, visitorID string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[eventID][visitorID]; ok {
+		return *entry, nil
+	}
+	return Entry{}, ErrNotInQueue
+}
+
+func (s *MemoryStore) State(ctx context.Context, eventID uint) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.states[eventID]; ok {
+		return *state, nil
+	}
+	return State{}, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, eventID uint, ratePerMinute int, now time.Time) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state(eventID)
+	previous := state.Released
+	refill(state, ratePerMinute, now)
+	for _, entry := range s.order[eventID][previous:state.Released] {
+		admittedAt := now
+		entry.AdmittedAt = &admittedAt
+	}
+	return *state, nil
+}
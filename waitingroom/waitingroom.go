@@ -0,0 +1,253 @@
+// Package waitingroom queues visitors of a high-demand event before checkout. A visitor
+// joins and gets a signed token carrying a random visitor ID; positions are released at
+// the event's rate (visitors per minute) and an admitted token lets its holder check
+// out for the event's admit window. Checkout verifies the token before opening a
+// transaction, so a rush of buyers waits here instead of on the ticket_types rows.
+//
+// Release works like a token bucket: allowance accrues at the rate, up to one minute's
+// worth, and is spent on the next waiting positions. Only the scheduler releases (see
+// Room.Release, run every few seconds), so joining takes one short lock on the event's
+// counter and polls and checkouts only read. With no queue, a visitor is admitted on the
+// next release run.
+//
+// Queue state lives in a Store: Postgres (default) or in-process memory for a single
+// instance (WAITING_ROOM_STORE=memory).
+package waitingroom
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kartcis-backend/models"
+)
+
+const (
+	DefaultRate         = 100 // visitors per minute
+	DefaultAdmitMinutes = 15
+)
+
+var (
+	ErrNotInQueue    = errors.New("not in the waiting room")
+	ErrInvalidToken  = errors.New("invalid waiting room token")
+	ErrNotAdmitted   = errors.New("waiting room token is not admitted yet")
+	ErrAdmitExpired  = errors.New("waiting room admission has expired")
+	ErrRoomNotActive = errors.New("event has no waiting room")
+)
+
+// Entry is a visitor's place in an event's queue.
+type Entry struct {
+	EventID    uint
+	VisitorID  string
+	Position   int64
+	JoinedAt   time.Time
+	AdmittedAt *time.Time
+}
+
+// State is an event's release counter.
+type State struct {
+	Tail       int64 // Last position handed out
+	Released   int64 // Last admitted position
+	Allowance  float64
+	RefilledAt time.Time
+}
+
+// Store keeps queue state. Implementations must make Join and Release atomic per event.
+type Store interface {
+	// Join puts the visitor at the back of the event's queue.
+	Join(ctx context.Context, eventID uint, visitorID string, now time.Time) (Entry, error)
+	// Get returns the visitor's entry or ErrNotInQueue.
+	Get(ctx context.Context, eventID uint, visitorID string) (Entry, error)
+	// State returns the event's release counter without changing it.
+	State(ctx context.Context, eventID uint) (State, error)
+	// Release refills the allowance at ratePerMinute, admits that many waiting positions
+	// (stamping their AdmittedAt) and returns the updated state.
+	Release(ctx context.Context, eventID uint, ratePerMinute int, now time.Time) (State, error)
+}
+
+// refill applies the token bucket to a state; it is shared by the stores.
+func refill(state *State, ratePerMinute int, now time.Time) {
+	rate := float64(ratePerMinute)
+	if state.RefilledAt.IsZero() {
+		state.Allowance = rate
+	} else if elapsed := now.Sub(state.RefilledAt); elapsed > 0 {
+		state.Allowance = math.Min(rate, state.Allowance+rate*elapsed.Minutes())
+	}
+	state.RefilledAt = now
+
+	admit := int64(state.Allowance)
+	if waiting := state.Tail - state.Released; admit > waiting {
+		admit = waiting
+	}
+	state.Released += admit
+	state.Allowance -= float64(admit)
+}
+
+// Status is what the frontend polls.
+type Status struct {
+	Token                string     `json:"token"`
+	Position             int64      `json:"position"`
+	Ahead                int64      `json:"ahead"` // Visitors in front still waiting
+	Admitted             bool       `json:"admitted"`
+	AdmittedAt           *time.Time `json:"admitted_at,omitempty"`
+	AdmitExpiresAt       *time.Time `json:"admit_expires_at,omitempty"`
+	EstimatedWaitSeconds int        `json:"estimated_wait_seconds"`
+	RatePerMinute        int        `json:"rate_per_minute"`
+}
+
+// Room is the waiting room of the events, backed by a store.
+type Room struct {
+	Store  Store
+	Secret []byte
+	Now    func() time.Time
+}
+
+var (
+	defaultRoom *Room
+	defaultOnce sync.Once
+)
+
+// Default returns the room used by the API, chosen by WAITING_ROOM_STORE and signed with
+// WAITING_ROOM_SECRET (falls back to JWT_SECRET). It is built on first use, so the
+// settings come from the environment loaded by config.ConnectDB rather than whatever
+// was set when the package was initialised.
+func Default() *Room {
+	defaultOnce.Do(func() {
+		var store Store = &GormStore{}
+		if strings.EqualFold(os.Getenv("WAITING_ROOM_STORE"), "memory") {
+			store = NewMemoryStore()
+		}
+		secret := os.Getenv("WAITING_ROOM_SECRET")
+		if secret == "" {
+			secret = os.Getenv("JWT_SECRET")
+		}
+		if secret == "" {
+			secret = "secret123"
+		}
+		defaultRoom = &Room{Store: store, Secret: []byte(secret), Now: time.Now}
+	})
+	return defaultRoom
+}
+
+func rate(event models.Event) int {
+	if event.WaitingRoomRate > 0 {
+		return event.WaitingRoomRate
+	}
+	return DefaultRate
+}
+
+func admitWindow(event models.Event) time.Duration {
+	minutes := event.WaitingRoomAdmitMinutes
+	if minutes <= 0 {
+		minutes = DefaultAdmitMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Join queues a new visitor for the event and returns their token and status.
+func (r *Room) Join(ctx context.Context, event models.Event) (Status, error) {
+	if !event.WaitingRoom {
+		return Status{}, ErrRoomNotActive
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Status{}, err
+	}
+	visitorID := hex.EncodeToString(id)
+	if _, err := r.Store.Join(ctx, event.ID, visitorID, r.Now()); err != nil {
+		return Status{}, err
+	}
+	return r.status(ctx, event, visitorID)
+}
+
+// Status returns the position of the token's holder. It only reads the queue.
+func (r *Room) Status(ctx context.Context, event models.Event, token string) (Status, error) {
+	if !event.WaitingRoom {
+		return Status{}, ErrRoomNotActive
+	}
+	visitorID, err := r.verify(event.ID, token)
+	if err != nil {
+		return Status{}, err
+	}
+	return r.status(ctx, event, visitorID)
+}
+
+// Admit checks that the token lets its holder check out for the event now.
+func (r *Room) Admit(ctx context.Context, event models.Event, token string) error {
+	status, err := r.Status(ctx, event, token)
+	if err != nil {
+		return err
+	}
+	if !status.Admitted {
+		return ErrNotAdmitted
+	}
+	if !r.Now().Before(*status.AdmitExpiresAt) {
+		return ErrAdmitExpired
+	}
+	return nil
+}
+
+// Release admits the positions due at the event's rate. The scheduler calls it for every
+// event with an open waiting room.
+func (r *Room) Release(ctx context.Context, event models.Event) error {
+	_, err := r.Store.Release(ctx, event.ID, rate(event), r.Now())
+	return err
+}
+
+func (r *Room) status(ctx context.Context, event models.Event, visitorID string) (Status, error) {
+	state, err := r.Store.State(ctx, event.ID)
+	if err != nil {
+		return Status{}, err
+	}
+	entry, err := r.Store.Get(ctx, event.ID, visitorID)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Token: r.sign(event.ID, visitorID), Position: entry.Position, RatePerMinute: rate(event)}
+	if entry.AdmittedAt != nil {
+		expires := entry.AdmittedAt.Add(admitWindow(event))
+		status.Admitted, status.AdmittedAt, status.AdmitExpiresAt = true, entry.AdmittedAt, &expires
+		return status, nil
+	}
+	status.Ahead = entry.Position - state.Released - 1
+	// Positions still to release before this one, minus what the allowance covers
+	missing := float64(entry.Position-state.Released) - state.Allowance
+	status.EstimatedWaitSeconds = int(math.Ceil(missing * 60 / float64(rate(event))))
+	return status, nil
+}
+
+// Tokens are "<event id>.<visitor id>.<hex hmac-sha256>"; the queue state stays in the
+// store, the token only proves which entry the caller holds.
+func (r *Room) sign(eventID uint, visitorID string) string {
+	payload := fmt.Sprintf("%d.%s", eventID, visitorID)
+	mac := hmac.New(sha256.New, r.Secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Room) verify(eventID uint, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+	tokenEventID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || uint(tokenEventID) != eventID {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(r.sign(eventID, parts[1])), []byte(token)) {
+		return "", ErrInvalidToken
+	}
+	return parts[1], nil
+}
@@ -0,0 +1,104 @@
+package waitingroom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kartcis-backend/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func stores(t *testing.T) map[string]Store {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.AutoMigrate(&models.WaitingRoomEntry{}, &models.WaitingRoomState{})
+	return map[string]Store{"gorm": &GormStore{DB: db}, "memory": NewMemoryStore()}
+}
+
+func TestRoom_ReleasesAtRate(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := &testClock{now: time.Date(2026, 10, 17, 10, 0, 0, 0, time.Local)}
+			room := &Room{Store: store, Secret: []byte("test"), Now: clock.Now}
+			event := models.Event{ID: 1, WaitingRoom: true, WaitingRoomRate: 2, WaitingRoomAdmitMinutes: 5}
+
+			var visitors []Status
+			for i := 0; i < 5; i++ {
+				status, err := room.Join(ctx, event)
+				assert.NoError(t, err)
+				assert.False(t, status.Admitted, "only the scheduler releases")
+				visitors = append(visitors, status)
+			}
+			// The first minute's allowance lets two in
+			assert.NoError(t, room.Release(ctx, event))
+			for i := range visitors {
+				visitors[i], _ = room.Status(ctx, event, visitors[i].Token)
+			}
+			assert.True(t, visitors[0].Admitted)
+			assert.True(t, visitors[1].Admitted)
+			assert.False(t, visitors[2].Admitted)
+			assert.Equal(t, int64(5), visitors[4].Position)
+			assert.Equal(t, int64(2), visitors[4].Ahead)
+			assert.Equal(t, 90, visitors[4].EstimatedWaitSeconds)
+			assert.ErrorIs(t, room.Admit(ctx, event, visitors[4].Token), ErrNotAdmitted)
+
+			// 30 seconds release one more, but polling alone admits nobody
+			clock.now = clock.now.Add(30 * time.Second)
+			status, _ := room.Status(ctx, event, visitors[2].Token)
+			assert.False(t, status.Admitted)
+			assert.NoError(t, room.Release(ctx, event))
+			status, _ = room.Status(ctx, event, visitors[2].Token)
+			assert.True(t, status.Admitted)
+			status, _ = room.Status(ctx, event, visitors[3].Token)
+			assert.False(t, status.Admitted)
+			assert.Equal(t, int64(0), status.Ahead)
+
+			assert.NoError(t, room.Admit(ctx, event, visitors[0].Token))
+			clock.now = clock.now.Add(5 * time.Minute)
+			assert.ErrorIs(t, room.Admit(ctx, event, visitors[0].Token), ErrAdmitExpired)
+			// Idle time builds up no more than a minute of allowance: two for the
+			// rest of the queue, none for a newcomer
+			assert.NoError(t, room.Release(ctx, event))
+			assert.NoError(t, room.Admit(ctx, event, visitors[3].Token))
+			assert.NoError(t, room.Admit(ctx, event, visitors[4].Token))
+			status, _ = room.Join(ctx, event)
+			assert.NoError(t, room.Release(ctx, event))
+			status, _ = room.Status(ctx, event, status.Token)
+			assert.False(t, status.Admitted)
+			assert.Equal(t, 30, status.EstimatedWaitSeconds)
+		})
+	}
+}
+
+func TestRoom_Tokens(t *testing.T) {
+	ctx := context.Background()
+	room := &Room{Store: NewMemoryStore(), Secret: []byte("test"), Now: time.Now}
+	event := models.Event{ID: 1, WaitingRoom: true}
+	status, err := room.Join(ctx, event)
+	assert.NoError(t, err)
+
+	other := models.Event{ID: 2, WaitingRoom: true}
+	_, err = room.Status(ctx, other, status.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "a token is only good for its event")
+
+	forged := status.Token[:len(status.Token)-2] + "xx"
+	_, err = room.Status(ctx, event, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	otherRoom := &Room{Store: room.Store, Secret: []byte("other"), Now: time.Now}
+	assert.ErrorIs(t, otherRoom.Admit(ctx, event, status.Token), ErrInvalidToken)
+
+	_, err = room.Join(ctx, models.Event{ID: 3})
+	assert.ErrorIs(t, err, ErrRoomNotActive)
+}